@@ -5,9 +5,15 @@
 package client
 
 import (
-	"os"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/an-prata/webby/daemon"
+	"github.com/an-prata/webby/logger"
 	"github.com/an-prata/webby/server"
 )
 
@@ -21,22 +27,161 @@ const (
 
 	// Reads the server log file and outputs it to the console.
 	ShowLog = "show-log"
+
+	// Prints a signed URL path for the given path, see `server.SignPath()`.
+	SignUrl = "sign-url"
+
+	// Sets the number of seconds a URL signed with `SignUrl` stays valid for.
+	SignExpiry = "sign-expiry"
+
+	// Checks a config for problems without running it, see `CheckConfigFile()`.
+	CheckConfig = "check-config"
+
+	// Builds the site of each server instance with a `BuildSource`, see
+	// `server.BuildSite()`.
+	Build = "build"
+
+	// Serves a directory for development with live reloading, see
+	// `RunDevServer()`.
+	Dev = "dev"
+
+	// Sets the format of the config written by `daemon.GenConfig`, see
+	// `server.ServerOptions.Marshal()`.
+	Format = "format"
 )
 
-func ShowLogFile() error {
+// The result of checking a config printed as JSON, see `daemon.OutputJson`.
+type ConfigCheckResult struct {
+	Path     string
+	Problems []string
+}
+
+// Checks the config at the given path, logging each problem found, or printing
+// a `ConfigCheckResult` if jsonOutput is true, and gives `daemon.ExitFailure` if
+// there were any.
+func CheckConfigFile(log *logger.Log, path string, jsonOutput bool) int {
+	problems := server.CheckConfig(path)
+
+	if jsonOutput {
+		result, _ := json.Marshal(ConfigCheckResult{path, append([]string{}, problems...)})
+		fmt.Println(string(result))
+
+		if len(problems) > 0 {
+			return daemon.ExitFailure
+		}
+
+		return daemon.ExitSuccess
+	}
+
+	for _, problem := range problems {
+		log.LogErr(problem)
+	}
+
+	if len(problems) > 0 {
+		log.LogInfo("Found " + strconv.Itoa(len(problems)) + " problems in '" + path + "'")
+		return daemon.ExitFailure
+	}
+
+	log.LogInfo("No problems found in '" + path + "'")
+	return daemon.ExitSuccess
+}
+
+// Builds the site of every server instance with a `BuildSource`, or only that
+// of the named instance if not empty, and gives `daemon.ExitFailure` if any
+// build failed.
+func BuildSites(log *logger.Log, instance string) int {
 	opts, err := server.LoadConfigFromPath(daemon.CONFIG_PATH)
 
 	if err != nil {
-		return err
+		log.LogErr(err.Error())
+		return daemon.ExitFailure
+	}
+
+	built := 0
+	code := daemon.ExitSuccess
+
+	for _, instanceOpts := range opts.ServerInstances() {
+		if instance != "" && instanceOpts.Name != instance {
+			continue
+		}
+
+		if instanceOpts.BuildSource == "" {
+			if instance != "" {
+				log.LogErr("No 'BuildSource' set for '" + instance + "'")
+				return daemon.ExitFailure
+			}
+
+			continue
+		}
+
+		built++
+
+		if err := server.BuildSite(instanceOpts); err != nil {
+			log.LogErr(err.Error())
+			code = daemon.ExitFailure
+		}
 	}
 
-	buf, err := os.ReadFile(opts.Log)
+	if built == 0 {
+		if instance != "" {
+			log.LogErr("No server instance named '" + instance + "'")
+		} else {
+			log.LogErr("No server instance has a 'BuildSource' set")
+		}
+
+		return daemon.ExitFailure
+	}
+
+	return code
+}
+
+// Signs the given URL path using the key from the config, or the config of the
+// named server instance if not empty, and prints the signed path. The signed
+// path will be valid for the given number of seconds.
+func PrintSignedUrl(path string, expiry int64, instance string) error {
+	opts, err := server.LoadConfigFromPath(daemon.CONFIG_PATH)
 
 	if err != nil {
 		return err
 	}
 
-	print(string(buf))
+	key := opts.SignedUrlKey
+	prefixes := opts.SignedPrefixes
+
+	if instance != "" {
+		found := false
+
+		for _, instanceOpts := range opts.Instances {
+			if instanceOpts.Name == instance {
+				key = instanceOpts.SignedUrlKey
+				prefixes = instanceOpts.SignedPrefixes
+				found = true
+			}
+		}
+
+		if !found {
+			return errors.New("No server instance named '" + instance + "'")
+		}
+	}
+
+	if key == "" {
+		return errors.New("No 'SignedUrlKey' set in config")
+	}
+
+	// Signatures are only checked under a signed prefix, elsewhere the path may
+	// be requested without one and would never expire.
+	protected := false
+
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(path, prefix) {
+			protected = true
+		}
+	}
+
+	if !protected {
+		return errors.New("Path '" + path + "' is not under any of 'SignedPrefixes', so it may be requested without a signature")
+	}
 
+	fmt.Println(server.SignPath(key, path, time.Now().Add(time.Duration(expiry)*time.Second)))
 	return nil
 }
@@ -0,0 +1,262 @@
+// Copyright (c) 2024 Evan Overman (https://an-prata.it).
+// Licensed under the MIT License.
+// See LICENSE file in repository root for complete license text.
+
+package client
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/an-prata/webby/logger"
+	"github.com/an-prata/webby/server"
+)
+
+// URL path of the event stream telling pages of the development server to
+// reload. Not hidden, since hidden paths are refused before custom handlers.
+const liveReloadPath = "/__webby/live-reload"
+
+// Script added to HTML pages served by the development server, reloading the
+// page when told to by the event stream.
+const liveReloadScript = `<script>new EventSource("` + liveReloadPath + `").onmessage = function () { location.reload(); };</script>`
+
+// Ports tried in order for the development server before letting the operating
+// system choose one.
+const (
+	devFirstPort = 8080
+	devLastPort  = 8099
+)
+
+// Serves the given directory on a free local port for development, without
+// the daemon or any config, until interrupted. Pages reload in the browser
+// whenever a file in the directory changes.
+func RunDevServer(log *logger.Log, dir string) error {
+	dir, err := filepath.Abs(dir)
+
+	if err != nil {
+		return err
+	}
+
+	port, err := freeLocalPort()
+
+	if err != nil {
+		return errors.New("Could not find a free port: " + err.Error())
+	}
+
+	opts := server.DefaultOptions()
+	opts.Site = dir
+	opts.BindAddress = "127.0.0.1"
+	opts.Port = int32(port)
+	opts.DynamicPaths = true
+	opts.AutoIndex = true
+	opts.Etags = false
+
+	// Event streams stay open for as long as their page is.
+	opts.WriteTimeout = 0
+
+	reloader := &liveReloader{clients: map[chan struct{}]bool{}}
+	handler := server.NewHandler(false)
+	handler.Map(liveReloadPath, reloader)
+	handler.Use(injectLiveReload)
+
+	srv, err := server.NewServerWithHandler(opts, handler)
+
+	if err != nil {
+		return err
+	}
+
+	stop := server.WatchPaths(func(signal server.FileChangeSignal) bool {
+		if signal == server.InitialReadError || signal == server.ReadError {
+			log.LogErr("Failed to read '" + dir + "' while watching for changes")
+			return false
+		}
+
+		reloader.changed()
+		return false
+	}, dir)
+
+	defer stop()
+
+	log.LogInfo("Serving '" + dir + "' at http://127.0.0.1:" + strconv.Itoa(port) + "/, press Ctrl+C to stop")
+	return srv.Start()
+}
+
+// Gives the first free port of those tried for the development server, or one
+// chosen by the operating system if none are free.
+func freeLocalPort() (int, error) {
+	for port := devFirstPort; port <= devLastPort; port++ {
+		if listener, err := net.Listen("tcp", "127.0.0.1:"+strconv.Itoa(port)); err == nil {
+			listener.Close()
+			return port, nil
+		}
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port, nil
+}
+
+// Tells every page open on the development server to reload, as an event
+// stream.
+type liveReloader struct {
+	mutex   sync.Mutex
+	clients map[chan struct{}]bool
+
+	// Saving a file often changes it several times, so reloads wait for changes
+	// to settle.
+	pending *time.Timer
+}
+
+// Tells pages to reload shortly, once changes have settled.
+func (l *liveReloader) changed() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.pending != nil {
+		l.pending.Stop()
+	}
+
+	l.pending = time.AfterFunc(100*time.Millisecond, func() {
+		l.mutex.Lock()
+		defer l.mutex.Unlock()
+
+		for client := range l.clients {
+			select {
+			case client <- struct{}{}:
+			default:
+			}
+		}
+	})
+}
+
+func (l *liveReloader) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	client := make(chan struct{}, 1)
+
+	l.mutex.Lock()
+	l.clients[client] = true
+	l.mutex.Unlock()
+
+	defer func() {
+		l.mutex.Lock()
+		delete(l.clients, client)
+		l.mutex.Unlock()
+	}()
+
+	controller := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	controller.Flush()
+
+	for {
+		select {
+		case <-client:
+			w.Write([]byte("data: reload\n\n"))
+			controller.Flush()
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
+
+// Adds the live reload script to HTML pages, and keeps everything from being
+// cached so that a reload always shows the latest files.
+func injectLiveReload(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+
+		if req.URL.Path == liveReloadPath || req.Method != http.MethodGet {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		// Pages are changed as they are served, so are always sent whole and
+		// unconditionally.
+		req.Header.Del("Range")
+		req.Header.Del("If-Modified-Since")
+		req.Header.Del("If-None-Match")
+
+		injector := &liveReloadWriter{ResponseWriter: w}
+		next.ServeHTTP(injector, req)
+		injector.finish()
+	})
+}
+
+// Buffers HTML responses to add the live reload script to them, writing other
+// responses through unchanged.
+type liveReloadWriter struct {
+	http.ResponseWriter
+	status  int
+	html    bool
+	buf     bytes.Buffer
+	decided bool
+}
+
+func (w *liveReloadWriter) WriteHeader(status int) {
+	if w.decided || status < 200 {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+
+	w.decided = true
+	w.status = status
+	contentType := w.Header().Get("Content-Type")
+	w.html = status == http.StatusOK && w.Header().Get("Content-Encoding") == "" && strings.HasPrefix(contentType, "text/html")
+
+	if !w.html {
+		w.ResponseWriter.WriteHeader(status)
+	}
+}
+
+func (w *liveReloadWriter) Write(b []byte) (int, error) {
+	if !w.decided {
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+
+	if w.html {
+		return w.buf.Write(b)
+	}
+
+	return w.ResponseWriter.Write(b)
+}
+
+// Writes out a buffered HTML page with the live reload script added before its
+// closing body tag, or at its end if it has none.
+func (w *liveReloadWriter) finish() {
+	if !w.html {
+		return
+	}
+
+	page := w.buf.Bytes()
+	end := bytes.LastIndex(bytes.ToLower(page), []byte("</body>"))
+
+	if end < 0 {
+		end = len(page)
+	}
+
+	injected := append(append(append([]byte{}, page[:end]...), liveReloadScript...), page[end:]...)
+	w.Header().Set("Content-Length", strconv.Itoa(len(injected)))
+	w.ResponseWriter.WriteHeader(w.status)
+	w.ResponseWriter.Write(injected)
+}
+
+// Allows `http.ResponseController` to reach the underlying writer.
+func (w *liveReloadWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,299 @@
+// Copyright (c) 2024 Evan Overman (https://an-prata.it).
+// Licensed under the MIT License.
+// See LICENSE file in repository root for complete license text.
+
+package client
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/an-prata/webby/daemon"
+	"github.com/an-prata/webby/logger"
+	"github.com/an-prata/webby/server"
+)
+
+// Flags filtering the messages shown by `ShowLog`, see `LogFilter`.
+const (
+	LogLevel  = "level"
+	LogSince  = "since"
+	LogUntil  = "until"
+	LogGrep   = "grep"
+	LogTail   = "tail"
+	LogFollow = "f"
+)
+
+// Time waited between reads of the log file for new messages when following it.
+const followInterval = 250 * time.Millisecond
+
+// Filters the messages shown by `ShowLogFile()`.
+type LogFilter struct {
+	// Levels of the messages shown, e.g. `logger.Err | logger.Warn`.
+	Levels logger.LogLevel
+
+	// Messages logged before `Since` or after `Until` are not shown, either may be
+	// zero to show messages regardless.
+	Since time.Time
+	Until time.Time
+
+	// Only messages matching are shown, all of them if nil.
+	Grep *regexp.Regexp
+
+	// Only the last this many messages matching are shown, all of them if zero
+	// or less.
+	Tail int
+
+	// Keep showing messages matching as they are logged.
+	Follow bool
+}
+
+// Creates a filter from the values of the flags given to `ShowLog`. The level is
+// given as to `logger.LevelFromString()`, showing messages of that level and
+// those more severe, or all messages if empty. Times are given as described by
+// `parseLogTime()`, and may be empty.
+func NewLogFilter(level, since, until, grep string, tail int, follow bool) (LogFilter, error) {
+	filter := LogFilter{Levels: logger.All, Tail: tail, Follow: follow}
+	var err error
+
+	if level != "" {
+		if filter.Levels, err = logger.LevelFromString(level); err != nil {
+			return filter, errors.New("Unknown log level '" + level + "'")
+		}
+	}
+
+	if since != "" {
+		if filter.Since, err = parseLogTime(since); err != nil {
+			return filter, err
+		}
+	}
+
+	if until != "" {
+		if filter.Until, err = parseLogTime(until); err != nil {
+			return filter, err
+		}
+	}
+
+	if grep != "" {
+		if filter.Grep, err = regexp.Compile(grep); err != nil {
+			return filter, errors.New("Could not compile '" + grep + "': " + err.Error())
+		}
+	}
+
+	return filter, nil
+}
+
+// Parses a time given to filter the log, either a duration before now such as
+// "90m", a date and time as RFC 3339, "2006-01-02 15:04:05", "2006-01-02 15:04"
+// or "2006-01-02", or a time of today as "15:04:05" or "15:04". Times without a
+// zone are taken as local.
+func parseLogTime(str string) (time.Time, error) {
+	if ago, err := time.ParseDuration(str); err == nil {
+		return time.Now().Add(-ago), nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, str); err == nil {
+		return t, nil
+	}
+
+	for _, layout := range []string{time.DateTime, "2006-01-02 15:04", time.DateOnly} {
+		if t, err := time.ParseInLocation(layout, str, time.Local); err == nil {
+			return t, nil
+		}
+	}
+
+	year, month, day := time.Now().Date()
+
+	for _, layout := range []string{time.TimeOnly, "15:04"} {
+		if t, err := time.ParseInLocation(layout, str, time.Local); err == nil {
+			return time.Date(year, month, day, t.Hour(), t.Minute(), t.Second(), 0, time.Local), nil
+		}
+	}
+
+	return time.Time{}, errors.New("Could not parse '" + str + "' as a time or duration")
+}
+
+// Gives whether the given message passes the filter.
+func (filter *LogFilter) matches(entry logger.Entry) bool {
+	if filter.Levels&entry.Level == 0 {
+		return false
+	}
+
+	if !filter.Since.IsZero() && entry.Time.Before(filter.Since) {
+		return false
+	}
+
+	if !filter.Until.IsZero() && entry.Time.After(filter.Until) {
+		return false
+	}
+
+	return filter.Grep == nil || filter.Grep.MatchString(strings.Join(entry.Lines, "\n"))
+}
+
+// Groups the lines of a log into messages and prints those passing its filter.
+type logPrinter struct {
+	filter LogFilter
+
+	// Color the level of each message as the live log does.
+	color bool
+
+	// The message being read, nil before the first.
+	entry *logger.Entry
+
+	// Whether the last message finished passed the filter, so that any more of
+	// its lines are shown.
+	shown bool
+
+	// The last messages passing the filter while reading the log to `Tail` it,
+	// nil once they have been printed.
+	tail []logger.Entry
+}
+
+// Reads the server log file from the path given in the config and prints the
+// messages passing the given filter, with their level colored as when the
+// daemon prints them if standard out is a terminal. Lines that do not begin a
+// message, such as those of a stack trace, are shown along with the message
+// before them. When following, the log is read for new messages until
+// interrupted, from its beginning again if it is rotated or truncated.
+func ShowLogFile(filter LogFilter) error {
+	opts, err := server.LoadConfigFromPath(daemon.CONFIG_PATH)
+
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(opts.Log)
+
+	if err != nil {
+		return err
+	}
+
+	defer func() { file.Close() }()
+
+	printer := logPrinter{filter: filter}
+
+	if info, err := os.Stdout.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		printer.color = true
+	}
+
+	if filter.Tail > 0 {
+		printer.tail = []logger.Entry{}
+	}
+
+	reader := bufio.NewReader(file)
+	var read int64
+	partial := ""
+
+	for {
+		line, err := reader.ReadString('\n')
+		read += int64(len(line))
+
+		if err == nil {
+			printer.line(partial + strings.TrimSuffix(line, "\n"))
+			partial = ""
+			continue
+		}
+
+		if err != io.EOF {
+			return err
+		}
+
+		// While following, a line is not finished until its newline is written.
+		partial += line
+
+		if !filter.Follow && partial != "" {
+			printer.line(partial)
+		}
+
+		printer.finish()
+		printer.printTail()
+
+		if !filter.Follow {
+			return nil
+		}
+
+		time.Sleep(followInterval)
+		info, statErr := os.Stat(opts.Log)
+		current, currentErr := file.Stat()
+
+		if statErr != nil || currentErr != nil {
+			continue
+		}
+
+		if !os.SameFile(info, current) || info.Size() < read {
+			file.Close()
+
+			if file, err = os.Open(opts.Log); err != nil {
+				return err
+			}
+
+			reader.Reset(file)
+			read = 0
+			partial = ""
+		}
+	}
+}
+
+// Handles a line of the log, beginning a new message or continuing the last.
+func (printer *logPrinter) line(line string) {
+	if entry, ok := logger.ParseLine(line); ok {
+		printer.finish()
+		printer.entry = &entry
+		return
+	}
+
+	if printer.entry != nil {
+		printer.entry.Lines = append(printer.entry.Lines, line)
+	} else if printer.shown {
+		os.Stdout.WriteString(line + "\n")
+	}
+}
+
+// Prints the message being read if it passes the filter, or keeps it for the
+// tail of the log.
+func (printer *logPrinter) finish() {
+	if printer.entry == nil {
+		return
+	}
+
+	entry := *printer.entry
+	printer.entry = nil
+	printer.shown = printer.filter.matches(entry)
+
+	if !printer.shown {
+		return
+	}
+
+	if printer.tail == nil {
+		printer.print(entry)
+		return
+	}
+
+	printer.tail = append(printer.tail, entry)
+
+	if len(printer.tail) > printer.filter.Tail {
+		printer.tail = printer.tail[1:]
+	}
+}
+
+// Prints the messages kept for the tail of the log, if they have not been.
+func (printer *logPrinter) printTail() {
+	for _, entry := range printer.tail {
+		printer.print(entry)
+	}
+
+	printer.tail = nil
+}
+
+// Prints the given message, colored if the printer colors them.
+func (printer *logPrinter) print(entry logger.Entry) {
+	if printer.color {
+		os.Stdout.WriteString(entry.Colored() + "\n")
+	} else {
+		os.Stdout.WriteString(strings.Join(entry.Lines, "\n") + "\n")
+	}
+}
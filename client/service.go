@@ -0,0 +1,168 @@
+// Copyright (c) 2024 Evan Overman (https://an-prata.it).
+// Licensed under the MIT License.
+// See LICENSE file in repository root for complete license text.
+
+package client
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/an-prata/webby/daemon"
+	"github.com/an-prata/webby/logger"
+	"github.com/an-prata/webby/server"
+)
+
+const (
+	// Installs webby as a hardened systemd service, see `InstallSystemdService()`.
+	InstallService = "install-service"
+
+	// The system user the installed service runs as.
+	ServiceUser = "webby"
+
+	// Where the systemd unit is written by `InstallSystemdService()`.
+	ServiceUnitPath = "/etc/systemd/system/webby.service"
+)
+
+// Directories owned by the service user, which the service may write to.
+var serviceDirs = []string{"/srv/webby", "/srv/webby/website", "/var/cache/webby"}
+
+// Sets webby up as a systemd service: creates the service user, its
+// directories, and a default config if there is none, writes a hardened unit
+// running the current executable, and then enables and starts the service.
+// Must be run as root.
+func InstallSystemdService(log *logger.Log) error {
+	if os.Geteuid() != 0 {
+		return errors.New("Installing the service requires root privileges")
+	}
+
+	executable, err := os.Executable()
+
+	if err != nil {
+		return errors.New("Could not find the webby executable: " + err.Error())
+	}
+
+	serviceUser, err := user.Lookup(ServiceUser)
+
+	if err != nil {
+		log.LogInfo("Creating system user '" + ServiceUser + "'...")
+		output, err := exec.Command(
+			"useradd", "--system", "--user-group", "--no-create-home",
+			"--home-dir", "/srv/webby", "--shell", "/usr/sbin/nologin", ServiceUser,
+		).CombinedOutput()
+
+		if err != nil {
+			return errors.New("Could not create user '" + ServiceUser + "': " + strings.TrimSpace(string(output)))
+		}
+
+		if serviceUser, err = user.Lookup(ServiceUser); err != nil {
+			return errors.New("Could not find user '" + ServiceUser + "' after creating it")
+		}
+	}
+
+	uid, err := strconv.Atoi(serviceUser.Uid)
+
+	if err != nil {
+		return errors.New("Could not parse UID from '" + serviceUser.Uid + "'")
+	}
+
+	gid, err := strconv.Atoi(serviceUser.Gid)
+
+	if err != nil {
+		return errors.New("Could not parse GID from '" + serviceUser.Gid + "'")
+	}
+
+	for _, dir := range serviceDirs {
+		log.LogInfo("Creating '" + dir + "'...")
+
+		if err = os.MkdirAll(dir, 0755); err != nil {
+			return errors.New("Could not create '" + dir + "': " + err.Error())
+		}
+
+		if err = os.Chown(dir, uid, gid); err != nil {
+			return errors.New("Could not change owner of '" + dir + "': " + err.Error())
+		}
+	}
+
+	configDir := filepath.Dir(daemon.CONFIG_PATH)
+
+	if _, err = os.Stat(daemon.CONFIG_PATH); err != nil {
+		log.LogInfo("Writing default config to '" + daemon.CONFIG_PATH + "'...")
+
+		if err = os.MkdirAll(configDir, 0755); err != nil {
+			return errors.New("Could not create '" + configDir + "': " + err.Error())
+		}
+
+		config := server.DefaultOptions()
+
+		if err = config.WriteToFile(daemon.CONFIG_PATH, server.ConfigFormatJson); err != nil {
+			return err
+		}
+	}
+
+	// The daemon writes options changed with '-config-set' back to its config.
+	for _, path := range []string{configDir, daemon.CONFIG_PATH} {
+		if err = os.Chown(path, uid, gid); err != nil {
+			return errors.New("Could not change owner of '" + path + "': " + err.Error())
+		}
+	}
+
+	log.LogInfo("Writing systemd unit to '" + ServiceUnitPath + "'...")
+
+	if err = os.WriteFile(ServiceUnitPath, []byte(serviceUnit(executable)), 0644); err != nil {
+		return errors.New("Could not write '" + ServiceUnitPath + "': " + err.Error())
+	}
+
+	for _, args := range [][]string{{"daemon-reload"}, {"enable", "--now", "webby.service"}} {
+		log.LogInfo("Running 'systemctl " + strings.Join(args, " ") + "'...")
+		output, err := exec.Command("systemctl", args...).CombinedOutput()
+
+		if err != nil {
+			return errors.New("Could not run 'systemctl " + strings.Join(args, " ") + "': " + strings.TrimSpace(string(output)))
+		}
+	}
+
+	return nil
+}
+
+// Gives a systemd unit running the given executable as the service user with
+// the filesystem read only but for its own directories and config, and only the
+// privilege of binding to ports below 1024.
+func serviceUnit(executable string) string {
+	return `[Unit]
+Description=webby
+Wants=network-online.target
+After=network-online.target
+
+[Service]
+Type=notify
+WatchdogSec=30
+ExecStart=` + executable + ` -daemon
+User=` + ServiceUser + `
+Group=` + ServiceUser + `
+Restart=on-failure
+RuntimeDirectory=` + filepath.Base(filepath.Dir(daemon.SocketPath)) + `
+AmbientCapabilities=CAP_NET_BIND_SERVICE
+CapabilityBoundingSet=CAP_NET_BIND_SERVICE
+NoNewPrivileges=true
+ProtectSystem=strict
+ReadWritePaths=` + strings.Join(append(serviceDirs, filepath.Dir(daemon.CONFIG_PATH)), " ") + `
+ProtectHome=true
+PrivateTmp=true
+PrivateDevices=true
+ProtectKernelTunables=true
+ProtectKernelModules=true
+ProtectControlGroups=true
+RestrictAddressFamilies=AF_UNIX AF_INET AF_INET6
+RestrictNamespaces=true
+LockPersonality=true
+
+[Install]
+WantedBy=multi-user.target
+`
+}
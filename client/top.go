@@ -0,0 +1,125 @@
+// Copyright (c) 2024 Evan Overman (https://an-prata.it).
+// Licensed under the MIT License.
+// See LICENSE file in repository root for complete license text.
+
+package client
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/an-prata/webby/daemon"
+	"github.com/an-prata/webby/logger"
+	"github.com/an-prata/webby/server"
+)
+
+// Shows a live view of the daemon's request stats.
+const Top = "top"
+
+// Moves the cursor to the top left of the terminal and clears it.
+const clearTerminal = "\033[H\033[2J"
+
+// Subscribes to the daemon's stats stream through the given socket and renders
+// each update in place until the daemon closes the stream, or gives no update
+// within the given timeout. Only the named server instance is shown unless the
+// instance name is empty. Gives the exit code of the client.
+func ShowTop(socket net.Conn, log *logger.Log, instance string, timeout time.Duration) int {
+	daemon.WriteCommand(socket, daemon.InstanceCommand(daemon.Stats, instance), 0)
+	socket.SetReadDeadline(time.Now().Add(timeout))
+
+	reader := bufio.NewReader(socket)
+	success, err := reader.ReadByte()
+
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		log.LogErr("Timed out waiting for webby to respond")
+		return daemon.ExitTimeout
+	}
+
+	if err == nil && daemon.DaemonCommandSuccess(success) == daemon.Refused {
+		log.LogErr("webby's daemon refused the connection, you may need elevated privileges or to be in its 'ControlGroup'")
+		return daemon.ExitRefused
+	}
+
+	if err != nil || daemon.DaemonCommandSuccess(success) != daemon.Success {
+		log.LogErr("Could not get stats from webby")
+		return daemon.ExitFailure
+	}
+
+	var last map[string]server.Stats
+	var lastTime time.Time
+
+	for {
+		socket.SetReadDeadline(time.Now().Add(timeout + daemon.StreamInterval))
+		line, err := reader.ReadBytes('\n')
+
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			log.LogErr("Timed out waiting for webby to update the stats stream")
+			return daemon.ExitTimeout
+		}
+
+		if err != nil {
+			log.LogErr("webby closed the stats stream")
+			return daemon.ExitSuccess
+		}
+
+		var stats map[string]server.Stats
+
+		if err = json.Unmarshal(line, &stats); err != nil {
+			log.LogErr("Could not decode stats from webby: " + err.Error())
+			return daemon.ExitFailure
+		}
+
+		now := time.Now()
+		fmt.Print(clearTerminal + renderTop(stats, last, now.Sub(lastTime)))
+		last = stats
+		lastTime = now
+	}
+}
+
+// Renders the stats of each server instance, giving request rates from the
+// difference with the previous stats over the given time.
+func renderTop(stats, last map[string]server.Stats, elapsed time.Duration) string {
+	var builder strings.Builder
+	builder.WriteString("webby top - " + time.Now().Format(time.UnixDate) + "\n")
+
+	names := []string{}
+
+	for name := range stats {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		s := stats[name]
+		rate := 0.0
+
+		if previous, ok := last[name]; ok && elapsed > 0 && s.Requests >= previous.Requests {
+			rate = float64(s.Requests-previous.Requests) / elapsed.Seconds()
+		}
+
+		builder.WriteString(fmt.Sprintf(
+			"\n[%s]\nRequests: %d total, %.1f/s    Connections: %d\n\n",
+			name, s.Requests, rate, s.ActiveConnections,
+		))
+
+		builder.WriteString(fmt.Sprintf("%10s  %s\n", "Requests", "Top paths"))
+
+		for _, p := range s.TopPaths {
+			builder.WriteString(fmt.Sprintf("%10d  %s\n", p.Count, p.Path))
+		}
+
+		builder.WriteString(fmt.Sprintf("\n%-8s  %6s  %-40s  %s\n", "Time", "Status", "Client", "Recent errors"))
+
+		for _, e := range s.RecentErrors {
+			builder.WriteString(fmt.Sprintf("%-8s  %6d  %-40s  %s\n", e.Time.Local().Format("15:04:05"), e.Status, e.Client, e.Path))
+		}
+	}
+
+	return builder.String()
+}
@@ -0,0 +1,238 @@
+// Copyright (c) 2024 Evan Overman (https://an-prata.it).
+// Licensed under the MIT License.
+// See LICENSE file in repository root for complete license text.
+
+package daemon
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"io"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"strings"
+	"time"
+
+	"github.com/an-prata/webby/logger"
+	"github.com/an-prata/webby/server"
+)
+
+// URL path prefix of the admin API, followed by the name of a daemon command,
+// e.g. "/v1/status".
+const adminPrefix = "/v1/"
+
+// Largest request body read by the admin API, for the text of text queries.
+const adminMaxBodyBytes = 64 << 10
+
+// Time given to admin API requests to finish when the daemon stops or reloads.
+const adminShutdownTimeout = 5 * time.Second
+
+// A response of the admin API.
+type AdminResponse struct {
+	Command string
+
+	// Whether the command succeeded, and the byte it responded with over the
+	// socket, e.g. a `WebbyStatus` for the status command.
+	Success bool
+	Status  DaemonCommandSuccess
+
+	// What the command responded with, as JSON if it responded with JSON and as
+	// a string otherwise, or null if it responded with nothing.
+	Result json.RawMessage
+}
+
+// Serves the daemon's commands over HTTP at the given address for tooling that
+// cannot reach the Unix Domain Socket, running them as the given listener
+// would. Every request must give the token as "Authorization: Bearer <token>".
+// With profiling, `net/http/pprof` is served under "/debug/pprof/" and `expvar`
+// at "/debug/vars" as well.
+// Queries and streams are run for GET requests and commands and text queries
+// for POST requests, given a server instance by the "instance" query parameter,
+// the text of a text query as the body, a log level by the "level" query
+// parameter, and the argument of commands such as config-set by "persist=true".
+// Streams respond with only their current value. Gives a function that stops
+// serving.
+func StartAdminApi(address, token string, profiling bool, listener *DaemonListener) (func(), error) {
+	if token == "" {
+		return nil, errors.New("Refusing to serve the admin API at '" + address + "' without an 'AdminToken'")
+	}
+
+	socket, err := net.Listen("tcp", address)
+
+	if err != nil {
+		return nil, errors.New("Could not listen for the admin API on '" + address + "': " + err.Error())
+	}
+
+	// Profiles are served from a mux of their own rather than the default one,
+	// which imports may add handlers to.
+	debug := http.NewServeMux()
+	debug.HandleFunc("/debug/pprof/", pprof.Index)
+	debug.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	debug.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	debug.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	debug.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	debug.Handle("/debug/vars", expvar.Handler())
+
+	srv := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if !authorizeAdmin(w, req, token) {
+				return
+			}
+
+			if profiling && strings.HasPrefix(req.URL.Path, "/debug/") {
+				debug.ServeHTTP(w, req)
+				return
+			}
+
+			serveAdmin(w, req, listener)
+		}),
+		ReadHeaderTimeout: 10 * time.Second,
+		ErrorLog:          logger.GlobalLog.StdLogger(logger.Warn),
+	}
+
+	server.Supervise("admin API", func() error {
+		if err := srv.Serve(socket); err != nil && err != http.ErrServerClosed {
+			return err
+		}
+
+		return nil
+	})
+
+	if profiling {
+		logger.GlobalLog.LogInfo("Serving the admin API with profiling on '" + address + "'")
+	} else {
+		logger.GlobalLog.LogInfo("Serving the admin API on '" + address + "'")
+	}
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), adminShutdownTimeout)
+		defer cancel()
+		srv.Shutdown(ctx)
+	}, nil
+}
+
+// Returns true if the request gives the admin API's token, otherwise responding
+// with 401 Unauthorized.
+func authorizeAdmin(w http.ResponseWriter, req *http.Request, token string) bool {
+	given, _ := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+
+	if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+		logger.GlobalLog.LogWarn("Refused admin API request without a valid token from " + req.RemoteAddr)
+		w.Header().Set("WWW-Authenticate", `Bearer realm="webby"`)
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return false
+	}
+
+	return true
+}
+
+// Runs the command requested of the admin API, responding with an
+// `AdminResponse` as JSON.
+func serveAdmin(w http.ResponseWriter, req *http.Request, listener *DaemonListener) {
+	name, found := strings.CutPrefix(req.URL.Path, adminPrefix)
+
+	if !found || name == "" {
+		http.Error(w, "Expected a command under '"+adminPrefix+"'", http.StatusNotFound)
+		return
+	}
+
+	query := req.URL.Query()
+	command := InstanceCommand(DaemonCommand(name), query.Get(Instance))
+	var arg DaemonCommandArg
+
+	if query.Get("persist") == "true" {
+		arg = 1
+	}
+
+	if level := query.Get("level"); level != "" {
+		logLevel, err := logger.LevelFromString(level)
+
+		if err != nil {
+			http.Error(w, "Unknown log level '"+level+"'", http.StatusBadRequest)
+			return
+		}
+
+		arg = DaemonCommandArg(logLevel)
+	}
+
+	logger.GlobalLog.LogInfo("Admin API request from " + req.RemoteAddr + " for '" + string(command) + "'")
+
+	var ret DaemonCommandSuccess
+	var result string
+
+	switch req.Method {
+	case http.MethodGet:
+		if command == Hello {
+			ret, result = Success, string(listener.hello())
+		} else if query, ok := listener.queries[command]; ok {
+			ret, result = query(arg)
+		} else if stream, ok := listener.streams[command]; ok {
+			ret, result = Success, stream(arg)
+		} else {
+			adminMethodError(w, listener, command)
+			return
+		}
+
+	case http.MethodPost:
+		if textQuery, ok := listener.textQueries[command]; ok {
+			body, err := io.ReadAll(http.MaxBytesReader(w, req.Body, adminMaxBodyBytes))
+
+			if err != nil {
+				http.Error(w, "Could not read request body", http.StatusBadRequest)
+				return
+			}
+
+			ret, result = textQuery(strings.TrimSpace(string(body)), arg)
+		} else if callback, ok := listener.callbacks[command]; ok {
+			ret = callback(arg)
+		} else {
+			adminMethodError(w, listener, command)
+			return
+		}
+
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	response := AdminResponse{string(command), ret == Success, ret, nil}
+	result = strings.TrimSpace(result)
+
+	if json.Valid([]byte(result)) {
+		response.Result = json.RawMessage(result)
+	} else if result != "" {
+		response.Result, _ = json.Marshal(result)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+
+	if !response.Success {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
+// Responds that the command is run by the other method, or is not known at all.
+func adminMethodError(w http.ResponseWriter, listener *DaemonListener, command DaemonCommand) {
+	if _, ok := listener.queries[command]; ok {
+		w.Header().Set("Allow", http.MethodGet)
+	} else if _, ok := listener.streams[command]; ok {
+		w.Header().Set("Allow", http.MethodGet)
+	} else if _, ok := listener.textQueries[command]; ok {
+		w.Header().Set("Allow", http.MethodPost)
+	} else if _, ok := listener.callbacks[command]; ok {
+		w.Header().Set("Allow", http.MethodPost)
+	} else {
+		http.Error(w, "Unknown command '"+string(command)+"'", http.StatusNotFound)
+		return
+	}
+
+	http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+}
@@ -5,8 +5,21 @@
 package daemon
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/json"
+	"io"
+	"net"
 	"net/http"
 	"os"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/an-prata/webby/logger"
 	"github.com/an-prata/webby/server"
@@ -29,24 +42,80 @@ const (
 
 	// The daemon command failed.
 	Failure
+
+	// The daemon does not know the command, likely due to a client newer than the
+	// daemon. Followed by text describing the error.
+	UnknownCommand DaemonCommandSuccess = 0xff
+
+	// The daemon refused the connection before reading a command, as its peer
+	// could not be identified or may not send commands.
+	Refused DaemonCommandSuccess = 0xfe
 )
 
+// The version of the protocol spoken over the control socket, increased
+// whenever existing commands change incompatibly. Version 2 frames commands
+// with their length, see `WriteCommand()`.
+const ProtocolVersion = 2
+
+// The capabilities of the daemon given as JSON in response to the hello
+// command.
+type HelloResponse struct {
+	ProtocolVersion int
+	Version         string
+
+	// Every command the daemon accepts, including those directed at a single
+	// server instance.
+	Commands []string
+}
+
 // Represents the status returned by the status callback
 type WebbyStatus uint8
 
 const (
 	// We make sure that the first bit can be compared the same way it could on the
-	// original success constants.
+	// original success constants. More severe statuses have greater values.
 
 	Ok              WebbyStatus = WebbyStatus(Success)                     // All gets gave 200
 	HttpNon2xx      WebbyStatus = WebbyStatus(Failure) | ((iota + 1) << 1) // Not every get gave 200
 	HttpPartialFail                                                        // Some gets gave code >= 400
 	HttpFail                                                               // All gets gave code >= 400
+	ServerDown                                                             // HTTP server is not running
 )
 
+func (s WebbyStatus) String() string {
+	switch s {
+	case Ok:
+		return "OK"
+	case HttpNon2xx:
+		return "HttpNon2xx"
+	case HttpPartialFail:
+		return "HttpPartialFail"
+	case HttpFail:
+		return "HttpFail"
+	case ServerDown:
+		return "ServerDown"
+	}
+
+	return "Unknown"
+}
+
 // Type alias for the function signature of a daemon command callback.
 type DaemonCommandCallback func(DaemonCommandArg) DaemonCommandSuccess
 
+// Type alias for the function signature of a daemon query callback. Queries are
+// commands that respond with text following their success byte.
+type DaemonQueryCallback func(DaemonCommandArg) (DaemonCommandSuccess, string)
+
+// Type alias for the function signature of a daemon text query callback. Text
+// queries are queries that are also given the text following their command, see
+// `TextCommand()`.
+type DaemonTextQueryCallback func(string, DaemonCommandArg) (DaemonCommandSuccess, string)
+
+// Type alias for the function signature of a daemon stream callback. Streams
+// respond with the text given by their callback every `StreamInterval` until
+// the client disconnects.
+type DaemonStreamCallback func(DaemonCommandArg) string
+
 // Represents a signal originating at a daemon command and sent through a
 // channel by the reload callback.
 type ReloadSignal struct{}
@@ -67,24 +136,65 @@ func (r StopSignal) String() string {
 
 func (r StopSignal) Signal() {}
 
-// Returns a function that will sent the `server.Restart` constant through the
-// given channel when called.
-func GetRestartCallback(serverCommandChan chan server.ServerThreadCommand) DaemonCommandCallback {
+// Represents a server instance failing to start, e.g. as its port is taken, sent
+// through a channel so that the daemon stops and exits with a failure.
+type StartFailureSignal struct {
+	Instance string
+	Err      error
+}
+
+func (r StartFailureSignal) String() string {
+	return "Server instance '" + r.Instance + "' failed to start: " + r.Err.Error()
+}
+
+func (r StartFailureSignal) Signal() {}
+
+// Returns a function that will sent the `server.Restart` constant through each
+// of the given channels when called.
+func GetRestartCallback(serverCommandChans ...chan server.ServerThreadCommand) DaemonCommandCallback {
 	return func(_ DaemonCommandArg) DaemonCommandSuccess {
-		serverCommandChan <- server.Restart
+		for _, serverCommandChan := range serverCommandChans {
+			serverCommandChan <- server.Restart
+		}
+
 		return Success
 	}
 }
 
 // Returns a function that will send a `ReloadSignal` though the given channel
-// when called.
+// when called, failing instead if the config has problems.
 func GetReloadCallback(signalChan chan os.Signal) DaemonCommandCallback {
 	return func(_ DaemonCommandArg) DaemonCommandSuccess {
+		if !checkConfig() {
+			return Failure
+		}
+
 		signalChan <- ReloadSignal{}
 		return Success
 	}
 }
 
+// Returns a function that reloads the certificates of each of the given servers
+// that serves HTTPS, failing if any of them could not be reloaded.
+func GetReloadCertsCallback(servers ...*server.Server) DaemonCommandCallback {
+	return func(_ DaemonCommandArg) DaemonCommandSuccess {
+		ret := Success
+
+		for _, srv := range servers {
+			if len(srv.CertificatePaths()) == 0 {
+				continue
+			}
+
+			if err := srv.ReloadCertificates(); err != nil {
+				logger.GlobalLog.LogErr(err.Error())
+				ret = Failure
+			}
+		}
+
+		return ret
+	}
+}
+
 // Returns a function that will send a `StopSignal` through the given channel
 // when called.
 func GetStopCallback(signalChan chan os.Signal) DaemonCommandCallback {
@@ -94,53 +204,485 @@ func GetStopCallback(signalChan chan os.Signal) DaemonCommandCallback {
 	}
 }
 
-// Returns a function that simply returns `Success` when called. If callbacks
-// are being called and the daemon can give the success message to a connection
-// then we consider this to be "ok" on webby's side.
-func GetStatusCallback(handler *server.Handler) DaemonCommandCallback {
-	return func(_ DaemonCommandArg) DaemonCommandSuccess {
-		getsFailed := 0
-		getsNot200 := 0
+// The result of a single GET request made for a status check.
+type PathCheck struct {
+	Url string
+
+	// The status code responded with, zero if the request failed.
+	Code int
+
+	// Why the request failed, empty if it did not, including when it served
+	// different content than its file for deep checks.
+	Error string
+
+	// Milliseconds until the response began.
+	LatencyMs float64
+}
+
+// A certificate served over HTTPS, checked by deep status checks.
+type CertificateCheck struct {
+	Path    string
+	Expires time.Time
 
+	// Whether the certificate has expired or expires within
+	// `server.CertExpiryWarning`, empty if neither.
+	Error string
+}
+
+// The detailed status of a single server instance, see `StatusReport`.
+type InstanceStatus struct {
+	Status            string
+	Degraded          bool
+	MappedPaths       int
+	Requests          int64
+	Errors            int64
+	Panics            int64
+	ActiveConnections int64
+	Checks            []PathCheck
+
+	// The slowest checks, slowest first.
+	Slowest []PathCheck
+
+	// Certificates served, only given by deep status checks.
+	Certificates []CertificateCheck
+
+	// A summary of recent automatic status checks, nil without them.
+	Health *HealthSummary
+}
+
+// The detailed status of the daemon given as JSON following the status byte of
+// the status command.
+type StatusReport struct {
+	Status     string
+	Version    string
+	ConfigPath string
+
+	// Seconds since the daemon started.
+	Uptime float64
+
+	// Memory obtained from the operating system and allocated on the heap, in
+	// bytes.
+	MemoryBytes uint64
+	HeapBytes   uint64
+
+	Instances map[string]InstanceStatus
+}
+
+// The time the daemon process started, for reporting uptime.
+var startTime = time.Now()
+
+// Time given to each request of a status check, so that a server that accepts
+// connections but never responds fails its checks rather than hanging them.
+const statusTimeout = 10 * time.Second
+
+// Most requests a status check makes at once.
+const statusConcurrency = 8
+
+// Number of the slowest checks given in a status report.
+const statusSlowest = 5
+
+// Client used for the external URLs of status checks.
+var statusClient = &http.Client{Timeout: statusTimeout}
+
+// Gives the base URL that the given server's own paths are checked at, over
+// HTTPS if it is served and plain HTTP otherwise, on its configured port. Gives
+// false if neither is served.
+func localStatusUrl(opts server.ServerOptions) (string, bool) {
+	scheme := "https"
+	addr, ok := opts.HttpsAddr()
+
+	if !ok {
+		scheme = "http"
+		addr, ok = opts.HttpAddr()
+	}
+
+	if !ok {
+		return "", false
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+
+	if err != nil {
+		return "", false
+	}
+
+	// Servers bound to every interface are reached over loopback.
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "localhost"
+	}
+
+	switch port {
+	case "http":
+		port = "80"
+	case "https":
+		port = "443"
+	}
+
+	return scheme + "://" + net.JoinHostPort(host, port), true
+}
+
+// Makes HTTP GET requests to every path hosted by the given server, other than
+// those needing a login, client certificate, or signature, as well as each of
+// the given external URLs, and gives a `WebbyStatus` according to their
+// responses along with the result of each request, `Ok` if there were none. External URLs allow for
+// checking the whole serving chain (e.g. a public domain or CDN) rather than
+// just localhost. The server's own paths are requested on its configured port,
+// over HTTPS if it serves it, and for its `CanonicalHost` if it has one, without
+// verifying its certificate since it is likely not issued for localhost.
+// Requests are made concurrently. With `DeepStatusChecks` each path must also
+// serve the same content as its file, and the server's certificates are given
+// and checked for expiry. If the server is degraded then no requests are made.
+func checkStatus(srv *server.Server, externalUrls []string) (WebbyStatus, []PathCheck, []CertificateCheck) {
+	if srv.Degraded() {
+		logger.GlobalLog.LogErr("HTTP server is not running")
+		logger.GlobalLog.LogInfo("Status requested, giving 'ServerDown'")
+		return ServerDown, []PathCheck{}, []CertificateCheck{}
+	}
+
+	handler := srv.Handler()
+	opts := srv.Options()
+	getsFailed := 0
+	getsNot200 := 0
+
+	// A client of its own lets the certificate for `CanonicalHost` be asked for,
+	// and its connections are reused between the requests of this check alone.
+	localClient := &http.Client{
+		Timeout: statusTimeout,
+		Transport: &http.Transport{
+			TLSClientConfig:     &tls.Config{InsecureSkipVerify: true, ServerName: opts.CanonicalHost},
+			MaxIdleConnsPerHost: statusConcurrency,
+		},
+	}
+
+	defer localClient.CloseIdleConnections()
+
+	urls := make([]string, 0, len(handler.ValidPaths)+len(externalUrls))
+	paths := []string{}
+
+	// Paths behind a login, client certificate, or signature refuse requests
+	// without one, which would otherwise count as failures.
+	if base, ok := localStatusUrl(opts); ok {
 		for _, path := range handler.ValidPaths {
-			response, err := http.Get("http://localhost" + path)
+			if !opts.Protects(path) {
+				paths = append(paths, path)
+				urls = append(urls, base+path)
+			}
+		}
+	}
+
+	local := len(urls)
+
+	urls = append(urls, externalUrls...)
+	checks := make([]PathCheck, len(urls))
+	indices := make(chan int)
+	var wg sync.WaitGroup
+
+	for worker := 0; worker < statusConcurrency; worker++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for i := range indices {
+				if i >= local {
+					checks[i] = getStatus(statusClient, urls[i], "", nil, 0)
+					continue
+				}
+
+				var digest []byte
+				var size int64
+
+				if opts.DeepStatusChecks {
+					digest, size, _ = handler.FileDigest(paths[i])
+				}
+
+				checks[i] = getStatus(localClient, urls[i], opts.CanonicalHost, digest, size)
+			}
+		}()
+	}
+
+	for i := range urls {
+		indices <- i
+	}
+
+	close(indices)
+	wg.Wait()
+
+	for _, check := range checks {
+		if check.Error != "" {
+			getsFailed++
+			continue
+		}
+
+		// Paths are expected to be unavailable during maintenance.
+		if check.Code == http.StatusServiceUnavailable && handler.Maintenance() {
+			continue
+		}
+
+		if check.Code >= 400 {
+			getsFailed++
+		}
+
+		if check.Code != 200 {
+			getsNot200++
+		}
+	}
+
+	status, certificates := Ok, []CertificateCheck{}
+
+	if opts.DeepStatusChecks {
+		status, certificates = checkCertificates(srv)
+	}
+
+	if len(urls) == 0 {
+		logger.GlobalLog.LogInfo("No paths or URLs to request for status check")
+	} else if getsFailed >= len(urls) {
+		logger.GlobalLog.LogErr("All HTTP requests made for status check failed")
+		logger.GlobalLog.LogInfo("Status requested, giving 'HttpFail'")
+		return HttpFail, checks, certificates
+	}
+
+	if getsFailed > 1 && status < HttpPartialFail {
+		logger.GlobalLog.LogErr("Some HTTP requests made for status check failed")
+		status = HttpPartialFail
+	}
+
+	if getsNot200 > 1 && status < HttpNon2xx {
+		logger.GlobalLog.LogWarn("Some HTTP requests made for status check gave code other that '200'")
+		status = HttpNon2xx
+	}
+
+	logger.GlobalLog.LogInfo("Status requested, giving '" + status.String() + "'")
+	return status, checks, certificates
+}
+
+// Makes a GET request to the given URL for the given host, or the host of the
+// URL if empty, giving its status code and latency or why it failed. Given a
+// digest, a 200 response must have the given size and SHA-256 digest.
+func getStatus(client *http.Client, url, host string, digest []byte, size int64) PathCheck {
+	check := PathCheck{Url: url}
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+
+	if err == nil {
+		if host != "" {
+			req.Host = host
+		}
+
+		// The response is compared unencoded with its file.
+		if digest != nil {
+			req.Header.Set("Accept-Encoding", "identity")
+		}
+
+		start := time.Now()
+		var response *http.Response
+
+		if response, err = client.Do(req); err == nil {
+			defer response.Body.Close()
+			check.Code = response.StatusCode
+			check.LatencyMs = float64(time.Since(start).Microseconds()) / 1000
+
+			if digest == nil || response.StatusCode != http.StatusOK {
+				return check
+			}
+
+			hash := sha256.New()
+			read, err := io.Copy(hash, response.Body)
 
 			if err != nil {
-				logger.GlobalLog.LogErr(err.Error())
-				logger.GlobalLog.LogErr("Could not make GET request to path '" + path + "'")
-				getsFailed++
-				continue
+				check.Error = "Could not read response: " + err.Error()
+			} else if read != size || !bytes.Equal(hash.Sum(nil), digest) {
+				check.Error = "Served " + strconv.FormatInt(read, 10) + " bytes that differ from its file of " + strconv.FormatInt(size, 10) + " bytes"
 			}
 
-			if response.StatusCode >= 400 {
-				getsFailed++
+			if check.Error != "" {
+				logger.GlobalLog.LogErr("Status check of '" + url + "' failed: " + check.Error)
 			}
 
-			if response.StatusCode != 200 {
-				getsNot200++
+			return check
+		}
+	}
+
+	logger.GlobalLog.LogErr(err.Error())
+	logger.GlobalLog.LogErr("Could not make GET request to '" + url + "'")
+	check.Error = err.Error()
+	return check
+}
+
+// Gives each certificate the given server serves, along with `HttpPartialFail`
+// if any have expired, `HttpNon2xx` if any expire within
+// `server.CertExpiryWarning`, and `Ok` otherwise. Certificates never give
+// `HttpFail`, as restarting the server would not renew them.
+func checkCertificates(srv *server.Server) (WebbyStatus, []CertificateCheck) {
+	status := Ok
+	certificates := []CertificateCheck{}
+
+	for path, expires := range srv.CertificateExpiries() {
+		check := CertificateCheck{path, expires, ""}
+
+		if time.Now().After(expires) {
+			check.Error = "Expired " + expires.Format(time.RFC3339)
+			status = HttpPartialFail
+		} else if time.Until(expires) < server.CertExpiryWarning {
+			check.Error = "Expires " + expires.Format(time.RFC3339)
+
+			if status < HttpNon2xx {
+				status = HttpNon2xx
 			}
 		}
 
-		if getsFailed >= len(handler.ValidPaths) {
-			logger.GlobalLog.LogErr("All HTTP requests made for status check failed")
-			logger.GlobalLog.LogInfo("Status requested, giving 'HttpFail'")
-			return DaemonCommandSuccess(HttpFail)
+		if check.Error != "" {
+			logger.GlobalLog.LogWarn("Certificate '" + path + "': " + check.Error)
 		}
 
-		if getsFailed > 1 {
-			logger.GlobalLog.LogErr("Some HTTP requests made for status check failed")
-			logger.GlobalLog.LogInfo("Status requested, giving 'HttpPartialFail'")
-			return DaemonCommandSuccess(HttpPartialFail)
+		certificates = append(certificates, check)
+	}
+
+	sort.Slice(certificates, func(i, j int) bool {
+		return certificates[i].Path < certificates[j].Path
+	})
+
+	return status, certificates
+}
+
+// Gives the slowest of the given checks to respond, slowest first.
+func slowestChecks(checks []PathCheck) []PathCheck {
+	slowest := make([]PathCheck, 0, len(checks))
+
+	for _, check := range checks {
+		if check.Code != 0 {
+			slowest = append(slowest, check)
+		}
+	}
+
+	sort.SliceStable(slowest, func(i, j int) bool {
+		return slowest[i].LatencyMs > slowest[j].LatencyMs
+	})
+
+	if len(slowest) > statusSlowest {
+		slowest = slowest[:statusSlowest]
+	}
+
+	return slowest
+}
+
+// Returns a function that checks the status of the given server, see
+// `checkStatus()`, giving only the resulting `WebbyStatus`.
+func GetStatusCallback(srv *server.Server, externalUrls []string) DaemonCommandCallback {
+	return func(_ DaemonCommandArg) DaemonCommandSuccess {
+		status, _, _ := checkStatus(srv, externalUrls)
+		return DaemonCommandSuccess(status)
+	}
+}
+
+// Returns a function that checks the status of each of the given servers, with
+// the external URLs and automatic check history given for their instance name,
+// and gives the most severe of their statuses along with a `StatusReport` as
+// JSON.
+func GetStatusQueryCallback(servers map[string]*server.Server, externalUrls map[string][]string, histories map[string]*HealthHistory) DaemonQueryCallback {
+	return func(_ DaemonCommandArg) (DaemonCommandSuccess, string) {
+		status := Ok
+		instances := map[string]InstanceStatus{}
+
+		for name, srv := range servers {
+			instanceStatus, checks, certificates := checkStatus(srv, externalUrls[name])
+			stats := srv.Handler().Stats()
+
+			if instanceStatus > status {
+				status = instanceStatus
+			}
+
+			var health *HealthSummary
+
+			if history := histories[name]; history != nil {
+				summary := history.Summary()
+				health = &summary
+			}
+
+			instances[name] = InstanceStatus{
+				instanceStatus.String(),
+				srv.Degraded(),
+				len(srv.Handler().ValidPaths),
+				stats.Requests,
+				stats.Errors,
+				stats.Panics,
+				stats.ActiveConnections,
+				checks,
+				slowestChecks(checks),
+				certificates,
+				health,
+			}
 		}
 
-		if getsNot200 > 1 {
-			logger.GlobalLog.LogWarn("Some HTTP requests made for status check gave code other that '200'")
-			logger.GlobalLog.LogInfo("Status requests, giving 'HttpNon2xx'")
-			return DaemonCommandSuccess(HttpNon2xx)
+		var memory runtime.MemStats
+		runtime.ReadMemStats(&memory)
+
+		report, err := json.Marshal(StatusReport{
+			status.String(),
+			Version(),
+			CONFIG_PATH,
+			time.Since(startTime).Seconds(),
+			memory.Sys,
+			memory.HeapAlloc,
+			instances,
+		})
+
+		if err != nil {
+			logger.GlobalLog.LogErr("Could not encode status report: " + err.Error())
+			return DaemonCommandSuccess(status), ""
 		}
 
-		logger.GlobalLog.LogInfo("Status requested, giving 'OK'")
-		return DaemonCommandSuccess(Ok)
+		return DaemonCommandSuccess(status), string(report)
+	}
+}
+
+// Gives the version of webby from its build information, "(devel)" for builds
+// not made from a tagged module version.
+func Version() string {
+	info, ok := debug.ReadBuildInfo()
+
+	if !ok || info.Main.Version == "" {
+		return "(devel)"
+	}
+
+	return info.Main.Version
+}
+
+// Returns a function that calls each of the given status callbacks and gives
+// the most severe of their statuses.
+func GetCombinedStatusCallback(statusCallbacks []DaemonCommandCallback) DaemonCommandCallback {
+	return func(arg DaemonCommandArg) DaemonCommandSuccess {
+		status := Ok
+
+		for _, callback := range statusCallbacks {
+			if s := WebbyStatus(callback(arg)); s > status {
+				status = s
+			}
+		}
+
+		return DaemonCommandSuccess(status)
+	}
+}
+
+// Returns a function that closes and reopens the log file and the access log of
+// each of the given servers, for log rotation.
+func GetRotateLogCallback(servers ...*server.Server) DaemonCommandCallback {
+	return func(_ DaemonCommandArg) DaemonCommandSuccess {
+		ret := Success
+
+		if err := logger.GlobalLog.Reopen(); err != nil {
+			logger.GlobalLog.LogErr(err.Error())
+			ret = Failure
+		}
+
+		for _, srv := range servers {
+			if err := srv.Handler().ReopenAccessLog(); err != nil {
+				logger.GlobalLog.LogErr(err.Error())
+				ret = Failure
+			}
+		}
+
+		logger.GlobalLog.LogInfo("Reopened log files")
+		return ret
 	}
 }
 
@@ -155,7 +697,7 @@ func GetLogPrintCallback() DaemonCommandCallback {
 			logger.GlobalLog.LogWarn("Invalid log level given, using 'All'")
 		}
 
-		logger.GlobalLog.Printing = logLevel
+		logger.GlobalLog.SetPrinting(logLevel)
 		return Success
 	}
 }
@@ -171,7 +713,350 @@ func GetLogRecordCallback() DaemonCommandCallback {
 			logger.GlobalLog.LogWarn("Invalid log level given, using 'All'")
 		}
 
-		logger.GlobalLog.Recording = logLevel
+		logger.GlobalLog.SetRecording(logLevel)
 		return Success
 	}
 }
+
+// Returns a function that gives the quota usage table of each of the given
+// servers, headed by the name of each server instance.
+func GetQuotaQueryCallback(servers map[string]*server.Server) DaemonQueryCallback {
+	return func(_ DaemonCommandArg) (DaemonCommandSuccess, string) {
+		report := ""
+		names := []string{}
+
+		for name := range servers {
+			names = append(names, name)
+		}
+
+		sort.Strings(names)
+
+		for _, name := range names {
+			quotas := servers[name].Handler().QuotaReport()
+
+			if quotas == "" {
+				quotas = "No quotas set\n"
+			}
+
+			report += "[" + name + "]\n" + quotas + "\n"
+		}
+
+		return Success, report
+	}
+}
+
+// Gives the hits of each path served by each of the given servers, by the name
+// of each server instance, as JSON.
+func hitsJson(servers map[string]*server.Server) ([]byte, error) {
+	hits := map[string][]server.PathHits{}
+
+	for name, srv := range servers {
+		hits[name] = srv.Handler().PathHits()
+	}
+
+	return json.Marshal(hits)
+}
+
+// Returns a function that gives the hits of each path served by each of the
+// given servers, by the name of each server instance, as JSON.
+func GetHitsQueryCallback(servers map[string]*server.Server) DaemonQueryCallback {
+	return func(_ DaemonCommandArg) (DaemonCommandSuccess, string) {
+		response, err := hitsJson(servers)
+
+		if err != nil {
+			logger.GlobalLog.LogErr("Could not encode path hits: " + err.Error())
+			return Failure, ""
+		}
+
+		return Success, string(response)
+	}
+}
+
+// Returns a function that gives the routes of each of the given servers, by the
+// name of each server instance, as JSON.
+func GetRoutesQueryCallback(servers map[string]*server.Server) DaemonQueryCallback {
+	return func(_ DaemonCommandArg) (DaemonCommandSuccess, string) {
+		routes := map[string][]server.Route{}
+
+		for name, srv := range servers {
+			routes[name] = srv.Handler().Routes()
+		}
+
+		response, err := json.Marshal(routes)
+
+		if err != nil {
+			logger.GlobalLog.LogErr("Could not encode routes: " + err.Error())
+			return Failure, ""
+		}
+
+		return Success, string(response)
+	}
+}
+
+// Returns a function that gives the stats of each of the given servers, by the
+// name of each server instance, as a single line of JSON.
+func GetStatsStreamCallback(servers map[string]*server.Server) DaemonStreamCallback {
+	return func(_ DaemonCommandArg) string {
+		stats := map[string]server.Stats{}
+
+		for name, srv := range servers {
+			stats[name] = srv.Handler().Stats()
+		}
+
+		line, err := json.Marshal(stats)
+
+		if err != nil {
+			logger.GlobalLog.LogErr("Could not encode server stats: " + err.Error())
+			return ""
+		}
+
+		return string(line) + "\n"
+	}
+}
+
+// Returns a function that gives the value of the option named by its text as
+// JSON. Options are read from the given server if it is not nil, otherwise from
+// the given options.
+func GetConfigGetCallback(opts *server.ServerOptions, srv *server.Server) DaemonTextQueryCallback {
+	return func(key string, _ DaemonCommandArg) (DaemonCommandSuccess, string) {
+		if server.SecretOptions[key] {
+			return Failure, "Option '" + key + "' holds a secret and is not given over the control socket"
+		}
+
+		current := *opts
+
+		if srv != nil {
+			current = srv.Options()
+		}
+
+		value, err := current.GetOption(key)
+
+		if err != nil {
+			return Failure, err.Error()
+		}
+
+		return Success, value
+	}
+}
+
+// Returns a function that sets an option of the running daemon, given its text
+// as the option's name and JSON value seperated by `TextSeparator`. Options
+// that apply to the whole daemon are set on the given options, these may not be
+// given for a single instance. Options of server instances are set on each of
+// the given servers, which are then restarted through their command channels.
+// The change is also written to the config file if given a non-zero argument.
+// Nothing is changed unless the value is accepted for every server and, when
+// asked, persisted.
+//
+// Only the given options are written for a server instance so that the
+// instance keeps any option set from the top level configuration.
+func GetConfigSetCallback(
+	opts *server.ServerOptions,
+	instance string,
+	servers map[string]*server.Server,
+	serverCommandChans map[string]chan server.ServerThreadCommand,
+	setAutoReload func(bool),
+) DaemonTextQueryCallback {
+	return func(text string, arg DaemonCommandArg) (DaemonCommandSuccess, string) {
+		key, value, _ := strings.Cut(text, TextSeparator)
+		restart, ok := server.LiveOptions[key]
+
+		if !ok {
+			return Failure, "Option '" + key + "' cannot be changed while webby is running, edit the config and reload instead"
+		}
+
+		if !restart && instance != "" {
+			return Failure, "Option '" + key + "' applies to the whole daemon, set it without an instance"
+		}
+
+		raw := json.RawMessage(value)
+
+		// Every change is made to copies first, so that a value refused by any
+		// server instance, or one that cannot be persisted, changes none of them.
+		updated := map[string]server.ServerOptions{}
+
+		if restart {
+			for name, srv := range servers {
+				instanceOpts := srv.Options()
+
+				if err := instanceOpts.SetOption(key, raw); err != nil {
+					return Failure, "Could not set '" + key + "' for '" + name + "': " + err.Error()
+				}
+
+				updated[name] = instanceOpts
+			}
+		}
+
+		topLevel := *opts
+
+		if instance == "" {
+			if err := topLevel.SetOption(key, raw); err != nil {
+				return Failure, err.Error()
+			}
+		}
+
+		var level string
+
+		if _, ok := logLevelOptions[key]; ok {
+			json.Unmarshal(raw, &level)
+
+			if _, err := logger.LevelFromString(level); err != nil {
+				return Failure, "Unknown log level '" + level + "' for '" + key + "'"
+			}
+		}
+
+		message := "Set '" + key + "' to " + value
+
+		if instance != "" {
+			message += " for '" + instance + "'"
+		}
+
+		if arg != 0 {
+			if err := server.SetOptionInFile(CONFIG_PATH, instance, key, raw); err != nil {
+				return Failure, "Could not persist '" + key + "', nothing was changed: " + err.Error()
+			}
+		}
+
+		for name, instanceOpts := range updated {
+			servers[name].SetOptions(instanceOpts)
+			serverCommandChans[name] <- server.Restart
+		}
+
+		// Keeps the top level options given by config-get in step with the servers.
+		if instance == "" {
+			*opts = topLevel
+		}
+
+		if _, ok := logLevelOptions[key]; ok {
+			setLogLevel(key, level)
+		} else if key == "AutoReload" {
+			setAutoReload(opts.AutoReload)
+		}
+
+		logger.GlobalLog.LogInfo(message)
+
+		if arg == 0 {
+			return Success, message + ", the change will be lost on reload unless persisted"
+		}
+
+		return Success, message + " and persisted it to '" + CONFIG_PATH + "'"
+	}
+}
+
+// Options setting the levels of the global log, by whether each sets the level
+// printed rather than recorded.
+var logLevelOptions = map[string]bool{
+	"LogLevelPrint":  true,
+	"LogLevelRecord": false,
+}
+
+// Sets the level of the global log that the given option of `logLevelOptions`
+// configures, from a string as given to `logger.LevelFromString()`. Options
+// are only ever mapped to the log's levels here.
+func setLogLevel(key, level string) error {
+	if logLevelOptions[key] {
+		return logger.GlobalLog.SetPrintLevelFromString(level)
+	}
+
+	return logger.GlobalLog.SetRecordLevelFromString(level)
+}
+
+// Returns a function that turns maintenance mode on or off for each of the given
+// servers without restarting them, persisting the change to the config file of
+// the named instance, or the top level if empty, when given a non-zero argument.
+func GetMaintenanceCallback(opts *server.ServerOptions, instance string, servers map[string]*server.Server) DaemonTextQueryCallback {
+	return func(text string, arg DaemonCommandArg) (DaemonCommandSuccess, string) {
+		if text != "on" && text != "off" {
+			return Failure, "Expected 'on' or 'off' for maintenance mode, not '" + text + "'"
+		}
+
+		enabled := text == "on"
+
+		for _, srv := range servers {
+			srv.SetMaintenance(enabled)
+		}
+
+		// Keeps the top level options given by config-get in step with the servers.
+		if instance == "" {
+			opts.Maintenance = enabled
+		}
+
+		message := "Turned maintenance mode " + text
+
+		if instance != "" {
+			message += " for '" + instance + "'"
+		}
+
+		logger.GlobalLog.LogInfo(message)
+
+		if arg == 0 {
+			return Success, message + ", the change will be lost on reload unless persisted"
+		}
+
+		if err := server.SetOptionInFile(CONFIG_PATH, instance, "Maintenance", json.RawMessage(strconv.FormatBool(enabled))); err != nil {
+			return Failure, message + " but could not persist it: " + err.Error()
+		}
+
+		return Success, message + " and persisted it to '" + CONFIG_PATH + "'"
+	}
+}
+
+// Returns a function that deploys the release directory given as text to the
+// given server, see `server.DeploySite()`, and restarts it to serve the release.
+// Fails if given more than one server, as a release is of a single site.
+func GetDeployCallback(servers map[string]*server.Server, serverCommandChans map[string]chan server.ServerThreadCommand) DaemonTextQueryCallback {
+	return func(text string, _ DaemonCommandArg) (DaemonCommandSuccess, string) {
+		if len(servers) != 1 {
+			return Failure, "Choose the server instance to deploy to with '-" + Instance + "'"
+		}
+
+		for name, srv := range servers {
+			if err := server.DeploySite(srv.Options(), text); err != nil {
+				logger.GlobalLog.LogErr(err.Error())
+				return Failure, err.Error()
+			}
+
+			serverCommandChans[name] <- server.Restart
+			return Success, "Deployed '" + text + "' to '" + name + "', roll back with '-" + Rollback + "'"
+		}
+
+		return Failure, "No server instance to deploy to"
+	}
+}
+
+// Returns a function that points the given server back at its previous
+// release, see `server.RollbackSite()`, and restarts it. Fails if given more
+// than one server, as with `GetDeployCallback()`.
+func GetRollbackCallback(servers map[string]*server.Server, serverCommandChans map[string]chan server.ServerThreadCommand) DaemonQueryCallback {
+	return func(_ DaemonCommandArg) (DaemonCommandSuccess, string) {
+		if len(servers) != 1 {
+			return Failure, "Choose the server instance to roll back with '-" + Instance + "'"
+		}
+
+		for name, srv := range servers {
+			if err := server.RollbackSite(srv.Options()); err != nil {
+				logger.GlobalLog.LogErr(err.Error())
+				return Failure, err.Error()
+			}
+
+			serverCommandChans[name] <- server.Restart
+			return Success, "Rolled '" + name + "' back to its previous release"
+		}
+
+		return Failure, "No server instance to roll back"
+	}
+}
+
+// Returns a function that gives the state of each of the given scheduled jobs
+// as JSON.
+func GetJobsQueryCallback(jobs *JobList) DaemonQueryCallback {
+	return func(_ DaemonCommandArg) (DaemonCommandSuccess, string) {
+		content, err := jobs.json()
+
+		if err != nil {
+			return Failure, err.Error()
+		}
+
+		return Success, string(content)
+	}
+}
@@ -5,15 +5,22 @@
 package daemon
 
 import (
+	"encoding/json"
+	"fmt"
+	"io"
 	"net"
 	"os"
 	"os/exec"
 	"os/user"
+	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/an-prata/webby/logger"
+	"github.com/an-prata/webby/server"
 )
 
 // Represents possible commands from client connections.
@@ -27,17 +34,78 @@ const (
 	// Reloads the configuration file and then restarts.
 	Reload = "reload"
 
+	// Reloads the certificates served over HTTPS from disk without restarting.
+	ReloadCerts = "reload-certs"
+
+	// Closes and reopens the log file and access logs, for log rotation.
+	RotateLog = "rotate-log"
+
 	// Stops the current daemon.
 	Stop = "stop"
 
 	// Gets webby's current status, status needs to be representable in the single
-	// byte returned to the client after a daemon command.
+	// byte returned to the client after a daemon command. The byte is followed by
+	// a `StatusReport` as JSON.
 	Status = "status"
 
 	// Like the Daemon variant this variant should not have a callback, and is
 	// intended to generate a default configuration file.
 	GenConfig = "gen-config"
 
+	// Queries the quota usage of each client IP. Responds with a table of usage
+	// following its success byte.
+	Quota = "quota"
+
+	// Streams the request stats of each server instance. Responds with its
+	// success byte and then a line of JSON every `StreamInterval`.
+	Stats = "stats"
+
+	// Gets the hits of each path served by each server instance. Responds with a
+	// list of `server.PathHits` for each instance by name as JSON following its
+	// success byte. Shown by the client's "stats" flag, unlike the stats stream.
+	Hits = "hits"
+
+	// Lists the routes of each server instance. Responds with the routes of each
+	// instance by name as JSON following its success byte.
+	Routes = "routes"
+
+	// Lists the scheduled jobs of the `Cron` option. Responds with a list of
+	// `JobStatus` as JSON following its success byte.
+	Jobs = "jobs"
+
+	// Gets the daemon's protocol version and the commands it supports. Responds
+	// with a `HelloResponse` as JSON following its success byte.
+	Hello = "hello"
+
+	// Gets the value of an option the daemon is running with. Takes the option's
+	// name as text, see `TextCommand()`, and responds with its value as JSON
+	// following its success byte.
+	ConfigGet = "config-get"
+
+	// Sets an option of the running daemon, see `server.LiveOptions`. Takes the
+	// option's name and JSON value as text, see `TextCommand()`, and writes the
+	// change to the config file if given a non-zero argument. Responds with a
+	// description of the change, or of why it failed, following its success byte.
+	ConfigSet = "config-set"
+
+	// Turns maintenance mode on or off without restarting, see
+	// `server.Handler.SetMaintenance()`. Takes "on" or "off" as text, see
+	// `TextCommand()`, and writes the change to the config file if given a
+	// non-zero argument. Responds with a description of the change, or of why it
+	// failed, following its success byte.
+	Maintenance = "maintenance"
+
+	// Deploys a release directory to a server instance's `Site` and restarts it,
+	// see `server.DeploySite()`. Takes the absolute path of the release as text,
+	// see `TextCommand()`. Responds with a description of the deploy, or of why
+	// it failed, following its success byte.
+	Deploy = "deploy"
+
+	// Points a server instance's `Site` back at its previous release and restarts
+	// it, see `server.RollbackSite()`. Responds with a description of the
+	// rollback, or of why it failed, following its success byte.
+	Rollback = "rollback"
+
 	// Sets the log level for recording logs to file. Should interperet its
 	// argument to be the desired log level.
 	LogRecord = "log-record"
@@ -48,8 +116,75 @@ const (
 	LogPrint = "log-print"
 )
 
+// Not a command itself, but selects the server instance that the restart,
+// reload-certs, status, quota, stats, hits, routes, config-get, config-set,
+// maintenance, deploy, and rollback commands apply to.
+const Instance = "instance"
+
+// Exit codes of the control client, distinguishing a failed command from a
+// daemon that could not be reached or did not respond in time.
+const (
+	ExitSuccess = 0
+	ExitFailure = 1
+	ExitRefused = 2
+	ExitTimeout = 3
+)
+
+// Not a command itself, but sets the number of seconds the control client waits
+// to connect to and get a response from the daemon.
+const Timeout = "timeout"
+
+// Not a command itself, but prints the output of the status, hits, routes, and
+// jobs commands as JSON for scripts and monitoring, the same as giving
+// `OutputJson` to `Output`.
+const Json = "json"
+
+// Not a command itself, but sets the format the control client prints its output
+// in, either `OutputText` or `OutputJson`.
+const Output = "output"
+
+// Formats the control client may print its output in, see `Output`.
+const (
+	// Text for people to read, with log messages colored.
+	OutputText = "text"
+
+	// A single JSON document for each command that gives output, with the schema
+	// of the type it is decoded from, e.g. `StatusReport` for the status
+	// command. Only warnings and errors are logged, each as a line of JSON, so
+	// that a command that succeeds prints nothing else.
+	OutputJson = "json"
+)
+
+// Not a command itself, but writes changes made by the config-set command to
+// the config file so that they outlast a reload.
+const Persist = "persist"
+
+// Seperates a command from the name of the server instance it is directed at.
+const InstanceSeparator = "@"
+
+// Seperates a command from the text it is given, see `TextCommand()`.
+const TextSeparator = " "
+
 const maximumSocketChecks = 10
 
+// Gets the daemon command for running the given command on only the named server
+// instance. An empty instance name gives the command unchanged, applying to all
+// instances.
+func InstanceCommand(command DaemonCommand, instance string) DaemonCommand {
+	if instance == "" {
+		return command
+	}
+
+	return command + InstanceSeparator + DaemonCommand(instance)
+}
+
+// Gets the daemon command for running the given command with the given text,
+// e.g. the name of an option. The command may already be directed at a server
+// instance, see `InstanceCommand()`.
+func TextCommand(command DaemonCommand, text string) DaemonCommand {
+	return command + TextSeparator + DaemonCommand(text)
+}
+
 // Starts a daemon process and forks it.
 func StartForkedDaemon(log *logger.Log) {
 	user, err := user.Current()
@@ -149,10 +284,10 @@ func StartForkedDaemon(log *logger.Log) {
 //
 // This function is intended as the end of execution for the command it
 // represents and will therefore perform I/O operations, output to the user, and
-// indicate errors only though these means.
-func CmdSetLogRecordLevel(socket net.Conn, log *logger.Log, arg string) {
+// indicate errors only though these means and the exit code it returns.
+func CmdSetLogRecordLevel(socket net.Conn, log *logger.Log, arg string) int {
 	if arg == "" {
-		return
+		return ExitSuccess
 	}
 
 	logLevel, err := logger.LevelFromString(arg)
@@ -160,18 +295,26 @@ func CmdSetLogRecordLevel(socket net.Conn, log *logger.Log, arg string) {
 	if err != nil {
 		log.LogErr("Could not identify log level from given argument (" + arg + ")")
 		log.LogInfo("try using 'error', 'warning', 'info', or 'all'")
-		return
+		return ExitFailure
 	}
 
 	var buf [1]byte
-	socket.Write(append([]byte(LogRecord), byte(logLevel)))
-	socket.Read(buf[:])
+	WriteCommand(socket, LogRecord, DaemonCommandArg(logLevel))
+	if _, err := socket.Read(buf[:]); err != nil {
+		return responseError(log, err)
+	}
+
+	if rejected(DaemonCommandSuccess(buf[0])) {
+		return rejectedCommand(log, DaemonCommandSuccess(buf[0]))
+	}
 
 	if DaemonCommandSuccess(buf[0]) != Success {
 		log.LogErr("Could not change log level for recording")
-	} else {
-		log.LogInfo("Log level for recording changed to '" + arg + "'")
+		return ExitFailure
 	}
+
+	log.LogInfo("Log level for recording changed to '" + arg + "'")
+	return ExitSuccess
 }
 
 // Sends the set print log level command to the daemon, using the given command
@@ -179,10 +322,10 @@ func CmdSetLogRecordLevel(socket net.Conn, log *logger.Log, arg string) {
 //
 // This function is intended as the end of execution for the command it
 // represents and will therefore perform I/O operations, output to the user, and
-// indicate errors only though these means.
-func CmdSetLogPrintLevel(socket net.Conn, log *logger.Log, arg string) {
+// indicate errors only though these means and the exit code it returns.
+func CmdSetLogPrintLevel(socket net.Conn, log *logger.Log, arg string) int {
 	if arg == "" {
-		return
+		return ExitSuccess
 	}
 
 	logLevel, err := logger.LevelFromString(arg)
@@ -190,127 +333,887 @@ func CmdSetLogPrintLevel(socket net.Conn, log *logger.Log, arg string) {
 	if err != nil {
 		log.LogErr("Could not identify log level from given argument (" + arg + ")")
 		log.LogInfo("try using 'error', 'warning', 'info', or 'all'")
-		return
+		return ExitFailure
 	}
 
 	var buf [1]byte
-	socket.Write(append([]byte(LogPrint), byte(logLevel)))
-	socket.Read(buf[:])
+	WriteCommand(socket, LogPrint, DaemonCommandArg(logLevel))
+	if _, err := socket.Read(buf[:]); err != nil {
+		return responseError(log, err)
+	}
+
+	if rejected(DaemonCommandSuccess(buf[0])) {
+		return rejectedCommand(log, DaemonCommandSuccess(buf[0]))
+	}
 
 	if DaemonCommandSuccess(buf[0]) != Success {
 		log.LogErr("Could not change log level for printing")
-	} else {
-		log.LogInfo("Log level for printing changed to '" + arg + "'")
+		return ExitFailure
 	}
+
+	log.LogInfo("Log level for printing changed to '" + arg + "'")
+	return ExitSuccess
 }
 
 // Sends the reload command to the daemon through the provided socket.
 //
 // This function is intended as the end of execution for the command it
 // represents and will therefore perform I/O operations, output to the user, and
-// indicate errors only though these means.
-func CmdReload(socket net.Conn, log *logger.Log, arg bool) {
+// indicate errors only though these means and the exit code it returns.
+func CmdReload(socket net.Conn, log *logger.Log, arg bool) int {
 	if !arg {
-		return
+		return ExitSuccess
 	}
 
 	log.LogInfo("Reloading config and restarting webby...")
 
 	var buf [1]byte
-	socket.Write(append([]byte(Reload), 0))
-	socket.Read(buf[:])
+	WriteCommand(socket, Reload, 0)
+	if _, err := socket.Read(buf[:]); err != nil {
+		return responseError(log, err)
+	}
+
+	if rejected(DaemonCommandSuccess(buf[0])) {
+		return rejectedCommand(log, DaemonCommandSuccess(buf[0]))
+	}
 
 	if DaemonCommandSuccess(buf[0]) != Success {
 		log.LogErr("Could not reload config or restart")
-	} else {
-		log.LogInfo("Reloaded and restarted!")
+		return ExitFailure
 	}
+
+	log.LogInfo("Reloaded and restarted!")
+	return ExitSuccess
 }
 
-// Sends the restart command to the daemon through the provided socket.
+// Sends the restart command to the daemon through the provided socket. Only the
+// named server instance is restarted unless the instance name is empty.
 //
 // This function is intended as the end of execution for the command it
 // represents and will therefore perform I/O operations, output to the user, and
-// indicate errors only though these means.
-func CmdRestart(socket net.Conn, log *logger.Log, arg bool) {
+// indicate errors only though these means and the exit code it returns.
+func CmdRestart(socket net.Conn, log *logger.Log, arg bool, instance string) int {
 	if !arg {
-		return
+		return ExitSuccess
 	}
 
 	log.LogInfo("Restarting webby...")
 
 	var buf [1]byte
-	socket.Write(append([]byte(Restart), 0))
-	socket.Read(buf[:])
+	WriteCommand(socket, InstanceCommand(Restart, instance), 0)
+	if _, err := socket.Read(buf[:]); err != nil {
+		return responseError(log, err)
+	}
+
+	if rejected(DaemonCommandSuccess(buf[0])) {
+		return rejectedCommand(log, DaemonCommandSuccess(buf[0]))
+	}
 
 	if DaemonCommandSuccess(buf[0]) != Success {
 		log.LogErr("Could not restart webby correctly")
-	} else {
-		log.LogInfo("Restarted!")
+		return ExitFailure
 	}
+
+	log.LogInfo("Restarted!")
+	return ExitSuccess
+}
+
+// Sends the reload-certs command to the daemon through the provided socket,
+// for the given server instance or all instances if empty.
+//
+// This function is intended as the end of execution for the command it
+// represents and will therefore perform I/O operations, output to the user, and
+// indicate errors only though these means and the exit code it returns.
+func CmdReloadCerts(socket net.Conn, log *logger.Log, arg bool, instance string) int {
+	if !arg {
+		return ExitSuccess
+	}
+
+	log.LogInfo("Reloading certificates...")
+
+	var buf [1]byte
+	WriteCommand(socket, InstanceCommand(ReloadCerts, instance), 0)
+	if _, err := socket.Read(buf[:]); err != nil {
+		return responseError(log, err)
+	}
+
+	if rejected(DaemonCommandSuccess(buf[0])) {
+		return rejectedCommand(log, DaemonCommandSuccess(buf[0]))
+	}
+
+	if DaemonCommandSuccess(buf[0]) != Success {
+		log.LogErr("Could not reload certificates, see the server log for details")
+		return ExitFailure
+	}
+
+	log.LogInfo("Reloaded!")
+	return ExitSuccess
+}
+
+// Sends the rotate log command to the daemon through the provided socket.
+//
+// This function is intended as the end of execution for the command it
+// represents and will therefore perform I/O operations, output to the user, and
+// indicate errors only though these means and the exit code it returns.
+func CmdRotateLog(socket net.Conn, log *logger.Log, arg bool) int {
+	if !arg {
+		return ExitSuccess
+	}
+
+	log.LogInfo("Reopening log files...")
+
+	var buf [1]byte
+	WriteCommand(socket, RotateLog, 0)
+	if _, err := socket.Read(buf[:]); err != nil {
+		return responseError(log, err)
+	}
+
+	if rejected(DaemonCommandSuccess(buf[0])) {
+		return rejectedCommand(log, DaemonCommandSuccess(buf[0]))
+	}
+
+	if DaemonCommandSuccess(buf[0]) != Success {
+		log.LogErr("Could not reopen log files, see the server log for details")
+		return ExitFailure
+	}
+
+	log.LogInfo("Reopened!")
+	return ExitSuccess
 }
 
 // Sends the stop command to the daemon through the provided socket.
 //
 // This function is intended as the end of execution for the command it
 // represents and will therefore perform I/O operations, output to the user, and
-// indicate errors only though these means.
-func CmdStop(socket net.Conn, log *logger.Log, arg bool) {
+// indicate errors only though these means and the exit code it returns.
+func CmdStop(socket net.Conn, log *logger.Log, arg bool) int {
 	if !arg {
-		return
+		return ExitSuccess
 	}
 
 	log.LogInfo("Stopping webby...")
 
 	var buf [1]byte
-	socket.Write(append([]byte(Stop), 0))
-	socket.Read(buf[:])
+	WriteCommand(socket, Stop, 0)
+	if _, err := socket.Read(buf[:]); err != nil {
+		return responseError(log, err)
+	}
+
+	if rejected(DaemonCommandSuccess(buf[0])) {
+		return rejectedCommand(log, DaemonCommandSuccess(buf[0]))
+	}
 
 	if DaemonCommandSuccess(buf[0]) != Success {
 		log.LogErr("Could not stop webby")
-	} else {
-		log.LogInfo("Stopped!")
+		return ExitFailure
 	}
+
+	log.LogInfo("Stopped!")
+	return ExitSuccess
 }
 
-func CmdStatus(socket net.Conn, log *logger.Log, arg bool) {
+// Sends the status command to the daemon through the provided socket and shows
+// the result. Only the named server instance is checked unless the instance
+// name is empty.
+//
+// This function is intended as the end of execution for the command it
+// represents and will therefore perform I/O operations, output to the user, and
+// indicate errors only though these means and the exit code it returns.
+func CmdStatus(socket net.Conn, log *logger.Log, arg bool, instance string, jsonOutput bool) int {
 	if !arg {
-		return
+		return ExitSuccess
+	}
+
+	if !jsonOutput {
+		log.LogInfo("Requesting status from webby..")
 	}
 
-	log.LogInfo("Requesting status from webby..")
+	WriteCommand(socket, InstanceCommand(Status, instance), 0)
+	response, err := io.ReadAll(socket)
 
-	var buf [1]byte
-	socket.Write(append([]byte(Status), 0))
-	socket.Read(buf[:])
+	if err != nil {
+		return responseError(log, err)
+	}
 
-	status := WebbyStatus(buf[0])
+	if len(response) == 0 {
+		log.LogErr("Could not get status from webby")
+		return ExitFailure
+	}
+
+	if rejected(DaemonCommandSuccess(response[0])) {
+		return rejectedCommand(log, DaemonCommandSuccess(response[0]))
+	}
+
+	status := WebbyStatus(response[0])
+	exitCode := ExitSuccess
+
+	if status != Ok {
+		exitCode = ExitFailure
+	}
+
+	if jsonOutput {
+		fmt.Println(string(response[1:]))
+		return exitCode
+	}
 
 	log.LogInfo("Got status!")
 
+	var report StatusReport
+
+	if json.Unmarshal(response[1:], &report) == nil {
+		defer printStatusReport(report)
+	}
+
 	print("\nstatus: ")
 
 	if status == Ok {
 		println("OK\n")
-		println("webby made HTTP GET requests to all hosted paths and got 200 for each.\n")
-		return
+		println("webby made HTTP GET requests to all hosted paths and external URLs and got 200 for each.\n")
+		return exitCode
 	}
 
 	if status == HttpNon2xx {
 		println("Non 200\n")
-		println("webby made HTTP GET requests to all hosted paths, all responded but some did not give 200.\n")
-		return
+		println("webby made HTTP GET requests to all hosted paths and external URLs, all responded but some did not give 200.\n")
+		return exitCode
 	}
 
 	if status == HttpPartialFail {
 		println("Partial Fail\n")
-		println("webby made HTTP GET requests to all hosted paths but some responded with a failure code, e.g. 400.\n")
-		return
+		println("webby made HTTP GET requests to all hosted paths and external URLs but some responded with a failure code, e.g. 400.\n")
+		return exitCode
 	}
 
 	if status == HttpFail {
 		println("Fail\n")
-		println("webby made HTTP GET requests to all hosted paths and all responded with a failure code, e.g. 400.\n")
-		return
+		println("webby made HTTP GET requests to all hosted paths and external URLs and all responded with a failure code, e.g. 400.\n")
+		return exitCode
+	}
+
+	if status == ServerDown {
+		println("Server Down\n")
+		println("webby's HTTP server stopped unexpectedly and is retrying, check the log for details.\n")
+		return exitCode
+	}
+
+	return exitCode
+}
+
+// Prints the details of a status report following the status itself, listing
+// only the checks that did not respond with 200 and certificates that expire
+// soon, followed by the slowest checks and a summary of automatic checks.
+func printStatusReport(report StatusReport) {
+	fmt.Printf("version: %s\nuptime: %s\nmemory: %.1f MiB\n", report.Version, (time.Duration(report.Uptime) * time.Second).String(), float64(report.MemoryBytes)/(1<<20))
+
+	names := []string{}
+
+	for name := range report.Instances {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		instance := report.Instances[name]
+		fmt.Printf("\n[%s] %s: %d paths mapped, %d requests, %d errors, %d panics, %d open connections\n", name, instance.Status, instance.MappedPaths, instance.Requests, instance.Errors, instance.Panics, instance.ActiveConnections)
+
+		for _, check := range instance.Checks {
+			if check.Error != "" {
+				fmt.Printf("  %s: %s\n", check.Url, check.Error)
+			} else if check.Code != 200 {
+				fmt.Printf("  %s: %d\n", check.Url, check.Code)
+			}
+		}
+
+		for _, cert := range instance.Certificates {
+			if cert.Error != "" {
+				fmt.Printf("  %s: %s\n", cert.Path, cert.Error)
+			}
+		}
+
+		if len(instance.Slowest) > 0 {
+			fmt.Println("  slowest:")
+		}
+
+		for _, check := range instance.Slowest {
+			fmt.Printf("    %s: %.1f ms\n", check.Url, check.LatencyMs)
+		}
+
+		if health := instance.Health; health != nil && health.Checks > 0 {
+			fmt.Printf("  health: %.1f%% of %d checks since %s ok, p50 %.1f ms, p90 %.1f ms, p99 %.1f ms\n", health.SuccessRate*100, health.Checks, health.Since.Format(time.DateTime), health.LatencyP50Ms, health.LatencyP90Ms, health.LatencyP99Ms)
+
+			if health.FailingSince != nil {
+				fmt.Printf("  failing since %s\n", health.FailingSince.Format(time.DateTime))
+			} else if health.LastFailure != nil {
+				fmt.Printf("  last failed %s\n", health.LastFailure.Format(time.DateTime))
+			}
+		}
+	}
+
+	fmt.Println()
+}
+
+// Sends the quota query to the daemon through the provided socket and prints
+// the quota usage of each client IP. Only the named server instance is shown
+// unless the instance name is empty.
+//
+// This function is intended as the end of execution for the command it
+// represents and will therefore perform I/O operations, output to the user, and
+// indicate errors only though these means and the exit code it returns.
+func CmdQuota(socket net.Conn, log *logger.Log, arg bool, instance string) int {
+	if !arg {
+		return ExitSuccess
+	}
+
+	WriteCommand(socket, InstanceCommand(Quota, instance), 0)
+	response, err := io.ReadAll(socket)
+
+	if err != nil {
+		return responseError(log, err)
+	}
+
+	if len(response) > 0 && rejected(DaemonCommandSuccess(response[0])) {
+		return rejectedCommand(log, DaemonCommandSuccess(response[0]))
+	}
+
+	if len(response) == 0 || DaemonCommandSuccess(response[0]) != Success {
+		log.LogErr("Could not get quota usage from webby")
+		return ExitFailure
+	}
+
+	fmt.Print(string(response[1:]))
+	return ExitSuccess
+}
+
+// Sends the hits query to the daemon through the provided socket and prints a
+// table of the requests, bytes, and statuses of each path served by each server
+// instance, or only by the named instance if the instance name is not empty.
+//
+// This function is intended as the end of execution for the command it
+// represents and will therefore perform I/O operations, output to the user, and
+// indicate errors only though these means and the exit code it returns.
+func CmdHits(socket net.Conn, log *logger.Log, arg bool, instance string, jsonOutput bool) int {
+	if !arg {
+		return ExitSuccess
+	}
+
+	WriteCommand(socket, InstanceCommand(Hits, instance), 0)
+	response, err := io.ReadAll(socket)
+
+	if err != nil {
+		return responseError(log, err)
+	}
+
+	if len(response) > 0 && rejected(DaemonCommandSuccess(response[0])) {
+		return rejectedCommand(log, DaemonCommandSuccess(response[0]))
+	}
+
+	var hits map[string][]server.PathHits
+
+	if len(response) == 0 || DaemonCommandSuccess(response[0]) != Success || json.Unmarshal(response[1:], &hits) != nil {
+		log.LogErr("Could not get stats from webby")
+		return ExitFailure
+	}
+
+	if jsonOutput {
+		fmt.Println(string(response[1:]))
+		return ExitSuccess
+	}
+
+	names := []string{}
+
+	for name := range hits {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		width := len("Path")
+
+		for _, path := range hits[name] {
+			if len(path.Path) > width {
+				width = len(path.Path)
+			}
+		}
+
+		fmt.Printf("[%s] %d paths\n", name, len(hits[name]))
+		fmt.Printf("%-*s  %10s  %12s  %s\n", width, "Path", "Requests", "Bytes", "Statuses")
+
+		for _, path := range hits[name] {
+			codes := []int{}
+
+			for code := range path.Statuses {
+				codes = append(codes, code)
+			}
+
+			sort.Ints(codes)
+			statuses := []string{}
+
+			for _, code := range codes {
+				statuses = append(statuses, fmt.Sprintf("%d: %d", code, path.Statuses[code]))
+			}
+
+			fmt.Printf("%-*s  %10d  %12d  %s\n", width, path.Path, path.Requests, path.Bytes, strings.Join(statuses, ", "))
+		}
+
+		fmt.Println()
+	}
+
+	return ExitSuccess
+}
+
+// Sends the routes query to the daemon through the provided socket and prints
+// a table of the routes of each server instance, or only of the named instance
+// if the instance name is not empty.
+//
+// This function is intended as the end of execution for the command it
+// represents and will therefore perform I/O operations, output to the user, and
+// indicate errors only though these means and the exit code it returns.
+func CmdRoutes(socket net.Conn, log *logger.Log, arg bool, instance string, jsonOutput bool) int {
+	if !arg {
+		return ExitSuccess
+	}
+
+	WriteCommand(socket, InstanceCommand(Routes, instance), 0)
+	response, err := io.ReadAll(socket)
+
+	if err != nil {
+		return responseError(log, err)
 	}
+
+	if len(response) > 0 && rejected(DaemonCommandSuccess(response[0])) {
+		return rejectedCommand(log, DaemonCommandSuccess(response[0]))
+	}
+
+	var routes map[string][]server.Route
+
+	if len(response) == 0 || DaemonCommandSuccess(response[0]) != Success || json.Unmarshal(response[1:], &routes) != nil {
+		log.LogErr("Could not get routes from webby")
+		return ExitFailure
+	}
+
+	if jsonOutput {
+		fmt.Println(string(response[1:]))
+		return ExitSuccess
+	}
+
+	names := []string{}
+
+	for name := range routes {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		width := len("Path")
+
+		for _, route := range routes[name] {
+			if len(route.Path) > width {
+				width = len(route.Path)
+			}
+		}
+
+		fmt.Printf("[%s] %d routes\n", name, len(routes[name]))
+		fmt.Printf("%-*s  %-10s  %s\n", width, "Path", "Kind", "Target")
+
+		for _, route := range routes[name] {
+			fmt.Println(strings.TrimRight(fmt.Sprintf("%-*s  %-10s  %s", width, route.Path, route.Kind, route.Target), " "))
+		}
+
+		fmt.Println()
+	}
+
+	return ExitSuccess
+}
+
+// Sends the jobs query to the daemon through the provided socket and prints a
+// table of the scheduled jobs, when each last ran and how, and when each runs
+// next.
+//
+// This function is intended as the end of execution for the command it
+// represents and will therefore perform I/O operations, output to the user, and
+// indicate errors only though these means and the exit code it returns.
+func CmdJobs(socket net.Conn, log *logger.Log, arg bool, jsonOutput bool) int {
+	if !arg {
+		return ExitSuccess
+	}
+
+	WriteCommand(socket, Jobs, 0)
+	response, err := io.ReadAll(socket)
+
+	if err != nil {
+		return responseError(log, err)
+	}
+
+	if len(response) > 0 && rejected(DaemonCommandSuccess(response[0])) {
+		return rejectedCommand(log, DaemonCommandSuccess(response[0]))
+	}
+
+	var jobs []JobStatus
+
+	if len(response) == 0 || DaemonCommandSuccess(response[0]) != Success || json.Unmarshal(response[1:], &jobs) != nil {
+		log.LogErr("Could not get scheduled jobs from webby")
+		return ExitFailure
+	}
+
+	if jsonOutput {
+		fmt.Println(string(response[1:]))
+		return ExitSuccess
+	}
+
+	if len(jobs) == 0 {
+		log.LogInfo("No jobs are scheduled, see the 'Cron' option")
+		return ExitSuccess
+	}
+
+	width := len("Name")
+
+	for _, job := range jobs {
+		if len(job.Name) > width {
+			width = len(job.Name)
+		}
+	}
+
+	fmt.Printf("%-*s  %-17s  %-20s  %-20s  %-20s  %s\n", width, "Name", "Task", "Schedule", "Last run", "Next run", "Result")
+
+	for _, job := range jobs {
+		last, next, result := "never", "never", "-"
+
+		if !job.LastRun.IsZero() {
+			last = job.LastRun.Format("2006-01-02 15:04:05")
+			result = "ok (" + strconv.FormatInt(job.LastDurationMs, 10) + "ms)"
+		}
+
+		if !job.Next.IsZero() {
+			next = job.Next.Format("2006-01-02 15:04:05")
+		}
+
+		if job.Running {
+			result = "running"
+		} else if job.LastError != "" {
+			result = "failed: " + job.LastError
+		}
+
+		fmt.Printf("%-*s  %-17s  %-20s  %-20s  %-20s  %s\n", width, job.Name, job.Task, job.Schedule, last, next, result)
+	}
+
+	return ExitSuccess
+}
+
+// Sends the config-get command to the daemon through the provided socket and
+// prints the value of the named option as JSON. The named server instance's
+// value is given unless the instance name is empty.
+//
+// This function is intended as the end of execution for the command it
+// represents and will therefore perform I/O operations, output to the user, and
+// indicate errors only though these means and the exit code it returns.
+func CmdConfigGet(socket net.Conn, log *logger.Log, key string, instance string) int {
+	if key == "" {
+		return ExitSuccess
+	}
+
+	if err := WriteCommand(socket, TextCommand(InstanceCommand(ConfigGet, instance), key), 0); err != nil {
+		log.LogErr("Could not get '" + key + "': " + err.Error())
+		return ExitFailure
+	}
+
+	response, err := io.ReadAll(socket)
+
+	if err != nil {
+		return responseError(log, err)
+	}
+
+	if len(response) > 0 && rejected(DaemonCommandSuccess(response[0])) {
+		return rejectedCommand(log, DaemonCommandSuccess(response[0]))
+	}
+
+	if len(response) == 0 || DaemonCommandSuccess(response[0]) != Success {
+		log.LogErr("Could not get '" + key + "' from webby")
+
+		if len(response) > 1 {
+			log.LogErr(string(response[1:]))
+		}
+
+		return ExitFailure
+	}
+
+	fmt.Println(string(response[1:]))
+	return ExitSuccess
+}
+
+// The result of the config-set command printed as JSON, see `OutputJson`.
+type ConfigSetResult struct {
+	Option   string
+	Instance string
+	Value    json.RawMessage
+
+	// Whether the change was written to the config file.
+	Persisted bool
+
+	// How the daemon applied the change, e.g. by restarting servers.
+	Message string
+}
+
+// Sends the config-set command to the daemon through the provided socket,
+// setting the named option to the given value on the named server instance, or
+// on all of them if the instance name is empty. Values that are not valid JSON
+// are given as strings. The change is written to the config file if persist is
+// true. Prints a `ConfigSetResult` if jsonOutput is true.
+//
+// This function is intended as the end of execution for the command it
+// represents and will therefore perform I/O operations, output to the user, and
+// indicate errors only though these means and the exit code it returns.
+func CmdConfigSet(socket net.Conn, log *logger.Log, key string, value string, persist bool, instance string, jsonOutput bool) int {
+	if key == "" {
+		return ExitSuccess
+	}
+
+	if !json.Valid([]byte(value)) {
+		encoded, _ := json.Marshal(value)
+		value = string(encoded)
+	}
+
+	var arg DaemonCommandArg
+
+	if persist {
+		arg = 1
+	}
+
+	if err := WriteCommand(socket, TextCommand(InstanceCommand(ConfigSet, instance), key+TextSeparator+value), arg); err != nil {
+		log.LogErr("Could not set '" + key + "': " + err.Error())
+		return ExitFailure
+	}
+
+	response, err := io.ReadAll(socket)
+
+	if err != nil {
+		return responseError(log, err)
+	}
+
+	if len(response) > 0 && rejected(DaemonCommandSuccess(response[0])) {
+		return rejectedCommand(log, DaemonCommandSuccess(response[0]))
+	}
+
+	if len(response) == 0 || DaemonCommandSuccess(response[0]) != Success {
+		log.LogErr("Could not set '" + key + "'")
+
+		if len(response) > 1 {
+			log.LogErr(string(response[1:]))
+		}
+
+		return ExitFailure
+	}
+
+	if jsonOutput {
+		result, _ := json.Marshal(ConfigSetResult{key, instance, json.RawMessage(value), persist, string(response[1:])})
+		fmt.Println(string(result))
+		return ExitSuccess
+	}
+
+	log.LogInfo(string(response[1:]))
+	return ExitSuccess
+}
+
+// Sends the maintenance command to the daemon through the provided socket,
+// turning maintenance mode "on" or "off" for the named server instance, or for
+// all of them if the instance name is empty. The change is written to the config
+// file if persist is true.
+//
+// This function is intended as the end of execution for the command it
+// represents and will therefore perform I/O operations, output to the user, and
+// indicate errors only though these means and the exit code it returns.
+func CmdMaintenance(socket net.Conn, log *logger.Log, mode string, persist bool, instance string) int {
+	if mode == "" {
+		return ExitSuccess
+	}
+
+	if mode != "on" && mode != "off" {
+		log.LogErr("Expected 'on' or 'off' for maintenance mode, not '" + mode + "'")
+		return ExitFailure
+	}
+
+	var arg DaemonCommandArg
+
+	if persist {
+		arg = 1
+	}
+
+	WriteCommand(socket, TextCommand(InstanceCommand(Maintenance, instance), mode), arg)
+	response, err := io.ReadAll(socket)
+
+	if err != nil {
+		return responseError(log, err)
+	}
+
+	if len(response) > 0 && rejected(DaemonCommandSuccess(response[0])) {
+		return rejectedCommand(log, DaemonCommandSuccess(response[0]))
+	}
+
+	if len(response) == 0 || DaemonCommandSuccess(response[0]) != Success {
+		log.LogErr("Could not turn maintenance mode " + mode)
+
+		if len(response) > 1 {
+			log.LogErr(string(response[1:]))
+		}
+
+		return ExitFailure
+	}
+
+	log.LogInfo(string(response[1:]))
+	return ExitSuccess
+}
+
+// Sends the deploy command to the daemon through the provided socket, deploying
+// the given release directory to the named server instance, which may only be
+// omitted if the daemon runs a single instance.
+//
+// This function is intended as the end of execution for the command it
+// represents and will therefore perform I/O operations, output to the user, and
+// indicate errors only though these means and the exit code it returns.
+func CmdDeploy(socket net.Conn, log *logger.Log, release string, instance string) int {
+	if release == "" {
+		return ExitSuccess
+	}
+
+	// The daemon does not share the client's working directory.
+	release, err := filepath.Abs(release)
+
+	if err != nil {
+		log.LogErr("Could not get absolute path of '" + release + "': " + err.Error())
+		return ExitFailure
+	}
+
+	log.LogInfo("Checking and deploying '" + release + "'...")
+	if err := WriteCommand(socket, TextCommand(InstanceCommand(Deploy, instance), release), 0); err != nil {
+		log.LogErr("Could not deploy '" + release + "': " + err.Error())
+		return ExitFailure
+	}
+
+	return releaseResponse(socket, log, "Could not deploy '"+release+"'")
+}
+
+// Sends the rollback command to the daemon through the provided socket, pointing
+// the named server instance back at its previous release. The instance may only
+// be omitted if the daemon runs a single instance.
+//
+// This function is intended as the end of execution for the command it
+// represents and will therefore perform I/O operations, output to the user, and
+// indicate errors only though these means and the exit code it returns.
+func CmdRollback(socket net.Conn, log *logger.Log, arg bool, instance string) int {
+	if !arg {
+		return ExitSuccess
+	}
+
+	WriteCommand(socket, InstanceCommand(Rollback, instance), 0)
+	return releaseResponse(socket, log, "Could not roll back")
+}
+
+// Reads the response to a deploy or rollback command, logging it, and gives
+// the exit code for it.
+func releaseResponse(socket net.Conn, log *logger.Log, failure string) int {
+	response, err := io.ReadAll(socket)
+
+	if err != nil {
+		return responseError(log, err)
+	}
+
+	if len(response) > 0 && rejected(DaemonCommandSuccess(response[0])) {
+		return rejectedCommand(log, DaemonCommandSuccess(response[0]))
+	}
+
+	if len(response) == 0 || DaemonCommandSuccess(response[0]) != Success {
+		log.LogErr(failure)
+
+		if len(response) > 1 {
+			log.LogErr(string(response[1:]))
+		}
+
+		return ExitFailure
+	}
+
+	log.LogInfo(string(response[1:]))
+	return ExitSuccess
+}
+
+// Sends the hello command to the daemon through the provided socket and prints
+// the daemon's version, protocol version, and supported commands, as a
+// `HelloResponse` if jsonOutput is true.
+//
+// This function is intended as the end of execution for the command it
+// represents and will therefore perform I/O operations, output to the user, and
+// indicate errors only though these means and the exit code it returns.
+func CmdHello(socket net.Conn, log *logger.Log, arg bool, jsonOutput bool) int {
+	if !arg {
+		return ExitSuccess
+	}
+
+	WriteCommand(socket, Hello, 0)
+	response, err := io.ReadAll(socket)
+
+	if err != nil {
+		return responseError(log, err)
+	}
+
+	var hello HelloResponse
+
+	if len(response) > 0 && DaemonCommandSuccess(response[0]) == Refused {
+		return rejectedCommand(log, Refused)
+	}
+
+	// Daemons from before the hello command close the connection without a
+	// response.
+	if len(response) == 0 || DaemonCommandSuccess(response[0]) != Success || json.Unmarshal(response[1:], &hello) != nil {
+		log.LogErr("webby's daemon did not respond to hello, it is likely older than this client")
+		return ExitFailure
+	}
+
+	if jsonOutput {
+		fmt.Println(string(response[1:]))
+	} else {
+		fmt.Printf("version: %s\nprotocol version: %d (client %d)\ncommands: %s\n", hello.Version, hello.ProtocolVersion, ProtocolVersion, strings.Join(hello.Commands, ", "))
+	}
+
+	if hello.ProtocolVersion != ProtocolVersion {
+		log.LogWarn("webby's daemon speaks a different protocol version than this client, some commands may not work")
+	}
+
+	return ExitSuccess
+}
+
+// Gives whether the daemon responded that it did not run a command, either as it
+// does not know the command or as it refused the connection.
+func rejected(status DaemonCommandSuccess) bool {
+	return status == UnknownCommand || status == Refused
+}
+
+// Logs why the daemon did not run a command and gives the exit code for it,
+// `ExitRefused` if it refused the connection.
+func rejectedCommand(log *logger.Log, status DaemonCommandSuccess) int {
+	if status == Refused {
+		log.LogErr("webby's daemon refused the connection, you may need elevated privileges or to be in its 'ControlGroup'")
+		return ExitRefused
+	}
+
+	return unknownCommand(log)
+}
+
+// Logs that the daemon did not know a command and gives the exit code for it.
+func unknownCommand(log *logger.Log) int {
+	log.LogErr("webby's daemon does not support this command, it is likely older than this client")
+	log.LogInfo("try 'webby -hello' to see the commands it supports")
+	return ExitFailure
+}
+
+// Logs why a response could not be read from the daemon and gives the exit code
+// for it.
+func responseError(log *logger.Log, err error) int {
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		log.LogErr("Timed out waiting for webby to respond")
+		return ExitTimeout
+	}
+
+	log.LogErr("Could not read response from webby: " + err.Error())
+	return ExitFailure
 }
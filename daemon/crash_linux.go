@@ -0,0 +1,30 @@
+// Copyright (c) 2024 Evan Overman (https://an-prata.it).
+// Licensed under the MIT License.
+// See LICENSE file in repository root for complete license text.
+
+package daemon
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// Points standard error at the file at the given path, appending to it, so that
+// the panic and stacks the Go runtime prints when the daemon crashes are kept
+// there rather than lost, as the forked daemon has no standard error of its own.
+func redirectCrashOutput(path string) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0640)
+
+	if err != nil {
+		return errors.New("Could not open crash log '" + path + "': " + err.Error())
+	}
+
+	defer file.Close()
+
+	if err = syscall.Dup3(int(file.Fd()), int(os.Stderr.Fd()), 0); err != nil {
+		return errors.New("Could not write crashes to '" + path + "': " + err.Error())
+	}
+
+	return nil
+}
@@ -0,0 +1,15 @@
+// Copyright (c) 2024 Evan Overman (https://an-prata.it).
+// Licensed under the MIT License.
+// See LICENSE file in repository root for complete license text.
+
+//go:build !linux
+
+package daemon
+
+import "errors"
+
+// Crashes are only redirected to a file on Linux, elsewhere they are left to
+// standard error.
+func redirectCrashOutput(path string) error {
+	return errors.New("A crash log is not supported on this platform")
+}
@@ -0,0 +1,331 @@
+// Copyright (c) 2024 Evan Overman (https://an-prata.it).
+// Licensed under the MIT License.
+// See LICENSE file in repository root for complete license text.
+
+package daemon
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/an-prata/webby/logger"
+	"github.com/an-prata/webby/server"
+)
+
+// The state of a scheduled job, as listed by the jobs query.
+type JobStatus struct {
+	server.CronJob
+
+	// When the job next runs, zero if never.
+	Next time.Time
+
+	// When the job last started, zero if it has not run, and how long it took in
+	// milliseconds.
+	LastRun        time.Time
+	LastDurationMs int64
+
+	// Why the job last failed, empty if it succeeded.
+	LastError string
+
+	Runs     int64
+	Failures int64
+	Running  bool
+}
+
+// Scheduled jobs and their state, see `StartCron()`.
+type JobList struct {
+	mutex sync.Mutex
+	jobs  []*JobStatus
+
+	// Schedule of each job, by its index in the list.
+	schedules []server.CronSchedule
+}
+
+// Runs each of the given jobs on its schedule by the given function in a
+// seperate thread, until sent through the returned channel. Jobs are not run
+// again while still running. Jobs with invalid schedules are logged and never
+// run. The returned list gives the state of each job for the jobs query.
+func StartCron(jobs []server.CronJob, run func(server.CronJob) error) (*JobList, chan bool) {
+	stopChan := make(chan bool, 1)
+	list := &JobList{}
+	now := time.Now()
+
+	for i, job := range jobs {
+		if job.Name == "" {
+			job.Name = job.Task + "-" + strconv.Itoa(i)
+		}
+
+		schedule, err := server.ParseCronSchedule(job.Schedule)
+		status := &JobStatus{CronJob: job}
+
+		if err != nil {
+			logger.GlobalLog.LogErr("Not running job '" + job.Name + "': " + err.Error())
+			status.LastError = err.Error()
+		} else {
+			status.Next = schedule.Next(now)
+			logger.GlobalLog.LogInfo("Scheduled job '" + job.Name + "' (" + job.Task + ") for '" + job.Schedule + "', next at " + status.Next.Format(time.RFC3339))
+		}
+
+		list.jobs = append(list.jobs, status)
+		list.schedules = append(list.schedules, schedule)
+	}
+
+	if len(list.jobs) == 0 {
+		return list, stopChan
+	}
+
+	server.Supervise("scheduled jobs", func() error {
+		for {
+			timer := time.NewTimer(list.untilNext())
+
+			select {
+			case <-stopChan:
+				timer.Stop()
+				return nil
+			case <-timer.C:
+			}
+
+			for _, job := range list.due(time.Now()) {
+				go list.runJob(job, run)
+			}
+		}
+	})
+
+	return list, stopChan
+}
+
+// Gives the time until the next job is due, or an hour if none are, after which
+// the jobs are looked at again.
+func (l *JobList) untilNext() time.Duration {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	wait := time.Hour
+
+	for _, job := range l.jobs {
+		if !job.Next.IsZero() && time.Until(job.Next) < wait {
+			wait = time.Until(job.Next)
+		}
+	}
+
+	if wait < 0 {
+		wait = 0
+	}
+
+	return wait
+}
+
+// Gives each job due at the given time, scheduling each of them to run next,
+// other than those still running, which are skipped.
+func (l *JobList) due(now time.Time) []*JobStatus {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	due := []*JobStatus{}
+
+	for i, job := range l.jobs {
+		if job.Next.IsZero() || job.Next.After(now) {
+			continue
+		}
+
+		job.Next = l.schedules[i].Next(now)
+
+		if job.Running {
+			logger.GlobalLog.LogWarn("Skipping job '" + job.Name + "', which is still running from " + job.LastRun.Format(time.RFC3339))
+			continue
+		}
+
+		job.Running = true
+		job.LastRun = now
+		due = append(due, job)
+	}
+
+	return due
+}
+
+// Runs the given job by the given function, recording the result.
+func (l *JobList) runJob(job *JobStatus, run func(server.CronJob) error) {
+	l.mutex.Lock()
+	cronJob := job.CronJob
+	l.mutex.Unlock()
+
+	logger.GlobalLog.LogInfo("Running job '" + cronJob.Name + "' (" + cronJob.Task + ")...")
+	start := time.Now()
+	err := run(cronJob)
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	job.Running = false
+	job.Runs++
+	job.LastDurationMs = time.Since(start).Milliseconds()
+	job.LastError = ""
+
+	if err != nil {
+		job.Failures++
+		job.LastError = err.Error()
+		logger.GlobalLog.LogErr("Job '" + cronJob.Name + "' failed: " + err.Error())
+		return
+	}
+
+	logger.GlobalLog.LogInfo("Job '" + cronJob.Name + "' finished in " + time.Since(start).Round(time.Millisecond).String())
+}
+
+// Gives the state of every job as JSON, sorted by name.
+func (l *JobList) json() ([]byte, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	jobs := make([]JobStatus, 0, len(l.jobs))
+
+	for _, job := range l.jobs {
+		jobs = append(jobs, *job)
+	}
+
+	sort.SliceStable(jobs, func(i, j int) bool {
+		return jobs[i].Name < jobs[j].Name
+	})
+
+	return json.Marshal(jobs)
+}
+
+// Returns a function that runs a scheduled job's task on the servers it applies
+// to, notifying of expiring certificates and of failures through the given
+// notifier.
+func GetJobRunner(
+	opts server.ServerOptions,
+	servers map[string]*server.Server,
+	serverCommandChans map[string]chan server.ServerThreadCommand,
+	notifier *Notifier,
+) func(server.CronJob) error {
+	return func(job server.CronJob) error {
+		err := runTask(job, opts, servers, serverCommandChans, notifier)
+
+		if err != nil {
+			notifier.Notify(EventJob, job.Instance, "Job '"+job.Name+"' failed: "+err.Error())
+		}
+
+		return err
+	}
+}
+
+// Runs the task of the given job, see `server.CronTasks`.
+func runTask(
+	job server.CronJob,
+	opts server.ServerOptions,
+	servers map[string]*server.Server,
+	serverCommandChans map[string]chan server.ServerThreadCommand,
+	notifier *Notifier,
+) error {
+	targets := servers
+
+	if job.Instance != "" {
+		srv, ok := servers[job.Instance]
+
+		if !ok {
+			return errors.New("No server instance named '" + job.Instance + "'")
+		}
+
+		targets = map[string]*server.Server{job.Instance: srv}
+	}
+
+	switch job.Task {
+	case server.TaskReloadCerts:
+		for name, srv := range targets {
+			if len(srv.CertificatePaths()) == 0 {
+				continue
+			}
+
+			if err := srv.ReloadCertificates(); err != nil {
+				return errors.New("Could not reload certificates of '" + name + "': " + err.Error())
+			}
+		}
+
+	case server.TaskCheckCerts:
+		expiring := []string{}
+
+		for name, srv := range targets {
+			for cert, expiry := range srv.CertificateExpiries() {
+				if time.Until(expiry) > server.CertExpiryWarning {
+					continue
+				}
+
+				message := "Certificate '" + cert + "' of '" + name + "' expires " + expiry.Format(time.RFC3339)
+				logger.GlobalLog.LogWarn(message)
+				notifier.Notify(EventCertExpiry, name, message)
+				expiring = append(expiring, cert)
+			}
+		}
+
+		if len(expiring) > 0 {
+			return errors.New("Certificates expire soon: " + strings.Join(expiring, ", "))
+		}
+
+	case server.TaskPurgeImageCache:
+		purged := map[string]bool{}
+
+		for _, srv := range targets {
+			instanceOpts := srv.Options()
+
+			if len(instanceOpts.ImageResizePrefixes) == 0 || purged[instanceOpts.ImageCacheDir] {
+				continue
+			}
+
+			purged[instanceOpts.ImageCacheDir] = true
+
+			if err := server.PurgeImageCache(instanceOpts.ImageCacheDir); err != nil {
+				return err
+			}
+		}
+
+	case server.TaskStatsDump:
+		if opts.StatsFile == "" {
+			return errors.New("No 'StatsFile' to write stats to")
+		}
+
+		return writeStats(opts.StatsFile, targets)
+
+	case server.TaskRestart:
+		for name := range targets {
+			serverCommandChans[name] <- server.Restart
+		}
+
+	case server.TaskBuild:
+		for name, srv := range targets {
+			if srv.Options().BuildSource == "" {
+				continue
+			}
+
+			if err := server.BuildSite(srv.Options()); err != nil {
+				return err
+			}
+
+			serverCommandChans[name] <- server.Restart
+		}
+
+	case server.TaskCommand:
+		cmd := exec.Command("sh", "-c", job.Command)
+		cmd.Env = append(os.Environ(), "WEBBY_JOB="+job.Name, "WEBBY_INSTANCE="+job.Instance)
+		output, err := cmd.CombinedOutput()
+
+		if err != nil {
+			return errors.New("Command failed: " + err.Error() + ": " + strings.TrimSpace(string(output)))
+		}
+
+		if len(output) > 0 {
+			logger.GlobalLog.LogInfo("Job '" + job.Name + "' output: " + strings.TrimSpace(string(output)))
+		}
+
+	default:
+		return errors.New("Unknown task '" + job.Task + "'")
+	}
+
+	return nil
+}
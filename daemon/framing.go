@@ -0,0 +1,84 @@
+// Copyright (c) 2024 Evan Overman (https://an-prata.it).
+// Licensed under the MIT License.
+// See LICENSE file in repository root for complete license text.
+
+package daemon
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+)
+
+// Largest command the daemon reads, including any text given with it and its
+// argument.
+const MaxCommandBytes = 64 << 10
+
+// Size of the read of an unframed command, as sent by clients from before
+// commands were framed.
+const legacyCommandBytes = 526
+
+// Writes the given command and argument to the daemon's socket, preceded by
+// their length as a 4 byte big endian integer so that the daemon reads all of
+// the command however it arrives. Commands longer than `MaxCommandBytes` are
+// not sent.
+func WriteCommand(socket net.Conn, command DaemonCommand, arg DaemonCommandArg) error {
+	size := len(command) + 1
+
+	if size > MaxCommandBytes {
+		return errors.New("Command is " + strconv.Itoa(size) + " bytes, more than the " + strconv.Itoa(MaxCommandBytes) + " webby's daemon accepts")
+	}
+
+	frame := make([]byte, 4, 4+size)
+	binary.BigEndian.PutUint32(frame, uint32(size))
+	frame = append(frame, command...)
+	frame = append(frame, byte(arg))
+
+	_, err := socket.Write(frame)
+	return err
+}
+
+// Reads a command and its argument as written by `WriteCommand()`. Since a
+// frame of at most `MaxCommandBytes` begins with a zero byte, which no command
+// does, commands from older clients are told apart and read as before, all in
+// one read with the argument as the last byte.
+func readCommand(connection net.Conn) (DaemonCommand, DaemonCommandArg, error) {
+	var header [4]byte
+
+	if _, err := io.ReadFull(connection, header[:1]); err != nil {
+		return "", 0, err
+	}
+
+	if header[0] != 0 {
+		var buf [legacyCommandBytes]byte
+		buf[0] = header[0]
+		n, _ := connection.Read(buf[1:])
+		n++
+
+		if n < 2 {
+			return "", 0, errors.New("Command is empty")
+		}
+
+		return DaemonCommand(buf[:n-1]), DaemonCommandArg(buf[n-1]), nil
+	}
+
+	if _, err := io.ReadFull(connection, header[1:]); err != nil {
+		return "", 0, err
+	}
+
+	size := binary.BigEndian.Uint32(header[:])
+
+	if size < 1 || size > MaxCommandBytes {
+		return "", 0, errors.New("Command of " + strconv.FormatUint(uint64(size), 10) + " bytes is empty or too long")
+	}
+
+	payload := make([]byte, size)
+
+	if _, err := io.ReadFull(connection, payload); err != nil {
+		return "", 0, err
+	}
+
+	return DaemonCommand(payload[:size-1]), DaemonCommandArg(payload[size-1]), nil
+}
@@ -0,0 +1,88 @@
+// Copyright (c) 2024 Evan Overman (https://an-prata.it).
+// Licensed under the MIT License.
+// See LICENSE file in repository root for complete license text.
+
+package daemon
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestCommandFraming(t *testing.T) {
+	tests := []struct {
+		name    string
+		command DaemonCommand
+		arg     DaemonCommandArg
+	}{
+		{"bare", Stop, 0},
+		{"argument", LogPrint, 7},
+		{"long text", TextCommand(InstanceCommand(ConfigSet, "site"), "Headers "+strings.Repeat("x", 4000)), 1},
+		{"largest", DaemonCommand(strings.Repeat("d", MaxCommandBytes-1)), 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer server.Close()
+
+			go func() {
+				defer client.Close()
+
+				if err := WriteCommand(client, test.command, test.arg); err != nil {
+					t.Error(err)
+				}
+			}()
+
+			command, arg, err := readCommand(server)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if command != test.command || arg != test.arg {
+				t.Errorf("expected %q bytes with argument %d, got %d bytes with argument %d", len(test.command), test.arg, len(command), arg)
+			}
+		})
+	}
+}
+
+func TestLegacyCommand(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+
+	go func() {
+		defer client.Close()
+		client.Write(append([]byte(InstanceCommand(Restart, "site")), 0))
+	}()
+
+	command, arg, err := readCommand(server)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if command != InstanceCommand(Restart, "site") || arg != 0 {
+		t.Errorf("expected %q with argument 0, got %q with argument %d", InstanceCommand(Restart, "site"), command, arg)
+	}
+}
+
+func TestOversizedCommand(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	if err := WriteCommand(client, DaemonCommand(strings.Repeat("d", MaxCommandBytes)), 0); err == nil {
+		t.Errorf("expected a command over %d bytes to be refused", MaxCommandBytes)
+	}
+
+	go func() {
+		// A header claiming more than the daemon reads.
+		client.Write([]byte{0, 0xff, 0xff, 0xff})
+	}()
+
+	if _, _, err := readCommand(server); err == nil {
+		t.Errorf("expected an oversized frame to be refused")
+	}
+}
@@ -0,0 +1,357 @@
+// Copyright (c) 2024 Evan Overman (https://an-prata.it).
+// Licensed under the MIT License.
+// See LICENSE file in repository root for complete license text.
+
+package daemon
+
+import (
+	"expvar"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/an-prata/webby/logger"
+	"github.com/an-prata/webby/server"
+)
+
+// Type alias for the function signature called when automatic health checks
+// fail consecutively. Given the most recent status and the number of
+// consecutive failures.
+type HealthAlertCallback func(status WebbyStatus, failures int64)
+
+// Type alias for the function signature called when automatic health checks
+// succeed again after an alert was raised. Given the number of consecutive
+// failures before.
+type HealthRecoveryCallback func(failures int64)
+
+// The longest time waited between restarts of a server whose automatic status
+// checks keep failing, see `GetHealthRestartCallback()`.
+const maxHealthRestartBackoff = 30 * time.Minute
+
+// The result of a single automatic status check, see `HealthHistory`.
+type HealthSample struct {
+	Time   time.Time
+	Status WebbyStatus
+
+	// Milliseconds taken by the whole check.
+	LatencyMs float64
+}
+
+// A summary of the automatic status checks kept by a `HealthHistory`, given in
+// status reports.
+type HealthSummary struct {
+	Checks int
+	Since  time.Time
+
+	// Fraction of checks that gave `Ok`, from zero to one.
+	SuccessRate float64
+
+	LatencyP50Ms float64
+	LatencyP90Ms float64
+	LatencyP99Ms float64
+
+	// When the current run of failed checks began, nil if the last check
+	// succeeded.
+	FailingSince *time.Time
+
+	// When a check last failed, nil if none kept have.
+	LastFailure *time.Time
+}
+
+// A ring buffer of the most recent automatic status checks of a server
+// instance, safe to use from multiple threads.
+type HealthHistory struct {
+	mutex   sync.Mutex
+	samples []HealthSample
+	next    int
+	full    bool
+}
+
+// Histories of each server instance by name, kept across reloads so that a
+// reload does not hide how long checks have been failing.
+var instanceHealthHistories = map[string]*HealthHistory{}
+var instanceHealthHistoriesMutex sync.Mutex
+var publishHealthOnce sync.Once
+
+// Creates an empty history keeping the given number of samples.
+func NewHealthHistory(size int) *HealthHistory {
+	return &HealthHistory{samples: make([]HealthSample, size)}
+}
+
+// Gives the history of the named server instance, keeping the given number of
+// samples, or nil for a size less than one. The history from before a reload is
+// given again, keeping its most recent samples if its size changed. Histories
+// are published through `expvar` as "health" on first use, see
+// `KeepHealthHistories()`.
+func GetHealthHistory(name string, size int64) *HealthHistory {
+	publishHealthOnce.Do(func() {
+		expvar.Publish("health", expvar.Func(func() interface{} {
+			return HealthSummaries()
+		}))
+	})
+
+	instanceHealthHistoriesMutex.Lock()
+	defer instanceHealthHistoriesMutex.Unlock()
+
+	if size < 1 {
+		return nil
+	}
+
+	history, ok := instanceHealthHistories[name]
+
+	if !ok {
+		history = NewHealthHistory(int(size))
+		instanceHealthHistories[name] = history
+	} else if len(history.samples) != int(size) {
+		history.resize(int(size))
+	}
+
+	return history
+}
+
+// Forgets the histories of server instances other than those given, such as
+// those removed from the config by a reload.
+func KeepHealthHistories(keep map[string]*HealthHistory) {
+	instanceHealthHistoriesMutex.Lock()
+	defer instanceHealthHistoriesMutex.Unlock()
+
+	for name, history := range instanceHealthHistories {
+		if keep[name] != history {
+			delete(instanceHealthHistories, name)
+		}
+	}
+}
+
+// Gives a summary of the history of each server instance by name.
+func HealthSummaries() map[string]HealthSummary {
+	instanceHealthHistoriesMutex.Lock()
+	defer instanceHealthHistoriesMutex.Unlock()
+
+	summaries := map[string]HealthSummary{}
+
+	for name, history := range instanceHealthHistories {
+		summaries[name] = history.Summary()
+	}
+
+	return summaries
+}
+
+// Records the given sample, replacing the oldest once full.
+func (h *HealthHistory) Add(sample HealthSample) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.samples[h.next] = sample
+	h.next = (h.next + 1) % len(h.samples)
+	h.full = h.full || h.next == 0
+}
+
+// Gives the samples kept, oldest first.
+func (h *HealthHistory) Samples() []HealthSample {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.ordered()
+}
+
+// Summarizes the samples kept.
+func (h *HealthHistory) Summary() HealthSummary {
+	samples := h.Samples()
+	summary := HealthSummary{Checks: len(samples)}
+
+	if len(samples) == 0 {
+		return summary
+	}
+
+	summary.Since = samples[0].Time
+	latencies := make([]float64, 0, len(samples))
+	succeeded := 0
+
+	for _, sample := range samples {
+		latencies = append(latencies, sample.LatencyMs)
+
+		if sample.Status == Ok {
+			succeeded++
+			summary.FailingSince = nil
+			continue
+		}
+
+		failed := sample.Time
+		summary.LastFailure = &failed
+
+		if summary.FailingSince == nil {
+			summary.FailingSince = &failed
+		}
+	}
+
+	sort.Float64s(latencies)
+	summary.SuccessRate = float64(succeeded) / float64(len(samples))
+	summary.LatencyP50Ms = percentile(latencies, 0.50)
+	summary.LatencyP90Ms = percentile(latencies, 0.90)
+	summary.LatencyP99Ms = percentile(latencies, 0.99)
+	return summary
+}
+
+// Gives the samples kept, oldest first, without locking.
+func (h *HealthHistory) ordered() []HealthSample {
+	if !h.full {
+		return append([]HealthSample{}, h.samples[:h.next]...)
+	}
+
+	return append(append([]HealthSample{}, h.samples[h.next:]...), h.samples[:h.next]...)
+}
+
+// Changes the number of samples kept, keeping the most recent.
+func (h *HealthHistory) resize(size int) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	samples := h.ordered()
+
+	if len(samples) > size {
+		samples = samples[len(samples)-size:]
+	}
+
+	h.samples = make([]HealthSample, size)
+	h.next = copy(h.samples, samples) % size
+	h.full = len(samples) == size
+}
+
+// Gives the value at the given fraction of the sorted values, by the nearest
+// rank.
+func percentile(sorted []float64, fraction float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+
+	rank := int(math.Ceil(fraction*float64(len(sorted)))) - 1
+
+	if rank < 0 {
+		rank = 0
+	} else if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+
+	return sorted[rank]
+}
+
+// Runs the given status callback of the named server instance, or of all of
+// them for an empty name, every interval in a seperate thread, logging any
+// degradation in status and recording each result in the given history if it
+// is not nil. A check fails with a status of `HttpPartialFail` or worse, some
+// paths not giving 200 is only logged. Once `threshold` consecutive checks have
+// failed the alert callback is called, it will be called again for every
+// following failure until a check succeeds, when the recovery callback is
+// called if it is not nil. Send through the returned channel to stop checking.
+func StartHealthChecks(
+	name string,
+	status DaemonCommandCallback,
+	interval time.Duration,
+	threshold int64,
+	history *HealthHistory,
+	alert HealthAlertCallback,
+	recovered HealthRecoveryCallback,
+) chan bool {
+	stopChan := make(chan bool, 1)
+	subject := "Automatic health check"
+
+	if name != "" {
+		subject += " of '" + name + "'"
+	}
+
+	server.Supervise("health checks", func() error {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		var failures int64 = 0
+
+		for {
+			select {
+			case <-stopChan:
+				return nil
+			case <-ticker.C:
+			}
+
+			start := time.Now()
+			result := WebbyStatus(status(0))
+
+			if history != nil {
+				history.Add(HealthSample{start, result, float64(time.Since(start).Microseconds()) / 1000})
+			}
+
+			if result < HttpPartialFail {
+				if result != Ok {
+					logger.GlobalLog.LogWarn(subject + " degraded (" + result.String() + ")")
+				}
+
+				if failures > 0 {
+					logger.GlobalLog.LogInfo(subject + " recovered after " + strconv.FormatInt(failures, 10) + " failure(s)")
+				}
+
+				if failures >= threshold && recovered != nil {
+					recovered(failures)
+				}
+
+				failures = 0
+				continue
+			}
+
+			failures++
+			logger.GlobalLog.LogWarn(subject + " failed (" + result.String() + ")")
+
+			if failures >= threshold {
+				alert(result, failures)
+			}
+		}
+	})
+
+	return stopChan
+}
+
+// Returns an alert callback that restarts the named server instance through the
+// given command channel for a status of `HttpFail` or `ServerDown`. While checks
+// keep failing each restart waits twice as long since the last as the one
+// before it, starting from the given interval, up to `maxHealthRestartBackoff`.
+func GetHealthRestartCallback(name string, interval time.Duration, commandChan chan server.ServerThreadCommand) HealthAlertCallback {
+	var backoff time.Duration
+	var lastRestart time.Time
+	var lastFailures int64
+
+	return func(status WebbyStatus, failures int64) {
+		// Failures are only counted up while checks keep failing, so a count no
+		// greater than the last begins a new run of failures.
+		if failures <= lastFailures {
+			backoff = 0
+		}
+
+		lastFailures = failures
+
+		// Restarting only helps a listener that is down or not answering at all,
+		// not one serving some paths poorly.
+		if status < HttpFail {
+			logger.GlobalLog.LogWarn("Not restarting '" + name + "' for status '" + status.String() + "'")
+			return
+		}
+
+		if time.Since(lastRestart) < backoff {
+			logger.GlobalLog.LogWarn("Waiting until " + lastRestart.Add(backoff).Format(time.TimeOnly) + " to restart '" + name + "' again")
+			return
+		}
+
+		select {
+		case commandChan <- server.Restart:
+			logger.GlobalLog.LogWarn("Restarting '" + name + "' after " + strconv.FormatInt(failures, 10) + " failed health checks")
+		default:
+			logger.GlobalLog.LogWarn("Could not restart '" + name + "', a command is already waiting to be handled")
+		}
+
+		lastRestart = time.Now()
+
+		if backoff == 0 {
+			backoff = interval
+		} else if backoff *= 2; backoff > maxHealthRestartBackoff {
+			backoff = maxHealthRestartBackoff
+		}
+	}
+}
@@ -5,16 +5,30 @@
 package daemon
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
 	"os"
+	"os/user"
+	"path/filepath"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/an-prata/webby/logger"
 )
 
 // The path of the Unix Domain Socket created by webby for accepting commands.
-const SocketPath = "/run/webby.sock"
+// It is kept in its own directory so that systemd may create it for the service
+// user, see `client.InstallSystemdService()`.
+const SocketPath = "/run/webby/webby.sock"
+
+// The time between each response of a stream.
+const StreamInterval = time.Second
 
 type DaemonListener struct {
 	// The Unix socket by which to listen for incoming commands/requests.
@@ -25,6 +39,23 @@ type DaemonListener struct {
 	// should be everything up to that.
 	callbacks map[DaemonCommand]DaemonCommandCallback
 
+	// A map of daemon queries to their callbacks. Queries take their argument the
+	// same way as commands but respond with text following their success byte.
+	queries map[DaemonCommand]DaemonQueryCallback
+
+	// A map of daemon text queries to their callbacks. Text queries are given the
+	// text following their command, see `TextCommand()`.
+	textQueries map[DaemonCommand]DaemonTextQueryCallback
+
+	// A map of daemon streams to their callbacks. Streams take their argument the
+	// same way as commands and respond with their success byte followed by text
+	// from their callback every `StreamInterval`.
+	streams map[DaemonCommand]DaemonStreamCallback
+
+	// Group whose members, along with root and the daemon's own user, may send
+	// commands. Negative for none, see `DaemonListener.AllowGroup()`.
+	allowedGid int
+
 	shuttingOff bool
 
 	// Channel for blocking the `Close()` function to prevent bad memory access.
@@ -33,12 +64,88 @@ type DaemonListener struct {
 
 // Creates a new Unix Domain Socket and returns a pointer to a listener for
 // application commands and requests on that socket. When the listener is
-// started all commands will be executed according to the given callbacks.
-func NewDaemonListener(callbacks map[DaemonCommand]DaemonCommandCallback) (DaemonListener, error) {
+// started all commands, queries, text queries, and streams will be executed
+// according to the given callbacks.
+func NewDaemonListener(
+	callbacks map[DaemonCommand]DaemonCommandCallback,
+	queries map[DaemonCommand]DaemonQueryCallback,
+	textQueries map[DaemonCommand]DaemonTextQueryCallback,
+	streams map[DaemonCommand]DaemonStreamCallback,
+) (DaemonListener, error) {
+	os.MkdirAll(filepath.Dir(SocketPath), 0755)
 	os.Remove(SocketPath)
 	socket, err := net.Listen("unix", SocketPath)
+
+	// Allow members of the socket's group to connect, commands are then only
+	// accepted from users allowed by `DaemonListener.authorized()`.
+	if err == nil {
+		os.Chmod(SocketPath, 0660)
+	}
+
 	shutoffChannel := make(chan bool, 1)
-	return DaemonListener{socket, callbacks, false, shutoffChannel}, err
+	return DaemonListener{socket, callbacks, queries, textQueries, streams, -1, false, shutoffChannel}, err
+}
+
+// Allows members of the named group to send commands, in addition to root and
+// the daemon's own user, giving the group ownership of the socket so that its
+// members may connect. An empty name allows no additional group.
+func (daemon *DaemonListener) AllowGroup(name string) error {
+	if name == "" {
+		daemon.allowedGid = -1
+		return nil
+	}
+
+	group, err := user.LookupGroup(name)
+
+	if err != nil {
+		return errors.New("Could not find control group '" + name + "': " + err.Error())
+	}
+
+	gid, err := strconv.Atoi(group.Gid)
+
+	if err != nil {
+		return errors.New("Could not parse GID of control group '" + name + "'")
+	}
+
+	if err = os.Chown(SocketPath, -1, gid); err != nil {
+		return errors.New("Could not give control group '" + name + "' ownership of '" + SocketPath + "': " + err.Error())
+	}
+
+	logger.GlobalLog.LogInfo("Allowing members of group '" + name + "' to send daemon commands")
+	daemon.allowedGid = gid
+	return nil
+}
+
+// Returns true if the user of the given UID may send commands, being root, the
+// daemon's own user, or a member of the allowed group.
+func (daemon *DaemonListener) authorized(uid int) bool {
+	if uid == 0 || uid == os.Getuid() {
+		return true
+	}
+
+	if daemon.allowedGid < 0 {
+		return false
+	}
+
+	peer, err := user.LookupId(strconv.Itoa(uid))
+
+	if err != nil {
+		return false
+	}
+
+	groups, err := peer.GroupIds()
+
+	if err != nil {
+		return false
+	}
+
+	for _, gid := range groups {
+		if gid == strconv.Itoa(daemon.allowedGid) {
+			return true
+		}
+	}
+
+	return false
 }
 
 // Starts listening for connections on the Unix Domain Socket. Each connection
@@ -83,26 +190,75 @@ func (daemon *DaemonListener) handleConnection(connection net.Conn, wg *sync.Wai
 	defer connection.Close()
 	defer wg.Done()
 
-	var buf [526]byte
-	n, err := connection.Read(buf[:])
+	defer func() {
+		if r := recover(); r != nil {
+			logger.GlobalLog.LogErr(fmt.Sprintf("Recovered from panic handling daemon connection: %v", r))
+			logger.GlobalLog.LogErr(string(debug.Stack()))
+		}
+	}()
+
+	uid, pid, err := peerCredentials(connection)
+
+	// A peer that cannot be identified cannot be authorized either.
+	if err != nil {
+		logger.GlobalLog.LogWarn("Refused daemon connection from unknown peer: " + err.Error())
+		connection.Write([]byte{byte(Refused)})
+		return
+	}
+
+	if !daemon.authorized(uid) {
+		logger.GlobalLog.LogWarn("Refused daemon connection from UID " + strconv.Itoa(uid) + " (PID " + strconv.Itoa(pid) + ")")
+		connection.Write([]byte{byte(Refused)})
+		return
+	}
+
+	logger.GlobalLog.LogInfo("Daemon connection from UID " + strconv.Itoa(uid) + " (PID " + strconv.Itoa(pid) + ")")
+
+	command, arg, err := readCommand(connection)
 
 	if err != nil {
-		logger.GlobalLog.LogErr("Could not read from daemon connection")
+		logger.GlobalLog.LogErr("Could not read from daemon connection: " + err.Error())
 		return
 	}
 
-	fn, ok := daemon.callbacks[DaemonCommand(buf[:n-1])]
+	if command == Hello {
+		connection.Write(append([]byte{byte(Success)}, daemon.hello()...))
+		return
+	}
+
+	if name, text, ok := strings.Cut(string(command), TextSeparator); ok {
+		if query, ok := daemon.textQueries[DaemonCommand(name)]; ok {
+			ret, response := query(text, arg)
+			connection.Write(append([]byte{byte(ret)}, []byte(response)...))
+			return
+		}
+	}
+
+	if query, ok := daemon.queries[command]; ok {
+		ret, text := query(arg)
+		connection.Write(append([]byte{byte(ret)}, []byte(text)...))
+		return
+	}
+
+	if stream, ok := daemon.streams[command]; ok {
+		daemon.runStream(connection, stream, arg)
+		return
+	}
+
+	fn, ok := daemon.callbacks[command]
 
 	if !ok {
-		logger.GlobalLog.LogErr("No callback for requested daemon command " + string(buf[:n-1]))
+		logger.GlobalLog.LogErr("No callback for requested daemon command " + string(command))
+		connection.Write(append([]byte{byte(UnknownCommand)}, []byte("unknown command '"+string(command)+"'")...))
+		return
 	}
 
-	ret := fn(DaemonCommandArg(buf[n-1]))
+	ret := fn(arg)
 
 	// We ont compare directly to `Success` in order to allow for commands to use
 	// the available 7 bits of their return value.
 	if ret&Success != Success {
-		logger.GlobalLog.LogErr((fmt.Sprintf("Failed to respond to command: %s %d", string(buf[:n-1]), uint8(buf[n-1]))))
+		logger.GlobalLog.LogErr((fmt.Sprintf("Failed to respond to command: %s %d", string(command), uint8(arg))))
 
 		// Giving the `ret` variable rather than just the `Success` constant is
 		// important for allowing some commands to use the other 7 bits available in
@@ -112,3 +268,56 @@ func (daemon *DaemonListener) handleConnection(connection net.Conn, wg *sync.Wai
 		connection.Write([]byte{byte(ret)})
 	}
 }
+
+// Gives a `HelloResponse` describing the daemon as JSON.
+func (daemon *DaemonListener) hello() []byte {
+	// Commands may be registered as both a callback and a query.
+	set := map[string]bool{Hello: true}
+
+	for command := range daemon.callbacks {
+		set[string(command)] = true
+	}
+
+	for command := range daemon.queries {
+		set[string(command)] = true
+	}
+
+	for command := range daemon.textQueries {
+		set[string(command)] = true
+	}
+
+	for command := range daemon.streams {
+		set[string(command)] = true
+	}
+
+	commands := make([]string, 0, len(set))
+
+	for command := range set {
+		commands = append(commands, command)
+	}
+
+	sort.Strings(commands)
+	response, err := json.Marshal(HelloResponse{ProtocolVersion, Version(), commands})
+
+	if err != nil {
+		logger.GlobalLog.LogErr("Could not encode hello response: " + err.Error())
+	}
+
+	return response
+}
+
+// Writes the text of the given stream callback to the connection every
+// `StreamInterval` until the client disconnects or the listener is closed.
+func (daemon *DaemonListener) runStream(connection net.Conn, stream DaemonStreamCallback, arg DaemonCommandArg) {
+	if _, err := connection.Write([]byte{byte(Success)}); err != nil {
+		return
+	}
+
+	for !daemon.shuttingOff {
+		if _, err := connection.Write([]byte(stream(arg))); err != nil {
+			return
+		}
+
+		time.Sleep(StreamInterval)
+	}
+}
@@ -0,0 +1,199 @@
+// Copyright (c) 2024 Evan Overman (https://an-prata.it).
+// Licensed under the MIT License.
+// See LICENSE file in repository root for complete license text.
+
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/an-prata/webby/logger"
+	"github.com/an-prata/webby/server"
+)
+
+// Events that may be notified of, see `server.NotificationOptions`.
+const (
+	EventStart        = "start"
+	EventStop         = "stop"
+	EventReload       = "reload"
+	EventCertReload   = "cert-reload"
+	EventCertExpiry   = "cert-expiry"
+	EventHealth       = "health"
+	EventServerErrors = "server-errors"
+	EventJob          = "job"
+)
+
+// Time given to a webhook to respond or a command to finish before it is
+// abandoned.
+const (
+	webhookTimeout = 10 * time.Second
+	commandTimeout = 30 * time.Second
+)
+
+// An event as sent to the configured webhook and command. The message is given
+// as "text" so that chat webhooks, such as Slack's, show it as is.
+type NotificationEvent struct {
+	Event    string    `json:"event"`
+	Instance string    `json:"instance"`
+	Message  string    `json:"text"`
+	Host     string    `json:"host"`
+	Time     time.Time `json:"time"`
+}
+
+// Sends events to the webhook and command given by its options in the
+// background, see `Notifier.Notify()`.
+type Notifier struct {
+	opts server.NotificationOptions
+
+	// Events to notify of, nil for all of them.
+	events map[string]bool
+
+	// Counts notifications still being sent, see `Notifier.Wait()`.
+	pending sync.WaitGroup
+}
+
+// Creates a notifier for the given options.
+func NewNotifier(opts server.NotificationOptions) *Notifier {
+	var events map[string]bool
+
+	if len(opts.Events) > 0 {
+		events = map[string]bool{}
+
+		for _, event := range opts.Events {
+			events[event] = true
+		}
+	}
+
+	return &Notifier{opts, events, sync.WaitGroup{}}
+}
+
+// Sends the given event, concerning the named server instance or the whole
+// daemon for an empty name, to the configured webhook and command in a seperate
+// thread. Does nothing if the event is not one to notify of.
+func (n *Notifier) Notify(event, instance, message string) {
+	if (n.opts.Webhook == "" && n.opts.Command == "") || (n.events != nil && !n.events[event]) {
+		return
+	}
+
+	host, _ := os.Hostname()
+	notification := NotificationEvent{event, instance, message, host, time.Now()}
+	body, err := json.Marshal(notification)
+
+	if err != nil {
+		logger.GlobalLog.LogErr("Could not encode '" + event + "' notification: " + err.Error())
+		return
+	}
+
+	n.pending.Add(1)
+
+	go func() {
+		defer n.pending.Done()
+
+		if n.opts.Webhook != "" {
+			if err := n.post(body); err != nil {
+				logger.GlobalLog.LogErr("Could not send '" + event + "' notification to webhook: " + err.Error())
+			}
+		}
+
+		if n.opts.Command != "" {
+			if err := n.run(notification, body); err != nil {
+				logger.GlobalLog.LogErr("Notification command failed for '" + event + "': " + err.Error())
+			}
+		}
+	}()
+}
+
+// Blocks until every notification sent has been delivered or abandoned.
+func (n *Notifier) Wait() {
+	n.pending.Wait()
+}
+
+// Posts the given JSON body to the webhook.
+func (n *Notifier) post(body []byte) error {
+	client := http.Client{Timeout: webhookTimeout}
+	response, err := client.Post(n.opts.Webhook, "application/json", bytes.NewReader(body))
+
+	if err != nil {
+		return err
+	}
+
+	response.Body.Close()
+
+	if response.StatusCode >= 300 {
+		return errors.New("Webhook responded with " + response.Status)
+	}
+
+	return nil
+}
+
+// Runs the command through the shell, giving it the event.
+func (n *Notifier) run(notification NotificationEvent, body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", n.opts.Command)
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Env = append(
+		os.Environ(),
+		"WEBBY_EVENT="+notification.Event,
+		"WEBBY_INSTANCE="+notification.Instance,
+		"WEBBY_MESSAGE="+notification.Message,
+	)
+
+	return cmd.Run()
+}
+
+// Checks the 5xx responses of each of the given servers every minute in a
+// seperate thread, notifying of a "server-errors" event for each server that gave
+// at least the given number of them since the last check. Send through the
+// returned channel to stop checking.
+func StartServerErrorWatch(notifier *Notifier, servers map[string]*server.Server, threshold int64) chan bool {
+	stopChan := make(chan bool, 1)
+
+	server.Supervise("server error watch", func() error {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+
+		last := map[string]int64{}
+
+		for name, srv := range servers {
+			last[name] = srv.Handler().Stats().ServerErrors
+		}
+
+		for {
+			select {
+			case <-stopChan:
+				return nil
+			case <-ticker.C:
+			}
+
+			for name, srv := range servers {
+				count := srv.Handler().Stats().ServerErrors
+
+				// Counts start over when a server restarts.
+				if count < last[name] {
+					last[name] = 0
+				}
+
+				if count-last[name] >= threshold {
+					message := strconv.FormatInt(count-last[name], 10) + " responses with a 5xx status in the last minute"
+					logger.GlobalLog.LogWarn("'" + name + "' gave " + message)
+					notifier.Notify(EventServerErrors, name, message)
+				}
+
+				last[name] = count
+			}
+		}
+	})
+
+	return stopChan
+}
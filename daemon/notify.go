@@ -0,0 +1,98 @@
+// Copyright (c) 2024 Evan Overman (https://an-prata.it).
+// Licensed under the MIT License.
+// See LICENSE file in repository root for complete license text.
+
+package daemon
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/an-prata/webby/logger"
+	"github.com/an-prata/webby/server"
+)
+
+// States that may be sent to systemd through `SdNotify()`, see sd_notify(3).
+const (
+	NotifyReady     = "READY=1"
+	NotifyReloading = "RELOADING=1"
+	NotifyStopping  = "STOPPING=1"
+	NotifyWatchdog  = "WATCHDOG=1"
+)
+
+// Sends the given state to systemd's notification socket. Does nothing if webby
+// was not started by systemd with a notification socket, e.g. when the service
+// is not of "Type=notify".
+func SdNotify(state string) error {
+	path := os.Getenv("NOTIFY_SOCKET")
+
+	if path == "" {
+		return nil
+	}
+
+	// Abstract sockets are given with a leading '@'.
+	if path[0] == '@' {
+		path = "\x00" + path[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// Gives the interval at which systemd expects watchdog heartbeats, from the
+// WatchdogSec of the service, or zero if the watchdog is not enabled for this
+// process.
+func WatchdogInterval() time.Duration {
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+
+	if err != nil || usec <= 0 {
+		return 0
+	}
+
+	return time.Duration(usec) * time.Microsecond
+}
+
+// Sends a watchdog heartbeat to systemd at half of the given interval in a
+// seperate thread for as long as the given callback reports webby as alive,
+// so that systemd restarts webby if it hangs or stops serving entirely. Send
+// through the returned channel to stop.
+func StartWatchdog(interval time.Duration, alive func() bool) chan bool {
+	stopChan := make(chan bool, 1)
+
+	server.Supervise("watchdog", func() error {
+		ticker := time.NewTicker(interval / 2)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stopChan:
+				return nil
+			case <-ticker.C:
+			}
+
+			if !alive() {
+				logger.GlobalLog.LogWarn("Withholding watchdog heartbeat, no server instance is serving")
+				continue
+			}
+
+			if err := SdNotify(NotifyWatchdog); err != nil {
+				logger.GlobalLog.LogErr("Could not send watchdog heartbeat: " + err.Error())
+			}
+		}
+	})
+
+	return stopChan
+}
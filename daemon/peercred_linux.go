@@ -0,0 +1,44 @@
+// Copyright (c) 2024 Evan Overman (https://an-prata.it).
+// Licensed under the MIT License.
+// See LICENSE file in repository root for complete license text.
+
+package daemon
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+// Gets the user and process IDs of the peer of a Unix Domain Socket connection
+// using SO_PEERCRED.
+func peerCredentials(connection net.Conn) (uid, pid int, err error) {
+	unixConn, ok := connection.(*net.UnixConn)
+
+	if !ok {
+		return 0, 0, errors.New("Not a Unix Domain Socket connection")
+	}
+
+	raw, err := unixConn.SyscallConn()
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var cred *syscall.Ucred
+	var credErr error
+
+	err = raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if credErr != nil {
+		return 0, 0, credErr
+	}
+
+	return int(cred.Uid), int(cred.Pid), nil
+}
@@ -0,0 +1,18 @@
+// Copyright (c) 2024 Evan Overman (https://an-prata.it).
+// Licensed under the MIT License.
+// See LICENSE file in repository root for complete license text.
+
+//go:build !linux
+
+package daemon
+
+import (
+	"errors"
+	"net"
+)
+
+// Peer credentials are only read on Linux. Elsewhere every connection to the
+// control socket is refused, leaving the admin API to control the daemon.
+func peerCredentials(connection net.Conn) (uid, pid int, err error) {
+	return 0, 0, errors.New("Peer credentials are not supported on this platform")
+}
@@ -5,9 +5,15 @@
 package daemon
 
 import (
+	"log"
 	"os"
 	"os/signal"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
 	"syscall"
+	"time"
 
 	"github.com/an-prata/webby/logger"
 	"github.com/an-prata/webby/server"
@@ -15,8 +21,29 @@ import (
 
 const CONFIG_PATH = "/etc/webby/config.json"
 
+// Time to wait for certificate files to stop changing before reloading them.
+const certReloadDelay = time.Second
+
 // Main function of daemon execution.
 func DaemonMain() {
+	reloaded := false
+	var previousOpts server.ServerOptions
+
+	// Anything logged through the standard library would otherwise be lost, as the
+	// forked daemon has no standard out or error.
+	log.SetFlags(0)
+	log.SetOutput(logger.GlobalLog.Writer(logger.Warn))
+
+	// A crash is written with the stack of every thread rather than only the one
+	// that panicked, see `CrashLog`.
+	debug.SetTraceback("all")
+
+	// Log rotation tools signal SIGHUP once they have moved the log away. This is
+	// handled for as long as the daemon runs, as SIGHUP would otherwise stop it
+	// while reloading.
+	hangupChan := make(chan os.Signal, 1)
+	signal.Notify(hangupChan, syscall.SIGHUP)
+
 Start:
 	opts, err := server.LoadConfigFromPath(CONFIG_PATH)
 
@@ -25,6 +52,11 @@ Start:
 		logger.GlobalLog.LogWarn("Using default configuration due to errors")
 	}
 
+	if err = logger.GlobalLog.SetFormat(opts.LogFormat); err != nil {
+		logger.GlobalLog.LogErr(err.Error())
+		logger.GlobalLog.LogWarn("Using log format 'text' due to errors")
+	}
+
 	opts.Show()
 
 	err = logger.GlobalLog.OpenFile(opts.Log)
@@ -33,92 +65,581 @@ Start:
 		logger.GlobalLog.LogErr("Could not open '" + opts.Log + "' for logging")
 	}
 
-	err = logger.GlobalLog.SetRecordLevelFromString(opts.LogLevelPrint)
+	if opts.CrashLog != "" {
+		if err := redirectCrashOutput(opts.CrashLog); err != nil {
+			logger.GlobalLog.LogErr(err.Error())
+		}
+	}
+
+	if opts.LogTarget.Syslog != "" {
+		if target, err := logger.DialSyslog(opts.LogTarget.Syslog, opts.LogTarget.Tag); err != nil {
+			logger.GlobalLog.LogErr(err.Error())
+		} else {
+			logger.GlobalLog.AddTarget(target)
+		}
+	}
+
+	if opts.LogTarget.Journald {
+		if target, err := logger.DialJournald(opts.LogTarget.Tag); err != nil {
+			logger.GlobalLog.LogErr(err.Error())
+		} else {
+			logger.GlobalLog.AddTarget(target)
+		}
+	}
+
+	err = setLogLevel("LogLevelPrint", opts.LogLevelPrint)
 
 	if err != nil {
 		logger.GlobalLog.LogErr(err.Error())
 		logger.GlobalLog.LogWarn("Using log level 'All' for printing due to errors")
 	}
 
-	err = logger.GlobalLog.SetPrintLevelFromString(opts.LogLevelRecord)
+	err = setLogLevel("LogLevelRecord", opts.LogLevelRecord)
 
 	if err != nil {
 		logger.GlobalLog.LogErr(err.Error())
 		logger.GlobalLog.LogWarn("Using log level 'All' for recording due to errors")
 	}
 
-	srv, err := server.NewServer(opts)
+	if reloaded {
+		logConfigChanges(previousOpts, opts)
+	}
 
-	if err != nil {
-		logger.GlobalLog.LogErr(err.Error())
-		return
+	for _, instanceOpts := range opts.ServerInstances() {
+		if instanceOpts.BuildOnReload && instanceOpts.BuildSource != "" {
+			if err := server.BuildSite(instanceOpts); err != nil {
+				logger.GlobalLog.LogErr(err.Error())
+				logger.GlobalLog.LogWarn("Serving the previous build of '" + instanceOpts.Name + "' due to errors")
+			}
+		}
 	}
 
-	serverCommandChan := srv.StartThreaded()
+	notifier := NewNotifier(opts.Notifications)
+	servers := map[string]*server.Server{}
+	serverCommandChans := map[string]chan server.ServerThreadCommand{}
+	statusCallbacks := []DaemonCommandCallback{}
+	statusUrls := map[string][]string{}
+	healthHistories := map[string]*HealthHistory{}
 	signalChan := make(chan os.Signal, 1)
 	signal.Notify(signalChan, syscall.SIGTERM, syscall.SIGINT)
 
-	commandListener, err := NewDaemonListener(map[DaemonCommand]DaemonCommandCallback{
-		Restart:   GetRestartCallback(serverCommandChan),
+	queries := map[DaemonCommand]DaemonQueryCallback{}
+	textQueries := map[DaemonCommand]DaemonTextQueryCallback{}
+	streams := map[DaemonCommand]DaemonStreamCallback{}
+	callbacks := map[DaemonCommand]DaemonCommandCallback{
 		Reload:    GetReloadCallback(signalChan),
 		Stop:      GetStopCallback(signalChan),
-		Status:    GetStatusCallback(srv.ReqHandler),
 		LogRecord: GetLogRecordCallback(),
 		LogPrint:  GetLogPrintCallback(),
-	})
+	}
 
-	if err != nil {
-		logger.GlobalLog.LogErr(err.Error())
-		logger.GlobalLog.LogErr("Could not open Unix Domain Socket")
-		os.Exit(1)
+	// The first server instance that could not be created, which fails the start
+	// as an instance that cannot start serving does.
+	var creationFailure *StartFailureSignal
+
+	for _, instanceOpts := range opts.ServerInstances() {
+		if _, ok := servers[instanceOpts.Name]; ok {
+			logger.GlobalLog.LogErr("Multiple server instances named '" + instanceOpts.Name + "', ignoring all but the first")
+			continue
+		}
+
+		srv, err := server.NewServer(instanceOpts)
+
+		if err != nil {
+			logger.GlobalLog.LogErr(err.Error())
+			logger.GlobalLog.LogErr("Could not create server instance '" + instanceOpts.Name + "'")
+
+			if creationFailure == nil {
+				creationFailure = &StartFailureSignal{instanceOpts.Name, err}
+			}
+
+			continue
+		}
+
+		servers[instanceOpts.Name] = srv
+		serverCommandChans[instanceOpts.Name] = srv.StartThreaded()
+		statusCallback := GetStatusCallback(srv, instanceOpts.StatusUrls)
+		statusCallbacks = append(statusCallbacks, statusCallback)
+		statusUrls[instanceOpts.Name] = instanceOpts.StatusUrls
+
+		// Without automatic checks nothing would be recorded.
+		if opts.HealthCheckInterval > 0 {
+			if history := GetHealthHistory(instanceOpts.Name, instanceOpts.HealthHistorySize); history != nil {
+				healthHistories[instanceOpts.Name] = history
+			}
+		}
+
+		callbacks[InstanceCommand(Restart, instanceOpts.Name)] = GetRestartCallback(serverCommandChans[instanceOpts.Name])
+		callbacks[InstanceCommand(ReloadCerts, instanceOpts.Name)] = GetReloadCertsCallback(srv)
+		callbacks[InstanceCommand(Status, instanceOpts.Name)] = statusCallback
+		queries[InstanceCommand(Status, instanceOpts.Name)] = GetStatusQueryCallback(
+			map[string]*server.Server{instanceOpts.Name: srv},
+			map[string][]string{instanceOpts.Name: instanceOpts.StatusUrls},
+			map[string]*HealthHistory{instanceOpts.Name: healthHistories[instanceOpts.Name]},
+		)
+		queries[InstanceCommand(Quota, instanceOpts.Name)] = GetQuotaQueryCallback(map[string]*server.Server{instanceOpts.Name: srv})
+		queries[InstanceCommand(Hits, instanceOpts.Name)] = GetHitsQueryCallback(map[string]*server.Server{instanceOpts.Name: srv})
+		queries[InstanceCommand(Routes, instanceOpts.Name)] = GetRoutesQueryCallback(map[string]*server.Server{instanceOpts.Name: srv})
+		textQueries[InstanceCommand(ConfigGet, instanceOpts.Name)] = GetConfigGetCallback(&opts, srv)
+		textQueries[InstanceCommand(ConfigSet, instanceOpts.Name)] = GetConfigSetCallback(
+			&opts,
+			instanceOpts.Name,
+			map[string]*server.Server{instanceOpts.Name: srv},
+			map[string]chan server.ServerThreadCommand{instanceOpts.Name: serverCommandChans[instanceOpts.Name]},
+			nil,
+		)
+		textQueries[InstanceCommand(Maintenance, instanceOpts.Name)] = GetMaintenanceCallback(&opts, instanceOpts.Name, map[string]*server.Server{instanceOpts.Name: srv})
+		streams[InstanceCommand(Stats, instanceOpts.Name)] = GetStatsStreamCallback(map[string]*server.Server{instanceOpts.Name: srv})
+		textQueries[InstanceCommand(Deploy, instanceOpts.Name)] = GetDeployCallback(
+			map[string]*server.Server{instanceOpts.Name: srv},
+			map[string]chan server.ServerThreadCommand{instanceOpts.Name: serverCommandChans[instanceOpts.Name]},
+		)
+		queries[InstanceCommand(Rollback, instanceOpts.Name)] = GetRollbackCallback(
+			map[string]*server.Server{instanceOpts.Name: srv},
+			map[string]chan server.ServerThreadCommand{instanceOpts.Name: serverCommandChans[instanceOpts.Name]},
+		)
+	}
+
+	if len(servers) == 0 {
+		logger.GlobalLog.LogErr("No server instances could be created")
+		logger.GlobalLog.Close()
+		return
+	}
+
+	KeepHealthHistories(healthHistories)
+
+	allCommandChans := []chan server.ServerThreadCommand{}
+
+	for _, commandChan := range serverCommandChans {
+		allCommandChans = append(allCommandChans, commandChan)
 	}
 
-	go commandListener.Listen()
+	allServers := []*server.Server{}
 
-	if opts.AutoReload {
-		server.CallOnChange(func(signal server.FileChangeSignal) bool {
-			if signal == server.TimeModifiedChange || signal == server.SizeChange {
+	for _, srv := range servers {
+		allServers = append(allServers, srv)
+	}
+
+	// Watching for changes to reload automatically may be turned on and off while
+	// running, see `server.LiveOptions`.
+	var autoReloadMutex sync.Mutex
+	stopAutoReload := []func(){}
+
+	setAutoReload := func(enabled bool) {
+		autoReloadMutex.Lock()
+		defer autoReloadMutex.Unlock()
+
+		for _, stop := range stopAutoReload {
+			stop()
+		}
+
+		stopAutoReload = []func(){}
+
+		if !enabled {
+			return
+		}
+
+		configPaths := []string{CONFIG_PATH}
+
+		if _, err := os.Stat(server.ConfigFragmentDir(CONFIG_PATH)); err == nil {
+			configPaths = append(configPaths, server.ConfigFragmentDir(CONFIG_PATH))
+		}
+
+		stopAutoReload = append(stopAutoReload, server.WatchPaths(func(signal server.FileChangeSignal) bool {
+			if signal == server.TimeModifiedChange || signal == server.SizeChange || signal == server.CreateChange || signal == server.RemoveChange {
 				logger.GlobalLog.LogInfo("Config file change detected, reloading...")
-				signalChan <- ReloadSignal{}
+				sendReload(signalChan)
 				return true
 			} else if signal == server.InitialReadError || signal == server.ReadError {
 				logger.GlobalLog.LogErr("Failed to read config while checking for change (auto reload is on)")
 			}
 
 			return false
-		}, CONFIG_PATH)
+		}, configPaths...))
+
+		sourcePaths := []string{}
+
+		for _, srv := range servers {
+			sourcePaths = append(sourcePaths, srv.SourcePaths()...)
+		}
+
+		stopAutoReload = append(stopAutoReload, server.WatchPaths(func(signal server.FileChangeSignal) bool {
+			if signal == server.InitialReadError || signal == server.ReadError {
+				logger.GlobalLog.LogErr("Failed to read site files while checking for change (auto reload is on)")
+				return false
+			}
+
+			logger.GlobalLog.LogInfo("Site file change detected, reloading...")
+			sendReload(signalChan)
+			return true
+		}, sourcePaths...))
+	}
+
+	callbacks[Restart] = GetRestartCallback(allCommandChans...)
+	callbacks[ReloadCerts] = GetReloadCertsCallback(allServers...)
+	callbacks[RotateLog] = GetRotateLogCallback(allServers...)
+	callbacks[Status] = GetCombinedStatusCallback(statusCallbacks)
+	queries[Status] = GetStatusQueryCallback(servers, statusUrls, healthHistories)
+	queries[Quota] = GetQuotaQueryCallback(servers)
+	queries[Hits] = GetHitsQueryCallback(servers)
+	queries[Routes] = GetRoutesQueryCallback(servers)
+	textQueries[ConfigGet] = GetConfigGetCallback(&opts, nil)
+	textQueries[ConfigSet] = GetConfigSetCallback(&opts, "", servers, serverCommandChans, setAutoReload)
+	textQueries[Maintenance] = GetMaintenanceCallback(&opts, "", servers)
+	streams[Stats] = GetStatsStreamCallback(servers)
+	textQueries[Deploy] = GetDeployCallback(servers, serverCommandChans)
+	queries[Rollback] = GetRollbackCallback(servers, serverCommandChans)
+
+	jobs, cronStopChan := StartCron(opts.Cron, GetJobRunner(opts, servers, serverCommandChans, notifier))
+	queries[Jobs] = GetJobsQueryCallback(jobs)
+	commandListener, err := NewDaemonListener(callbacks, queries, textQueries, streams)
+
+	if err != nil {
+		logger.GlobalLog.LogErr(err.Error())
+		logger.GlobalLog.LogErr("Could not open Unix Domain Socket")
+		logger.GlobalLog.Sync()
+		os.Exit(1)
+	}
+
+	if err = commandListener.AllowGroup(opts.ControlGroup); err != nil {
+		logger.GlobalLog.LogErr(err.Error())
+		logger.GlobalLog.LogWarn("Only root and the daemon's own user may send daemon commands")
+	}
+
+	server.Supervise("control listener", commandListener.Listen)
+
+	stopAdminApi := func() {}
+
+	if opts.AdminAddress != "" {
+		if stop, err := StartAdminApi(opts.AdminAddress, opts.AdminToken, opts.AdminProfiling, &commandListener); err != nil {
+			logger.GlobalLog.LogErr(err.Error())
+		} else {
+			stopAdminApi = stop
+		}
+	}
 
-		for _, filePath := range srv.ReqHandler.PathMap {
-			server.CallOnChange(func(signal server.FileChangeSignal) bool {
-				if signal == server.TimeModifiedChange || signal == server.SizeChange {
-					logger.GlobalLog.LogInfo("Site file change detected, reloading...")
-					signalChan <- ReloadSignal{}
+	// The control socket is already accepting connections, so systemd is told
+	// webby is ready once every server instance is too, and never if one could
+	// not be created.
+	notifyDone := make(chan struct{})
+
+	go func() {
+		if creationFailure != nil {
+			return
+		}
+
+		for _, srv := range servers {
+			select {
+			case <-srv.Ready():
+			case <-notifyDone:
+				return
+			}
+		}
+
+		if err := SdNotify(NotifyReady); err != nil {
+			logger.GlobalLog.LogErr("Could not notify systemd of readiness: " + err.Error())
+		}
+
+		if !reloaded {
+			notifier.Notify(EventStart, "", "webby started serving "+strconv.Itoa(len(servers))+" server instance(s)")
+		}
+	}()
+
+	// A server that fails before it is first ready, e.g. as its port is taken,
+	// stops the daemon rather than leaving it running without serving. Later
+	// failures are retried by the server, see `server.Server.StartThreaded()`.
+	for name, srv := range servers {
+		name := name
+		srv := srv
+
+		go func() {
+			select {
+			case err := <-srv.Errors():
+				select {
+				case signalChan <- StartFailureSignal{name, err}:
+				case <-notifyDone:
+				}
+			case <-srv.Ready():
+			case <-notifyDone:
+			}
+		}()
+	}
+
+	if creationFailure != nil {
+		go func() {
+			select {
+			case signalChan <- *creationFailure:
+			case <-notifyDone:
+			}
+		}()
+	}
+
+	var watchdogStopChan chan bool
+
+	if interval := WatchdogInterval(); interval > 0 {
+		logger.GlobalLog.LogInfo("Sending systemd watchdog heartbeats every " + (interval / 2).String())
+		watchdogStopChan = StartWatchdog(interval, func() bool {
+			for _, srv := range servers {
+				if !srv.Degraded() {
 					return true
-				} else if signal == server.InitialReadError || signal == server.ReadError {
-					logger.GlobalLog.LogErr("Failed to read site file while checking for change (auto reload is on)")
 				}
+			}
 
-				return false
-			}, filePath)
+			return false
+		})
+	}
+
+	healthStopChans := []chan bool{}
+
+	// Servers are checked seperately so that only failing instances restart.
+	if opts.HealthCheckInterval > 0 {
+		interval := time.Duration(opts.HealthCheckInterval) * time.Second
+
+		for name, srv := range servers {
+			name := name
+			var restart HealthAlertCallback
+
+			if opts.HealthCheckRestart {
+				restart = GetHealthRestartCallback(name, interval, serverCommandChans[name])
+			}
+
+			healthStopChans = append(healthStopChans, StartHealthChecks(
+				name,
+				GetStatusCallback(srv, statusUrls[name]),
+				interval,
+				opts.HealthCheckThreshold,
+				healthHistories[name],
+				func(status WebbyStatus, failures int64) {
+					// Only the first alert of consecutive failures is logged as an error
+					// and notified of, until the checks recover.
+					if failures == opts.HealthCheckThreshold {
+						message := "Automatic health check of '" + name + "' failed " + strconv.FormatInt(failures, 10) + " consecutive time(s), last status: " + status.String()
+						logger.GlobalLog.LogErr(message)
+						notifier.Notify(EventHealth, name, message)
+					}
+
+					if restart != nil {
+						restart(status, failures)
+					}
+				},
+				func(failures int64) {
+					notifier.Notify(EventHealth, name, "Automatic health check of '"+name+"' recovered after "+strconv.FormatInt(failures, 10)+" consecutive failure(s)")
+				},
+			))
+		}
+	}
+
+	var serverErrorStopChan chan bool
+
+	if opts.Notifications.ServerErrorThreshold > 0 {
+		serverErrorStopChan = StartServerErrorWatch(notifier, servers, opts.Notifications.ServerErrorThreshold)
+	}
+
+	var statsStopChan chan bool
+
+	if opts.StatsFile != "" && opts.StatsInterval > 0 {
+		logger.GlobalLog.LogInfo("Writing path hits to '" + opts.StatsFile + "' every " + strconv.FormatInt(opts.StatsInterval, 10) + " seconds")
+		statsStopChan = StartStatsDump(opts.StatsFile, time.Duration(opts.StatsInterval)*time.Second, servers)
+	}
+
+	hangupDone := make(chan struct{})
+
+	go func() {
+		for {
+			select {
+			case <-hangupChan:
+				callbacks[RotateLog](0)
+			case <-hangupDone:
+				return
+			}
+		}
+	}()
+
+	stopWatching := []func(){}
+
+	// Certificates are always watched so that renewals are served without a
+	// restart. Renewals write several files, so reloading waits for them to settle.
+	for name, srv := range servers {
+		name, srv := name, srv
+
+		if len(srv.CertificatePaths()) == 0 {
+			continue
 		}
+
+		var reloadTimer *time.Timer
+
+		stopWatching = append(stopWatching, server.WatchPaths(func(signal server.FileChangeSignal) bool {
+			if signal == server.InitialReadError || signal == server.ReadError {
+				logger.GlobalLog.LogErr("Failed to read certificates of '" + name + "' while checking for renewal")
+				return false
+			}
+
+			if reloadTimer != nil {
+				reloadTimer.Reset(certReloadDelay)
+				return false
+			}
+
+			reloadTimer = time.AfterFunc(certReloadDelay, func() {
+				logger.GlobalLog.LogInfo("Certificate change detected for '" + name + "', reloading certificates...")
+
+				if err := srv.ReloadCertificates(); err != nil {
+					logger.GlobalLog.LogErr(err.Error())
+					logger.GlobalLog.LogWarn("Keeping previous certificates of '" + name + "'")
+					notifier.Notify(EventCertReload, name, "Could not reload certificates, keeping previous certificates: "+err.Error())
+				} else {
+					notifier.Notify(EventCertReload, name, "Reloaded certificates after a change")
+				}
+			})
+
+			return false
+		}, srv.CertificatePaths()...))
 	}
 
+	setAutoReload(opts.AutoReload)
+
 	sig := <-signalChan
-	serverCommandChan <- server.Shutoff
+
+	// A config with problems is not reloaded, leaving the servers running as they
+	// were. Config changes stop the auto reload watchers, so they are restarted.
+	for _, ok := sig.(ReloadSignal); ok && !checkConfig(); _, ok = sig.(ReloadSignal) {
+		setAutoReload(opts.AutoReload)
+		sig = <-signalChan
+	}
+
+	close(notifyDone)
+
+	if _, ok := sig.(ReloadSignal); ok {
+		SdNotify(NotifyReloading)
+		notifier.Notify(EventReload, "", "webby is reloading its configuration")
+	} else if failure, ok := sig.(StartFailureSignal); ok {
+		logger.GlobalLog.LogErr(failure.String())
+		SdNotify(NotifyStopping)
+		notifier.Notify(EventStop, failure.Instance, "webby is stopping as the server instance failed to start: "+failure.Err.Error())
+	} else {
+		SdNotify(NotifyStopping)
+		notifier.Notify(EventStop, "", "webby is stopping after receiving signal: "+sig.String())
+	}
+
+	for _, commandChan := range serverCommandChans {
+		commandChan <- server.Shutoff
+	}
+
 	logger.GlobalLog.LogInfo("Received signal: " + sig.String())
+	close(hangupDone)
+
+	for _, stop := range stopWatching {
+		stop()
+	}
+
+	setAutoReload(false)
+
+	for _, healthStopChan := range healthStopChans {
+		healthStopChan <- true
+	}
+
+	if statsStopChan != nil {
+		statsStopChan <- true
+	}
+
+	cronStopChan <- true
+
+	if serverErrorStopChan != nil {
+		serverErrorStopChan <- true
+	}
+
+	if watchdogStopChan != nil {
+		watchdogStopChan <- true
+	}
 
-	logger.GlobalLog.LogInfo("Closing Unix Domain Socket...")
+	logger.GlobalLog.LogInfo("Closing admin API and Unix Domain Socket...")
+	stopAdminApi()
 	commandListener.Close()
 
-	logger.GlobalLog.LogInfo("Stopping server...")
-	srv.Stop()
+	logger.GlobalLog.LogInfo("Waiting for servers to drain connections...")
+
+	for _, srv := range servers {
+		srv.Wait()
+	}
+
+	logger.GlobalLog.LogInfo("Waiting for notifications to be sent...")
+	notifier.Wait()
 
 	logger.GlobalLog.LogInfo("Closing log...")
 	logger.GlobalLog.Close()
 
+	if _, ok := sig.(StartFailureSignal); ok {
+		os.Exit(ExitFailure)
+	}
+
 	_, ok := sig.(ReloadSignal)
 
 	if ok {
+		reloaded = true
+		previousOpts = opts
 		goto Start
 	}
 }
+
+// Checks the config for problems, logging each of them, and gives whether there
+// were none. See `server.CheckConfig()`.
+func checkConfig() bool {
+	problems := server.CheckConfig(CONFIG_PATH)
+
+	for _, problem := range problems {
+		logger.GlobalLog.LogErr(problem)
+	}
+
+	if len(problems) > 0 {
+		logger.GlobalLog.LogErr("Refusing to reload config with problems, keeping the running config")
+		return false
+	}
+
+	return true
+}
+
+// Logs each option changed by a reload, and whether it needed the servers to
+// restart or could have been applied in place, see `server.DiffOptions()`.
+// Values of secret options are not logged.
+func logConfigChanges(previous, current server.ServerOptions) {
+	changes := server.DiffOptions(previous, current)
+	restarts := 0
+
+	for _, change := range changes {
+		key := change.Key[strings.LastIndex(change.Key, ".")+1:]
+		values := ""
+
+		if !server.SecretOptions[key] {
+			values = " from " + orAbsent(change.Previous) + " to " + orAbsent(change.Current)
+		}
+
+		if change.Restart {
+			restarts++
+			logger.GlobalLog.LogInfo("Config: '" + change.Key + "' changed" + values + ", requires a restart")
+		} else {
+			logger.GlobalLog.LogInfo("Config: '" + change.Key + "' changed" + values + ", could be applied in place")
+		}
+	}
+
+	if len(changes) == 0 {
+		logger.GlobalLog.LogInfo("Config unchanged by reload")
+	} else {
+		logger.GlobalLog.LogInfo("Config: " + strconv.Itoa(len(changes)) + " option(s) changed by reload, " + strconv.Itoa(restarts) + " of which require a restart")
+	}
+}
+
+// Gives the given JSON value, or "(absent)" if it is empty.
+func orAbsent(value string) string {
+	if value == "" {
+		return "(absent)"
+	}
+
+	return value
+}
+
+// Sends a reload signal unless another signal is already waiting to be handled.
+func sendReload(signalChan chan os.Signal) {
+	select {
+	case signalChan <- ReloadSignal{}:
+	default:
+	}
+}
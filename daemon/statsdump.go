@@ -0,0 +1,67 @@
+// Copyright (c) 2024 Evan Overman (https://an-prata.it).
+// Licensed under the MIT License.
+// See LICENSE file in repository root for complete license text.
+
+package daemon
+
+import (
+	"errors"
+	"os"
+	"time"
+
+	"github.com/an-prata/webby/logger"
+	"github.com/an-prata/webby/server"
+)
+
+// Writes the hits of each path served by each of the given servers to the file
+// at the given path as JSON every interval in a seperate thread, and once more
+// when stopped. Send through the returned channel to stop writing.
+func StartStatsDump(path string, interval time.Duration, servers map[string]*server.Server) chan bool {
+	stopChan := make(chan bool, 1)
+
+	server.Supervise("stats file", func() error {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			stopping := false
+
+			select {
+			case <-stopChan:
+				stopping = true
+			case <-ticker.C:
+			}
+
+			if err := writeStats(path, servers); err != nil {
+				logger.GlobalLog.LogErr(err.Error())
+			}
+
+			if stopping {
+				return nil
+			}
+		}
+	})
+
+	return stopChan
+}
+
+// Writes the hits of each path served by each of the given servers to the file
+// at the given path, replacing it only once it is completely written so that
+// readers never see a partial file.
+func writeStats(path string, servers map[string]*server.Server) error {
+	content, err := hitsJson(servers)
+
+	if err != nil {
+		return errors.New("Could not encode path hits: " + err.Error())
+	}
+
+	if err = os.WriteFile(path+".tmp", content, 0644); err != nil {
+		return errors.New("Could not write to file '" + path + ".tmp': " + err.Error())
+	}
+
+	if err = os.Rename(path+".tmp", path); err != nil {
+		return errors.New("Could not replace '" + path + "': " + err.Error())
+	}
+
+	return nil
+}
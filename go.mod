@@ -1,3 +1,3 @@
 module github.com/an-prata/webby
 
-go 1.20
+go 1.21
@@ -0,0 +1,112 @@
+// Copyright (c) 2024 Evan Overman (https://an-prata.it).
+// Licensed under the MIT License.
+// See LICENSE file in repository root for complete license text.
+
+package logger
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"sync"
+	"time"
+)
+
+// The longest time a message waits in a log file's buffer before being written.
+const flushInterval = time.Second
+
+// Serializes printing so that messages printed from many threads do not mix.
+var printMutex sync.Mutex
+
+// A log file written to through a buffer, which is flushed at most
+// `flushInterval` after a message is written so that logging does not block on
+// writes to disk. May be written to from many threads.
+type logFile struct {
+	mutex  sync.Mutex
+	file   *os.File
+	buffer *bufio.Writer
+	path   string
+
+	// Whether a flush of the buffer has been scheduled.
+	flushPending bool
+}
+
+func newLogFile(file *os.File, path string) *logFile {
+	return &logFile{sync.Mutex{}, file, bufio.NewWriter(file), path, false}
+}
+
+// Writes the given line to the buffer, flushing it immediately if directed and
+// otherwise within `flushInterval`. Errors writing a buffered line are only
+// returned by the write that flushes it.
+func (f *logFile) write(line string, flush bool) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if _, err := f.buffer.WriteString(line); err != nil {
+		return err
+	}
+
+	if flush {
+		return f.buffer.Flush()
+	}
+
+	if !f.flushPending {
+		f.flushPending = true
+		time.AfterFunc(flushInterval, func() { f.sync() })
+	}
+
+	return nil
+}
+
+// Writes everything buffered to the file and commits it to disk.
+func (f *logFile) sync() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.flushPending = false
+
+	if err := f.buffer.Flush(); err != nil {
+		return errors.New("Failed to flush log file: " + err.Error())
+	}
+
+	if err := f.file.Sync(); err != nil {
+		return errors.New("Failed to sync log file")
+	}
+
+	return nil
+}
+
+// Flushes the buffer to the file and then replaces it with the file at the same
+// path, appending to it, see `Log.Reopen()`.
+func (f *logFile) reopen() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+
+	if err != nil {
+		return errors.New("Could not reopen log file '" + f.path + "': " + err.Error())
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.buffer.Flush()
+	f.file.Close()
+	f.file = file
+	f.buffer.Reset(file)
+	return nil
+}
+
+// Flushes the buffer to the file and closes it.
+func (f *logFile) close() error {
+	if err := f.sync(); err != nil {
+		return err
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.file.Close() != nil {
+		return errors.New("Failed to close log file")
+	}
+
+	return nil
+}
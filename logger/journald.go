@@ -0,0 +1,98 @@
+// Copyright (c) 2024 Evan Overman (https://an-prata.it).
+// Licensed under the MIT License.
+// See LICENSE file in repository root for complete license text.
+
+package logger
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Socket journald receives messages through using its native protocol.
+const journaldSocket = "/run/systemd/journal/socket"
+
+// Sends messages to journald, see `DialJournald()`.
+type journaldTarget struct {
+	conn       net.Conn
+	identifier string
+}
+
+// Connects to journald's socket, sending messages with the given syslog
+// identifier. Each field of a message is sent as a journal field of its own,
+// its key uppercased with characters journald does not allow replaced by
+// underscores, so that it may be matched on with e.g. "journalctl PATH=/".
+func DialJournald(identifier string) (Target, error) {
+	conn, err := net.Dial("unixgram", journaldSocket)
+
+	if err != nil {
+		return nil, errors.New("Could not connect to journald: " + err.Error())
+	}
+
+	return &journaldTarget{conn, identifier}, nil
+}
+
+func (t *journaldTarget) Send(level LogLevel, msg string, fields map[string]interface{}) error {
+	// Syslog priorities, as journald expects.
+	priority := 6
+
+	switch level {
+	case Err:
+		priority = 3
+	case Warn:
+		priority = 4
+	}
+
+	var buf bytes.Buffer
+	writeJournalField(&buf, "PRIORITY", strconv.Itoa(priority))
+	writeJournalField(&buf, "SYSLOG_IDENTIFIER", t.identifier)
+	writeJournalField(&buf, "MESSAGE", msg)
+
+	for key, value := range fields {
+		writeJournalField(&buf, journalFieldName(key), fmt.Sprint(value))
+	}
+
+	_, err := t.conn.Write(buf.Bytes())
+	return err
+}
+
+func (t *journaldTarget) Close() error {
+	return t.conn.Close()
+}
+
+// Writes a field in journald's native format, values containing newlines being
+// given with their length rather than ending at one.
+func writeJournalField(buf *bytes.Buffer, name, value string) {
+	if !strings.Contains(value, "\n") {
+		buf.WriteString(name + "=" + value + "\n")
+		return
+	}
+
+	buf.WriteString(name + "\n")
+	binary.Write(buf, binary.LittleEndian, uint64(len(value)))
+	buf.WriteString(value + "\n")
+}
+
+// Gives a journal field name for the given key, which may only contain
+// uppercase letters, digits, and underscores, and may not start with an
+// underscore or digit.
+func journalFieldName(key string) string {
+	name := []byte(strings.ToUpper(key))
+
+	for i, c := range name {
+		if (c < 'A' || c > 'Z') && (c < '0' || c > '9') {
+			name[i] = '_'
+		}
+	}
+
+	if len(name) == 0 || name[0] == '_' || (name[0] >= '0' && name[0] <= '9') {
+		return "FIELD_" + string(name)
+	}
+
+	return string(name)
+}
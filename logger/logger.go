@@ -5,10 +5,14 @@
 package logger
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -40,16 +44,42 @@ const (
 	normal        = "\033[0m"
 )
 
+// Formats log messages may be written in.
+const (
+	// A line per message with its level, time, and any fields as "key=value"
+	// pairs, colored when printed.
+	FormatText = "text"
+
+	// A JSON object per line with the message's "time", "level", and "message",
+	// along with its fields.
+	FormatJson = "json"
+)
+
+// Guards the settings of logs not created by `NewLog()`, which have no mutex of
+// their own.
+var zeroLogMutex sync.RWMutex
+
 // Represents a single log that will print to stdout and save to a file.
 type Log struct {
 	// The log items that will be printed to the console.
-	Printing LogLevel
+	printing LogLevel
 
 	// Log items that will be saved to the log file.
-	Recording LogLevel
+	recording LogLevel
+
+	// The format messages are written in, either `FormatText` or `FormatJson`.
+	format string
 
-	// Pointer to a file for saving log messages, may be nil.
-	file *os.File
+	// The file for saving log messages, may be nil. Shared by copies of the log.
+	file *logFile
+
+	// Destinations other than the log file that recorded messages are sent to,
+	// see `Log.AddTarget()`.
+	targets []Target
+
+	// Guards the levels, format, file, and targets, which may be changed while
+	// messages are logged from many threads. Shared by copies of the log.
+	mutex *sync.RWMutex
 }
 
 // Global logger instance.
@@ -97,7 +127,7 @@ func CheckLogLevel(level uint8) (LogLevel, error) {
 // will only print messages. This function will never error if the given file
 // path is empty.
 func NewLog(print LogLevel, save LogLevel, file string) (Log, error) {
-	log := Log{print, save, nil}
+	log := Log{printing: print, recording: save, format: FormatText, mutex: &sync.RWMutex{}}
 
 	if file == "" {
 		return log, nil
@@ -106,7 +136,7 @@ func NewLog(print LogLevel, save LogLevel, file string) (Log, error) {
 	f, err := os.Create(file)
 
 	if err == nil {
-		log.file = f
+		log.file = newLogFile(f, file)
 	}
 
 	return log, err
@@ -116,7 +146,7 @@ func NewLog(print LogLevel, save LogLevel, file string) (Log, error) {
 // invalid and returns an error.
 func (log *Log) SetRecordLevelFromString(str string) error {
 	level, err := LevelFromString(str)
-	log.Recording = level
+	log.SetRecording(level)
 	return err
 }
 
@@ -124,10 +154,65 @@ func (log *Log) SetRecordLevelFromString(str string) error {
 // invalid and returns an error.
 func (log *Log) SetPrintLevelFromString(str string) error {
 	level, err := LevelFromString(str)
-	log.Printing = level
+	log.SetPrinting(level)
 	return err
 }
 
+// Sets the log items that will be printed to the console.
+func (log *Log) SetPrinting(level LogLevel) {
+	log.settingsMutex().Lock()
+	defer log.settingsMutex().Unlock()
+	log.printing = level
+}
+
+// Sets the log items that will be saved to the log file and sent to targets.
+func (log *Log) SetRecording(level LogLevel) {
+	log.settingsMutex().Lock()
+	defer log.settingsMutex().Unlock()
+	log.recording = level
+}
+
+// Gives the log items that will be printed to the console.
+func (log *Log) Printing() LogLevel {
+	log.settingsMutex().RLock()
+	defer log.settingsMutex().RUnlock()
+	return log.printing
+}
+
+// Gives the log items that will be saved to the log file and sent to targets.
+func (log *Log) Recording() LogLevel {
+	log.settingsMutex().RLock()
+	defer log.settingsMutex().RUnlock()
+	return log.recording
+}
+
+// Gives the format messages are written in, either `FormatText` or
+// `FormatJson`.
+func (log *Log) Format() string {
+	log.settingsMutex().RLock()
+	defer log.settingsMutex().RUnlock()
+	return log.format
+}
+
+// Sets the format messages are written in, see `FormatText` and `FormatJson`.
+// Sets `FormatText` if the format is invalid and returns an error.
+func (log *Log) SetFormat(format string) error {
+	log.settingsMutex().Lock()
+	defer log.settingsMutex().Unlock()
+
+	switch strings.ToLower(format) {
+	case FormatText, "":
+		log.format = FormatText
+	case FormatJson:
+		log.format = FormatJson
+	default:
+		log.format = FormatText
+		return errors.New("Unknown log format '" + format + "', expected 'text' or 'json'")
+	}
+
+	return nil
+}
+
 // Creates a new file or truncates it at the given path and uses it for
 // recording log messages. This function will return no error if passed an empty
 // string.
@@ -142,72 +227,219 @@ func (log *Log) OpenFile(path string) error {
 		return errors.New("Could not open new log file")
 	}
 
-	log.file = file
+	log.settingsMutex().Lock()
+	defer log.settingsMutex().Unlock()
+	log.file = newLogFile(file, path)
 	return nil
 }
 
-// Log a message at the error level.
-func (log *Log) LogErr(msg string) error {
-	now := time.Now().Format(time.UnixDate)
+// Closes the log file and opens it again at the same path, appending to it, so
+// that a log file moved away by log rotation is replaced by a new file rather
+// than being written to in its new place. Does nothing if there is no log file.
+func (log *Log) Reopen() error {
+	file := log.logFile()
 
-	if log.Printing&Err == Err {
-		fmt.Printf("[%s%sERR%s]  (%s): %s\n", bold, red, normal, now, msg)
+	if file == nil {
+		return nil
 	}
 
-	if log.Recording&Err == Err && log.file != nil {
-		_, err := fmt.Fprintf(log.file, "[ERR]  (%s): %s\n", now, msg)
-		return err
+	return file.reopen()
+}
+
+// Writes any messages waiting to be recorded to the log file and commits them to
+// disk. Should be called before exiting so that no messages are lost.
+func (log *Log) Sync() error {
+	file := log.logFile()
+
+	if file == nil {
+		return nil
 	}
 
-	return nil
+	return file.sync()
+}
+
+// Gives the mutex guarding the log's settings.
+func (log *Log) settingsMutex() *sync.RWMutex {
+	if log.mutex == nil {
+		return &zeroLogMutex
+	}
+
+	return log.mutex
+}
+
+// Gives the file for saving log messages, which may be nil.
+func (log *Log) logFile() *logFile {
+	log.settingsMutex().RLock()
+	defer log.settingsMutex().RUnlock()
+	return log.file
+}
+
+// Log a message at the error level.
+func (log *Log) LogErr(msg string) error {
+	return log.LogFields(Err, msg, nil)
 }
 
 // Log a message at the warning level.
 func (log *Log) LogWarn(msg string) error {
-	now := time.Now().Format(time.UnixDate)
+	return log.LogFields(Warn, msg, nil)
+}
 
-	if log.Printing&Warn == Warn {
-		fmt.Printf("[%s%sWARN%s] (%s): %s\n", bold, yellow, normal, now, msg)
+// Log a message at the info level.
+func (log *Log) LogInfo(msg string) error {
+	return log.LogFields(Info, msg, nil)
+}
+
+// Log a message at the given level, which should be one of `Err`, `Warn`, or
+// `Info`, along with the given fields, which may be nil. In the JSON format each
+// field is a member of the message's object, otherwise they follow the message
+// as "key=value" pairs.
+func (log *Log) LogFields(level LogLevel, msg string, fields map[string]interface{}) error {
+	now := time.Now()
+	label, color := levelLabel(level)
+
+	log.settingsMutex().RLock()
+	printing, recording, format := log.printing, log.recording, log.format
+	file, targets := log.file, log.targets
+	log.settingsMutex().RUnlock()
+
+	var printed, recorded string
+
+	if format == FormatJson {
+		line, err := jsonLine(now, level, msg, fields)
+
+		if err != nil {
+			return err
+		}
+
+		printed = line + "\n"
+		recorded = printed
+	} else {
+		text := msg + textFields(fields)
+		padding := strings.Repeat(" ", 5-len(label))
+		printed = fmt.Sprintf("[%s%s%s%s]%s(%s): %s\n", bold, color, label, normal, padding, now.Format(time.UnixDate), text)
+		recorded = fmt.Sprintf("[%s]%s(%s): %s\n", label, padding, now.Format(time.UnixDate), text)
 	}
 
-	if log.Recording&Warn == Warn && log.file != nil {
-		_, err := fmt.Fprintf(log.file, "[WARN] (%s): %s\n", now, msg)
-		return err
+	if printing&level == level {
+		printMutex.Lock()
+		os.Stdout.WriteString(printed)
+		printMutex.Unlock()
 	}
 
-	return nil
+	if recording&level != level {
+		return nil
+	}
+
+	var err error
+
+	// Errors are written immediately so that they are not lost should webby crash.
+	if file != nil {
+		err = file.write(recorded, level == Err)
+	}
+
+	for _, target := range targets {
+		if targetErr := target.Send(level, msg, fields); targetErr != nil && err == nil {
+			err = targetErr
+		}
+	}
+
+	return err
 }
 
-// Log a message at the info level.
-func (log *Log) LogInfo(msg string) error {
-	now := time.Now().Format(time.UnixDate)
+// Gives the label and color a message of the given level is shown with.
+func levelLabel(level LogLevel) (string, string) {
+	switch level {
+	case Err:
+		return "ERR", red
+	case Warn:
+		return "WARN", yellow
+	}
 
-	if log.Printing&Info == Info {
-		fmt.Printf("[%s%sINFO%s] (%s): %s\n", bold, blue, normal, now, msg)
+	return "INFO", blue
+}
+
+// Gives a message of the given level as a line of JSON with its time, level,
+// and fields.
+func jsonLine(now time.Time, level LogLevel, msg string, fields map[string]interface{}) (string, error) {
+	object := map[string]interface{}{}
+
+	for key, value := range fields {
+		object[key] = value
 	}
 
-	if log.Recording&Info == Info && log.file != nil {
-		_, err := fmt.Fprintf(log.file, "[INFO] (%s): %s\n", now, msg)
-		return err
+	object["time"] = now.Format(time.RFC3339Nano)
+	object["level"] = "info"
+
+	if level == Err {
+		object["level"] = "error"
+	} else if level == Warn {
+		object["level"] = "warning"
 	}
 
-	return nil
+	object["message"] = msg
+
+	line, err := json.Marshal(object)
+
+	if err != nil {
+		return "", errors.New("Could not encode log message as JSON: " + err.Error())
+	}
+
+	return string(line), nil
+}
+
+// Gives the given fields as "key=value" pairs for the text format, sorted by
+// key and each preceded by a space. Values containing spaces are quoted.
+func textFields(fields map[string]interface{}) string {
+	keys := make([]string, 0, len(fields))
+
+	for key := range fields {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+	text := ""
+
+	for _, key := range keys {
+		value := fmt.Sprint(fields[key])
+
+		if strings.ContainsAny(value, " \t\"") {
+			value = strconv.Quote(value)
+		}
+
+		text += " " + key + "=" + value
+	}
+
+	return text
 }
 
-// Closes the log file, if no file was opened when creating the log then this
-// function will simply return no error.
+// Writes any messages waiting to be recorded and closes the log file, along with
+// any targets, which are then all removed from the log. If no file was opened
+// when creating the log then this function will simply return no error.
 func (log *Log) Close() error {
-	if log.file == nil {
-		return nil
+	var err error
+
+	log.settingsMutex().Lock()
+	file, targets := log.file, log.targets
+
+	// Messages logged after closing are only printed, rather than written into
+	// the buffer of a closed file.
+	log.file = nil
+	log.targets = nil
+	log.settingsMutex().Unlock()
+
+	for _, target := range targets {
+		if targetErr := target.Close(); targetErr != nil && err == nil {
+			err = targetErr
+		}
 	}
 
-	if log.file.Sync() != nil {
-		return errors.New("Failed to sync log file")
+	if file == nil {
+		return err
 	}
 
-	if log.file.Close() != nil {
-		return errors.New("Failed to close log file")
+	if fileErr := file.close(); fileErr != nil {
+		return fileErr
 	}
 
-	return nil
+	return err
 }
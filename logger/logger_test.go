@@ -0,0 +1,110 @@
+// Copyright (c) 2024 Evan Overman (https://an-prata.it).
+// Licensed under the MIT License.
+// See LICENSE file in repository root for complete license text.
+
+package logger
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// A target counting the messages sent to it.
+type countTarget struct {
+	mutex sync.Mutex
+	sent  int
+}
+
+func (t *countTarget) Send(level LogLevel, msg string, fields map[string]interface{}) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.sent++
+	return nil
+}
+
+func (t *countTarget) Close() error {
+	return nil
+}
+
+// Changes the log's levels, format, and targets while messages are logged from
+// several goroutines, which should be run with the race detector.
+func TestConcurrentSettings(t *testing.T) {
+	log, err := NewLog(None, All, "")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target := &countTarget{}
+	var wg sync.WaitGroup
+
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < 100; j++ {
+				log.LogInfo("message")
+			}
+		}()
+	}
+
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+
+		for j := 0; j < 100; j++ {
+			log.SetRecording(All)
+			log.SetPrinting(None)
+			log.SetFormat([]string{FormatText, FormatJson}[j%2])
+		}
+
+		log.AddTarget(target)
+	}()
+
+	wg.Wait()
+	log.LogErr("after")
+
+	if target.sent == 0 {
+		t.Errorf("expected the target to be sent messages once added")
+	}
+
+	if err := log.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Logs to a file after closing the log, which should no longer be written to.
+func TestLogAfterClose(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "webby.log")
+	log, err := NewLog(None, All, path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	log.LogInfo("before")
+
+	if err := log.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := log.LogErr("after"); err != nil {
+		t.Errorf("expected no error logging after closing, got %v", err)
+	}
+
+	if err := log.Sync(); err != nil {
+		t.Errorf("expected no error syncing after closing, got %v", err)
+	}
+
+	written, _ := os.ReadFile(path)
+
+	if !strings.Contains(string(written), "before") || strings.Contains(string(written), "after") {
+		t.Errorf("expected only the message from before closing, got %q", written)
+	}
+}
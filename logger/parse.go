@@ -0,0 +1,125 @@
+// Copyright (c) 2024 Evan Overman (https://an-prata.it).
+// Licensed under the MIT License.
+// See LICENSE file in repository root for complete license text.
+
+package logger
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// A message read back from a log file written in either format.
+type Entry struct {
+	Time  time.Time
+	Level LogLevel
+
+	// The message along with any fields, as written.
+	Message string
+
+	// The lines of the message as written, more than one for messages that span
+	// multiple lines such as stack traces.
+	Lines []string
+}
+
+// Parses a line of a log file written in either `FormatText` or `FormatJson`,
+// giving false for lines that do not begin a message, such as the following
+// lines of a message spanning multiple.
+func ParseLine(line string) (Entry, bool) {
+	if strings.HasPrefix(line, "{") {
+		return parseJsonLine(line)
+	}
+
+	return parseTextLine(line)
+}
+
+// Parses a line written in `FormatText`, e.g.
+// "[WARN] (Mon Jan  2 15:04:05 UTC 2006): message".
+func parseTextLine(line string) (Entry, bool) {
+	label, rest, found := strings.Cut(strings.TrimPrefix(line, "["), "]")
+
+	if !found || !strings.HasPrefix(line, "[") {
+		return Entry{}, false
+	}
+
+	level, ok := levelFromLabel(label)
+
+	if !ok {
+		return Entry{}, false
+	}
+
+	rest = strings.TrimLeft(rest, " ")
+	stamp, msg, found := strings.Cut(strings.TrimPrefix(rest, "("), "): ")
+
+	if !found || !strings.HasPrefix(rest, "(") {
+		return Entry{}, false
+	}
+
+	// Zone abbreviations are only understood in the local time zone, which the
+	// daemon most likely also logged in.
+	now, err := time.ParseInLocation(time.UnixDate, stamp, time.Local)
+
+	if err != nil {
+		return Entry{}, false
+	}
+
+	return Entry{now, level, msg, []string{line}}, true
+}
+
+// Parses a line written in `FormatJson`.
+func parseJsonLine(line string) (Entry, bool) {
+	var object struct {
+		Time    string
+		Level   string
+		Message string
+	}
+
+	if err := json.Unmarshal([]byte(line), &object); err != nil {
+		return Entry{}, false
+	}
+
+	now, err := time.Parse(time.RFC3339Nano, object.Time)
+
+	if err != nil {
+		return Entry{}, false
+	}
+
+	level := Info
+
+	switch object.Level {
+	case "error":
+		level = Err
+	case "warning":
+		level = Warn
+	}
+
+	return Entry{now, level, object.Message, []string{line}}, true
+}
+
+// Gives the level of the label a message is written with in `FormatText`.
+func levelFromLabel(label string) (LogLevel, bool) {
+	switch label {
+	case "ERR":
+		return Err, true
+	case "WARN":
+		return Warn, true
+	case "INFO":
+		return Info, true
+	}
+
+	return None, false
+}
+
+// Gives the lines of the entry as the log prints them, with the level of lines
+// written in `FormatText` colored.
+func (entry Entry) Colored() string {
+	lines := append([]string{}, entry.Lines...)
+	label, color := levelLabel(entry.Level)
+
+	if strings.HasPrefix(lines[0], "["+label+"]") {
+		lines[0] = "[" + bold + color + label + normal + "]" + strings.TrimPrefix(lines[0], "["+label+"]")
+	}
+
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,112 @@
+// Copyright (c) 2024 Evan Overman (https://an-prata.it).
+// Licensed under the MIT License.
+// See LICENSE file in repository root for complete license text.
+
+//go:build go1.21
+
+package logger
+
+import (
+	"context"
+	"log/slog"
+)
+
+// An `slog.Handler` writing records to a `Log`, see `Log.SlogHandler()`.
+type slogHandler struct {
+	log *Log
+
+	// Attributes added with `WithAttrs()`, as fields of each message.
+	fields map[string]interface{}
+
+	// Prefix given to the keys of attributes, from the groups opened with
+	// `WithGroup()`.
+	prefix string
+}
+
+// Gives an `slog.Handler` writing records to the log, so that it may be used
+// with `slog.New()`. Records at `slog.LevelError` and above are logged as
+// errors, those at `slog.LevelWarn` and above as warnings, and the rest as info.
+// Attributes become the message's fields, with keys in groups joined by dots.
+func (log *Log) SlogHandler() slog.Handler {
+	return &slogHandler{log, map[string]interface{}{}, ""}
+}
+
+// Gives the log level an slog record of the given level is logged at.
+func slogLevel(level slog.Level) LogLevel {
+	if level >= slog.LevelError {
+		return Err
+	}
+
+	if level >= slog.LevelWarn {
+		return Warn
+	}
+
+	return Info
+}
+
+func (h *slogHandler) Enabled(_ context.Context, level slog.Level) bool {
+	logLevel := slogLevel(level)
+	return h.log.Printing()&logLevel == logLevel || h.log.Recording()&logLevel == logLevel
+}
+
+func (h *slogHandler) Handle(_ context.Context, record slog.Record) error {
+	fields := make(map[string]interface{}, len(h.fields)+record.NumAttrs())
+
+	for key, value := range h.fields {
+		fields[key] = value
+	}
+
+	record.Attrs(func(attr slog.Attr) bool {
+		addSlogAttr(fields, h.prefix, attr)
+		return true
+	})
+
+	return h.log.LogFields(slogLevel(record.Level), record.Message, fields)
+}
+
+func (h *slogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	fields := make(map[string]interface{}, len(h.fields)+len(attrs))
+
+	for key, value := range h.fields {
+		fields[key] = value
+	}
+
+	for _, attr := range attrs {
+		addSlogAttr(fields, h.prefix, attr)
+	}
+
+	return &slogHandler{h.log, fields, h.prefix}
+}
+
+func (h *slogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+
+	return &slogHandler{h.log, h.fields, h.prefix + name + "."}
+}
+
+// Adds the given attribute to the fields under the given key prefix, adding the
+// attributes of groups individually.
+func addSlogAttr(fields map[string]interface{}, prefix string, attr slog.Attr) {
+	value := attr.Value.Resolve()
+
+	if value.Kind() == slog.KindGroup {
+		// Groups without a key are inlined.
+		if attr.Key != "" {
+			prefix += attr.Key + "."
+		}
+
+		for _, groupAttr := range value.Group() {
+			addSlogAttr(fields, prefix, groupAttr)
+		}
+
+		return
+	}
+
+	if attr.Key == "" {
+		return
+	}
+
+	fields[prefix+attr.Key] = value.Any()
+}
@@ -0,0 +1,60 @@
+// Copyright (c) 2024 Evan Overman (https://an-prata.it).
+// Licensed under the MIT License.
+// See LICENSE file in repository root for complete license text.
+
+//go:build !windows && !plan9
+
+package logger
+
+import (
+	"errors"
+	"log/syslog"
+	"strings"
+)
+
+// Sends messages to syslog, see `DialSyslog()`.
+type syslogTarget struct {
+	writer *syslog.Writer
+}
+
+// Connects to syslog at the given address, either "local" for the local syslog
+// daemon or a "udp://" or "tcp://" URL of a remote one, e.g.
+// "udp://logs.example.com:514". Messages are sent with the daemon facility and
+// the given tag. Fields follow the message as "key=value" pairs.
+func DialSyslog(address, tag string) (Target, error) {
+	network, host := "", ""
+
+	if address != "local" {
+		var ok bool
+		network, host, ok = strings.Cut(address, "://")
+
+		if !ok || (network != "udp" && network != "tcp") || host == "" {
+			return nil, errors.New("Expected syslog address '" + address + "' to be 'local' or a 'udp://' or 'tcp://' URL")
+		}
+	}
+
+	writer, err := syslog.Dial(network, host, syslog.LOG_DAEMON|syslog.LOG_INFO, tag)
+
+	if err != nil {
+		return nil, errors.New("Could not connect to syslog at '" + address + "': " + err.Error())
+	}
+
+	return &syslogTarget{writer}, nil
+}
+
+func (t *syslogTarget) Send(level LogLevel, msg string, fields map[string]interface{}) error {
+	msg += textFields(fields)
+
+	switch level {
+	case Err:
+		return t.writer.Err(msg)
+	case Warn:
+		return t.writer.Warning(msg)
+	}
+
+	return t.writer.Info(msg)
+}
+
+func (t *syslogTarget) Close() error {
+	return t.writer.Close()
+}
@@ -0,0 +1,14 @@
+// Copyright (c) 2024 Evan Overman (https://an-prata.it).
+// Licensed under the MIT License.
+// See LICENSE file in repository root for complete license text.
+
+//go:build windows || plan9
+
+package logger
+
+import "errors"
+
+// Syslog is unavailable on this platform.
+func DialSyslog(address, tag string) (Target, error) {
+	return nil, errors.New("Syslog is not supported on this platform")
+}
@@ -0,0 +1,25 @@
+// Copyright (c) 2024 Evan Overman (https://an-prata.it).
+// Licensed under the MIT License.
+// See LICENSE file in repository root for complete license text.
+
+package logger
+
+// A destination messages are sent to alongside standard out and the log file,
+// such as syslog or journald. Messages are sent to it at the log's recording
+// level.
+type Target interface {
+	// Sends a message of the given level, one of `Err`, `Warn`, or `Info`, with
+	// the given fields, which may be nil.
+	Send(level LogLevel, msg string, fields map[string]interface{}) error
+
+	// Closes any connection the target holds.
+	Close() error
+}
+
+// Sends messages recorded by the log to the given target as well, until the log
+// is closed.
+func (log *Log) AddTarget(target Target) {
+	log.settingsMutex().Lock()
+	defer log.settingsMutex().Unlock()
+	log.targets = append(log.targets, target)
+}
@@ -0,0 +1,46 @@
+// Copyright (c) 2024 Evan Overman (https://an-prata.it).
+// Licensed under the MIT License.
+// See LICENSE file in repository root for complete license text.
+
+package logger
+
+import (
+	"io"
+	stdlog "log"
+	"strings"
+)
+
+// Logs everything written to it as messages of a single level, see
+// `Log.Writer()`.
+type levelWriter struct {
+	log   *Log
+	level LogLevel
+}
+
+// Gives an `io.Writer` that logs each line written to it as a message of the
+// given level, which should be one of `Err`, `Warn`, or `Info`. Since the log
+// is referred to rather than copied, changes to its levels and format apply to
+// the writer.
+func (log *Log) Writer(level LogLevel) io.Writer {
+	return levelWriter{log, level}
+}
+
+// Gives a standard library logger that logs everything given to it as messages
+// of the given level, for use as e.g. `http.Server.ErrorLog`.
+func (log *Log) StdLogger(level LogLevel) *stdlog.Logger {
+	return stdlog.New(log.Writer(level), "", 0)
+}
+
+func (w levelWriter) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		if line == "" {
+			continue
+		}
+
+		if err := w.log.LogFields(w.level, line, nil); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(p), nil
+}
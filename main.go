@@ -7,6 +7,9 @@ package main
 import (
 	"flag"
 	"net"
+	"os"
+	"strings"
+	"time"
 
 	"github.com/an-prata/webby/client"
 	"github.com/an-prata/webby/daemon"
@@ -19,22 +22,84 @@ func main() {
 	var start bool
 	var reload bool
 	var restart bool
+	var reloadCerts bool
+	var rotateLog bool
 	var stop bool
 	var status bool
+	var jsonOutput bool
+	var output string
+	var quota bool
 	var genConfig bool
+	var format string
 	var logRecord string
 	var logPrint string
 	var showLog bool
+	var logLevel string
+	var logSince string
+	var logUntil string
+	var logGrep string
+	var logTail int
+	var logFollow bool
+	var instance string
+	var signUrl string
+	var signExpiry int64
+	var installService bool
+	var timeout int64
+	var top bool
+	var hello bool
+	var routes bool
+	var hits bool
+	var configGet string
+	var configSet string
+	var maintenance string
+	var persist bool
+	var checkConfig bool
+	var build bool
+	var dev bool
+	var deploy string
+	var rollback bool
+	var jobs bool
 
 	flag.BoolVar(&daemonProc, client.Daemon, false, "runs the webby server daemon process rather than behaving like a control application")
 	flag.BoolVar(&start, client.Start, false, "starts the daemon in a new process and forks it into the background")
+	flag.BoolVar(&installService, client.InstallService, false, "creates the webby user and directories, then installs and enables a hardened systemd service")
+	flag.BoolVar(&checkConfig, client.CheckConfig, false, "checks the config, or the config at the path following all flags, for unknown options, wrong types, missing files, and port conflicts")
+	flag.BoolVar(&dev, client.Dev, false, "serves the directory following all flags, or the current directory, on a free local port as the current user, reloading pages in the browser when files change")
+	flag.BoolVar(&build, client.Build, false, "builds the site of each server instance with a 'BuildSource' from its Markdown and layouts, then exits")
+	flag.StringVar(&deploy, daemon.Deploy, "", "checks the given release directory and then atomically points the 'Site' symbolic link of the server instance at it, keeping the previous release")
+	flag.BoolVar(&rollback, daemon.Rollback, false, "points the 'Site' symbolic link of the server instance back at the release deployed before the current one")
 	flag.BoolVar(&showLog, client.ShowLog, false, "shows the server log")
+	flag.StringVar(&logLevel, client.LogLevel, "", "shows only log messages of the given level ('error', 'warning', or 'info') and those more severe with '-"+client.ShowLog+"'")
+	flag.StringVar(&logSince, client.LogSince, "", "shows only log messages since the given time, e.g. '2006-01-02 15:04', '15:04', or a duration before now such as '2h', with '-"+client.ShowLog+"'")
+	flag.StringVar(&logUntil, client.LogUntil, "", "shows only log messages until the given time, given as to '-"+client.LogSince+"', with '-"+client.ShowLog+"'")
+	flag.StringVar(&logGrep, client.LogGrep, "", "shows only log messages matching the given regular expression with '-"+client.ShowLog+"'")
+	flag.IntVar(&logTail, client.LogTail, 0, "shows only the last given number of log messages with '-"+client.ShowLog+"'")
+	flag.BoolVar(&logFollow, client.LogFollow, false, "keeps showing log messages as they are written with '-"+client.ShowLog+"'")
+	flag.StringVar(&signUrl, client.SignUrl, "", "prints a signed version of the given URL path for use under a signed prefix")
+	flag.Int64Var(&signExpiry, client.SignExpiry, 24*60*60, "sets the number of seconds a signed URL stays valid for")
 	flag.BoolVar(&reload, daemon.Reload, false, "reloads the configuration file and then restarts, this will reset log levels")
 	flag.BoolVar(&restart, daemon.Restart, false, "restarts the webby HTTP server, rescanning directories")
+	flag.BoolVar(&reloadCerts, daemon.ReloadCerts, false, "reloads the certificates served over HTTPS from disk without restarting, e.g. after a renewal")
+	flag.BoolVar(&rotateLog, daemon.RotateLog, false, "closes and reopens the log file and access logs after they have been rotated, as does sending webby SIGHUP")
 	flag.BoolVar(&stop, daemon.Stop, false, "stops the running daemon")
-	flag.BoolVar(&status, daemon.Status, false, "gets webby's status by requesting that webby make HTTP get requests to all hosted paths")
+	flag.BoolVar(&status, daemon.Status, false, "gets webby's status by requesting that webby make HTTP get requests to all hosted paths and configured external URLs")
+	flag.BoolVar(&jsonOutput, daemon.Json, false, "prints the output of the status, stats, routes, and jobs commands as JSON, the status including uptime, memory, request counts, and each check, the same as '-"+daemon.Output+" "+daemon.OutputJson+"'")
+	flag.StringVar(&output, daemon.Output, daemon.OutputText, "sets the format of the output of the status, stats, routes, jobs, hello, config-get, config-set, and check-config commands, either 'text' or 'json' to print a single JSON document and only log warnings and errors, as JSON")
+	flag.BoolVar(&top, client.Top, false, "shows a live view of request rates, connections, top paths, and recent errors")
+	flag.BoolVar(&quota, daemon.Quota, false, "shows the requests made by and bytes served to each client IP against configured quotas")
+	flag.BoolVar(&hits, daemon.Stats, false, "shows the requests, bytes served, and response statuses of each path served")
+	flag.BoolVar(&routes, daemon.Routes, false, "lists the URL paths each server instance responds to, what kind of route each is, and what it serves")
+	flag.BoolVar(&jobs, daemon.Jobs, false, "lists the jobs scheduled by the 'Cron' option, when each last ran and with what result, and when each runs next")
+	flag.BoolVar(&hello, daemon.Hello, false, "shows the running daemon's version, control protocol version, and the commands it supports")
 	flag.BoolVar(&genConfig, daemon.GenConfig, false, "generated a new default config at '"+daemon.CONFIG_PATH+"'")
+	flag.StringVar(&format, client.Format, server.ConfigFormatJsonc, "sets the format of the config written by '-"+daemon.GenConfig+"', either 'jsonc' with a comment describing each option or plain 'json'")
 	flag.StringVar(&logRecord, daemon.LogRecord, "", "sets the log level to record to file, defaults to 'All'")
+	flag.StringVar(&instance, daemon.Instance, "", "selects a single server instance for the build, restart, reload-certs, status, quota, top, stats, routes, config-get, config-set, maintenance, deploy, and rollback commands, defaults to all instances")
+	flag.Int64Var(&timeout, daemon.Timeout, 60, "sets the number of seconds to wait to connect to and get a response from the daemon")
+	flag.StringVar(&configGet, daemon.ConfigGet, "", "prints the value of the named option that webby is running with as JSON")
+	flag.StringVar(&configSet, daemon.ConfigSet, "", "sets the named option of the running daemon to the value following all flags, which is taken as a string if it is not JSON")
+	flag.StringVar(&maintenance, daemon.Maintenance, "", "turns maintenance mode 'on' or 'off' without restarting, answering requests not allowed through with 503 Service Unavailable")
+	flag.BoolVar(&persist, daemon.Persist, false, "writes changes made with '-"+daemon.ConfigSet+"' or '-"+daemon.Maintenance+"' to the config file so that they outlast a reload")
 	flag.StringVar(&logPrint, daemon.LogPrint, "", "sets the log level to print to standard out, defaults to 'All'")
 
 	flag.Parse()
@@ -46,11 +111,26 @@ func main() {
 
 	log, _ := logger.NewLog(logger.All, logger.None, "")
 
+	switch output {
+	case daemon.OutputText:
+	case daemon.OutputJson:
+		jsonOutput = true
+	default:
+		log.LogErr("Expected '" + daemon.OutputText + "' or '" + daemon.OutputJson + "' for '-" + daemon.Output + "', not '" + output + "'")
+		os.Exit(daemon.ExitFailure)
+	}
+
+	// Anything else printed would be mixed into the JSON of a command's output.
+	if jsonOutput {
+		log.SetPrinting(logger.Err | logger.Warn)
+		log.SetFormat(logger.FormatJson)
+	}
+
 	if genConfig {
 		log.LogInfo("Writing default config to '" + daemon.CONFIG_PATH + "'...")
 
 		config := server.DefaultOptions()
-		err := config.WriteToFile(daemon.CONFIG_PATH)
+		err := config.WriteToFile(daemon.CONFIG_PATH, format)
 
 		if err != nil {
 			log.LogErr(err.Error())
@@ -60,40 +140,162 @@ func main() {
 		return
 	}
 
+	if installService {
+		err := client.InstallSystemdService(&log)
+
+		if err != nil {
+			log.LogErr(err.Error())
+			return
+		}
+
+		log.LogInfo("Done!")
+		return
+	}
+
+	if checkConfig {
+		path := daemon.CONFIG_PATH
+
+		if flag.NArg() > 0 {
+			path = flag.Arg(0)
+		}
+
+		os.Exit(client.CheckConfigFile(&log, path, jsonOutput))
+	}
+
+	if dev {
+		dir := "."
+
+		if flag.NArg() > 0 {
+			dir = flag.Arg(0)
+		}
+
+		if err := client.RunDevServer(&log, dir); err != nil {
+			log.LogErr(err.Error())
+			os.Exit(daemon.ExitFailure)
+		}
+
+		return
+	}
+
+	if build {
+		os.Exit(client.BuildSites(&log, instance))
+	}
+
 	if showLog {
-		err := client.ShowLogFile()
+		filter, err := client.NewLogFilter(logLevel, logSince, logUntil, logGrep, logTail, logFollow)
 
 		if err != nil {
+			log.LogErr(err.Error())
+			os.Exit(daemon.ExitFailure)
+		}
+
+		if err = client.ShowLogFile(filter); err != nil {
 			log.LogErr("Could not read server log file: " + err.Error())
 		}
 
 		return
 	}
 
+	if signUrl != "" {
+		err := client.PrintSignedUrl(signUrl, signExpiry, instance)
+
+		if err != nil {
+			log.LogErr("Could not sign URL: " + err.Error())
+		}
+
+		return
+	}
+
 	if start {
 		daemon.StartForkedDaemon(&log)
 		return
 	}
 
-	socket, err := net.Dial("unix", daemon.SocketPath)
+	os.Exit(runCommands(&log, time.Duration(timeout)*time.Second, func(socket net.Conn) []int {
+		if top {
+			return []int{client.ShowTop(socket, &log, instance, time.Duration(timeout)*time.Second)}
+		}
 
-	if err != nil {
-		log.LogErr("Could not open Unix Domain Socket, webby may not be running or you may need elevated privileges")
+		if hello {
+			return []int{daemon.CmdHello(socket, &log, hello, jsonOutput)}
+		}
 
-		if status {
-			log.LogInfo("webby's daemon uses a Unix Domain Socket for control")
-			log.LogInfo("being unable to open the socket likely means webby is not running")
+		if configGet != "" {
+			return []int{daemon.CmdConfigGet(socket, &log, configGet, instance)}
 		}
 
-		return
+		if configSet != "" {
+			if flag.NArg() == 0 {
+				log.LogErr("No value given for '" + configSet + "'")
+				log.LogInfo("try 'webby -" + daemon.ConfigSet + " " + configSet + " <value>'")
+				return []int{daemon.ExitFailure}
+			}
+
+			return []int{daemon.CmdConfigSet(socket, &log, configSet, strings.Join(flag.Args(), " "), persist, instance, jsonOutput)}
+		}
+
+		if deploy != "" {
+			return []int{daemon.CmdDeploy(socket, &log, deploy, instance)}
+		}
+
+		if rollback {
+			return []int{daemon.CmdRollback(socket, &log, rollback, instance)}
+		}
+
+		if maintenance != "" {
+			return []int{daemon.CmdMaintenance(socket, &log, maintenance, persist, instance)}
+		}
+
+		return []int{
+			daemon.CmdSetLogRecordLevel(socket, &log, logRecord),
+			daemon.CmdSetLogPrintLevel(socket, &log, logPrint),
+			daemon.CmdRestart(socket, &log, restart, instance),
+			daemon.CmdReload(socket, &log, reload),
+			daemon.CmdReloadCerts(socket, &log, reloadCerts, instance),
+			daemon.CmdRotateLog(socket, &log, rotateLog),
+			daemon.CmdStop(socket, &log, stop),
+			daemon.CmdStatus(socket, &log, status, instance, jsonOutput),
+			daemon.CmdQuota(socket, &log, quota, instance),
+			daemon.CmdHits(socket, &log, hits, instance, jsonOutput),
+			daemon.CmdRoutes(socket, &log, routes, instance, jsonOutput),
+			daemon.CmdJobs(socket, &log, jobs, jsonOutput),
+		}
+	}))
+}
+
+// Connects to the daemon, giving up after the given timeout, and runs the given
+// commands through the connection, each given the same timeout to respond.
+// Gives the first non-zero exit code of the commands, or `daemon.ExitRefused`
+// if the daemon could not be connected to.
+func runCommands(log *logger.Log, timeout time.Duration, commands func(net.Conn) []int) int {
+	socket, err := net.DialTimeout("unix", daemon.SocketPath, timeout)
+
+	if err != nil {
+		log.LogErr("Could not open Unix Domain Socket, webby may not be running or you may need elevated privileges")
+		log.LogInfo("webby's daemon uses a Unix Domain Socket for control")
+		log.LogInfo("being unable to open the socket likely means webby is not running")
+		return daemon.ExitRefused
 	}
 
 	defer socket.Close()
 
-	daemon.CmdSetLogRecordLevel(socket, &log, logRecord)
-	daemon.CmdSetLogPrintLevel(socket, &log, logPrint)
-	daemon.CmdRestart(socket, &log, restart)
-	daemon.CmdReload(socket, &log, reload)
-	daemon.CmdStop(socket, &log, stop)
-	daemon.CmdStatus(socket, &log, status)
+	for _, code := range commands(commandConn{socket, timeout}) {
+		if code != daemon.ExitSuccess {
+			return code
+		}
+	}
+
+	return daemon.ExitSuccess
+}
+
+// A connection to the daemon whose deadline is renewed whenever a command is
+// written to it, so that each command is given the whole timeout to respond.
+type commandConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c commandConn) Write(p []byte) (int, error) {
+	c.SetDeadline(time.Now().Add(c.timeout))
+	return c.Conn.Write(p)
 }
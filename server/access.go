@@ -0,0 +1,170 @@
+// Copyright (c) 2024 Evan Overman (https://an-prata.it).
+// Licensed under the MIT License.
+// See LICENSE file in repository root for complete license text.
+
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Formats an access log may be written in.
+const (
+	// The Common Log Format of NCSA httpd and Apache.
+	AccessLogCommon = "common"
+
+	// The Common Log Format followed by the referer and user agent.
+	AccessLogCombined = "combined"
+
+	// One JSON object per line.
+	AccessLogJson = "json"
+)
+
+// Records every request served to a file, separately from the server log.
+type accessLog struct {
+	format string
+	path   string
+
+	mutex sync.Mutex
+	file  *os.File
+}
+
+// A request as recorded in a JSON access log.
+type accessLogEntry struct {
+	Time      time.Time `json:"time"`
+	Client    string    `json:"client"`
+	Method    string    `json:"method"`
+	Uri       string    `json:"uri"`
+	Proto     string    `json:"proto"`
+	Host      string    `json:"host"`
+	Status    int       `json:"status"`
+	Bytes     int64     `json:"bytes"`
+	Referer   string    `json:"referer"`
+	UserAgent string    `json:"user_agent"`
+	Duration  float64   `json:"duration_ms"`
+}
+
+// Opens the access log at the given path for appending.
+func newAccessLog(path, format string) (*accessLog, error) {
+	switch format {
+	case AccessLogCommon, AccessLogCombined, AccessLogJson:
+	default:
+		return nil, errors.New("Unknown access log format '" + format + "'")
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+
+	if err != nil {
+		return nil, errors.New("Could not open access log '" + path + "': " + err.Error())
+	}
+
+	return &accessLog{format, path, sync.Mutex{}, file}, nil
+}
+
+// Writes a line for the given request, responded to with the given status and
+// number of body bytes.
+func (l *accessLog) record(req *http.Request, status int, written int64, duration time.Duration) {
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	var line string
+
+	if l.format == AccessLogJson {
+		entry, err := json.Marshal(accessLogEntry{
+			time.Now(),
+			clientIp(req),
+			req.Method,
+			req.RequestURI,
+			req.Proto,
+			req.Host,
+			status,
+			written,
+			req.Referer(),
+			req.UserAgent(),
+			float64(duration.Microseconds()) / 1000,
+		})
+
+		if err != nil {
+			return
+		}
+
+		line = string(entry) + "\n"
+	} else {
+		size := "-"
+
+		if written > 0 {
+			size = strconv.FormatInt(written, 10)
+		}
+
+		line = clientIp(req) + " - - [" + time.Now().Format("02/Jan/2006:15:04:05 -0700") + "] " +
+			quoteLogField(req.Method+" "+req.RequestURI+" "+req.Proto) + " " + strconv.Itoa(status) + " " + size
+
+		if l.format == AccessLogCombined {
+			line += " " + quoteLogField(req.Referer()) + " " + quoteLogField(req.UserAgent())
+		}
+
+		line += "\n"
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.file.WriteString(line)
+}
+
+// Closes the access log and opens it again at the same path, see
+// `Handler.ReopenAccessLog()`.
+func (l *accessLog) reopen() error {
+	file, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+
+	if err != nil {
+		return errors.New("Could not reopen access log '" + l.path + "': " + err.Error())
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.file.Close()
+	l.file = file
+	return nil
+}
+
+func (l *accessLog) close() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.file.Close()
+}
+
+// Quotes a field of a Common Log Format line, escaping quotes, backslashes, and
+// control characters so that a client cannot forge lines. Empty fields are
+// given as "-".
+func quoteLogField(field string) string {
+	if field == "" {
+		return "\"-\""
+	}
+
+	var builder strings.Builder
+	builder.WriteByte('"')
+
+	for _, c := range []byte(field) {
+		switch {
+		case c == '"' || c == '\\':
+			builder.WriteByte('\\')
+			builder.WriteByte(c)
+		case c < 0x20 || c == 0x7f:
+			builder.WriteString(fmt.Sprintf("\\x%02x", c))
+		default:
+			builder.WriteByte(c)
+		}
+	}
+
+	builder.WriteByte('"')
+	return builder.String()
+}
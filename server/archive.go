@@ -0,0 +1,109 @@
+// Copyright (c) 2024 Evan Overman (https://an-prata.it).
+// Licensed under the MIT License.
+// See LICENSE file in repository root for complete license text.
+
+package server
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"testing/fstest"
+)
+
+// Returns true if the given path names an archive that a site may be served
+// from, judged by its extension.
+func IsArchive(path string) bool {
+	lower := strings.ToLower(path)
+
+	for _, ext := range []string{".zip", ".tar", ".tar.gz", ".tgz"} {
+		if strings.HasSuffix(lower, ext) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Opens the archive at the given path as a filesystem. Zip archives are read
+// from as needed and must be closed with the returned closer, tar archives are
+// indexed into memory since they cannot be read from randomly, in which case
+// the closer is nil.
+func openArchive(archivePath string) (fs.FS, io.Closer, error) {
+	if strings.HasSuffix(strings.ToLower(archivePath), ".zip") {
+		reader, err := zip.OpenReader(archivePath)
+
+		if err != nil {
+			return nil, nil, errors.New("Could not open zip archive '" + archivePath + "': " + err.Error())
+		}
+
+		return reader, reader, nil
+	}
+
+	file, err := os.Open(archivePath)
+
+	if err != nil {
+		return nil, nil, errors.New("Could not open tar archive '" + archivePath + "': " + err.Error())
+	}
+
+	defer file.Close()
+	var reader io.Reader = file
+
+	if !strings.HasSuffix(strings.ToLower(archivePath), ".tar") {
+		gzipReader, err := gzip.NewReader(file)
+
+		if err != nil {
+			return nil, nil, errors.New("Could not decompress tar archive '" + archivePath + "': " + err.Error())
+		}
+
+		defer gzipReader.Close()
+		reader = gzipReader
+	}
+
+	// `fstest.MapFS` is a plain in memory filesystem, despite its package it has
+	// no dependency on testing.
+	fsys := fstest.MapFS{}
+	tarReader := tar.NewReader(reader)
+
+	for {
+		header, err := tarReader.Next()
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, nil, errors.New("Could not read tar archive '" + archivePath + "': " + err.Error())
+		}
+
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		name := path.Clean(strings.TrimPrefix(header.Name, "./"))
+
+		if !fs.ValidPath(name) {
+			continue
+		}
+
+		content, err := io.ReadAll(tarReader)
+
+		if err != nil {
+			return nil, nil, errors.New("Could not read '" + name + "' from tar archive '" + archivePath + "'")
+		}
+
+		fsys[name] = &fstest.MapFile{
+			Data:    content,
+			Mode:    fs.FileMode(header.Mode).Perm(),
+			ModTime: header.ModTime,
+		}
+	}
+
+	return fsys, nil, nil
+}
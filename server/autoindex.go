@@ -0,0 +1,87 @@
+// Copyright (c) 2024 Evan Overman (https://an-prata.it).
+// Licensed under the MIT License.
+// See LICENSE file in repository root for complete license text.
+
+package server
+
+import (
+	"html"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Responds with an HTML listing of the given directory of the filesystem,
+// returning false without writing a response if it cannot be read. Hidden
+// files, those starting with ".", are not listed.
+func serveDirListing(w http.ResponseWriter, req *http.Request, fsys fs.FS, dir string) bool {
+	entries, err := fs.ReadDir(fsys, dir)
+
+	if err != nil {
+		return false
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].IsDir() && !entries[j].IsDir()
+	})
+
+	uriPath := req.URL.Path
+
+	if !strings.HasSuffix(uriPath, "/") {
+		uriPath += "/"
+	}
+
+	title := html.EscapeString("Index of " + uriPath)
+
+	var builder strings.Builder
+	builder.WriteString("<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>" + title + "</title>\n</head>\n<body>\n")
+	builder.WriteString("<h1>" + title + "</h1>\n<table>\n<tr><th>Name</th><th>Size</th><th>Modified</th></tr>\n")
+
+	if uriPath != "/" {
+		parent := path.Dir(strings.TrimSuffix(uriPath, "/"))
+
+		if parent != "/" {
+			parent += "/"
+		}
+
+		builder.WriteString("<tr><td><a href=\"" + html.EscapeString(parent) + "\">../</a></td><td></td><td></td></tr>\n")
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+
+		info, err := entry.Info()
+
+		if err != nil {
+			continue
+		}
+
+		size := strconv.FormatInt(info.Size(), 10)
+		href := uriPath + url.PathEscape(name)
+
+		if entry.IsDir() {
+			name += "/"
+			href += "/"
+			size = "-"
+		}
+
+		builder.WriteString(
+			"<tr><td><a href=\"" + html.EscapeString(href) + "\">" + html.EscapeString(name) + "</a></td><td>" +
+				size + "</td><td>" + info.ModTime().UTC().Format(time.RFC1123) + "</td></tr>\n",
+		)
+	}
+
+	builder.WriteString("</table>\n</body>\n</html>\n")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(builder.String()))
+	return true
+}
@@ -0,0 +1,139 @@
+// Copyright (c) 2024 Evan Overman (https://an-prata.it).
+// Licensed under the MIT License.
+// See LICENSE file in repository root for complete license text.
+
+package server
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/an-prata/webby/logger"
+)
+
+// Temporarily bans client IPs that make too many requests for dead paths or
+// missing files, as scanners do. Each such request is a strike, and strikes
+// decay one at a time so that `threshold` strikes are forgotten over `window`.
+type banTracker struct {
+	threshold int64
+	window    time.Duration
+	duration  time.Duration
+
+	mutex   sync.Mutex
+	strikes map[string]*banStrikes
+	banned  map[string]time.Time
+}
+
+// Strikes against a single client IP as of the last time they were updated.
+type banStrikes struct {
+	count   int64
+	updated time.Time
+}
+
+func newBanTracker(threshold int64, window, duration time.Duration) *banTracker {
+	return &banTracker{
+		threshold,
+		window,
+		duration,
+		sync.Mutex{},
+		map[string]*banStrikes{},
+		map[string]time.Time{},
+	}
+}
+
+// Sets the threshold, window, and duration of bans, keeping the strikes and bans
+// made so far.
+func (b *banTracker) setLimits(threshold int64, window, duration time.Duration) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.threshold = threshold
+	b.window = window
+	b.duration = duration
+}
+
+// Returns true if the given IP is currently banned.
+func (b *banTracker) isBanned(ip string) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	until, ok := b.banned[ip]
+
+	if !ok {
+		return false
+	}
+
+	if time.Now().After(until) {
+		delete(b.banned, ip)
+		logger.GlobalLog.LogInfo("Unbanned client " + ip)
+		return false
+	}
+
+	return true
+}
+
+// Adds a strike against the given IP for a request to the given path, banning
+// it once it reaches the threshold. Bans are logged in the format documented
+// in the README for use with fail2ban.
+func (b *banTracker) strike(ip, path string) {
+	now := time.Now()
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	// Forget clients without strikes or whose bans have expired so that the maps
+	// cannot grow forever.
+	for k, until := range b.banned {
+		if now.After(until) {
+			delete(b.banned, k)
+		}
+	}
+
+	for k, s := range b.strikes {
+		s.decay(now, b.threshold, b.window)
+
+		if s.count == 0 {
+			delete(b.strikes, k)
+		}
+	}
+
+	s, ok := b.strikes[ip]
+
+	if !ok {
+		s = &banStrikes{0, now}
+		b.strikes[ip] = s
+	}
+
+	s.count++
+
+	if s.count < b.threshold {
+		return
+	}
+
+	delete(b.strikes, ip)
+	b.banned[ip] = now.Add(b.duration)
+	logger.GlobalLog.LogWarn(
+		"Banned client " + ip + " for " + strconv.FormatInt(int64(b.duration.Seconds()), 10) + " seconds after request for " + path,
+	)
+}
+
+// Removes strikes that have decayed by the given time, one for every
+// `window / threshold` elapsed.
+func (s *banStrikes) decay(now time.Time, threshold int64, window time.Duration) {
+	interval := window / time.Duration(threshold)
+
+	if interval <= 0 {
+		return
+	}
+
+	elapsed := int64(now.Sub(s.updated) / interval)
+
+	if elapsed >= s.count {
+		s.count = 0
+		s.updated = now
+		return
+	}
+
+	s.count -= elapsed
+	s.updated = s.updated.Add(time.Duration(elapsed) * interval)
+}
@@ -0,0 +1,47 @@
+// Copyright (c) 2024 Evan Overman (https://an-prata.it).
+// Licensed under the MIT License.
+// See LICENSE file in repository root for complete license text.
+
+package server
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/an-prata/webby/logger"
+)
+
+// Returns true if the request carries the credentials of one of the given users,
+// each given by name with the hex encoded SHA-256 hash of their password,
+// answering it with 401 Unauthorized for the given realm if not. Credentials are
+// only accepted over HTTPS or from the local machine, e.g. through a reverse
+// proxy, since basic auth sends them in the clear.
+func basicAuthorize(w http.ResponseWriter, req *http.Request, users map[string]string, realm string) bool {
+	if req.TLS == nil {
+		if ip := net.ParseIP(clientIp(req)); ip == nil || !ip.IsLoopback() {
+			http.Error(w, realm+" requires HTTPS", http.StatusForbidden)
+			return false
+		}
+	}
+
+	name, password, ok := req.BasicAuth()
+
+	if ok {
+		hash := sha256.Sum256([]byte(password))
+		expected, found := users[name]
+
+		if found && subtle.ConstantTimeCompare([]byte(hex.EncodeToString(hash[:])), []byte(strings.ToLower(expected))) == 1 {
+			return true
+		}
+
+		logger.GlobalLog.LogWarn("Denied " + realm + " request with invalid credentials for '" + name + "' from " + req.RemoteAddr)
+	}
+
+	w.Header().Set("WWW-Authenticate", `Basic realm="webby `+realm+`", charset="UTF-8"`)
+	http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	return false
+}
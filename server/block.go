@@ -0,0 +1,144 @@
+// Copyright (c) 2024 Evan Overman (https://an-prata.it).
+// Licensed under the MIT License.
+// See LICENSE file in repository root for complete license text.
+
+package server
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/an-prata/webby/logger"
+)
+
+// Actions taken on requests matching a `BlockRule`.
+const (
+	// Responds with 403 Forbidden.
+	BlockForbid = "forbid"
+
+	// Closes the connection without responding.
+	BlockDrop = "drop"
+
+	// Responds a byte at a time, slowly, as a dead response does with
+	// `DeadTarpit`.
+	BlockTarpit = "tarpit"
+)
+
+// Blocks requests whose header matches, e.g. scrapers by their User-Agent.
+type BlockRule struct {
+	// Name of the header to match, e.g. "User-Agent" or "Host".
+	Header string
+
+	// Text the header must contain, case insensitively, or a regular expression
+	// if it starts with "~". Empty to match requests missing the header.
+	Match string
+
+	// One of `BlockForbid`, `BlockDrop`, or `BlockTarpit`, empty to forbid.
+	Action string
+}
+
+// A `BlockRule` ready to match requests.
+type blockMatcher struct {
+	rule BlockRule
+	re   *regexp.Regexp
+}
+
+// Compiles the given rule, giving an error if its regular expression or action
+// is invalid.
+func compileBlockRule(rule BlockRule) (blockMatcher, error) {
+	matcher := blockMatcher{rule, nil}
+	matcher.rule.Action = strings.ToLower(rule.Action)
+
+	switch matcher.rule.Action {
+	case "":
+		matcher.rule.Action = BlockForbid
+	case BlockForbid, BlockDrop, BlockTarpit:
+	default:
+		return matcher, errors.New("Unknown action '" + rule.Action + "' of block rule for '" + rule.Header + "', expected 'forbid', 'drop', or 'tarpit'")
+	}
+
+	if expr, ok := strings.CutPrefix(rule.Match, "~"); ok {
+		re, err := regexp.Compile(expr)
+
+		if err != nil {
+			return matcher, errors.New("Could not compile block rule '" + rule.Match + "' for '" + rule.Header + "': " + err.Error())
+		}
+
+		matcher.re = re
+	}
+
+	return matcher, nil
+}
+
+// Gives whether the request matches the rule.
+func (m blockMatcher) matches(req *http.Request) bool {
+	values := req.Header.Values(m.rule.Header)
+
+	// The Host header is moved out of the other headers by the server.
+	if http.CanonicalHeaderKey(m.rule.Header) == "Host" {
+		values = []string{}
+
+		if req.Host != "" {
+			values = append(values, req.Host)
+		}
+	}
+
+	if m.rule.Match == "" {
+		return len(values) == 0
+	}
+
+	for _, value := range values {
+		if m.re != nil && m.re.MatchString(value) {
+			return true
+		}
+
+		if m.re == nil && strings.Contains(strings.ToLower(value), strings.ToLower(m.rule.Match)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Adds rules blocking requests by their headers, see `BlockRule`. Rules that
+// could not be compiled are skipped with a warning.
+func (h *Handler) AddBlockRules(rules []BlockRule) {
+	for _, rule := range rules {
+		matcher, err := compileBlockRule(rule)
+
+		if err != nil {
+			logger.GlobalLog.LogWarn(err.Error())
+			continue
+		}
+
+		logger.GlobalLog.LogInfo("Blocking requests by '" + matcher.rule.Action + "' with header '" + rule.Header + "' matching '" + rule.Match + "'")
+		h.blockRules = append(h.blockRules, matcher)
+	}
+}
+
+// Takes the action of the first block rule the request matches, giving false
+// without responding if it matches none.
+func (h *Handler) block(w http.ResponseWriter, req *http.Request) bool {
+	for _, matcher := range h.blockRules {
+		if !matcher.matches(req) {
+			continue
+		}
+
+		logger.GlobalLog.LogWarn("Blocked request from " + req.RemoteAddr + " for " + req.URL.Path + " by '" + matcher.rule.Action + "' with header '" + matcher.rule.Header + "' matching '" + matcher.rule.Match + "'")
+
+		switch matcher.rule.Action {
+		case BlockDrop:
+			dropConnection()
+		case BlockTarpit:
+			h.tarpit(w, req)
+		default:
+			h.serveError(w, req, http.StatusForbidden)
+		}
+
+		return true
+	}
+
+	return false
+}
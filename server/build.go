@@ -0,0 +1,431 @@
+// Copyright (c) 2024 Evan Overman (https://an-prata.it).
+// Licensed under the MIT License.
+// See LICENSE file in repository root for complete license text.
+
+package server
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"html/template"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/an-prata/webby/logger"
+)
+
+// A page built from Markdown by `BuildSite()`, given to layouts as the data
+// they are executed with.
+type BuildPage struct {
+	// Given by the page's front matter, or the name of its file if not.
+	Title string
+
+	// Given by the page's front matter as "2006-01-02" or RFC 3339, zero if not.
+	Date time.Time
+
+	// URL path the page is served at, e.g. "/posts/hello/".
+	Url string
+
+	// The page's Markdown rendered to HTML.
+	Content template.HTML
+
+	// Front matter other than the title, date, draft, and layout.
+	Params map[string]string
+
+	// Values given by `TemplateSiteData` in the config.
+	Site map[string]string
+
+	// Every page of the site, newest first, e.g. for listing posts.
+	Pages []BuildPage
+
+	// Name of the layout the page is rendered through.
+	layout string
+
+	// Markdown of the page, rendered into `Content` once all pages are read.
+	markdown string
+}
+
+// Layout used for pages when the source has no "_layouts/default" of its own.
+const defaultLayout = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{ .Title }}{{ with .Site.title }} - {{ . }}{{ end }}</title>
+</head>
+<body>
+<main>
+{{ .Content }}
+</main>
+</body>
+</html>
+`
+
+// Builds the site from `BuildSource` into `Site`. Markdown files become pages,
+// e.g. "posts/hello.md" becomes "posts/hello/index.html", rendered through the
+// layouts in "_layouts", and all other files are copied unchanged. A
+// "sitemap.xml" listing every page is written if `BuildBaseUrl` or
+// `CanonicalHost` is set. Files and directories starting with "." or "_" are
+// not copied.
+//
+// The site is built beside `Site` and then swapped in, so a failed build leaves
+// the previous one in place.
+func BuildSite(opts ServerOptions) error {
+	if opts.BuildSource == "" {
+		return errors.New("No 'BuildSource' set for '" + opts.Name + "'")
+	}
+
+	if opts.S3Bucket != "" || IsArchive(opts.Site) {
+		return errors.New("Cannot build into '" + opts.Name + "', which serves its site from an archive or bucket")
+	}
+
+	if filepath.Clean(opts.BuildSource) == filepath.Clean(opts.Site) {
+		return errors.New("'BuildSource' and 'Site' of '" + opts.Name + "' must be different directories")
+	}
+
+	layouts, err := readLayouts(filepath.Join(opts.BuildSource, "_layouts"))
+
+	if err != nil {
+		return err
+	}
+
+	out, err := os.MkdirTemp(filepath.Dir(filepath.Clean(opts.Site)), "."+filepath.Base(opts.Site)+"-build-*")
+
+	if err != nil {
+		return errors.New("Could not create build directory for '" + opts.Site + "': " + err.Error())
+	}
+
+	// Removes what is left of the build directory if anything fails, after a
+	// successful build it will have been renamed away.
+	defer os.RemoveAll(out)
+
+	if err = os.Chmod(out, 0755); err != nil {
+		return err
+	}
+
+	pages, err := buildFiles(opts.BuildSource, out)
+
+	if err != nil {
+		return err
+	}
+
+	for i := range pages {
+		pages[i].Content = template.HTML(renderMarkdown(pages[i].markdown))
+		pages[i].Site = opts.TemplateSiteData
+	}
+
+	sort.SliceStable(pages, func(i, j int) bool {
+		if !pages[i].Date.Equal(pages[j].Date) {
+			return pages[i].Date.After(pages[j].Date)
+		}
+
+		return pages[i].Url < pages[j].Url
+	})
+
+	for i := range pages {
+		pages[i].Pages = pages
+	}
+
+	for _, page := range pages {
+		if err = writePage(out, layouts, page); err != nil {
+			return err
+		}
+	}
+
+	baseUrl := opts.BuildBaseUrl
+
+	if baseUrl == "" && opts.CanonicalHost != "" {
+		baseUrl = "https://" + opts.CanonicalHost
+	}
+
+	if baseUrl != "" {
+		if err = writeSitemap(filepath.Join(out, "sitemap.xml"), baseUrl, pages); err != nil {
+			return err
+		}
+	}
+
+	if err = swapDirectory(out, opts.Site); err != nil {
+		return err
+	}
+
+	logger.GlobalLog.LogInfo("Built " + strconv.Itoa(len(pages)) + " pages from '" + opts.BuildSource + "' into '" + opts.Site + "'")
+	return nil
+}
+
+// Parses every file in the given directory as a layout named for the file
+// without its extension, e.g. "post" for "post.gohtml". Layouts may include one
+// another by name, e.g. `{{ template "header" . }}`. The built-in layout is
+// used as "default" if the directory does not give one.
+func readLayouts(dir string) (*template.Template, error) {
+	layouts := template.New("")
+	entries, err := os.ReadDir(dir)
+
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, errors.New("Could not read layouts '" + dir + "': " + err.Error())
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+
+		content, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+
+		if err != nil {
+			return nil, errors.New("Could not read layout '" + entry.Name() + "': " + err.Error())
+		}
+
+		name := strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name()))
+
+		if _, err = layouts.New(name).Parse(string(content)); err != nil {
+			return nil, errors.New("Could not parse layout '" + entry.Name() + "': " + err.Error())
+		}
+	}
+
+	if layouts.Lookup("default") == nil {
+		template.Must(layouts.New("default").Parse(defaultLayout))
+	}
+
+	return layouts, nil
+}
+
+// Copies the files of the given source directory into the output directory,
+// giving the pages read from its Markdown files rather than copying them.
+func buildFiles(source, out string) ([]BuildPage, error) {
+	pages := []BuildPage{}
+
+	err := filepath.WalkDir(source, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return errors.New("Could not read '" + file + "': " + err.Error())
+		}
+
+		rel, _ := filepath.Rel(source, file)
+
+		if rel == "." {
+			return nil
+		}
+
+		if strings.HasPrefix(entry.Name(), ".") || strings.HasPrefix(entry.Name(), "_") {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		if entry.IsDir() {
+			return os.MkdirAll(filepath.Join(out, rel), 0755)
+		}
+
+		if strings.ToLower(filepath.Ext(rel)) != ".md" {
+			return copyFile(file, filepath.Join(out, rel))
+		}
+
+		page, err := readPage(file, rel)
+
+		if err != nil {
+			return err
+		}
+
+		if page != nil {
+			pages = append(pages, *page)
+		}
+
+		return nil
+	})
+
+	return pages, err
+}
+
+// Reads a Markdown page and its front matter, giving nil for drafts. The
+// front matter is a block of "key: value" lines between two lines of "---" at
+// the start of the file.
+func readPage(file, rel string) (*BuildPage, error) {
+	content, err := os.ReadFile(file)
+
+	if err != nil {
+		return nil, errors.New("Could not read page '" + file + "': " + err.Error())
+	}
+
+	name := strings.TrimSuffix(filepath.Base(rel), filepath.Ext(rel))
+	dir := path.Join("/", filepath.ToSlash(filepath.Dir(rel)))
+	page := BuildPage{Title: name, Url: path.Join(dir, name) + "/", Params: map[string]string{}, layout: "default"}
+
+	if name == "index" {
+		page.Url = strings.TrimSuffix(dir, "/") + "/"
+	}
+
+	text := strings.ReplaceAll(string(content), "\r\n", "\n")
+
+	if strings.HasPrefix(text, "---\n") {
+		matter, body, found := strings.Cut(text[4:], "\n---\n")
+
+		if !found {
+			return nil, errors.New("Front matter of '" + file + "' is not closed with '---'")
+		}
+
+		text = body
+
+		for _, line := range strings.Split(matter, "\n") {
+			key, value, found := strings.Cut(line, ":")
+
+			if !found {
+				continue
+			}
+
+			key = strings.ToLower(strings.TrimSpace(key))
+			value = strings.Trim(strings.TrimSpace(value), `"'`)
+
+			switch key {
+			case "title":
+				page.Title = value
+			case "layout":
+				page.layout = value
+			case "draft":
+				if value == "true" {
+					return nil, nil
+				}
+			case "date":
+				page.Date, err = parseDate(value)
+
+				if err != nil {
+					return nil, errors.New("Could not parse date of '" + file + "': " + err.Error())
+				}
+			default:
+				page.Params[key] = value
+			}
+		}
+	}
+
+	page.markdown = text
+	return &page, nil
+}
+
+// Parses a date given as "2006-01-02", "2006-01-02 15:04", or RFC 3339.
+func parseDate(value string) (time.Time, error) {
+	for _, layout := range []string{"2006-01-02", "2006-01-02 15:04", time.RFC3339} {
+		if date, err := time.Parse(layout, value); err == nil {
+			return date, nil
+		}
+	}
+
+	return time.Time{}, errors.New("expected '2006-01-02' or RFC 3339, got '" + value + "'")
+}
+
+// Renders a page through its layout into "index.html" of its URL path.
+func writePage(out string, layouts *template.Template, page BuildPage) error {
+	layout := layouts.Lookup(page.layout)
+
+	if layout == nil {
+		return errors.New("No layout named '" + page.layout + "' for page '" + page.Url + "'")
+	}
+
+	var buf bytes.Buffer
+
+	if err := layout.Execute(&buf, page); err != nil {
+		return errors.New("Could not render page '" + page.Url + "': " + err.Error())
+	}
+
+	file := filepath.Join(out, filepath.FromSlash(page.Url), "index.html")
+
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+
+	return os.WriteFile(file, buf.Bytes(), 0644)
+}
+
+// Writes a sitemap of the given pages with the given base URL, e.g.
+// "https://example.com".
+func writeSitemap(file, baseUrl string, pages []BuildPage) error {
+	type sitemapUrl struct {
+		Loc     string `xml:"loc"`
+		LastMod string `xml:"lastmod,omitempty"`
+	}
+
+	type sitemap struct {
+		XMLName xml.Name     `xml:"urlset"`
+		Xmlns   string       `xml:"xmlns,attr"`
+		Urls    []sitemapUrl `xml:"url"`
+	}
+
+	urls := sitemap{Xmlns: "http://www.sitemaps.org/schemas/sitemap/0.9"}
+	baseUrl = strings.TrimSuffix(baseUrl, "/")
+
+	for _, page := range pages {
+		url := sitemapUrl{Loc: baseUrl + page.Url}
+
+		if !page.Date.IsZero() {
+			url.LastMod = page.Date.Format("2006-01-02")
+		}
+
+		urls.Urls = append(urls.Urls, url)
+	}
+
+	sort.Slice(urls.Urls, func(i, j int) bool {
+		return urls.Urls[i].Loc < urls.Urls[j].Loc
+	})
+
+	content, err := xml.MarshalIndent(urls, "", "  ")
+
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(file, append([]byte(xml.Header), append(content, '\n')...), 0644)
+}
+
+// Copies a file, keeping its permissions.
+func copyFile(from, to string) error {
+	src, err := os.Open(from)
+
+	if err != nil {
+		return errors.New("Could not open '" + from + "': " + err.Error())
+	}
+
+	defer src.Close()
+	stat, err := src.Stat()
+
+	if err != nil {
+		return err
+	}
+
+	dst, err := os.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, stat.Mode().Perm())
+
+	if err != nil {
+		return errors.New("Could not create '" + to + "': " + err.Error())
+	}
+
+	_, err = io.Copy(dst, src)
+
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+// Replaces the directory at the given path with the built one, removing the
+// previous directory once the new one is in place.
+func swapDirectory(built, dir string) error {
+	previous := built + "-previous"
+
+	if err := os.Rename(dir, previous); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return errors.New("Could not move aside '" + dir + "': " + err.Error())
+	}
+
+	if err := os.Rename(built, dir); err != nil {
+		os.Rename(previous, dir)
+		return errors.New("Could not move build into '" + dir + "': " + err.Error())
+	}
+
+	return os.RemoveAll(previous)
+}
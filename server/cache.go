@@ -0,0 +1,54 @@
+// Copyright (c) 2024 Evan Overman (https://an-prata.it).
+// Licensed under the MIT License.
+// See LICENSE file in repository root for complete license text.
+
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"strconv"
+	"strings"
+)
+
+// Gives an entity tag for a file from its modification time and size, which
+// changes whenever the file is written without needing to read it.
+func metadataEtag(stat fs.FileInfo) string {
+	return "\"" + strconv.FormatInt(stat.ModTime().UnixNano(), 16) + "-" + strconv.FormatInt(stat.Size(), 16) + "\""
+}
+
+// Gives an entity tag for content generated from a file, from a hash of the
+// content itself.
+func contentEtag(content []byte) string {
+	sum := sha256.Sum256(content)
+	return "\"" + hex.EncodeToString(sum[:16]) + "\""
+}
+
+// Finds the rule matching the given URL path among rules starting with '.',
+// which match file extensions, rules ending in '/', which match path prefixes,
+// and any other rules, which match exact paths. Exact paths take priority over
+// the longest matching prefix, which takes priority over extensions. Returns
+// the value of the matching rule and true if one matches.
+func matchRule(rules map[string]string, uriPath, ext string) (string, bool) {
+	if value, ok := rules[uriPath]; ok {
+		return value, true
+	}
+
+	longest := -1
+	var match string
+
+	for rule, value := range rules {
+		if strings.HasSuffix(rule, "/") && strings.HasPrefix(uriPath, rule) && len(rule) > longest {
+			longest = len(rule)
+			match = value
+		}
+	}
+
+	if longest >= 0 {
+		return match, true
+	}
+
+	value, ok := rules[ext]
+	return value, ok && ext != ""
+}
@@ -0,0 +1,107 @@
+// Copyright (c) 2024 Evan Overman (https://an-prata.it).
+// Licensed under the MIT License.
+// See LICENSE file in repository root for complete license text.
+
+package server
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/an-prata/webby/logger"
+)
+
+// Sets the host that requests for any other host name are permanently
+// redirected to, e.g. "example.com" to redirect "www.example.com". Requests for
+// "localhost" or an IP address are never redirected, so that local status
+// checks still reach the site. An empty host disables redirection.
+func (h *Handler) SetCanonicalHost(host string) {
+	if host != "" {
+		logger.GlobalLog.LogInfo("Redirecting requests to canonical host '" + host + "'")
+	}
+
+	h.canonicalHost = strings.ToLower(host)
+}
+
+// Sets whether requests for paths should be permanently redirected to add or
+// remove a trailing slash, given as "add" or "remove", when the path they
+// would be redirected to is mapped. An empty string disables redirection.
+func (h *Handler) SetCanonicalTrailingSlash(trailingSlash string) {
+	switch strings.ToLower(trailingSlash) {
+	case "":
+		h.trailingSlash = ""
+	case "add", "remove":
+		logger.GlobalLog.LogInfo("Redirecting requests to " + strings.ToLower(trailingSlash) + " trailing slashes")
+		h.trailingSlash = strings.ToLower(trailingSlash)
+	default:
+		logger.GlobalLog.LogWarn("Unknown trailing slash policy '" + trailingSlash + "', expected 'add' or 'remove'")
+		h.trailingSlash = ""
+	}
+}
+
+// Gives the canonical URL of the given request, and true if it differs from the
+// requested URL.
+func (h *Handler) canonicalUrl(req *http.Request) (string, bool) {
+	host := req.Host
+	hostname, port, err := net.SplitHostPort(host)
+
+	if err != nil {
+		hostname = host
+		port = ""
+	}
+
+	redirect := false
+
+	if h.canonicalHost != "" && strings.ToLower(hostname) != h.canonicalHost && hostname != "localhost" && net.ParseIP(hostname) == nil {
+		host = h.canonicalHost
+
+		if port != "" {
+			host = net.JoinHostPort(host, port)
+		}
+
+		redirect = true
+	}
+
+	uriPath := req.URL.Path
+	escapedPath := req.URL.EscapedPath()
+
+	switch h.trailingSlash {
+	case "add":
+		if !strings.HasSuffix(uriPath, "/") && h.isMapped(uriPath+"/") {
+			escapedPath += "/"
+			redirect = true
+		}
+	case "remove":
+		if uriPath != "/" && strings.HasSuffix(uriPath, "/") && h.isMapped(strings.TrimSuffix(uriPath, "/")) {
+			escapedPath = strings.TrimSuffix(escapedPath, "/")
+			redirect = true
+		}
+	}
+
+	if !redirect {
+		return "", false
+	}
+
+	scheme := "http"
+
+	if req.TLS != nil {
+		scheme = "https"
+	}
+
+	url := scheme + "://" + host + escapedPath
+
+	if req.URL.RawQuery != "" {
+		url += "?" + req.URL.RawQuery
+	}
+
+	return url, true
+}
+
+// Returns true if a file or custom handler is mapped to the given URL path, or
+// a file is resolved for it.
+func (h *Handler) isMapped(uriPath string) bool {
+	_, file := h.fileFor(uriPath)
+	_, handler := h.handlerMap[uriPath]
+	return file || handler
+}
@@ -0,0 +1,358 @@
+// Copyright (c) 2024 Evan Overman (https://an-prata.it).
+// Licensed under the MIT License.
+// See LICENSE file in repository root for complete license text.
+
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/an-prata/webby/logger"
+)
+
+// Checks the config at the given path, with its fragments merged over it,
+// giving a description of each problem found, or none if it is valid. Unlike
+// `LoadConfigFromPath()`, which falls back to defaults, unknown options and
+// values of the wrong type are problems, as are missing site roots,
+// certificates, and keys, and servers listening on the same port.
+func CheckConfig(path string) []string {
+	merged, err := readConfigMap(path)
+
+	if err != nil {
+		return []string{err.Error()}
+	}
+
+	// Options are decoded from JSON again, strictly, see `decodeOptions()`.
+	data, _ := json.Marshal(merged)
+	var optsMap map[string]json.RawMessage
+	json.Unmarshal(data, &optsMap)
+
+	opts := DefaultOptions()
+	problems := decodeOptions(optsMap, &opts, "")
+
+	if raw, ok := optsMap["Instances"]; ok {
+		var instances []map[string]json.RawMessage
+
+		if err = unmarshalConfig(raw, &instances); err != nil {
+			problems = append(problems, "Expected 'Instances' to be a list of objects")
+		}
+
+		base := opts
+		base.Instances = []ServerOptions{}
+
+		for i, instanceMap := range instances {
+			instance := base
+			instance.Name = "instance-" + strconv.Itoa(i)
+			problems = append(problems, decodeOptions(instanceMap, &instance, "Instances["+strconv.Itoa(i)+"].")...)
+			opts.Instances = append(opts.Instances, instance)
+		}
+	}
+
+	return append(problems, checkOptions(&opts)...)
+}
+
+// Options that webby deliberately does not have, by why, so that configs
+// setting them are told more than that the option is unknown.
+var unsupportedOptions = map[string]string{
+	"EnableHttp3": "as HTTP/3 needs a QUIC implementation, which would be webby's first dependency",
+}
+
+// Decodes each option of the given map over the given options, giving a problem
+// for each that is unknown or of the wrong type. Keys are given with the prefix
+// in problems. Instances are not decoded.
+func decodeOptions(optsMap map[string]json.RawMessage, opts *ServerOptions, prefix string) []string {
+	problems := []string{}
+
+	for key, raw := range optsMap {
+		if key == "Instances" {
+			continue
+		}
+
+		if reason, ok := unsupportedOptions[key]; ok {
+			problems = append(problems, "Option '"+prefix+key+"' is not supported, "+reason)
+			continue
+		}
+
+		if _, ok := reflect.TypeOf(*opts).FieldByName(key); !ok {
+			problems = append(problems, "Unknown option '"+prefix+key+"'")
+			continue
+		}
+
+		// Decoded as a member of the options, rather than into the field alone, so
+		// that errors name nested fields, and into a copy so that a partially
+		// decoded value is not kept.
+		decoded := *opts
+		wrapped, _ := json.Marshal(map[string]json.RawMessage{key: raw})
+		decoder := json.NewDecoder(bytes.NewReader(wrapped))
+		decoder.DisallowUnknownFields()
+
+		if err := decoder.Decode(&decoded); err != nil {
+			problems = append(problems, decodeProblem(prefix+key, err))
+			continue
+		}
+
+		*opts = decoded
+	}
+
+	sort.Strings(problems)
+	return problems
+}
+
+// Describes an error decoding the given option.
+func decodeProblem(key string, err error) string {
+	if typeErr, ok := err.(*json.UnmarshalTypeError); ok {
+		// The field is given from the options, e.g. "LogTarget.Journald" or
+		// "DeadPaths.0", with map keys escaped as in a JSON pointer.
+		if typeErr.Field != "" {
+			field := strings.NewReplacer("~1", "/", "~0", "~").Replace(typeErr.Field)
+			key = strings.TrimSuffix(key, key[strings.LastIndex(key, ".")+1:]) + field
+		}
+
+		return "Expected '" + key + "' to be " + describeType(typeErr.Type) + ", not " + typeErr.Value
+	}
+
+	if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+		return "Unknown field " + field + " in '" + key + "'"
+	}
+
+	return "Could not parse '" + key + "': " + err.Error()
+}
+
+// Describes the JSON value expected for the given type.
+func describeType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Bool:
+		return "a bool"
+	case reflect.String:
+		return "a string"
+	case reflect.Slice, reflect.Array:
+		return "a list"
+	case reflect.Map, reflect.Struct:
+		return "an object"
+	}
+
+	return "a number"
+}
+
+// Checks the values of the given options and their instances, giving a problem
+// for each missing file, invalid log setting, and port used by more than one
+// server.
+func checkOptions(opts *ServerOptions) []string {
+	problems := []string{}
+
+	if _, err := logger.LevelFromString(opts.LogLevelPrint); err != nil {
+		problems = append(problems, "Unknown log level '"+opts.LogLevelPrint+"' for 'LogLevelPrint'")
+	}
+
+	if _, err := logger.LevelFromString(opts.LogLevelRecord); err != nil {
+		problems = append(problems, "Unknown log level '"+opts.LogLevelRecord+"' for 'LogLevelRecord'")
+	}
+
+	log := logger.Log{}
+
+	if err := log.SetFormat(opts.LogFormat); err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	if opts.AdminProfiling && opts.AdminAddress == "" {
+		problems = append(problems, "'AdminProfiling' needs an 'AdminAddress' to serve profiles at")
+	}
+
+	if opts.AdminAddress != "" && opts.AdminToken == "" {
+		problems = append(problems, "Admin API at '"+opts.AdminAddress+"' has no 'AdminToken' to authenticate requests")
+	}
+
+	instanceNames := map[string]bool{}
+
+	for _, instance := range opts.ServerInstances() {
+		instanceNames[instance.Name] = true
+	}
+
+	for i, job := range opts.Cron {
+		name := "Cron job '" + job.Name + "'"
+
+		if job.Name == "" {
+			name = "Cron job " + strconv.Itoa(i)
+		}
+
+		if _, err := ParseCronSchedule(job.Schedule); err != nil {
+			problems = append(problems, name+": "+err.Error())
+		}
+
+		if !containsString(CronTasks, job.Task) {
+			problems = append(problems, name+" has unknown task '"+job.Task+"', expected one of "+strings.Join(CronTasks, ", "))
+		}
+
+		if job.Task == TaskCommand && job.Command == "" {
+			problems = append(problems, name+" has no 'Command' to run")
+		}
+
+		if job.Instance != "" && !instanceNames[job.Instance] {
+			problems = append(problems, name+" is for unknown instance '"+job.Instance+"'")
+		}
+	}
+
+	// Addresses listened on, by the server listening on them.
+	listening := map[string]string{}
+
+	for _, instance := range opts.ServerInstances() {
+		instance.checkForDefaults()
+		name := "'" + instance.Name + "'"
+
+		// A site that is built may not have been built yet.
+		if _, err := os.Stat(instance.Site); err != nil && instance.S3Bucket == "" && instance.BuildSource == "" {
+			problems = append(problems, "Site root '"+instance.Site+"' of "+name+" does not exist")
+		}
+
+		for prefix, dir := range instance.Mounts {
+			if stat, err := os.Stat(dir); err != nil || !stat.IsDir() {
+				problems = append(problems, "Directory '"+dir+"' mounted at '"+prefix+"' of "+name+" does not exist")
+			}
+		}
+
+		if instance.WebDavRoot != "" {
+			if stat, err := os.Stat(instance.WebDavRoot); err != nil || !stat.IsDir() {
+				problems = append(problems, "WebDAV root '"+instance.WebDavRoot+"' of "+name+" does not exist")
+			}
+		}
+
+		if instance.DefaultLanguage != "" {
+			found := false
+
+			for _, language := range instance.Languages {
+				found = found || strings.EqualFold(language, instance.DefaultLanguage)
+			}
+
+			if !found {
+				problems = append(problems, "Default language '"+instance.DefaultLanguage+"' of "+name+" is not one of 'Languages'")
+			}
+		}
+
+		if instance.BuildSource != "" {
+			if stat, err := os.Stat(instance.BuildSource); err != nil || !stat.IsDir() {
+				problems = append(problems, "Build source '"+instance.BuildSource+"' of "+name+" does not exist")
+			} else if instance.S3Bucket != "" || IsArchive(instance.Site) {
+				problems = append(problems, "Build source '"+instance.BuildSource+"' of "+name+" cannot be built into an archive or bucket")
+			}
+		}
+
+		if instance.UploadPath != "" && len(instance.UploadUsers) == 0 && !instance.UploadAnonymous && !instance.Protects(instance.UploadPath) {
+			problems = append(problems, "Upload path of "+name+" has no 'UploadUsers', set 'UploadAnonymous' to let anyone upload")
+		}
+
+		if instance.UploadPath != "" {
+			if stat, err := os.Stat(instance.UploadDir); err != nil || !stat.IsDir() {
+				problems = append(problems, "Upload directory '"+instance.UploadDir+"' of "+name+" does not exist")
+			}
+		}
+
+		if instance.DeployHookPath != "" {
+			if instance.DeployHookSecret == "" {
+				problems = append(problems, "Deploy hook of "+name+" has no 'DeployHookSecret' to authenticate webhooks")
+			}
+
+			if instance.S3Bucket != "" || IsArchive(instance.Site) {
+				problems = append(problems, "Deploy hook of "+name+" cannot update a site served from an archive or bucket")
+			}
+
+			if instance.Protects(instance.DeployHookPath) {
+				problems = append(problems, "Deploy hook of "+name+" is protected, so Git hosts cannot reach it")
+			}
+		}
+
+		for kind, users := range map[string]map[string]string{"WebDAV": instance.WebDavUsers, "upload": instance.UploadUsers} {
+			for user, hash := range users {
+				if decoded, err := hex.DecodeString(hash); err != nil || len(decoded) != sha256.Size {
+					problems = append(problems, "Password of "+kind+" user '"+user+"' of "+name+" is not a hex encoded SHA-256 hash")
+				}
+			}
+		}
+
+		for _, pair := range instance.CertificatePairs() {
+			for _, file := range []string{pair.Cert, pair.Key} {
+				if _, err := os.Stat(file); err != nil {
+					problems = append(problems, "Certificate file '"+file+"' of "+name+" does not exist")
+				}
+			}
+		}
+
+		if instance.ClientCA != "" {
+			if _, err := os.Stat(instance.ClientCA); err != nil {
+				problems = append(problems, "Client CA '"+instance.ClientCA+"' of "+name+" does not exist")
+			}
+		}
+
+		for _, deadPath := range instance.DeadPaths {
+			if _, err := compileDeadPattern(deadPath); isDeadPattern(deadPath) && err != nil {
+				problems = append(problems, err.Error()+" of "+name)
+			}
+		}
+
+		for _, ip := range instance.MaintenanceAllowIPs {
+			if _, err := parseNetwork(ip); err != nil {
+				problems = append(problems, "Invalid maintenance IP '"+ip+"' of "+name)
+			}
+		}
+
+		for _, rule := range instance.BlockRules {
+			if _, err := compileBlockRule(rule); err != nil {
+				problems = append(problems, err.Error()+" of "+name)
+			}
+		}
+
+		switch strings.ToLower(instance.DeadResponseMode) {
+		case "", DeadRedirect, DeadDrop, DeadGone, DeadTarpit:
+		default:
+			problems = append(problems, "Unknown dead response mode '"+instance.DeadResponseMode+"' of "+name+", expected 'redirect', 'drop', 'gone', or 'tarpit'")
+		}
+
+		addrs := []string{}
+
+		if addr, ok := instance.HttpAddr(); ok {
+			addrs = append(addrs, addr)
+		}
+
+		if addr, ok := instance.HttpsAddr(); ok {
+			addrs = append(addrs, addr)
+		}
+
+		for _, addr := range addrs {
+			for other, otherName := range listening {
+				if addrsConflict(addr, other) {
+					problems = append(problems, name+" and "+otherName+" both listen on "+addr)
+				}
+			}
+
+			listening[addr] = name
+		}
+	}
+
+	return problems
+}
+
+// Gives whether listening on both of the given addresses would conflict, that
+// is if they share a port and either listens on all interfaces or both listen on
+// the same one.
+func addrsConflict(a, b string) bool {
+	hostA, portA, _ := net.SplitHostPort(a)
+	hostB, portB, _ := net.SplitHostPort(b)
+
+	if port, err := net.LookupPort("tcp", portA); err == nil {
+		portA = strconv.Itoa(port)
+	}
+
+	if port, err := net.LookupPort("tcp", portB); err == nil {
+		portB = strconv.Itoa(port)
+	}
+
+	return portA == portB && (hostA == "" || hostB == "" || hostA == hostB)
+}
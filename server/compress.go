@@ -0,0 +1,267 @@
+// Copyright (c) 2024 Evan Overman (https://an-prata.it).
+// Licensed under the MIT License.
+// See LICENSE file in repository root for complete license text.
+
+package server
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/an-prata/webby/logger"
+)
+
+// Compresses responses on the fly with the first of its encodings accepted by
+// the client. Responses that are already encoded, such as precompressed files,
+// are left untouched.
+type compressor struct {
+	// Encoding tokens in order of preference.
+	encodings []string
+
+	minSize int64
+
+	// Allowed MIME types, without parameters.
+	types map[string]bool
+}
+
+// Creates a compressor for the given encodings, which may only be "gzip".
+// Unknown encodings are logged and ignored, giving nil if none remain.
+func newCompressor(encodings []string, minSize int64, types []string) *compressor {
+	c := &compressor{[]string{}, minSize, map[string]bool{}}
+
+	for _, encoding := range encodings {
+		switch strings.ToLower(encoding) {
+		case "gzip":
+			c.encodings = append(c.encodings, "gzip")
+		default:
+			logger.GlobalLog.LogWarn("Unsupported compression encoding '" + encoding + "', serve precompressed files instead")
+		}
+	}
+
+	if len(c.encodings) == 0 {
+		return nil
+	}
+
+	for _, t := range types {
+		c.types[strings.ToLower(t)] = true
+	}
+
+	return c
+}
+
+// Wraps the given writer to compress the response to the given request. The
+// returned writer must be closed once the response is written.
+func (c *compressor) wrap(w http.ResponseWriter, req *http.Request) *compressWriter {
+	encoding := ""
+
+	if req.Method != http.MethodHead {
+		header := req.Header.Get("Accept-Encoding")
+
+		for _, e := range c.encodings {
+			if acceptsEncoding(header, e) {
+				encoding = e
+				break
+			}
+		}
+	}
+
+	return &compressWriter{w, c, encoding, 0, false, nil, nil}
+}
+
+// Returns true if responses of the given content type may be compressed.
+func (c *compressor) allows(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && c.types[strings.ToLower(mediaType)]
+}
+
+// Buffers the start of a response until it is known whether it should be
+// compressed, then writes through an encoder if so.
+type compressWriter struct {
+	http.ResponseWriter
+	compressor *compressor
+
+	// Negotiated encoding, empty if the client accepts none.
+	encoding string
+
+	status  int
+	decided bool
+	buf     []byte
+	encoder io.WriteCloser
+}
+
+func (w *compressWriter) WriteHeader(status int) {
+	// Informational responses, such as early hints, precede the real status.
+	if status < 200 {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+
+	if w.status != 0 {
+		return
+	}
+
+	w.status = status
+
+	if w.ResponseWriter.Header().Get("Content-Length") != "" || status != http.StatusOK {
+		w.decide()
+	}
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	if !w.decided {
+		w.buf = append(w.buf, b...)
+
+		if int64(len(w.buf)) < w.compressor.minSize {
+			return len(b), nil
+		}
+
+		w.decide()
+		return len(b), w.flushBuffer()
+	}
+
+	if w.encoder != nil {
+		return w.encoder.Write(b)
+	}
+
+	return w.ResponseWriter.Write(b)
+}
+
+// Decides whether to compress the response from its headers and buffered
+// content, and then writes its header.
+func (w *compressWriter) decide() {
+	w.decided = true
+	header := w.ResponseWriter.Header()
+
+	if header.Get("Content-Encoding") != "" {
+		w.ResponseWriter.WriteHeader(w.status)
+		return
+	}
+
+	// Parts of a response are never compressed, as ranges are of the identity
+	// encoding, but the whole response would have been so caches must still vary.
+	if w.status == http.StatusPartialContent && w.compressor.allows(header.Get("Content-Type")) {
+		addVary(header, "Accept-Encoding")
+	}
+
+	if w.status != http.StatusOK {
+		w.ResponseWriter.WriteHeader(w.status)
+		return
+	}
+
+	// The type would otherwise be sniffed from the compressed content.
+	if header.Get("Content-Type") == "" && len(w.buf) > 0 {
+		header.Set("Content-Type", http.DetectContentType(w.buf))
+	}
+
+	if !w.compressor.allows(header.Get("Content-Type")) {
+		w.ResponseWriter.WriteHeader(w.status)
+		return
+	}
+
+	addVary(header, "Accept-Encoding")
+	size := int64(len(w.buf))
+
+	if length, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64); err == nil {
+		size = length
+	}
+
+	if w.encoding == "" || size < w.compressor.minSize {
+		w.ResponseWriter.WriteHeader(w.status)
+		return
+	}
+
+	header.Del("Content-Length")
+	header.Del("Accept-Ranges")
+	header.Set("Content-Encoding", w.encoding)
+
+	if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		header.Set("ETag", "W/"+etag)
+	}
+
+	w.ResponseWriter.WriteHeader(w.status)
+	w.encoder = gzip.NewWriter(w.ResponseWriter)
+}
+
+// Writes out any buffered content.
+func (w *compressWriter) flushBuffer() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+
+	buf := w.buf
+	w.buf = nil
+
+	if w.encoder != nil {
+		_, err := w.encoder.Write(buf)
+		return err
+	}
+
+	_, err := w.ResponseWriter.Write(buf)
+	return err
+}
+
+// Flushes buffered and encoded content to the client, deciding on compression
+// early if needed.
+func (w *compressWriter) Flush() {
+	if w.status == 0 {
+		return
+	}
+
+	if !w.decided {
+		w.decide()
+		w.flushBuffer()
+	}
+
+	if flusher, ok := w.encoder.(*gzip.Writer); ok {
+		flusher.Flush()
+	}
+
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Finishes the response, writing out anything buffered.
+func (w *compressWriter) Close() error {
+	if w.status == 0 {
+		return nil
+	}
+
+	if !w.decided {
+		w.decide()
+	}
+
+	err := w.flushBuffer()
+
+	if w.encoder != nil {
+		if closeErr := w.encoder.Close(); err == nil {
+			err = closeErr
+		}
+	}
+
+	return err
+}
+
+// Allows `http.ResponseController` to reach the underlying writer.
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Adds the given header name to the Vary header unless it is already listed.
+func addVary(header http.Header, name string) {
+	for _, value := range header.Values("Vary") {
+		for _, listed := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(listed), name) {
+				return
+			}
+		}
+	}
+
+	header.Add("Vary", name)
+}
@@ -5,11 +5,16 @@
 package server
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
+	"net"
 	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
 	"strconv"
-	"time"
+	"strings"
 
 	"github.com/an-prata/webby/logger"
 )
@@ -21,26 +26,144 @@ const (
 	InitialReadError
 	SizeChange
 	TimeModifiedChange
+
+	// A file was added to a watched directory, see `WatchPaths()`.
+	CreateChange
+
+	// A file was removed from a watched directory, see `WatchPaths()`.
+	RemoveChange
 )
 
+// Where and when to notify of events in the daemon's lifecycle, see
+// `ServerOptions.Notifications`.
+type NotificationOptions struct {
+	// URL to POST each event to as JSON. Use an empty string for no webhook.
+	Webhook string
+
+	// Command to run for each event, given the event as JSON on standard in and
+	// through the "WEBBY_EVENT", "WEBBY_INSTANCE", and "WEBBY_MESSAGE"
+	// environment variables. Use an empty string for no command.
+	Command string
+
+	// Events to notify of, from "start", "stop", "reload", "cert-reload",
+	// "cert-expiry", "health", "server-errors", and "job". Empty for all of them.
+	Events []string
+
+	// Number of 5xx responses from a server instance within a minute that raise a
+	// "server-errors" event. Use zero to never raise it.
+	ServerErrorThreshold int64
+}
+
+// Destinations log messages are sent to besides the log file, see
+// `ServerOptions.LogTarget`.
+type LogTargetOptions struct {
+	// Syslog to send messages to, either "local" for the local syslog daemon or a
+	// "udp://" or "tcp://" URL of a remote one, e.g. "udp://logs.example.com:514".
+	// Use an empty string to not send messages to syslog.
+	Syslog string
+
+	// Whether to send messages to journald, with their fields as journal fields.
+	Journald bool
+
+	// Identifier messages are sent to syslog and journald with.
+	Tag string
+}
+
 type ServerOptions struct {
-	// Path to the root of the website to host. Use an empty string for default.
-	// See `server.DefaultSitePath`
+	// Name used to address this server instance in daemon commands.
+	Name string
+
+	// Path to the root of the website to host, or to a ".zip", ".tar", ".tar.gz",
+	// or ".tgz" archive of it. Use an empty string for default. See
+	// `server.DefaultSitePath`
 	Site string
 
+	// Further directories to serve by the URL prefix they are served under, e.g.
+	// {"/docs": "/srv/docs-build"}. Mounted directories take priority over `Site`,
+	// and the longest matching prefix over other mounts. Not supported for sites
+	// served from an archive or bucket.
+	Mounts map[string]string
+
+	// Resolves request paths against `Site` and `Mounts` as requests arrive,
+	// rather than mapping every file when the server starts. New files are served
+	// without a restart, and large sites start quickly, but routes and status
+	// checks only list the roots. Not supported for sites served from an archive
+	// or bucket.
+	DynamicPaths bool
+
+	// Serves hidden files, those starting with "." such as ".git" or ".env".
+	// Requests for them are otherwise refused with 404 Not Found, except for those
+	// under "/.well-known/".
+	ServeHiddenFiles bool
+
 	// Path to a TLS/SSL certificate. Use an empty string for no HTTPS.
 	Cert string
 
 	// Path to a TLS/SSL private key. Use an empty string for no HTTPS.
 	Key string
 
+	// Additional certificates to serve over HTTPS, each given as an object with
+	// "Cert" and "Key" paths, chosen by the server name a client asks for (SNI).
+	// `Cert` and `Key`, or otherwise the first of these, are served to clients
+	// whose server name matches none of them.
+	Certificates []CertificatePair
+
+	// The minimum version of TLS accepted from clients, one of "1.0", "1.1",
+	// "1.2", or "1.3".
+	MinTLSVersion string
+
+	// Cipher suites allowed for TLS 1.2 and earlier, by their standard names
+	// (e.g. "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"). Empty for Go's defaults.
+	// TLS 1.3 cipher suites are not configurable.
+	CipherSuites []string
+
+	// Elliptic curves to use for key exchange, in order of preference, from
+	// "X25519", "P256", "P384", and "P521". Empty for Go's defaults.
+	CurvePreferences []string
+
+	// Path to a PEM bundle of certificate authorities that client certificates
+	// are verified against. When given, every client must present a valid
+	// certificate over HTTPS unless `ClientAuthPaths` limits this to some paths.
+	ClientCA string
+
+	// URL prefixes that may only be requested with a verified client certificate,
+	// see `ClientCA`. Empty to require one for every HTTPS request.
+	ClientAuthPaths []string
+
 	// The port to host on, negative numbers and zero will utilize a default (80
-	// for HTTP and 443 for HTTPS).
+	// for HTTP and 443 for HTTPS). If given along with TLS then only HTTPS is
+	// served on this port. Overridden by `HttpPort` and `HttpsPort`.
 	Port int32
 
+	// The port to serve plain HTTP on, taking priority over `Port`. Zero defers to
+	// `Port` and negative numbers disable the HTTP listener entirely.
+	HttpPort int32
+
+	// The port to serve HTTPS on when TLS is supported, taking priority over
+	// `Port`. Zero defers to `Port` and negative numbers disable the HTTPS
+	// listener entirely.
+	HttpsPort int32
+
+	// The address of the interface to bind both listeners to, e.g. "127.0.0.1" or
+	// "::1". Use an empty string to bind all interfaces.
+	BindAddress string
+
+	// Expects every connection to begin with a PROXY protocol version 1 or 2
+	// header, as sent by HAProxy or stunnel in TCP mode, and uses the client
+	// address it gives. Only enable this when the listeners are reachable by the
+	// proxy alone, since any client could otherwise claim any address.
+	AcceptProxyProtocol bool
+
 	// Path to a file for logging. Use an empty string for no log file.
 	Log string
 
+	// Path to a file that a panic which crashes the daemon is written to, along
+	// with the stack of every thread, for looking into once it has exited. Panics
+	// while serving a request are only logged. Use an empty string to leave them
+	// to standard error, such as when run by systemd. Only the top level value is
+	// used.
+	CrashLog string
+
 	// Log level for printing to standard out. Can be "All", "None", "Error",
 	// "Warning", or "Info".
 	LogLevelPrint string
@@ -49,54 +172,656 @@ type ServerOptions struct {
 	// or "Info".
 	LogLevelRecord string
 
+	// Format of log messages, either "text" or "json" for a JSON object per line
+	// with the message's time, level, and any fields.
+	LogFormat string
+
+	// Syslog and journald to send log messages to, at the level given by
+	// `LogLevelRecord`, see `LogTargetOptions`.
+	LogTarget LogTargetOptions
+
 	// Whether or not to check for changes in the config or site files and reload
 	// automatically.
 	AutoReload bool
 
 	// Paths that should be granted a dead response, can be used for fucking with
 	// bot probing or the like. A dead response is just the name I gave to
-	// redirecting a request back onto the client for the same path.
+	// redirecting a request back onto the client for the same path. Paths
+	// starting with "~" are regular expressions, and those with a "*" wildcards
+	// matching any run of characters, e.g. "/wp-admin/*" or "*.php".
 	DeadPaths []string
 
+	// How dead responses are given: "redirect" back onto the client, "drop" to
+	// close the connection without responding, "gone" for 410 Gone, or "tarpit"
+	// to respond a byte at a time, see `TarpitInterval`.
+	DeadResponseMode string
+
+	// Rules blocking requests by their headers, e.g. scrapers by User-Agent, each
+	// an object with the "Header" to match, text it must contain, or a regular
+	// expression starting with "~", as its "Match" (empty to match a missing
+	// header), and an "Action" of "forbid", "drop", or "tarpit". The first rule
+	// matched is acted on.
+	BlockRules []BlockRule
+
+	// Milliseconds between each byte of a tarpit response, and seconds a tarpit
+	// response lasts in total, see `DeadResponseMode` and `BlockRules`.
+	TarpitInterval int64
+	TarpitDuration int64
+
+	// Tarpits requests from banned clients and those over quota rather than
+	// refusing them with 403 Forbidden or 429 Too Many Requests.
+	TarpitAbusive bool
+
 	// Redirect automatically from HTTP to HTTPS.
 	RedirectHttp bool
 
-	// Response write timeout in seconds.
+	// Response write timeout in seconds, zero for no timeout. Long downloads over
+	// slow connections must finish within this time.
 	WriteTimeout int64
 
-	// Request read timeout in seconds.
+	// Request read timeout in seconds, including the body, zero for no timeout.
 	ReadTimeout int64
+
+	// Request header read timeout in seconds, zero to use `ReadTimeout`.
+	ReadHeaderTimeout int64
+
+	// Seconds to keep idle connections open waiting for another request, zero to
+	// use `ReadTimeout`.
+	IdleTimeout int64
+
+	// Largest request body accepted in bytes, zero for no limit. This includes
+	// bodies sent on to `Proxies`.
+	MaxRequestBodyBytes int64
+
+	// Largest request header accepted in bytes, including the request line, zero
+	// for Go's default of one megabyte.
+	MaxHeaderBytes int64
+
+	// Connections allowed open at once in total, and from any one client, zero
+	// for no limit. Connections over either limit are closed when accepted.
+	MaxConcurrentConnections int64
+	MaxConnectionsPerIP      int64
+
+	// Bandwidth limits on responses in bytes per second, zero for no limit. The
+	// total limit is shared by all responses, and each connection has its own.
+	ThrottleBytesPerSecond           int64
+	ThrottleConnectionBytesPerSecond int64
+
+	// Bandwidth limits in bytes per second shared by all responses under each URL
+	// path prefix, e.g. {"/files/": 500000} to keep large downloads from starving
+	// other pages. The longest prefix matched applies.
+	ThrottlePaths map[string]int64
+
+	// Answers requests with 503 Service Unavailable, using the error page for 503
+	// from `ErrorPages` if there is one, e.g. during backend maintenance. May be
+	// turned on and off without restarting with `webby -maintenance on|off`.
+	Maintenance bool
+
+	// Seconds clients are told to retry after during maintenance, zero to not tell
+	// them.
+	MaintenanceRetryAfter int64
+
+	// URL path prefixes, such as those of the 503 page's styles and images, and
+	// client IPs or CIDR ranges, e.g. "10.0.0.0/8", still served as usual during
+	// maintenance.
+	MaintenanceAllowPaths []string
+	MaintenanceAllowIPs   []string
+
+	// External URLs (e.g. the site via its public domain or a CDN) that should
+	// also be requested when checking webby's status.
+	StatusUrls []string
+
+	// Also check that each path serves the same content as its file, and that
+	// certificates served over HTTPS are not expired or expiring within 14 days,
+	// when checking webby's status. Every file is read for each check.
+	DeepStatusChecks bool
+
+	// Interval in seconds between automatic status checks run by the daemon. Use
+	// zero or a negative number to disable automatic checks.
+	HealthCheckInterval int64
+
+	// Number of consecutive failed automatic status checks before an alert is
+	// raised.
+	HealthCheckThreshold int64
+
+	// Restart a server instance once its automatic status checks have failed
+	// `HealthCheckThreshold` times in a row, waiting twice as long after each
+	// restart before restarting it again while its checks keep failing.
+	HealthCheckRestart bool
+
+	// Number of automatic status check results kept for each server instance,
+	// from which the status command gives their success rate and latency. Use
+	// zero or a negative number to keep none.
+	HealthHistorySize int64
+
+	// URL of an OpenID Connect provider used to authenticate requests for paths
+	// under `OidcPrefixes`. Use an empty string to disable OpenID Connect login.
+	OidcIssuer string
+
+	// Client ID registered with the OpenID Connect provider.
+	OidcClientId string
+
+	// Client secret registered with the OpenID Connect provider.
+	OidcClientSecret string
+
+	// Full URL the provider redirects back to after login, its path is handled by
+	// webby and should not be used by the site (e.g.
+	// "https://example.com/.webby/oidc/callback").
+	OidcRedirectUrl string
+
+	// URL path prefixes that require an OpenID Connect login.
+	OidcPrefixes []string
+
+	// ID token claim checked against `OidcAllowedValues`, e.g. "groups". Use an
+	// empty string to allow any authenticated user.
+	OidcClaim string
+
+	// Values of `OidcClaim` that are granted access, the claim may be a single
+	// string or a list of strings. An empty list allows any authenticated user.
+	OidcAllowedValues []string
+
+	// Email addresses, or whole domains given as "@example.com", granted access
+	// by the ID token's "email" claim, which the provider must not mark as
+	// unverified. An empty list allows any user allowed by `OidcClaim`.
+	OidcAllowedEmails []string
+
+	// Length of a login session in seconds.
+	OidcSessionLength int64
+
+	// URL prefix to serve `WebDavRoot` under over WebDAV, e.g. "/dav/", so that
+	// site content may be updated remotely. Use an empty string to disable WebDAV.
+	WebDavPrefix string
+
+	// Directory served over WebDAV, an empty string for `Site`.
+	WebDavRoot string
+
+	// Users allowed to use WebDAV by name, each with the hex encoded SHA-256 hash
+	// of their password, e.g. from `printf %s password | sha256sum`. WebDAV is
+	// only served over HTTPS or to the local machine.
+	WebDavUsers map[string]string
+
+	// URL path accepting file uploads as POST requests with a
+	// "multipart/form-data" body, e.g. "/upload", saving them into `UploadDir`.
+	// Use an empty string to disable uploads.
+	UploadPath string
+	UploadDir  string
+
+	// Largest upload request accepted in bytes, zero for no limit.
+	UploadMaxBytes int64
+
+	// File extensions accepted for upload, e.g. [".pdf", ".png"]. An empty list
+	// accepts any file.
+	UploadExtensions []string
+
+	// Users allowed to upload, given as with `WebDavUsers`. Uploads are refused
+	// without any unless `UploadAnonymous` is set or the path is otherwise
+	// protected, e.g. by `OidcPrefixes`.
+	UploadUsers map[string]string
+
+	// Lets anyone upload to `UploadPath` when `UploadUsers` is empty.
+	UploadAnonymous bool
+
+	// URL path accepting push webhooks from GitHub, GitLab, or Gitea, e.g.
+	// "/_webby/deploy", which runs `DeployHookCommand` in `Site` and then maps the
+	// site again. Use an empty string to disable the hook.
+	DeployHookPath string
+
+	// Secret webhooks must be signed with, given as the webhook's secret or, for
+	// GitLab, its secret token. The hook is disabled without one.
+	DeployHookSecret string
+
+	// Command and arguments run in `Site` by the deploy hook, e.g. ["make",
+	// "deploy"]. An empty list runs ["git", "pull", "--ff-only"].
+	DeployHookCommand []string
+
+	// URL path prefixes that may only be requested with a valid, unexpired
+	// signature as generated by `webby -sign-url`.
+	SignedPrefixes []string
+
+	// Secret key used to sign and verify URLs under `SignedPrefixes`. Signed
+	// prefixes deny all requests if this is empty.
+	SignedUrlKey string
+
+	// Rules for serving files as downloads with a `Content-Disposition: attachment`
+	// header. Keys starting with '.' match file extensions, keys ending in '/' match
+	// path prefixes, and any other key matches an exact path. Values override the
+	// downloaded file's name, use an empty string to keep the file's own name.
+	Attachments map[string]string
+
+	// URL path prefixes under which byte range requests are ignored, sending
+	// whole files with "Accept-Ranges: none", e.g. ["/downloads/"] to keep
+	// download managers from splitting files across many connections.
+	NoRangePaths []string
+
+	// Serve precompressed siblings of files (e.g. "style.css.zst" for "style.css")
+	// to clients that accept their encoding. Supports zstd (".zst"), brotli
+	// (".br"), and gzip (".gz"), preferred in that order.
+	Precompressed bool
+
+	// Types of text assets to minify when served, may include "html", "css", and
+	// "js". Minified files are cached until they change.
+	Minify []string
+
+	// Extension of files rendered through Go's `html/template` when served, e.g.
+	// ".gohtml", giving them the request's method, host, path, query, headers,
+	// and client IP, along with `TemplateSiteData`. Use an empty string to serve
+	// such files unchanged.
+	TemplateExtension string
+
+	// Values given to templates as `.Site`, e.g. {"title": "My Site"}.
+	TemplateSiteData map[string]string
+
+	// Directory of Markdown and other files built into `Site` by `webby -build`,
+	// Markdown becoming pages rendered through the layouts in its "_layouts"
+	// directory. Use an empty string to disable building.
+	BuildSource string
+
+	// Build `Site` from `BuildSource` whenever the config is loaded or reloaded,
+	// keeping the previous build if it fails. With `AutoReload` changes to
+	// `BuildSource` rather than `Site` cause a reload.
+	BuildOnReload bool
+
+	// URL the built site is served at, e.g. "https://example.com", for the
+	// "sitemap.xml" written by builds. Defaults to `CanonicalHost` over HTTPS, no
+	// sitemap is written if neither are set.
+	BuildBaseUrl string
+
+	// Serve AVIF or WebP siblings of JPEG, PNG, and GIF images (e.g. "photo.avif"
+	// for "photo.jpg") to clients that accept them, keeping the original URL.
+	ModernImages bool
+
+	// URL path prefixes of JPEG and PNG images that are resized when requested
+	// with a width, e.g. "/photos/cat.jpg?w=800". Empty to disable.
+	ImageResizePrefixes []string
+
+	// Widths images may be resized to, requested widths being rounded up to the
+	// nearest of them so that clients cannot fill the cache with every width.
+	ImageResizeWidths []int64
+
+	// Directory resized images are cached in.
+	ImageCacheDir string
+
+	// Assets to preload for pages, mapping a page's URL path to a list of asset
+	// paths (e.g. {"/": ["/style.css", "/app.js"]}). Each is sent in a Link
+	// preload header, full Link header values may also be given.
+	Preloads map[string][]string
+
+	// Also preload the stylesheets and scripts linked from the head of each HTML
+	// page, found by scanning the pages whenever the server restarts.
+	AutoPreloads bool
+
+	// Send a 103 Early Hints response with the Link headers of `Preloads` before
+	// serving a page, only done for HTTP/2 and newer.
+	EarlyHints bool
+
+	// Name of an S3 compatible bucket to serve the site from instead of `Site`. Use
+	// an empty string to serve from `Site`. Objects are listed when the server
+	// starts or restarts and downloaded into `S3CacheDir` when first requested.
+	S3Bucket string
+
+	// URL of the S3 compatible service hosting `S3Bucket`, the bucket is addressed
+	// by path (e.g. "https://s3.us-east-1.amazonaws.com").
+	S3Endpoint string
+
+	// Region of `S3Bucket`, used for signing requests.
+	S3Region string
+
+	// Key prefix within `S3Bucket` under which the site is stored, e.g. "site/".
+	S3Prefix string
+
+	// Access key ID for `S3Bucket`. Use an empty string for a public bucket.
+	S3AccessKey string
+
+	// Secret access key for `S3Bucket`.
+	S3SecretKey string
+
+	// Directory for caching objects downloaded from `S3Bucket`.
+	S3CacheDir string
+
+	// Maximum requests a single client IP may make per hour, zero for no limit.
+	// Clients over any quota are responded to with 429 Too Many Requests.
+	QuotaRequestsHourly int64
+
+	// Maximum requests a single client IP may make per day, zero for no limit.
+	QuotaRequestsDaily int64
+
+	// Maximum response bytes served to a single client IP per hour, zero for no
+	// limit.
+	QuotaBytesHourly int64
+
+	// Maximum response bytes served to a single client IP per day, zero for no
+	// limit.
+	QuotaBytesDaily int64
+
+	// Number of requests for dead paths or missing files after which a client
+	// IP is temporarily banned, zero to never ban.
+	BanThreshold int64
+
+	// Seconds over which strikes toward `BanThreshold` decay completely, so that
+	// clients making occasional mistakes are never banned.
+	BanWindow int64
+
+	// Seconds for which a client IP stays banned, banned clients are responded
+	// to with 403 Forbidden.
+	BanDuration int64
+
+	// Contacts for reporting security issues, given as URIs such as
+	// "mailto:security@example.com". If any are given then
+	// "/.well-known/security.txt" is generated from these and the options below.
+	SecurityContacts []string
+
+	// Expiry of the generated security.txt as an RFC 3339 date, one year from
+	// when the server starts if empty.
+	SecurityExpires string
+
+	// URL of a security policy to link in the generated security.txt, if any.
+	SecurityPolicy string
+
+	// Generate "/sitemap.xml" listing the site's HTML pages, rescanned whenever
+	// the server restarts. Pages under `SitemapExclude`, error pages, and paths
+	// needing a login, certificate, or signature are left out. URLs are given for
+	// `CanonicalHost`, or the requested host if it is empty.
+	Sitemap bool
+
+	// URL path prefixes of pages left out of the generated sitemap, e.g.
+	// ["/drafts/"].
+	SitemapExclude []string
+
+	// Generate "/robots.txt" allowing all crawlers everywhere but
+	// `RobotsDisallow`, linking the generated sitemap if `Sitemap` is set.
+	RobotsTxt bool
+
+	// URL path prefixes crawlers are asked not to visit by the generated
+	// robots.txt, e.g. ["/private/"].
+	RobotsDisallow []string
+
+	// Files to serve under "/.well-known/" by the endpoint name they are served
+	// as, e.g. "openpgpkey/policy". Files are read from disk even when the site
+	// is served from an archive or bucket, and take priority over the site.
+	WellKnown map[string]string
+
+	// Upstream URLs to proxy requests to by the URL prefix they are proxied for,
+	// e.g. {"/api/": "http://localhost:8080"}. The request path is appended to
+	// the upstream URL unchanged, the longest matching prefix is used, and proxied
+	// prefixes take priority over files of the site.
+	Proxies map[string]string
+
+	// Encodings to compress responses with when the client accepts them, in order
+	// of preference. Only "gzip" is supported, an empty list disables compression.
+	Compression []string
+
+	// Responses smaller than this many bytes are not compressed.
+	CompressionMinSize int64
+
+	// MIME types of responses that may be compressed, parameters such as charset
+	// are ignored when matching.
+	CompressionTypes []string
+
+	// Path of a log recording every request served, separate from `Log`. Empty
+	// for no access log.
+	AccessLog string
+
+	// Format of the access log: "common" or "combined" for the Common and
+	// Combined Log Formats read by tools like goaccess, or "json" for one JSON
+	// object per line.
+	AccessLogFormat string
+
+	// Responds to requests for directories without an "index.html" with a
+	// generated listing of their contents rather than 404 Not Found.
+	AutoIndex bool
+
+	// Pages of the site served in place of default error responses by the status
+	// code they are served for, e.g. {"404": "/errors/404.html"}.
+	ErrorPages map[string]string
+
+	// Serves the site's root "index.html" for GET requests to paths with nothing
+	// mapped to them, so that single page apps with client side routing may be
+	// deep linked to.
+	SpaFallback bool
+
+	// Seconds to wait for in flight requests to finish when stopping, restarting,
+	// or reloading before their connections are closed. Zero closes connections
+	// immediately.
+	ShutdownTimeout int64
+
+	// Whether or not files are served with an ETag header, computed from their
+	// modification time and size, or from a hash of their content when minified,
+	// so that browsers may revalidate them rather than downloading them again.
+	Etags bool
+
+	// Maps rules to the Cache-Control header to serve matching files with. Rules
+	// starting with '.' match file extensions, rules ending in '/' match path
+	// prefixes, and any other rule matches an exact path, e.g.
+	// `{"/assets/": "public, max-age=31536000, immutable", ".html": "no-cache"}`.
+	CacheControl map[string]string
+
+	// Headers to set on responses by the URL prefix they are set for, e.g.
+	// `{"/api/": {"Access-Control-Allow-Origin": "*"}}`. Headers of every matching
+	// prefix are set, with longer prefixes overriding shorter ones.
+	Headers map[string]map[string]string
+
+	// HTTP methods allowed by the URL prefix they are allowed for, e.g.
+	// `{"/api/": ["GET", "POST"]}`, the longest matching prefix being used. Other
+	// methods are refused with 405 Method Not Allowed. Without a matching prefix
+	// files allow GET and HEAD, and proxies any method. OPTIONS is always answered
+	// and TRACE always refused.
+	AllowedMethods map[string][]string
+
+	// Host that requests for any other host name are permanently redirected to,
+	// e.g. "example.com" to redirect "www.example.com". Requests for "localhost"
+	// or an IP address are not redirected. Empty to disable.
+	CanonicalHost string
+
+	// Language codes of a site translated into directories of the same names,
+	// e.g. ["en", "de"] for "/en/" and "/de/". Requests for paths outside of them
+	// are redirected to the client's language, e.g. "/about/" to "/de/about/",
+	// chosen by a "lang" query parameter, `LanguageCookie`, or Accept-Language.
+	// Empty to disable.
+	Languages []string
+
+	// Language chosen for clients that accept none of `Languages`, the first of
+	// them if empty.
+	DefaultLanguage string
+
+	// Name of the cookie remembering the language chosen with a "lang" query
+	// parameter, e.g. by a link to "/about/?lang=de".
+	LanguageCookie string
+
+	// Either "add" or "remove" to permanently redirect requests to add or remove
+	// a trailing slash when the resulting path is mapped, e.g. "/docs" to "/docs/".
+	// Empty to disable.
+	CanonicalTrailingSlash string
+
+	// Whether or not HTTP/2 is negotiated with clients of the HTTPS listener, plain
+	// HTTP is always served as HTTP/1.1.
+	EnableHttp2 bool
+
+	// Name of a group whose members may send commands to the daemon, such as stop
+	// or reload, along with root and the daemon's own user. Empty to allow only
+	// those. Only the top level value is used.
+	ControlGroup string
+
+	// Address to serve the daemon's commands over HTTP at as JSON, e.g.
+	// "127.0.0.1:9091", for tooling that cannot reach the control socket. Use an
+	// empty string to not serve them. Only the top level value is used.
+	AdminAddress string
+
+	// Token every request to `AdminAddress` must give as "Authorization: Bearer
+	// <token>". The admin API is not served without one.
+	AdminToken string
+
+	// Serve `net/http/pprof` profiles under "/debug/pprof/" and `expvar` runtime
+	// stats at "/debug/vars" of `AdminAddress`, never of a server instance. Only
+	// the top level value is used.
+	AdminProfiling bool
+
+	// Path to a file that the hit statistics of each path, see `webby -stats`, are
+	// written to as JSON every `StatsInterval`. Use an empty string to not write
+	// them.
+	StatsFile string
+
+	// Interval in seconds between writes of `StatsFile`.
+	StatsInterval int64
+
+	// Webhook and command to notify of daemon events, see `NotificationOptions`.
+	Notifications NotificationOptions
+
+	// Jobs the daemon runs on a schedule, each an object with a "Name", a cron
+	// "Schedule" such as "0 3 * * *" or "@every 1h", a "Task" of "reload-certs",
+	// "check-certs", "purge-image-cache", "stats-dump", "restart", "build", or
+	// "command", the shell "Command" of a "command" job, and the "Instance" it
+	// applies to, empty for all. Listed by `webby -jobs`. Only the top level value
+	// is used.
+	Cron []CronJob
+
+	// Number of milliseconds a request may take to handle before its log line is
+	// a warning rather than info. Use zero to never warn of slow requests.
+	SlowRequestThreshold int64
+
+	// Additional server blocks, each hosting its own site with its own port and
+	// TLS settings, all managed by one daemon. Options absent from an instance are
+	// taken from the top level configuration. When any instances are given the top
+	// level configuration is not itself served.
+	Instances []ServerOptions
 }
 
-// Tries to parse JSON for a `ServerOptions` with the file at the given path.
-// Returns an error and a default configuration on parse failure, individual
-// options are replaced by defaults for incorrect types and absences.
+// Tries to parse JSON for a `ServerOptions` with the file at the given path,
+// along with any fragments merged over it, see `ConfigFragments()`. Returns an
+// error and a default configuration on parse failure, individual options are
+// replaced by defaults for incorrect types and absences.
 func LoadConfigFromPath(path string) (ServerOptions, error) {
-	if _, err := os.Stat(path); err != nil {
-		return DefaultOptions(), errors.New("Could not stat config at '" + path + "'")
+	optsMap, err := readConfigMap(path)
+
+	if err != nil {
+		return DefaultOptions(), err
+	}
+
+	opts := parseOptions(optsMap, DefaultOptions())
+
+	if v, ok := optsMap["Instances"]; ok {
+		if value, ok := v.([]interface{}); ok {
+			for i, instance := range value {
+				instanceMap, ok := instance.(map[string]interface{})
+
+				if !ok {
+					logger.GlobalLog.LogWarn("Expected all elements of 'Instances' to be objects")
+					continue
+				}
+
+				base := opts
+				base.Name = "instance-" + strconv.Itoa(i)
+				base.Instances = []ServerOptions{}
+				opts.Instances = append(opts.Instances, parseOptions(instanceMap, base))
+			}
+		} else {
+			logger.GlobalLog.LogWarn("Expected 'Instances' field in config to be a list of objects.")
+		}
 	}
 
+	return opts, nil
+}
+
+// Gives the directory of config fragments for the config at the given path, a
+// "conf.d" directory beside it.
+func ConfigFragmentDir(path string) string {
+	return filepath.Join(filepath.Dir(path), "conf.d")
+}
+
+// Gives the paths of the config fragments for the config at the given path, the
+// ".json" files of its fragment directory in lexical order. Each is merged over
+// the config and those before it, see `mergeConfig()`.
+func ConfigFragments(path string) []string {
+	fragments, _ := filepath.Glob(filepath.Join(ConfigFragmentDir(path), "*.json"))
+	return fragments
+}
+
+// Reads the config JSON at the given path with its fragments merged over it.
+func readConfigMap(path string) (map[string]interface{}, error) {
 	var optsMap map[string]interface{}
-	opts := DefaultOptions()
 
-	bytes, err := os.ReadFile(path)
+	for i, file := range append([]string{path}, ConfigFragments(path)...) {
+		if _, err := os.Stat(file); err != nil {
+			return nil, errors.New("Could not stat config at '" + file + "'")
+		}
+
+		bytes, err := os.ReadFile(file)
 
-	if err != nil {
-		return DefaultOptions(), errors.New("Could not read config at '" + path + "'")
+		if err != nil {
+			return nil, errors.New("Could not read config at '" + file + "'")
+		}
+
+		var fragment map[string]interface{}
+
+		if err = unmarshalConfig(bytes, &fragment); err != nil {
+			return nil, errors.New("Could not parse config JSON at '" + file + "': " + err.Error())
+		}
+
+		if i == 0 {
+			optsMap = fragment
+		} else {
+			mergeConfig(optsMap, fragment)
+		}
 	}
 
-	if json.Unmarshal(bytes, &optsMap) != nil {
-		return DefaultOptions(), errors.New("Could not parse config JSON at '" + path + "'")
+	return optsMap, nil
+}
+
+// Merges the given fragment of parsed config JSON over the given config. Lists,
+// such as `DeadPaths` or `Instances`, are appended to, objects are merged member
+// by member, and other values are replaced.
+func mergeConfig(optsMap, fragment map[string]interface{}) {
+	for key, value := range fragment {
+		switch value := value.(type) {
+		case []interface{}:
+			if list, ok := optsMap[key].([]interface{}); ok {
+				optsMap[key] = append(list, value...)
+				continue
+			}
+		case map[string]interface{}:
+			if object, ok := optsMap[key].(map[string]interface{}); ok {
+				mergeConfig(object, value)
+				continue
+			}
+		}
+
+		optsMap[key] = value
 	}
+}
 
+// Reads each option present in the given map of parsed JSON over the given
+// options, returning the result. Options of an incorrect type are left as they
+// were given. Instances are not read.
+func parseOptions(optsMap map[string]interface{}, opts ServerOptions) ServerOptions {
 	for k, v := range optsMap {
 		switch k {
+		case "Name":
+			if value, ok := v.(string); ok {
+				opts.Name = value
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'Name' field in config to be a string.")
+			}
 		case "Site":
 			if value, ok := v.(string); ok {
 				opts.Site = value
 			} else {
 				logger.GlobalLog.LogWarn("Expected 'Site' field in config to be a string.")
 			}
+		case "Mounts":
+			if value, ok := parseStringMap("Mounts", v); ok {
+				opts.Mounts = value
+			}
+		case "ServeHiddenFiles":
+			if value, ok := v.(bool); ok {
+				opts.ServeHiddenFiles = value
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'ServeHiddenFiles' field in config to be a bool.")
+			}
+		case "DynamicPaths":
+			if value, ok := v.(bool); ok {
+				opts.DynamicPaths = value
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'DynamicPaths' field in config to be a bool.")
+			}
 		case "Cert":
 			if value, ok := v.(string); ok {
 				opts.Cert = value
@@ -109,18 +834,89 @@ func LoadConfigFromPath(path string) (ServerOptions, error) {
 			} else {
 				logger.GlobalLog.LogWarn("Expected 'Key' field in config to be a string.")
 			}
+		case "MinTLSVersion":
+			if value, ok := v.(string); ok {
+				opts.MinTLSVersion = value
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'MinTLSVersion' field in config to be a string.")
+			}
+		case "CipherSuites":
+			if value, ok := parseStringList("CipherSuites", v); ok {
+				opts.CipherSuites = value
+			}
+		case "CurvePreferences":
+			if value, ok := parseStringList("CurvePreferences", v); ok {
+				opts.CurvePreferences = value
+			}
+		case "ClientCA":
+			if value, ok := v.(string); ok {
+				opts.ClientCA = value
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'ClientCA' field in config to be a string.")
+			}
+		case "ClientAuthPaths":
+			if value, ok := parseStringList("ClientAuthPaths", v); ok {
+				opts.ClientAuthPaths = value
+			}
+		case "Certificates":
+			if value, ok := v.([]interface{}); ok {
+				opts.Certificates = []CertificatePair{}
+
+				for _, element := range value {
+					pair, ok := parseStringMap("Certificates", element)
+
+					if !ok || pair["Cert"] == "" || pair["Key"] == "" {
+						logger.GlobalLog.LogWarn("Expected all members of 'Certificates' to have a 'Cert' and 'Key'")
+						continue
+					}
+
+					opts.Certificates = append(opts.Certificates, CertificatePair{pair["Cert"], pair["Key"]})
+				}
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'Certificates' field in config to be a list.")
+			}
 		case "Port":
 			if value, ok := v.(float64); ok {
 				opts.Port = int32(value)
 			} else {
 				logger.GlobalLog.LogWarn("Expected 'Port' field in config to be a number.")
 			}
+		case "HttpPort":
+			if value, ok := v.(float64); ok {
+				opts.HttpPort = int32(value)
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'HttpPort' field in config to be a number.")
+			}
+		case "HttpsPort":
+			if value, ok := v.(float64); ok {
+				opts.HttpsPort = int32(value)
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'HttpsPort' field in config to be a number.")
+			}
+		case "BindAddress":
+			if value, ok := v.(string); ok {
+				opts.BindAddress = value
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'BindAddress' field in config to be a string.")
+			}
+		case "AcceptProxyProtocol":
+			if value, ok := v.(bool); ok {
+				opts.AcceptProxyProtocol = value
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'AcceptProxyProtocol' field in config to be a bool.")
+			}
 		case "Log":
 			if value, ok := v.(string); ok {
 				opts.Log = value
 			} else {
 				logger.GlobalLog.LogWarn("Expected 'Log' field in config to be a string.")
 			}
+		case "CrashLog":
+			if value, ok := v.(string); ok {
+				opts.CrashLog = value
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'CrashLog' field in config to be a string.")
+			}
 		case "LogLevelPrint":
 			if value, ok := v.(string); ok {
 				opts.LogLevelPrint = value
@@ -133,6 +929,12 @@ func LoadConfigFromPath(path string) (ServerOptions, error) {
 			} else {
 				logger.GlobalLog.LogWarn("Expected 'LogLevelRecord' field in config to be a string.")
 			}
+		case "LogFormat":
+			if value, ok := v.(string); ok {
+				opts.LogFormat = value
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'LogFormat' field in config to be a string.")
+			}
 		case "AutoReload":
 			if value, ok := v.(bool); ok {
 				opts.AutoReload = value
@@ -140,16 +942,49 @@ func LoadConfigFromPath(path string) (ServerOptions, error) {
 				logger.GlobalLog.LogWarn("Expected 'AutoReload' field in config to be a bool.")
 			}
 		case "DeadPaths":
+			if value, ok := parseStringList("DeadPaths", v); ok {
+				opts.DeadPaths = value
+			}
+		case "DeadResponseMode":
+			if value, ok := v.(string); ok {
+				opts.DeadResponseMode = value
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'DeadResponseMode' field in config to be a string.")
+			}
+		case "BlockRules":
 			if value, ok := v.([]interface{}); ok {
-				for _, path := range value {
-					if p, ok := path.(string); ok {
-						opts.DeadPaths = append(opts.DeadPaths, p)
-					} else {
-						logger.GlobalLog.LogWarn("Expected all elements of 'DeadPaths' to be strings")
+				opts.BlockRules = []BlockRule{}
+
+				for _, element := range value {
+					rule, ok := parseStringMap("BlockRules", element)
+
+					if !ok || rule["Header"] == "" {
+						logger.GlobalLog.LogWarn("Expected all members of 'BlockRules' to have a 'Header'")
+						continue
 					}
+
+					opts.BlockRules = append(opts.BlockRules, BlockRule{rule["Header"], rule["Match"], rule["Action"]})
 				}
 			} else {
-				logger.GlobalLog.LogWarn("Expected 'DeadPaths' field in config to be a list of strings.")
+				logger.GlobalLog.LogWarn("Expected 'BlockRules' field in config to be a list.")
+			}
+		case "TarpitInterval":
+			if value, ok := v.(float64); ok {
+				opts.TarpitInterval = int64(value)
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'TarpitInterval' field in config to be a number.")
+			}
+		case "TarpitDuration":
+			if value, ok := v.(float64); ok {
+				opts.TarpitDuration = int64(value)
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'TarpitDuration' field in config to be a number.")
+			}
+		case "TarpitAbusive":
+			if value, ok := v.(bool); ok {
+				opts.TarpitAbusive = value
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'TarpitAbusive' field in config to be a bool.")
 			}
 		case "RedirectHttp":
 			if value, ok := v.(bool); ok {
@@ -167,100 +1002,1280 @@ func LoadConfigFromPath(path string) (ServerOptions, error) {
 			if value, ok := v.(float64); ok {
 				opts.ReadTimeout = int64(value)
 			} else {
-				logger.GlobalLog.LogWarn("Expected 'ReadTimout' field in config to be a number.")
+				logger.GlobalLog.LogWarn("Expected 'ReadTimeout' field in config to be a number.")
 			}
-		}
-	}
-
-	return opts, nil
-}
-
-// Prints log options to the info log.
-func (opts *ServerOptions) Show() {
-	logger.GlobalLog.LogInfo("Config: Site: " + opts.Site)
-	logger.GlobalLog.LogInfo("Config: Cert: " + opts.Cert)
-	logger.GlobalLog.LogInfo("Config: Key: " + opts.Key)
-	logger.GlobalLog.LogInfo("Config: Port: " + strconv.FormatInt(int64(opts.Port), 10))
-	logger.GlobalLog.LogInfo("Config: Log: " + opts.Log)
-	logger.GlobalLog.LogInfo("Config: LogLevelPrint: " + opts.LogLevelPrint)
-	logger.GlobalLog.LogInfo("Config: LogLevelRecord: " + opts.LogLevelRecord)
-	logger.GlobalLog.LogInfo("Config: AutoReload: " + strconv.FormatBool(opts.AutoReload))
-	logger.GlobalLog.LogInfo("Config: RedirectHttp: " + strconv.FormatBool(opts.RedirectHttp))
-	logger.GlobalLog.LogInfo("Config: WriteTimeout: " + strconv.FormatInt(int64(opts.WriteTimeout), 10))
-	logger.GlobalLog.LogInfo("Config: ReadTimeout: " + strconv.FormatInt(int64(opts.ReadTimeout), 10))
-}
-
-// Watches for changes in the given file, intended for configs but anything
-// should work. This function will report all errors through the given callback.
-//
-// This function will not call the given callback more than once per detected
-// file change and because of this file modification date changes take
-// precedence over size changes.
-//
-// Callback should return true to terminate the goroutine checking for changes
-// and false to continue.
-func CallOnChange(callback func(FileChangeSignal) bool, filePath string) {
-	go callOnChange(callback, filePath)
+		case "ReadHeaderTimeout":
+			if value, ok := v.(float64); ok {
+				opts.ReadHeaderTimeout = int64(value)
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'ReadHeaderTimeout' field in config to be a number.")
+			}
+		case "IdleTimeout":
+			if value, ok := v.(float64); ok {
+				opts.IdleTimeout = int64(value)
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'IdleTimeout' field in config to be a number.")
+			}
+		case "MaxRequestBodyBytes":
+			if value, ok := v.(float64); ok {
+				opts.MaxRequestBodyBytes = int64(value)
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'MaxRequestBodyBytes' field in config to be a number.")
+			}
+		case "MaxHeaderBytes":
+			if value, ok := v.(float64); ok {
+				opts.MaxHeaderBytes = int64(value)
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'MaxHeaderBytes' field in config to be a number.")
+			}
+		case "MaxConcurrentConnections":
+			if value, ok := v.(float64); ok {
+				opts.MaxConcurrentConnections = int64(value)
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'MaxConcurrentConnections' field in config to be a number.")
+			}
+		case "MaxConnectionsPerIP":
+			if value, ok := v.(float64); ok {
+				opts.MaxConnectionsPerIP = int64(value)
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'MaxConnectionsPerIP' field in config to be a number.")
+			}
+		case "ThrottleBytesPerSecond":
+			if value, ok := v.(float64); ok {
+				opts.ThrottleBytesPerSecond = int64(value)
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'ThrottleBytesPerSecond' field in config to be a number.")
+			}
+		case "ThrottleConnectionBytesPerSecond":
+			if value, ok := v.(float64); ok {
+				opts.ThrottleConnectionBytesPerSecond = int64(value)
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'ThrottleConnectionBytesPerSecond' field in config to be a number.")
+			}
+		case "ThrottlePaths":
+			if value, ok := parseNumberMap("ThrottlePaths", v); ok {
+				opts.ThrottlePaths = value
+			}
+		case "Maintenance":
+			if value, ok := v.(bool); ok {
+				opts.Maintenance = value
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'Maintenance' field in config to be a bool.")
+			}
+		case "MaintenanceRetryAfter":
+			if value, ok := v.(float64); ok {
+				opts.MaintenanceRetryAfter = int64(value)
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'MaintenanceRetryAfter' field in config to be a number.")
+			}
+		case "MaintenanceAllowPaths":
+			if value, ok := parseStringList("MaintenanceAllowPaths", v); ok {
+				opts.MaintenanceAllowPaths = value
+			}
+		case "MaintenanceAllowIPs":
+			if value, ok := parseStringList("MaintenanceAllowIPs", v); ok {
+				opts.MaintenanceAllowIPs = value
+			}
+		case "StatusUrls":
+			if value, ok := parseStringList("StatusUrls", v); ok {
+				opts.StatusUrls = value
+			}
+		case "DeepStatusChecks":
+			if value, ok := v.(bool); ok {
+				opts.DeepStatusChecks = value
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'DeepStatusChecks' field in config to be a bool.")
+			}
+		case "HealthCheckInterval":
+			if value, ok := v.(float64); ok {
+				opts.HealthCheckInterval = int64(value)
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'HealthCheckInterval' field in config to be a number.")
+			}
+		case "HealthCheckThreshold":
+			if value, ok := v.(float64); ok {
+				opts.HealthCheckThreshold = int64(value)
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'HealthCheckThreshold' field in config to be a number.")
+			}
+		case "HealthCheckRestart":
+			if value, ok := v.(bool); ok {
+				opts.HealthCheckRestart = value
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'HealthCheckRestart' field in config to be a bool.")
+			}
+		case "HealthHistorySize":
+			if value, ok := v.(float64); ok {
+				opts.HealthHistorySize = int64(value)
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'HealthHistorySize' field in config to be a number.")
+			}
+		case "OidcIssuer":
+			if value, ok := v.(string); ok {
+				opts.OidcIssuer = value
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'OidcIssuer' field in config to be a string.")
+			}
+		case "OidcClientId":
+			if value, ok := v.(string); ok {
+				opts.OidcClientId = value
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'OidcClientId' field in config to be a string.")
+			}
+		case "OidcClientSecret":
+			if value, ok := v.(string); ok {
+				opts.OidcClientSecret = value
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'OidcClientSecret' field in config to be a string.")
+			}
+		case "OidcRedirectUrl":
+			if value, ok := v.(string); ok {
+				opts.OidcRedirectUrl = value
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'OidcRedirectUrl' field in config to be a string.")
+			}
+		case "OidcPrefixes":
+			if value, ok := parseStringList("OidcPrefixes", v); ok {
+				opts.OidcPrefixes = value
+			}
+		case "OidcClaim":
+			if value, ok := v.(string); ok {
+				opts.OidcClaim = value
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'OidcClaim' field in config to be a string.")
+			}
+		case "OidcAllowedValues":
+			if value, ok := parseStringList("OidcAllowedValues", v); ok {
+				opts.OidcAllowedValues = value
+			}
+		case "OidcAllowedEmails":
+			if value, ok := parseStringList("OidcAllowedEmails", v); ok {
+				opts.OidcAllowedEmails = value
+			}
+		case "OidcSessionLength":
+			if value, ok := v.(float64); ok {
+				opts.OidcSessionLength = int64(value)
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'OidcSessionLength' field in config to be a number.")
+			}
+		case "WebDavPrefix":
+			if value, ok := v.(string); ok {
+				opts.WebDavPrefix = value
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'WebDavPrefix' field in config to be a string.")
+			}
+		case "WebDavRoot":
+			if value, ok := v.(string); ok {
+				opts.WebDavRoot = value
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'WebDavRoot' field in config to be a string.")
+			}
+		case "WebDavUsers":
+			if value, ok := parseStringMap("WebDavUsers", v); ok {
+				opts.WebDavUsers = value
+			}
+		case "UploadPath":
+			if value, ok := v.(string); ok {
+				opts.UploadPath = value
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'UploadPath' field in config to be a string.")
+			}
+		case "UploadDir":
+			if value, ok := v.(string); ok {
+				opts.UploadDir = value
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'UploadDir' field in config to be a string.")
+			}
+		case "UploadMaxBytes":
+			if value, ok := v.(float64); ok {
+				opts.UploadMaxBytes = int64(value)
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'UploadMaxBytes' field in config to be a number.")
+			}
+		case "UploadExtensions":
+			if value, ok := parseStringList("UploadExtensions", v); ok {
+				opts.UploadExtensions = value
+			}
+		case "DeployHookPath":
+			if value, ok := v.(string); ok {
+				opts.DeployHookPath = value
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'DeployHookPath' field in config to be a string.")
+			}
+		case "DeployHookSecret":
+			if value, ok := v.(string); ok {
+				opts.DeployHookSecret = value
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'DeployHookSecret' field in config to be a string.")
+			}
+		case "DeployHookCommand":
+			if value, ok := parseStringList("DeployHookCommand", v); ok {
+				opts.DeployHookCommand = value
+			}
+		case "UploadUsers":
+			if value, ok := parseStringMap("UploadUsers", v); ok {
+				opts.UploadUsers = value
+			}
+		case "UploadAnonymous":
+			if value, ok := v.(bool); ok {
+				opts.UploadAnonymous = value
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'UploadAnonymous' field in config to be a bool.")
+			}
+		case "SignedPrefixes":
+			if value, ok := parseStringList("SignedPrefixes", v); ok {
+				opts.SignedPrefixes = value
+			}
+		case "SignedUrlKey":
+			if value, ok := v.(string); ok {
+				opts.SignedUrlKey = value
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'SignedUrlKey' field in config to be a string.")
+			}
+		case "Attachments":
+			if value, ok := parseStringMap("Attachments", v); ok {
+				opts.Attachments = value
+			}
+		case "NoRangePaths":
+			if value, ok := parseStringList("NoRangePaths", v); ok {
+				opts.NoRangePaths = value
+			}
+		case "Precompressed":
+			if value, ok := v.(bool); ok {
+				opts.Precompressed = value
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'Precompressed' field in config to be a bool.")
+			}
+		case "Minify":
+			if value, ok := parseStringList("Minify", v); ok {
+				opts.Minify = value
+			}
+		case "TemplateExtension":
+			if value, ok := v.(string); ok {
+				opts.TemplateExtension = value
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'TemplateExtension' field in config to be a string.")
+			}
+		case "TemplateSiteData":
+			if value, ok := parseStringMap("TemplateSiteData", v); ok {
+				opts.TemplateSiteData = value
+			}
+		case "BuildSource":
+			if value, ok := v.(string); ok {
+				opts.BuildSource = value
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'BuildSource' field in config to be a string.")
+			}
+		case "BuildOnReload":
+			if value, ok := v.(bool); ok {
+				opts.BuildOnReload = value
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'BuildOnReload' field in config to be a bool.")
+			}
+		case "BuildBaseUrl":
+			if value, ok := v.(string); ok {
+				opts.BuildBaseUrl = value
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'BuildBaseUrl' field in config to be a string.")
+			}
+		case "ImageResizePrefixes":
+			if value, ok := parseStringList("ImageResizePrefixes", v); ok {
+				opts.ImageResizePrefixes = value
+			}
+		case "ImageResizeWidths":
+			if value, ok := parseNumberList("ImageResizeWidths", v); ok {
+				opts.ImageResizeWidths = value
+			}
+		case "ImageCacheDir":
+			if value, ok := v.(string); ok {
+				opts.ImageCacheDir = value
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'ImageCacheDir' field in config to be a string.")
+			}
+		case "ModernImages":
+			if value, ok := v.(bool); ok {
+				opts.ModernImages = value
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'ModernImages' field in config to be a bool.")
+			}
+		case "Preloads":
+			if value, ok := v.(map[string]interface{}); ok {
+				opts.Preloads = map[string][]string{}
+
+				for page, assets := range value {
+					if list, ok := parseStringList("Preloads", assets); ok {
+						opts.Preloads[page] = list
+					}
+				}
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'Preloads' field in config to be an object of lists of strings.")
+			}
+		case "AutoPreloads":
+			if value, ok := v.(bool); ok {
+				opts.AutoPreloads = value
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'AutoPreloads' field in config to be a bool.")
+			}
+		case "EarlyHints":
+			if value, ok := v.(bool); ok {
+				opts.EarlyHints = value
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'EarlyHints' field in config to be a bool.")
+			}
+		case "S3Bucket":
+			if value, ok := v.(string); ok {
+				opts.S3Bucket = value
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'S3Bucket' field in config to be a string.")
+			}
+		case "S3Endpoint":
+			if value, ok := v.(string); ok {
+				opts.S3Endpoint = value
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'S3Endpoint' field in config to be a string.")
+			}
+		case "S3Region":
+			if value, ok := v.(string); ok {
+				opts.S3Region = value
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'S3Region' field in config to be a string.")
+			}
+		case "S3Prefix":
+			if value, ok := v.(string); ok {
+				opts.S3Prefix = value
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'S3Prefix' field in config to be a string.")
+			}
+		case "S3AccessKey":
+			if value, ok := v.(string); ok {
+				opts.S3AccessKey = value
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'S3AccessKey' field in config to be a string.")
+			}
+		case "S3SecretKey":
+			if value, ok := v.(string); ok {
+				opts.S3SecretKey = value
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'S3SecretKey' field in config to be a string.")
+			}
+		case "S3CacheDir":
+			if value, ok := v.(string); ok {
+				opts.S3CacheDir = value
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'S3CacheDir' field in config to be a string.")
+			}
+		case "QuotaRequestsHourly":
+			if value, ok := v.(float64); ok {
+				opts.QuotaRequestsHourly = int64(value)
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'QuotaRequestsHourly' field in config to be a number.")
+			}
+		case "QuotaRequestsDaily":
+			if value, ok := v.(float64); ok {
+				opts.QuotaRequestsDaily = int64(value)
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'QuotaRequestsDaily' field in config to be a number.")
+			}
+		case "QuotaBytesHourly":
+			if value, ok := v.(float64); ok {
+				opts.QuotaBytesHourly = int64(value)
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'QuotaBytesHourly' field in config to be a number.")
+			}
+		case "QuotaBytesDaily":
+			if value, ok := v.(float64); ok {
+				opts.QuotaBytesDaily = int64(value)
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'QuotaBytesDaily' field in config to be a number.")
+			}
+		case "BanThreshold":
+			if value, ok := v.(float64); ok {
+				opts.BanThreshold = int64(value)
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'BanThreshold' field in config to be a number.")
+			}
+		case "BanWindow":
+			if value, ok := v.(float64); ok {
+				opts.BanWindow = int64(value)
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'BanWindow' field in config to be a number.")
+			}
+		case "BanDuration":
+			if value, ok := v.(float64); ok {
+				opts.BanDuration = int64(value)
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'BanDuration' field in config to be a number.")
+			}
+		case "SecurityContacts":
+			if value, ok := parseStringList("SecurityContacts", v); ok {
+				opts.SecurityContacts = value
+			}
+		case "SecurityExpires":
+			if value, ok := v.(string); ok {
+				opts.SecurityExpires = value
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'SecurityExpires' field in config to be a string.")
+			}
+		case "SecurityPolicy":
+			if value, ok := v.(string); ok {
+				opts.SecurityPolicy = value
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'SecurityPolicy' field in config to be a string.")
+			}
+		case "Sitemap":
+			if value, ok := v.(bool); ok {
+				opts.Sitemap = value
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'Sitemap' field in config to be a bool.")
+			}
+		case "SitemapExclude":
+			if value, ok := parseStringList("SitemapExclude", v); ok {
+				opts.SitemapExclude = value
+			}
+		case "RobotsTxt":
+			if value, ok := v.(bool); ok {
+				opts.RobotsTxt = value
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'RobotsTxt' field in config to be a bool.")
+			}
+		case "RobotsDisallow":
+			if value, ok := parseStringList("RobotsDisallow", v); ok {
+				opts.RobotsDisallow = value
+			}
+		case "WellKnown":
+			if value, ok := parseStringMap("WellKnown", v); ok {
+				opts.WellKnown = value
+			}
+		case "Proxies":
+			if value, ok := parseStringMap("Proxies", v); ok {
+				opts.Proxies = value
+			}
+		case "Compression":
+			if value, ok := parseStringList("Compression", v); ok {
+				opts.Compression = value
+			}
+		case "CompressionMinSize":
+			if value, ok := v.(float64); ok {
+				opts.CompressionMinSize = int64(value)
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'CompressionMinSize' field in config to be a number.")
+			}
+		case "CompressionTypes":
+			if value, ok := parseStringList("CompressionTypes", v); ok {
+				opts.CompressionTypes = value
+			}
+		case "AccessLog":
+			if value, ok := v.(string); ok {
+				opts.AccessLog = value
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'AccessLog' field in config to be a string.")
+			}
+		case "AccessLogFormat":
+			if value, ok := v.(string); ok {
+				opts.AccessLogFormat = value
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'AccessLogFormat' field in config to be a string.")
+			}
+		case "AutoIndex":
+			if value, ok := v.(bool); ok {
+				opts.AutoIndex = value
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'AutoIndex' field in config to be a bool.")
+			}
+		case "ErrorPages":
+			if value, ok := parseStringMap("ErrorPages", v); ok {
+				opts.ErrorPages = value
+			}
+		case "SpaFallback":
+			if value, ok := v.(bool); ok {
+				opts.SpaFallback = value
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'SpaFallback' field in config to be a bool.")
+			}
+		case "ShutdownTimeout":
+			if value, ok := v.(float64); ok {
+				opts.ShutdownTimeout = int64(value)
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'ShutdownTimeout' field in config to be a number.")
+			}
+		case "Etags":
+			if value, ok := v.(bool); ok {
+				opts.Etags = value
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'Etags' field in config to be a bool.")
+			}
+		case "CacheControl":
+			if value, ok := parseStringMap("CacheControl", v); ok {
+				opts.CacheControl = value
+			}
+		case "Headers":
+			if value, ok := v.(map[string]interface{}); ok {
+				opts.Headers = map[string]map[string]string{}
+
+				for prefix, headers := range value {
+					if headers, ok := parseStringMap("Headers", headers); ok {
+						opts.Headers[prefix] = headers
+					}
+				}
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'Headers' field in config to be an object of objects.")
+			}
+		case "AllowedMethods":
+			if value, ok := v.(map[string]interface{}); ok {
+				opts.AllowedMethods = map[string][]string{}
+
+				for prefix, methods := range value {
+					if list, ok := parseStringList("AllowedMethods", methods); ok {
+						opts.AllowedMethods[prefix] = list
+					}
+				}
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'AllowedMethods' field in config to be an object of lists of strings.")
+			}
+		case "CanonicalHost":
+			if value, ok := v.(string); ok {
+				opts.CanonicalHost = value
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'CanonicalHost' field in config to be a string.")
+			}
+		case "Languages":
+			if value, ok := parseStringList("Languages", v); ok {
+				opts.Languages = value
+			}
+		case "DefaultLanguage":
+			if value, ok := v.(string); ok {
+				opts.DefaultLanguage = value
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'DefaultLanguage' field in config to be a string.")
+			}
+		case "LanguageCookie":
+			if value, ok := v.(string); ok {
+				opts.LanguageCookie = value
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'LanguageCookie' field in config to be a string.")
+			}
+		case "CanonicalTrailingSlash":
+			if value, ok := v.(string); ok {
+				opts.CanonicalTrailingSlash = value
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'CanonicalTrailingSlash' field in config to be a string.")
+			}
+		case "EnableHttp2":
+			if value, ok := v.(bool); ok {
+				opts.EnableHttp2 = value
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'EnableHttp2' field in config to be a bool.")
+			}
+		case "AdminAddress":
+			if value, ok := v.(string); ok {
+				opts.AdminAddress = value
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'AdminAddress' field in config to be a string.")
+			}
+		case "AdminToken":
+			if value, ok := v.(string); ok {
+				opts.AdminToken = value
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'AdminToken' field in config to be a string.")
+			}
+		case "AdminProfiling":
+			if value, ok := v.(bool); ok {
+				opts.AdminProfiling = value
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'AdminProfiling' field in config to be a bool.")
+			}
+		case "ControlGroup":
+			if value, ok := v.(string); ok {
+				opts.ControlGroup = value
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'ControlGroup' field in config to be a string.")
+			}
+		case "StatsFile":
+			if value, ok := v.(string); ok {
+				opts.StatsFile = value
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'StatsFile' field in config to be a string.")
+			}
+		case "StatsInterval":
+			if value, ok := v.(float64); ok {
+				opts.StatsInterval = int64(value)
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'StatsInterval' field in config to be a number.")
+			}
+		case "LogTarget":
+			value, ok := v.(map[string]interface{})
+
+			if !ok {
+				logger.GlobalLog.LogWarn("Expected 'LogTarget' field in config to be an object.")
+				break
+			}
+
+			if syslog, ok := value["Syslog"].(string); ok {
+				opts.LogTarget.Syslog = syslog
+			}
+
+			if journald, ok := value["Journald"].(bool); ok {
+				opts.LogTarget.Journald = journald
+			}
+
+			if tag, ok := value["Tag"].(string); ok {
+				opts.LogTarget.Tag = tag
+			}
+		case "Notifications":
+			value, ok := v.(map[string]interface{})
+
+			if !ok {
+				logger.GlobalLog.LogWarn("Expected 'Notifications' field in config to be an object.")
+				break
+			}
+
+			if webhook, ok := value["Webhook"].(string); ok {
+				opts.Notifications.Webhook = webhook
+			}
+
+			if command, ok := value["Command"].(string); ok {
+				opts.Notifications.Command = command
+			}
+
+			if events, ok := value["Events"]; ok {
+				if list, ok := parseStringList("Notifications.Events", events); ok {
+					opts.Notifications.Events = list
+				}
+			}
+
+			if threshold, ok := value["ServerErrorThreshold"].(float64); ok {
+				opts.Notifications.ServerErrorThreshold = int64(threshold)
+			}
+		case "Cron":
+			if value, ok := v.([]interface{}); ok {
+				opts.Cron = []CronJob{}
+
+				for _, element := range value {
+					job, ok := parseStringMap("Cron", element)
+
+					if !ok || job["Schedule"] == "" || job["Task"] == "" {
+						logger.GlobalLog.LogWarn("Expected all members of 'Cron' to have a 'Schedule' and 'Task'")
+						continue
+					}
+
+					opts.Cron = append(opts.Cron, CronJob{job["Name"], job["Schedule"], job["Task"], job["Command"], job["Instance"]})
+				}
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'Cron' field in config to be a list.")
+			}
+		case "SlowRequestThreshold":
+			if value, ok := v.(float64); ok {
+				opts.SlowRequestThreshold = int64(value)
+			} else {
+				logger.GlobalLog.LogWarn("Expected 'SlowRequestThreshold' field in config to be a number.")
+			}
+		}
+	}
+
+	return opts
+}
+
+// Reads a list of strings from a parsed JSON value, warning about incorrect
+// types using the given field name. Elements that are not strings are skipped.
+// Returns false if the value is not a list.
+func parseStringList(field string, v interface{}) ([]string, bool) {
+	value, ok := v.([]interface{})
+
+	if !ok {
+		logger.GlobalLog.LogWarn("Expected '" + field + "' field in config to be a list of strings.")
+		return nil, false
+	}
+
+	list := []string{}
+
+	for _, element := range value {
+		if str, ok := element.(string); ok {
+			list = append(list, str)
+		} else {
+			logger.GlobalLog.LogWarn("Expected all elements of '" + field + "' to be strings")
+		}
+	}
+
+	return list, true
+}
+
+// Reads a list of numbers from a parsed JSON value, warning about incorrect
+// types using the given field name. Elements that are not numbers are skipped.
+// Returns false if the value is not a list.
+func parseNumberList(field string, v interface{}) ([]int64, bool) {
+	value, ok := v.([]interface{})
+
+	if !ok {
+		logger.GlobalLog.LogWarn("Expected '" + field + "' field in config to be a list of numbers.")
+		return nil, false
+	}
+
+	list := []int64{}
+
+	for _, element := range value {
+		if number, ok := element.(float64); ok {
+			list = append(list, int64(number))
+		} else {
+			logger.GlobalLog.LogWarn("Expected all elements of '" + field + "' to be numbers")
+		}
+	}
+
+	return list, true
+}
+
+// Reads an object of strings from a parsed JSON value, warning about incorrect
+// types using the given field name. Members that are not strings are skipped.
+// Returns false if the value is not an object.
+func parseStringMap(field string, v interface{}) (map[string]string, bool) {
+	value, ok := v.(map[string]interface{})
+
+	if !ok {
+		logger.GlobalLog.LogWarn("Expected '" + field + "' field in config to be an object of strings.")
+		return nil, false
+	}
+
+	m := map[string]string{}
+
+	for k, element := range value {
+		if str, ok := element.(string); ok {
+			m[k] = str
+		} else {
+			logger.GlobalLog.LogWarn("Expected all members of '" + field + "' to be strings")
+		}
+	}
+
+	return m, true
+}
+
+// Reads an object of numbers from a parsed JSON value, warning about incorrect
+// types using the given field name. Members that are not numbers are skipped.
+// Returns false if the value is not an object.
+func parseNumberMap(field string, v interface{}) (map[string]int64, bool) {
+	value, ok := v.(map[string]interface{})
+
+	if !ok {
+		logger.GlobalLog.LogWarn("Expected '" + field + "' field in config to be an object of numbers.")
+		return nil, false
+	}
+
+	m := map[string]int64{}
+
+	for k, element := range value {
+		if number, ok := element.(float64); ok {
+			m[k] = int64(number)
+		} else {
+			logger.GlobalLog.LogWarn("Expected all members of '" + field + "' to be numbers")
+		}
+	}
+
+	return m, true
+}
+
+// Options that may be changed while webby is running, see `webby
+// -config-set`. Options mapped to true only take effect once the servers using
+// them restart, the others apply to the whole daemon rather than any one server
+// instance.
+var LiveOptions = map[string]bool{
+	"LogLevelPrint":                    false,
+	"LogLevelRecord":                   false,
+	"AutoReload":                       false,
+	"DeadPaths":                        true,
+	"WriteTimeout":                     true,
+	"ReadTimeout":                      true,
+	"ReadHeaderTimeout":                true,
+	"IdleTimeout":                      true,
+	"MaxRequestBodyBytes":              true,
+	"MaxHeaderBytes":                   true,
+	"MaxConcurrentConnections":         true,
+	"MaxConnectionsPerIP":              true,
+	"ThrottleBytesPerSecond":           true,
+	"ThrottleConnectionBytesPerSecond": true,
+	"ThrottlePaths":                    true,
+	"Maintenance":                      true,
+	"MaintenanceRetryAfter":            true,
+	"MaintenanceAllowPaths":            true,
+	"MaintenanceAllowIPs":              true,
+}
+
+// Options holding secrets, which are not given by `webby -config-get` since
+// members of the `ControlGroup` may not otherwise be able to read them.
+var SecretOptions = map[string]bool{
+	"OidcClientSecret": true,
+	"SignedUrlKey":     true,
+	"S3AccessKey":      true,
+	"S3SecretKey":      true,
+	"WebDavUsers":      true,
+	"UploadUsers":      true,
+	"DeployHookSecret": true,
+	"AdminToken":       true,
+}
+
+// Gives the value of the named option as JSON.
+func (opts *ServerOptions) GetOption(key string) (string, error) {
+	var optsMap map[string]json.RawMessage
+	bytes, err := json.Marshal(opts)
+
+	if err != nil {
+		return "", errors.New("Failed to parse ServerOptions into JSON: " + err.Error())
+	}
+
+	if err = json.Unmarshal(bytes, &optsMap); err != nil {
+		return "", errors.New("Failed to parse ServerOptions into JSON: " + err.Error())
+	}
+
+	value, ok := optsMap[key]
+
+	if !ok || key == "Instances" {
+		return "", errors.New("Unknown option '" + key + "'")
+	}
+
+	return string(value), nil
+}
+
+// A change to one option between two configs, see `DiffOptions()`.
+type OptionChange struct {
+	// Name of the option, given as "Instances[<name>].<option>" for an option of
+	// a server instance.
+	Key string
+
+	// The option's previous and current values as JSON, empty if the option or
+	// its instance was absent.
+	Previous string
+	Current  string
+
+	// Whether the change only takes effect once the servers using it restart,
+	// rather than being applied to the running daemon in place, see
+	// `LiveOptions`.
+	Restart bool
+}
+
+// Gives each option that differs between the given configs, in order of name.
+// Server instances are matched by name, so that an instance added or removed
+// gives a change for each of its options.
+func DiffOptions(previous, current ServerOptions) []OptionChange {
+	changes := diffOptionMaps(optionMap(previous), optionMap(current), "")
+
+	// Without instances the top level configuration is the only instance, which
+	// the top level options already cover.
+	if len(previous.Instances) == 0 && len(current.Instances) == 0 {
+		return changes
+	}
+
+	instances := map[string]bool{}
+	previousInstances := map[string]map[string]json.RawMessage{}
+	currentInstances := map[string]map[string]json.RawMessage{}
+
+	for _, instance := range previous.Instances {
+		instances[instance.Name] = true
+		previousInstances[instance.Name] = optionMap(instance)
+	}
+
+	for _, instance := range current.Instances {
+		instances[instance.Name] = true
+		currentInstances[instance.Name] = optionMap(instance)
+	}
+
+	names := []string{}
+
+	for name := range instances {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		changes = append(changes, diffOptionMaps(previousInstances[name], currentInstances[name], "Instances["+name+"].")...)
+	}
+
+	return changes
 }
 
-func callOnChange(callback func(FileChangeSignal) bool, filePath string) {
-	previousStat, err := os.Stat(filePath)
-	shouldReturn := false
+// Gives the options as a map of JSON values, without instances.
+func optionMap(opts ServerOptions) map[string]json.RawMessage {
+	var optsMap map[string]json.RawMessage
+	bytes, _ := json.Marshal(opts)
+	json.Unmarshal(bytes, &optsMap)
+	delete(optsMap, "Instances")
+	return optsMap
+}
+
+// Gives each option that differs between the given maps of JSON values, in
+// order of name, with the given prefix before each name.
+func diffOptionMaps(previous, current map[string]json.RawMessage, prefix string) []OptionChange {
+	keys := map[string]bool{}
+
+	for key := range previous {
+		keys[key] = true
+	}
+
+	for key := range current {
+		keys[key] = true
+	}
+
+	changes := []OptionChange{}
+
+	for key := range keys {
+		if string(previous[key]) == string(current[key]) {
+			continue
+		}
+
+		restart, live := LiveOptions[key]
+
+		changes = append(changes, OptionChange{
+			Key:      prefix + key,
+			Previous: string(previous[key]),
+			Current:  string(current[key]),
+			Restart:  restart || !live,
+		})
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Key < changes[j].Key
+	})
+
+	return changes
+}
+
+// Sets the named option from the given JSON value. Returns an error if the
+// option may not be changed while webby is running, see `LiveOptions`, or if the
+// value is not of the option's type.
+func (opts *ServerOptions) SetOption(key string, value json.RawMessage) error {
+	if _, ok := LiveOptions[key]; !ok {
+		return errors.New("Option '" + key + "' cannot be changed while webby is running, edit the config and reload instead")
+	}
+
+	var v interface{}
+
+	if err := json.Unmarshal(value, &v); err != nil {
+		return errors.New("Could not parse value for '" + key + "': " + err.Error())
+	}
+
+	// Values of an incorrect type are left unchanged by `parseOptions()`, so the
+	// option is read back to check that it took.
+	updated := parseOptions(map[string]interface{}{key: v}, *opts)
+	got, err := updated.GetOption(key)
+
+	if err != nil {
+		return err
+	}
+
+	var gotValue interface{}
+
+	if err = json.Unmarshal([]byte(got), &gotValue); err != nil || !reflect.DeepEqual(gotValue, v) {
+		return errors.New("Value '" + string(value) + "' is not of the type of option '" + key + "', which is currently " + got)
+	}
+
+	*opts = updated
+	return nil
+}
+
+// Sets the named option to the given JSON value in the config file at the given
+// path, leaving other options as they are. An instance name gives the server
+// instance to set the option for, or an empty string for the top level. Only
+// the option's value is rewritten, or the option added, so that the rest of the
+// config keeps its formatting and comments.
+func SetOptionInFile(path, instance, key string, value json.RawMessage) error {
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		return errors.New("Could not read config at '" + path + "'")
+	}
+
+	var compacted bytes.Buffer
+
+	if err = json.Compact(&compacted, value); err != nil {
+		return errors.New("Could not parse value for '" + key + "': " + err.Error())
+	}
+
+	stripped := stripJsonComments(data)
+	members, _, err := jsonChildren(stripped, 0)
 
 	if err != nil {
-		shouldReturn = callback(InitialReadError)
+		return errors.New("Could not parse config JSON at '" + path + "'")
 	}
 
-	for {
-		currentStat, err := os.Stat(filePath)
+	// The top level object begins the config.
+	start := 0
+
+	if instance != "" {
+		start, err = findInstance(stripped, members, instance)
 
 		if err != nil {
-			shouldReturn = callback(ReadError)
-			goto Sleep
+			return errors.New(err.Error() + " in config at '" + path + "'")
 		}
+	}
 
-		if currentStat.ModTime() != previousStat.ModTime() {
-			shouldReturn = callback(TimeModifiedChange)
-			goto Sleep
+	data, err = setJsonMember(data, start, key, compacted.Bytes())
+
+	if err != nil {
+		return errors.New("Could not parse config JSON at '" + path + "'")
+	}
+
+	if err = os.WriteFile(path, data, 0644); err != nil {
+		return errors.New("Could not write to file '" + path + "': " + err.Error())
+	}
+
+	return nil
+}
+
+// Gives the offset of the named server instance's object in the given config
+// JSON, without comments, given the members of its top level object. Without
+// instances the top level configuration is the only instance, which is given
+// for any name.
+func findInstance(data []byte, members []jsonChild, instance string) (int, error) {
+	for _, member := range members {
+		if member.key != "Instances" {
+			continue
 		}
 
-		if currentStat.Size() != previousStat.Size() {
-			shouldReturn = callback(SizeChange)
-			goto Sleep
+		elements, _, err := jsonChildren(data, member.valueStart)
+
+		if err != nil || len(elements) == 0 {
+			break
 		}
 
-	Sleep:
-		if shouldReturn {
-			return
+		// Instances without a name are named by their position, see
+		// `LoadConfigFromPath()`.
+		for i, element := range elements {
+			var named struct{ Name *string }
+
+			if json.Unmarshal(data[element.valueStart:element.valueEnd], &named) != nil {
+				continue
+			}
+
+			if (named.Name != nil && *named.Name == instance) || (named.Name == nil && "instance-"+strconv.Itoa(i) == instance) {
+				return element.valueStart, nil
+			}
 		}
 
-		previousStat = currentStat
-		time.Sleep(1 * time.Second)
+		return 0, errors.New("Could not find instance '" + instance + "'")
+	}
+
+	return 0, nil
+}
+
+// Prints log options to the info log.
+func (opts *ServerOptions) Show() {
+	logger.GlobalLog.LogInfo("Config: Name: " + opts.Name)
+	logger.GlobalLog.LogInfo("Config: Site: " + opts.Site)
+	logger.GlobalLog.LogInfo("Config: DynamicPaths: " + strconv.FormatBool(opts.DynamicPaths))
+	logger.GlobalLog.LogInfo("Config: ServeHiddenFiles: " + strconv.FormatBool(opts.ServeHiddenFiles))
+	logger.GlobalLog.LogInfo("Config: Cert: " + opts.Cert)
+	logger.GlobalLog.LogInfo("Config: Key: " + opts.Key)
+	logger.GlobalLog.LogInfo("Config: Port: " + strconv.FormatInt(int64(opts.Port), 10))
+	logger.GlobalLog.LogInfo("Config: HttpPort: " + strconv.FormatInt(int64(opts.HttpPort), 10))
+	logger.GlobalLog.LogInfo("Config: HttpsPort: " + strconv.FormatInt(int64(opts.HttpsPort), 10))
+	logger.GlobalLog.LogInfo("Config: BindAddress: " + opts.BindAddress)
+	logger.GlobalLog.LogInfo("Config: AcceptProxyProtocol: " + strconv.FormatBool(opts.AcceptProxyProtocol))
+	logger.GlobalLog.LogInfo("Config: Log: " + opts.Log)
+	logger.GlobalLog.LogInfo("Config: CrashLog: " + opts.CrashLog)
+	logger.GlobalLog.LogInfo("Config: LogLevelPrint: " + opts.LogLevelPrint)
+	logger.GlobalLog.LogInfo("Config: LogLevelRecord: " + opts.LogLevelRecord)
+	logger.GlobalLog.LogInfo("Config: LogFormat: " + opts.LogFormat)
+	logger.GlobalLog.LogInfo("Config: LogTarget: Syslog: " + opts.LogTarget.Syslog)
+	logger.GlobalLog.LogInfo("Config: LogTarget: Journald: " + strconv.FormatBool(opts.LogTarget.Journald))
+	logger.GlobalLog.LogInfo("Config: LogTarget: Tag: " + opts.LogTarget.Tag)
+	logger.GlobalLog.LogInfo("Config: AutoReload: " + strconv.FormatBool(opts.AutoReload))
+	logger.GlobalLog.LogInfo("Config: RedirectHttp: " + strconv.FormatBool(opts.RedirectHttp))
+	logger.GlobalLog.LogInfo("Config: WriteTimeout: " + strconv.FormatInt(int64(opts.WriteTimeout), 10))
+	logger.GlobalLog.LogInfo("Config: ReadTimeout: " + strconv.FormatInt(int64(opts.ReadTimeout), 10))
+	logger.GlobalLog.LogInfo("Config: ReadHeaderTimeout: " + strconv.FormatInt(opts.ReadHeaderTimeout, 10))
+	logger.GlobalLog.LogInfo("Config: IdleTimeout: " + strconv.FormatInt(opts.IdleTimeout, 10))
+	logger.GlobalLog.LogInfo("Config: MaxRequestBodyBytes: " + strconv.FormatInt(opts.MaxRequestBodyBytes, 10))
+	logger.GlobalLog.LogInfo("Config: MaxHeaderBytes: " + strconv.FormatInt(opts.MaxHeaderBytes, 10))
+	logger.GlobalLog.LogInfo("Config: MaxConcurrentConnections: " + strconv.FormatInt(opts.MaxConcurrentConnections, 10))
+	logger.GlobalLog.LogInfo("Config: MaxConnectionsPerIP: " + strconv.FormatInt(opts.MaxConnectionsPerIP, 10))
+	logger.GlobalLog.LogInfo("Config: ThrottleBytesPerSecond: " + strconv.FormatInt(opts.ThrottleBytesPerSecond, 10))
+	logger.GlobalLog.LogInfo("Config: ThrottleConnectionBytesPerSecond: " + strconv.FormatInt(opts.ThrottleConnectionBytesPerSecond, 10))
+	logger.GlobalLog.LogInfo("Config: Maintenance: " + strconv.FormatBool(opts.Maintenance))
+	logger.GlobalLog.LogInfo("Config: MaintenanceRetryAfter: " + strconv.FormatInt(opts.MaintenanceRetryAfter, 10))
+	logger.GlobalLog.LogInfo("Config: DeepStatusChecks: " + strconv.FormatBool(opts.DeepStatusChecks))
+	logger.GlobalLog.LogInfo("Config: HealthCheckInterval: " + strconv.FormatInt(opts.HealthCheckInterval, 10))
+	logger.GlobalLog.LogInfo("Config: HealthCheckThreshold: " + strconv.FormatInt(opts.HealthCheckThreshold, 10))
+	logger.GlobalLog.LogInfo("Config: HealthCheckRestart: " + strconv.FormatBool(opts.HealthCheckRestart))
+	logger.GlobalLog.LogInfo("Config: HealthHistorySize: " + strconv.FormatInt(opts.HealthHistorySize, 10))
+
+	for i := range opts.Instances {
+		opts.Instances[i].Show()
+	}
+}
+
+// Watches for changes in the given file, intended for configs but anything
+// should work. This function will report all errors through the given callback.
+// See `WatchPaths()` for watching many files or directories at once.
+//
+// Callback should return true to stop watching for changes and false to
+// continue.
+func CallOnChange(callback func(FileChangeSignal) bool, filePath string) {
+	WatchPaths(callback, filePath)
+}
+
+// Gets the options for every server that should be hosted, this is either the
+// configured instances or, if there are none, just the top level options.
+func (opts *ServerOptions) ServerInstances() []ServerOptions {
+	if len(opts.Instances) == 0 {
+		return []ServerOptions{*opts}
 	}
+
+	return opts.Instances
 }
 
 // Get the default configuration.
 func DefaultOptions() ServerOptions {
 	return ServerOptions{
-		Site:           "/srv/webby/website",
-		Cert:           "",
-		Key:            "",
-		Port:           -1,
-		Log:            "/srv/webby/webby.log",
-		LogLevelPrint:  "all",
-		LogLevelRecord: "all",
-		AutoReload:     true,
-		DeadPaths:      []string{},
-		WriteTimeout:   60,
-		ReadTimeout:    60,
+		Name:                             "default",
+		Site:                             "/srv/webby/website",
+		Mounts:                           map[string]string{},
+		DynamicPaths:                     false,
+		ServeHiddenFiles:                 false,
+		Cert:                             "",
+		Key:                              "",
+		Certificates:                     []CertificatePair{},
+		MinTLSVersion:                    "1.2",
+		CipherSuites:                     []string{},
+		CurvePreferences:                 []string{},
+		ClientCA:                         "",
+		ClientAuthPaths:                  []string{},
+		Port:                             -1,
+		HttpPort:                         0,
+		HttpsPort:                        0,
+		BindAddress:                      "",
+		AcceptProxyProtocol:              false,
+		Log:                              "/srv/webby/webby.log",
+		CrashLog:                         "/srv/webby/crash.log",
+		LogLevelPrint:                    "all",
+		LogLevelRecord:                   "all",
+		LogFormat:                        "text",
+		LogTarget:                        LogTargetOptions{"", false, "webby"},
+		AutoReload:                       true,
+		DeadPaths:                        []string{},
+		DeadResponseMode:                 DeadRedirect,
+		BlockRules:                       []BlockRule{},
+		TarpitInterval:                   2000,
+		TarpitDuration:                   60,
+		TarpitAbusive:                    false,
+		WriteTimeout:                     60,
+		ReadTimeout:                      60,
+		ReadHeaderTimeout:                10,
+		IdleTimeout:                      120,
+		MaxRequestBodyBytes:              0,
+		MaxHeaderBytes:                   1 << 20,
+		MaxConcurrentConnections:         0,
+		MaxConnectionsPerIP:              0,
+		ThrottleBytesPerSecond:           0,
+		ThrottleConnectionBytesPerSecond: 0,
+		ThrottlePaths:                    map[string]int64{},
+		Maintenance:                      false,
+		MaintenanceRetryAfter:            300,
+		MaintenanceAllowPaths:            []string{},
+		MaintenanceAllowIPs:              []string{},
+		StatusUrls:                       []string{},
+		DeepStatusChecks:                 false,
+		HealthCheckInterval:              0,
+		HealthCheckThreshold:             3,
+		HealthCheckRestart:               false,
+		HealthHistorySize:                1440,
+		OidcIssuer:                       "",
+		OidcClientId:                     "",
+		OidcClientSecret:                 "",
+		OidcRedirectUrl:                  "",
+		OidcPrefixes:                     []string{},
+		OidcClaim:                        "groups",
+		OidcAllowedValues:                []string{},
+		OidcAllowedEmails:                []string{},
+		OidcSessionLength:                8 * 60 * 60,
+		WebDavPrefix:                     "",
+		WebDavRoot:                       "",
+		WebDavUsers:                      map[string]string{},
+		UploadPath:                       "",
+		UploadDir:                        "",
+		UploadMaxBytes:                   100 << 20,
+		UploadExtensions:                 []string{},
+		UploadUsers:                      map[string]string{},
+		UploadAnonymous:                  false,
+		DeployHookPath:                   "",
+		DeployHookSecret:                 "",
+		DeployHookCommand:                []string{},
+		SignedPrefixes:                   []string{},
+		SignedUrlKey:                     "",
+		Attachments:                      map[string]string{},
+		NoRangePaths:                     []string{},
+		Precompressed:                    false,
+		Minify:                           []string{},
+		TemplateExtension:                "",
+		TemplateSiteData:                 map[string]string{},
+		BuildSource:                      "",
+		BuildOnReload:                    false,
+		BuildBaseUrl:                     "",
+		ModernImages:                     false,
+		ImageResizePrefixes:              []string{},
+		ImageResizeWidths:                []int64{320, 640, 960, 1280, 1920},
+		ImageCacheDir:                    "/var/cache/webby/images",
+		Preloads:                         map[string][]string{},
+		AutoPreloads:                     false,
+		EarlyHints:                       false,
+		S3Bucket:                         "",
+		S3Endpoint:                       "https://s3.amazonaws.com",
+		S3Region:                         "us-east-1",
+		S3Prefix:                         "",
+		S3AccessKey:                      "",
+		S3SecretKey:                      "",
+		S3CacheDir:                       "/var/cache/webby/s3",
+		QuotaRequestsHourly:              0,
+		QuotaRequestsDaily:               0,
+		QuotaBytesHourly:                 0,
+		QuotaBytesDaily:                  0,
+		BanThreshold:                     0,
+		BanWindow:                        600,
+		BanDuration:                      3600,
+		SecurityContacts:                 []string{},
+		SecurityExpires:                  "",
+		SecurityPolicy:                   "",
+		Sitemap:                          false,
+		SitemapExclude:                   []string{},
+		RobotsTxt:                        false,
+		RobotsDisallow:                   []string{},
+		WellKnown:                        map[string]string{},
+		Proxies:                          map[string]string{},
+		Compression:                      []string{},
+		CompressionMinSize:               1024,
+		CompressionTypes:                 []string{"text/html", "text/css", "text/plain", "text/javascript", "application/javascript", "application/json", "application/xml", "image/svg+xml"},
+		AccessLog:                        "",
+		AccessLogFormat:                  "combined",
+		AutoIndex:                        false,
+		ErrorPages:                       map[string]string{},
+		SpaFallback:                      false,
+		ShutdownTimeout:                  30,
+		Etags:                            true,
+		CacheControl:                     map[string]string{},
+		Headers:                          map[string]map[string]string{},
+		AllowedMethods:                   map[string][]string{},
+		CanonicalHost:                    "",
+		Languages:                        []string{},
+		DefaultLanguage:                  "",
+		LanguageCookie:                   "lang",
+		CanonicalTrailingSlash:           "",
+		EnableHttp2:                      true,
+		ControlGroup:                     "",
+		AdminAddress:                     "",
+		AdminToken:                       "",
+		AdminProfiling:                   false,
+		StatsFile:                        "",
+		StatsInterval:                    300,
+		Notifications:                    NotificationOptions{"", "", []string{}, 0},
+		Cron:                             []CronJob{},
+		SlowRequestThreshold:             0,
+		Instances:                        []ServerOptions{},
 	}
 }
 
-func (opts *ServerOptions) WriteToFile(path string) error {
-	json_string, err := json.MarshalIndent(opts, "", "    ")
+// Writes the options to the file at the given path as a config in the given
+// format, see `ServerOptions.Marshal()`.
+func (opts *ServerOptions) WriteToFile(path, format string) error {
+	data, err := opts.Marshal(format)
 
 	if err != nil {
-		return errors.New("Failed to parse ServerOptions into JSON: " + err.Error())
+		return err
 	}
 
 	file, err := os.Create(path)
@@ -269,7 +2284,7 @@ func (opts *ServerOptions) WriteToFile(path string) error {
 		return errors.New("Could not create file '" + path + "': " + err.Error())
 	}
 
-	_, err = file.Write(json_string)
+	_, err = file.Write(data)
 
 	if err != nil {
 		return errors.New("Could not write to file '" + path + "': " + err.Error())
@@ -285,7 +2300,91 @@ func (opts *ServerOptions) WriteToFile(path string) error {
 // Returns true if the config has the needed fields populated to support TLS and
 // HTTPS connections.
 func (opts *ServerOptions) SupportsTLS() bool {
-	return opts.Cert != "" && opts.Key != ""
+	return len(opts.CertificatePairs()) > 0
+}
+
+// Gives every certificate pair to serve over TLS, starting with `Cert` and `Key`
+// if they are given, followed by `Certificates`.
+func (opts *ServerOptions) CertificatePairs() []CertificatePair {
+	pairs := []CertificatePair{}
+
+	if opts.Cert != "" && opts.Key != "" {
+		pairs = append(pairs, CertificatePair{opts.Cert, opts.Key})
+	}
+
+	return append(pairs, opts.Certificates...)
+}
+
+// Gives the address to serve plain HTTP on, and false if HTTP should not be
+// served.
+func (opts *ServerOptions) HttpAddr() (string, bool) {
+	switch {
+	case opts.HttpPort > 0:
+		return opts.bindTo(strconv.FormatInt(int64(opts.HttpPort), 10)), true
+	case opts.HttpPort < 0:
+		return "", false
+	case opts.Port > 0 && opts.SupportsTLS() && opts.HttpsPort == 0:
+		// The port is taken by HTTPS.
+		return "", false
+	case opts.Port > 0:
+		return opts.bindTo(strconv.FormatInt(int64(opts.Port), 10)), true
+	default:
+		return opts.bindTo("http"), true
+	}
+}
+
+// Gives the address to serve HTTPS on, and false if HTTPS should not be served.
+func (opts *ServerOptions) HttpsAddr() (string, bool) {
+	switch {
+	case !opts.SupportsTLS() || opts.HttpsPort < 0:
+		return "", false
+	case opts.HttpsPort > 0:
+		return opts.bindTo(strconv.FormatInt(int64(opts.HttpsPort), 10)), true
+	case opts.Port > 0:
+		return opts.bindTo(strconv.FormatInt(int64(opts.Port), 10)), true
+	default:
+		return opts.bindTo("https"), true
+	}
+}
+
+// Gives the address of the given port on the configured bind address.
+func (opts *ServerOptions) bindTo(port string) string {
+	host := strings.TrimSuffix(strings.TrimPrefix(opts.BindAddress, "["), "]")
+	return net.JoinHostPort(host, port)
+}
+
+// Returns true if the config has the needed fields populated to protect paths
+// with an OpenID Connect login.
+func (opts *ServerOptions) SupportsOidc() bool {
+	return opts.OidcIssuer != "" && opts.OidcClientId != "" && opts.OidcRedirectUrl != "" && len(opts.OidcPrefixes) > 0
+}
+
+// Returns true if requests for the given URL path need an OpenID Connect login,
+// a client certificate, or a signature.
+func (opts *ServerOptions) Protects(urlPath string) bool {
+	return hasAnyPrefix(urlPath, opts.protectedPrefixes())
+}
+
+// Gives the URL path prefixes that need an OpenID Connect login, a client
+// certificate, or a signature.
+func (opts *ServerOptions) protectedPrefixes() []string {
+	prefixes := []string{}
+
+	if opts.SupportsOidc() {
+		prefixes = append(prefixes, opts.OidcPrefixes...)
+	}
+
+	if opts.ClientCA != "" && len(opts.ClientAuthPaths) == 0 {
+		prefixes = append(prefixes, "/")
+	} else if opts.ClientCA != "" {
+		prefixes = append(prefixes, opts.ClientAuthPaths...)
+	}
+
+	if opts.SignedUrlKey != "" {
+		prefixes = append(prefixes, opts.SignedPrefixes...)
+	}
+
+	return prefixes
 }
 
 // Replaces appropriate fields with default values.
@@ -294,7 +2393,7 @@ func (opts *ServerOptions) checkForDefaults() {
 		opts.Site = DefaultSitePath
 	}
 
-	if opts.Site[len(opts.Site)-1] != '/' {
+	if opts.Site[len(opts.Site)-1] != '/' && !IsArchive(opts.Site) {
 		opts.Site += "/"
 	}
 }
@@ -0,0 +1,123 @@
+// Copyright (c) 2024 Evan Overman (https://an-prata.it).
+// Licensed under the MIT License.
+// See LICENSE file in repository root for complete license text.
+
+package server
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"reflect"
+	"strings"
+)
+
+// Source of the `ServerOptions` type, whose field comments document each option
+// in generated configs. Reading them from the source keeps generated configs in
+// step with the comments rather than with a copy of them.
+//
+//go:embed config.go
+var configSource string
+
+// Formats a config may be generated in, see `ServerOptions.Marshal()`. TOML and
+// YAML are not supported, as parsing them would take webby's first
+// dependencies.
+const (
+	// JSON with a comment describing each option above it.
+	ConfigFormatJsonc = "jsonc"
+
+	// Plain JSON, as `LoadConfigFromPath()` reads it.
+	ConfigFormatJson = "json"
+)
+
+// Gives the doc comment of each field of `ServerOptions` by name.
+func optionDocs() map[string]string {
+	docs := map[string]string{}
+	file, err := parser.ParseFile(token.NewFileSet(), "config.go", configSource, parser.ParseComments)
+
+	if err != nil {
+		return docs
+	}
+
+	ast.Inspect(file, func(node ast.Node) bool {
+		spec, ok := node.(*ast.TypeSpec)
+
+		if !ok || spec.Name.Name != "ServerOptions" {
+			return true
+		}
+
+		for _, field := range spec.Type.(*ast.StructType).Fields.List {
+			for _, name := range field.Names {
+				docs[name.Name] = strings.TrimSpace(field.Doc.Text())
+			}
+		}
+
+		return false
+	})
+
+	return docs
+}
+
+// Gives the options as a config in the given format, see `ConfigFormatJsonc`
+// and `ConfigFormatJson`.
+func (opts *ServerOptions) Marshal(format string) ([]byte, error) {
+	switch format {
+	case ConfigFormatJson:
+		data, err := json.MarshalIndent(opts, "", "    ")
+
+		if err != nil {
+			return nil, errors.New("Failed to parse ServerOptions into JSON: " + err.Error())
+		}
+
+		return data, nil
+	case ConfigFormatJsonc:
+		return opts.marshalDocumented()
+	default:
+		return nil, errors.New("Unknown config format '" + format + "', expected '" + ConfigFormatJsonc + "' or '" + ConfigFormatJson + "'")
+	}
+}
+
+// Gives the options as JSON with the doc comment of each option above it, and
+// a blank line between options.
+func (opts *ServerOptions) marshalDocumented() ([]byte, error) {
+	docs := optionDocs()
+	value := reflect.ValueOf(*opts)
+	var buf bytes.Buffer
+
+	buf.WriteString("{\n")
+
+	for i := 0; i < value.NumField(); i++ {
+		name := value.Type().Field(i).Name
+		data, err := json.MarshalIndent(value.Field(i).Interface(), "    ", "    ")
+
+		if err != nil {
+			return nil, errors.New("Failed to parse option '" + name + "' into JSON: " + err.Error())
+		}
+
+		if i > 0 {
+			buf.WriteString("\n")
+		}
+
+		if doc := docs[name]; doc != "" {
+			for _, line := range strings.Split(doc, "\n") {
+				buf.WriteString(strings.TrimRight("    // "+line, " ") + "\n")
+			}
+		}
+
+		buf.WriteString("    \"" + name + "\": ")
+		buf.Write(data)
+
+		if i < value.NumField()-1 {
+			buf.WriteString(",")
+		}
+
+		buf.WriteString("\n")
+	}
+
+	buf.WriteString("}\n")
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,224 @@
+// Copyright (c) 2024 Evan Overman (https://an-prata.it).
+// Licensed under the MIT License.
+// See LICENSE file in repository root for complete license text.
+
+package server
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Tasks a `CronJob` may run.
+const (
+	// Reloads the certificates served over HTTPS from disk.
+	TaskReloadCerts = "reload-certs"
+
+	// Warns, and notifies of a "cert-expiry" event, when a certificate served
+	// over HTTPS expires within `CertExpiryWarning`.
+	TaskCheckCerts = "check-certs"
+
+	// Removes every image cached by `ImageResizePrefixes`.
+	TaskPurgeImageCache = "purge-image-cache"
+
+	// Writes the hits of each path to `StatsFile`.
+	TaskStatsDump = "stats-dump"
+
+	// Restarts server instances, mapping their sites again.
+	TaskRestart = "restart"
+
+	// Builds sites from their `BuildSource` and restarts their instances.
+	TaskBuild = "build"
+
+	// Runs the job's `Command`.
+	TaskCommand = "command"
+)
+
+// Every task a `CronJob` may run.
+var CronTasks = []string{TaskReloadCerts, TaskCheckCerts, TaskPurgeImageCache, TaskStatsDump, TaskRestart, TaskBuild, TaskCommand}
+
+// How long before a certificate expires that `TaskCheckCerts` warns of it.
+const CertExpiryWarning = 14 * 24 * time.Hour
+
+// A recurring job run by the daemon, see `ServerOptions.Cron`.
+type CronJob struct {
+	// Name the job is logged and listed by.
+	Name string
+
+	// When the job runs, see `ParseCronSchedule()`.
+	Schedule string
+
+	// One of `CronTasks`.
+	Task string
+
+	// Shell command run by "command" jobs.
+	Command string
+
+	// Server instance the job applies to, empty for all of them.
+	Instance string
+}
+
+// When a job runs, either every interval or at the minutes matched by five cron
+// fields.
+type CronSchedule struct {
+	every time.Duration
+
+	// Bits set for each minute, hour, day of the month, month, and day of the
+	// week matched.
+	minutes, hours, days, months, weekdays uint64
+
+	// Like cron, a day matches either the day of the month or the day of the week
+	// when both are restricted, and both otherwise.
+	anyDay, anyWeekday bool
+}
+
+// Shorthands for common schedules.
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// Parses a schedule of five cron fields, the minute, hour, day of the month,
+// month, and day of the week (0 or 7 for Sunday), e.g. "30 3 * * 1-5". Each
+// field is "*", a number, a range such as "1-5", or a list of them such as
+// "0,30", any of which may be followed by a step such as "*/15". The shorthands
+// "@hourly", "@daily", "@weekly", "@monthly", and "@yearly" are accepted, as is
+// "@every" followed by a duration, e.g. "@every 10m".
+func ParseCronSchedule(expr string) (CronSchedule, error) {
+	expr = strings.TrimSpace(expr)
+
+	if interval, found := strings.CutPrefix(expr, "@every "); found {
+		every, err := time.ParseDuration(strings.TrimSpace(interval))
+
+		if err != nil || every < time.Second {
+			return CronSchedule{}, errors.New("Expected a duration of at least a second after '@every', not '" + interval + "'")
+		}
+
+		return CronSchedule{every: every}, nil
+	}
+
+	if macro, ok := cronMacros[expr]; ok {
+		expr = macro
+	}
+
+	fields := strings.Fields(expr)
+
+	if len(fields) != 5 {
+		return CronSchedule{}, errors.New("Expected five fields in schedule '" + expr + "'")
+	}
+
+	var schedule CronSchedule
+	var err error
+	bounds := [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+	sets := [5]*uint64{&schedule.minutes, &schedule.hours, &schedule.days, &schedule.months, &schedule.weekdays}
+
+	for i, field := range fields {
+		if *sets[i], err = parseCronField(field, bounds[i][0], bounds[i][1]); err != nil {
+			return CronSchedule{}, errors.New("Could not parse schedule '" + expr + "': " + err.Error())
+		}
+	}
+
+	// Sunday may be given as 7.
+	if schedule.weekdays&(1<<7) != 0 {
+		schedule.weekdays |= 1
+	}
+
+	schedule.anyDay = strings.HasPrefix(fields[2], "*")
+	schedule.anyWeekday = strings.HasPrefix(fields[4], "*")
+	return schedule, nil
+}
+
+// Gives the bits set for each value matched by a cron field within the given
+// bounds.
+func parseCronField(field string, min, max int) (uint64, error) {
+	var set uint64
+
+	for _, part := range strings.Split(field, ",") {
+		span, stepText, stepped := strings.Cut(part, "/")
+		step := 1
+		low, high := min, max
+
+		if stepped {
+			var err error
+
+			if step, err = strconv.Atoi(stepText); err != nil || step < 1 {
+				return 0, errors.New("invalid step '" + stepText + "'")
+			}
+		}
+
+		if span != "*" {
+			from, to, ranged := strings.Cut(span, "-")
+			var err error
+
+			if low, err = strconv.Atoi(from); err != nil {
+				return 0, errors.New("invalid value '" + from + "'")
+			}
+
+			high = low
+
+			if ranged {
+				if high, err = strconv.Atoi(to); err != nil {
+					return 0, errors.New("invalid value '" + to + "'")
+				}
+			} else if stepped {
+				high = max
+			}
+		}
+
+		if low < min || high > max || low > high {
+			return 0, errors.New("'" + part + "' is not within " + strconv.Itoa(min) + "-" + strconv.Itoa(max))
+		}
+
+		for value := low; value <= high; value += step {
+			set |= 1 << value
+		}
+	}
+
+	return set, nil
+}
+
+// Gives the first time after the given one that the schedule runs at, or the
+// zero time if it never does, e.g. "0 0 30 2 *".
+func (s CronSchedule) Next(after time.Time) time.Time {
+	if s.every > 0 {
+		return after.Add(s.every)
+	}
+
+	t := time.Date(after.Year(), after.Month(), after.Day(), after.Hour(), after.Minute()+1, 0, 0, after.Location())
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if s.months&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		} else if !s.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		} else if s.hours&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		} else if s.minutes&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+		} else {
+			return t
+		}
+	}
+
+	return time.Time{}
+}
+
+// Returns true if the schedule runs on the day of the given time.
+func (s CronSchedule) matchesDay(t time.Time) bool {
+	day := s.days&(1<<uint(t.Day())) != 0
+	weekday := s.weekdays&(1<<uint(t.Weekday())) != 0
+
+	if s.anyDay || s.anyWeekday {
+		return day && weekday
+	}
+
+	return day || weekday
+}
@@ -0,0 +1,115 @@
+// Copyright (c) 2024 Evan Overman (https://an-prata.it).
+// Licensed under the MIT License.
+// See LICENSE file in repository root for complete license text.
+
+package server
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/an-prata/webby/logger"
+)
+
+// Ways of giving a dead response, see `Handler.SetDeadResponseMode()`.
+const (
+	// Redirects the client back onto itself for the same path.
+	DeadRedirect = "redirect"
+
+	// Closes the connection without responding, like nginx's 444.
+	DeadDrop = "drop"
+
+	// Responds with 410 Gone.
+	DeadGone = "gone"
+
+	// Responds one byte at a time, slowly, see `Handler.SetTarpit()`.
+	DeadTarpit = "tarpit"
+)
+
+// A dead path given as a pattern, see `isDeadPattern()`.
+type deadPattern struct {
+	pattern string
+	re      *regexp.Regexp
+}
+
+// Gives whether the given dead path is a pattern rather than an exact path, a
+// regular expression if it starts with "~" or otherwise a wildcard if it has a
+// "*".
+func isDeadPattern(deadPath string) bool {
+	return strings.HasPrefix(deadPath, "~") || strings.Contains(deadPath, "*")
+}
+
+// Compiles a dead path pattern, see `isDeadPattern()`. Regular expressions are
+// matched anywhere in the path unless anchored, and each "*" of a wildcard
+// matches any run of characters, including "/", e.g. "/wp-admin/*" or "*.php".
+func compileDeadPattern(pattern string) (*regexp.Regexp, error) {
+	if expr, ok := strings.CutPrefix(pattern, "~"); ok {
+		re, err := regexp.Compile(expr)
+
+		if err != nil {
+			return nil, errors.New("Could not compile dead path '" + pattern + "': " + err.Error())
+		}
+
+		return re, nil
+	}
+
+	parts := strings.Split(pattern, "*")
+
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+
+	return regexp.MustCompile("^" + strings.Join(parts, ".*") + "$"), nil
+}
+
+// Sets how dead responses are given, one of `DeadRedirect`, `DeadDrop`,
+// `DeadGone`, or `DeadTarpit`. An empty mode redirects.
+func (h *Handler) SetDeadResponseMode(mode string) {
+	switch strings.ToLower(mode) {
+	case "", DeadRedirect:
+		h.deadMode = DeadRedirect
+	case DeadDrop, DeadGone, DeadTarpit:
+		logger.GlobalLog.LogInfo("Giving dead responses by '" + strings.ToLower(mode) + "'")
+		h.deadMode = strings.ToLower(mode)
+	default:
+		logger.GlobalLog.LogWarn("Unknown dead response mode '" + mode + "', expected 'redirect', 'drop', 'gone', or 'tarpit'")
+		h.deadMode = DeadRedirect
+	}
+}
+
+// Gives whether the given URL path matches a dead path pattern.
+func (h *Handler) isDeadPath(uriPath string) bool {
+	for _, pattern := range h.deadPatterns {
+		if pattern.re.MatchString(uriPath) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Gives a dead response to the request in the handler's dead response mode.
+func (h *Handler) deadRespond(w http.ResponseWriter, req *http.Request) {
+	logger.GlobalLog.LogInfo("Dead responding to request from '" + req.RemoteAddr + "'")
+	h.strike(req)
+
+	switch h.deadMode {
+	case DeadDrop:
+		dropConnection()
+	case DeadGone:
+		http.Error(w, http.StatusText(http.StatusGone), http.StatusGone)
+	case DeadTarpit:
+		h.tarpit(w, req)
+	default:
+		http.Redirect(w, req, "http://localhost"+req.URL.Path, http.StatusMovedPermanently)
+	}
+}
+
+// Closes the connection of the current request without responding, or resets
+// its stream over HTTP/2, by aborting the handler. The server does not log a
+// stack trace for this.
+func dropConnection() {
+	panic(http.ErrAbortHandler)
+}
@@ -0,0 +1,205 @@
+// Copyright (c) 2024 Evan Overman (https://an-prata.it).
+// Licensed under the MIT License.
+// See LICENSE file in repository root for complete license text.
+
+package server
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/an-prata/webby/logger"
+)
+
+// Suffix of the symbolic link beside `Site` pointing at the release deployed
+// before the current one, e.g. "/srv/webby/website.previous".
+const previousReleaseSuffix = ".previous"
+
+// Deploys the given release directory by atomically pointing `Site`, which must
+// be a symbolic link or not yet exist, at it. The release is first served once
+// by a server with the same options, and is not deployed if any of its paths
+// give a server error or its root cannot be served. The release `Site` pointed
+// at before is kept for `RollbackSite()`.
+//
+// Running servers keep serving the release they mapped until restarted, so
+// that a deploy never serves a mix of two releases.
+func DeploySite(opts ServerOptions, release string) error {
+	link, current, err := siteLink(opts)
+
+	if err != nil {
+		return err
+	}
+
+	if release, err = filepath.Abs(release); err != nil {
+		return err
+	}
+
+	if stat, err := os.Stat(release); err != nil || !stat.IsDir() {
+		return errors.New("Release '" + release + "' is not a directory")
+	}
+
+	if filepath.Clean(release) == filepath.Clean(current) {
+		return errors.New("Release '" + release + "' is already deployed to '" + opts.Name + "'")
+	}
+
+	if err = checkRelease(opts, release); err != nil {
+		return err
+	}
+
+	if err = swapSymlink(link, release); err != nil {
+		return err
+	}
+
+	if current != "" {
+		if err = swapSymlink(link+previousReleaseSuffix, current); err != nil {
+			logger.GlobalLog.LogWarn("Deployed '" + release + "' but could not keep '" + current + "' for rolling back: " + err.Error())
+		}
+	}
+
+	logger.GlobalLog.LogInfo("Deployed '" + release + "' to '" + link + "'")
+	return nil
+}
+
+// Points `Site` back at the release deployed before the current one, see
+// `DeploySite()`. Rolling back again returns to the current release.
+func RollbackSite(opts ServerOptions) error {
+	link, current, err := siteLink(opts)
+
+	if err != nil {
+		return err
+	}
+
+	previous, err := os.Readlink(link + previousReleaseSuffix)
+
+	if err != nil {
+		return errors.New("No previous release of '" + opts.Name + "' to roll back to")
+	}
+
+	if stat, err := os.Stat(previous); err != nil || !stat.IsDir() {
+		return errors.New("Previous release '" + previous + "' no longer exists")
+	}
+
+	if err = swapSymlink(link, previous); err != nil {
+		return err
+	}
+
+	if current != "" {
+		if err = swapSymlink(link+previousReleaseSuffix, current); err != nil {
+			return err
+		}
+	}
+
+	logger.GlobalLog.LogInfo("Rolled '" + link + "' back to '" + previous + "'")
+	return nil
+}
+
+// Gives the path of the symbolic link at `Site` and the release it points at,
+// or an empty release if the link does not yet exist.
+func siteLink(opts ServerOptions) (string, string, error) {
+	opts.checkForDefaults()
+
+	if opts.S3Bucket != "" || IsArchive(opts.Site) {
+		return "", "", errors.New("Cannot deploy to '" + opts.Name + "', which serves its site from an archive or bucket")
+	}
+
+	link := filepath.Clean(opts.Site)
+	stat, err := os.Lstat(link)
+
+	if errors.Is(err, fs.ErrNotExist) {
+		return link, "", nil
+	}
+
+	if err != nil {
+		return "", "", err
+	}
+
+	if stat.Mode()&fs.ModeSymlink == 0 {
+		return "", "", errors.New("'Site' of '" + opts.Name + "' must be a symbolic link to deploy releases, '" + link + "' is not")
+	}
+
+	current, err := os.Readlink(link)
+
+	if err != nil {
+		return "", "", errors.New("Could not read link '" + link + "': " + err.Error())
+	}
+
+	if !filepath.IsAbs(current) {
+		current = filepath.Join(filepath.Dir(link), current)
+	}
+
+	return link, current, nil
+}
+
+// Serves every path of the given release once with the given options, giving
+// an error if any gives a server error or if the root of the site cannot be
+// served.
+func checkRelease(opts ServerOptions, release string) error {
+	opts.Site = release + "/"
+	srv, err := NewServer(opts)
+
+	if err != nil {
+		return errors.New("Could not serve release '" + release + "': " + err.Error())
+	}
+
+	handler := srv.ReqHandler
+	defer handler.Close()
+
+	for _, uriPath := range handler.ValidPaths {
+		req := httptest.NewRequest(http.MethodGet, uriPath, nil)
+		req.RemoteAddr = "127.0.0.1:0"
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+
+		// Paths are expected to be unavailable during maintenance.
+		if recorder.Code == http.StatusServiceUnavailable && handler.Maintenance() {
+			continue
+		}
+
+		if recorder.Code >= 500 || (uriPath == "/" && recorder.Code >= 400) {
+			return errors.New("Release '" + release + "' gave " + strconv.Itoa(recorder.Code) + " for '" + uriPath + "', not deploying it")
+		}
+	}
+
+	return nil
+}
+
+// Atomically points the symbolic link at the given path at the target,
+// creating it if it does not exist.
+func swapSymlink(link, target string) error {
+	temp := link + ".deploying"
+	os.Remove(temp)
+
+	if err := os.Symlink(target, temp); err != nil {
+		return errors.New("Could not link '" + temp + "': " + err.Error())
+	}
+
+	if err := os.Rename(temp, link); err != nil {
+		os.Remove(temp)
+		return errors.New("Could not replace '" + link + "': " + err.Error())
+	}
+
+	return nil
+}
+
+// Gives the release the given site directory links to, ending with a "/" as
+// the site directory does, so that a server keeps serving one release even if
+// the link changes while it runs. Gives the directory unchanged if it is not a
+// symbolic link.
+func resolveRelease(site string) string {
+	if stat, err := os.Lstat(filepath.Clean(site)); err != nil || stat.Mode()&fs.ModeSymlink == 0 {
+		return site
+	}
+
+	release, err := filepath.EvalSymlinks(site)
+
+	if err != nil {
+		return site
+	}
+
+	return release + "/"
+}
@@ -0,0 +1,159 @@
+// Copyright (c) 2024 Evan Overman (https://an-prata.it).
+// Licensed under the MIT License.
+// See LICENSE file in repository root for complete license text.
+
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/an-prata/webby/logger"
+)
+
+// Updates the site when a Git host notifies it of a push, see
+// `Handler.SetDeployHook()`.
+type deployHook struct {
+	path   string
+	secret string
+
+	// Command run to update the site and its arguments.
+	command []string
+
+	// Directory the command is run in.
+	dir string
+
+	// Tells the server to restart once the site is updated, see `Handler.rescans`.
+	rescans chan struct{}
+
+	// Held while the command runs, so that pushes in quick succession update the
+	// site one at a time.
+	mutex sync.Mutex
+}
+
+// Largest webhook request body read, GitHub sends at most 25 MiB.
+const deployHookMaxBytes = 25 << 20
+
+// Longest the deploy command may run before it is killed.
+const deployHookTimeout = 5 * time.Minute
+
+// Command run by the deploy hook when none is given.
+var defaultDeployCommand = []string{"git", "pull", "--ff-only"}
+
+// Accepts webhooks from GitHub, GitLab, or Gitea at the given URL path, e.g.
+// "/_webby/deploy", running the given command in the given directory and then
+// restarting the server to map the site again. Without a command `git pull
+// --ff-only` is run. Requests must be signed with the given secret, as GitHub
+// and Gitea do, or give it as GitLab does, so an empty path or secret leaves the
+// hook disabled. The site is only mapped again for servers started with
+// `Server.StartThreaded()`.
+func (h *Handler) SetDeployHook(path, secret string, command []string, dir string) {
+	h.deployHook = nil
+
+	if path == "" {
+		return
+	}
+
+	if secret == "" {
+		logger.GlobalLog.LogWarn("Ignoring 'DeployHookPath', which needs a 'DeployHookSecret' to authenticate webhooks")
+		return
+	}
+
+	if len(command) == 0 {
+		command = defaultDeployCommand
+	}
+
+	logger.GlobalLog.LogInfo("Accepting deploy webhooks at '" + path + "' to run '" + strings.Join(command, " ") + "' in '" + dir + "'")
+	h.deployHook = &deployHook{path, secret, command, dir, h.rescans, sync.Mutex{}}
+}
+
+func (d *deployHook) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, req.Body, deployHookMaxBytes))
+
+	if err != nil {
+		http.Error(w, "Could not read request body", http.StatusBadRequest)
+		return
+	}
+
+	if !d.authorized(req, body) {
+		logger.GlobalLog.LogWarn("Refused deploy webhook without a valid signature from " + req.RemoteAddr)
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	// Sent by GitHub and Gitea when a webhook is created.
+	if event := req.Header.Get("X-GitHub-Event") + req.Header.Get("X-Gitea-Event"); event == "ping" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	// Git hosts give up on webhooks that take more than a few seconds, so the
+	// site is updated after responding.
+	go d.deploy()
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// Returns true if the request is signed with the secret in GitHub's or Gitea's
+// signature header, or gives the secret in GitLab's token header.
+func (d *deployHook) authorized(req *http.Request, body []byte) bool {
+	if token := req.Header.Get("X-Gitlab-Token"); token != "" {
+		return subtle.ConstantTimeCompare([]byte(token), []byte(d.secret)) == 1
+	}
+
+	signature := strings.TrimPrefix(req.Header.Get("X-Hub-Signature-256"), "sha256=")
+
+	if signature == "" {
+		signature = req.Header.Get("X-Gitea-Signature")
+	}
+
+	given, err := hex.DecodeString(signature)
+
+	if err != nil || len(given) == 0 {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(d.secret))
+	mac.Write(body)
+	return hmac.Equal(given, mac.Sum(nil))
+}
+
+// Runs the deploy command and tells the server to restart if it succeeds.
+func (d *deployHook) deploy() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), deployHookTimeout)
+	defer cancel()
+
+	logger.GlobalLog.LogInfo("Deploy webhook received, running '" + strings.Join(d.command, " ") + "'...")
+	cmd := exec.CommandContext(ctx, d.command[0], d.command[1:]...)
+	cmd.Dir = d.dir
+	output, err := cmd.CombinedOutput()
+
+	if err != nil {
+		logger.GlobalLog.LogErr("Deploy command failed: " + err.Error() + ": " + strings.TrimSpace(string(output)))
+		return
+	}
+
+	logger.GlobalLog.LogInfo("Deploy command finished: " + strings.TrimSpace(string(output)))
+
+	select {
+	case d.rescans <- struct{}{}:
+	default:
+	}
+}
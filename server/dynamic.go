@@ -0,0 +1,103 @@
+// Copyright (c) 2024 Evan Overman (https://an-prata.it).
+// Licensed under the MIT License.
+// See LICENSE file in repository root for complete license text.
+
+package server
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/an-prata/webby/logger"
+)
+
+// A directory whose files are resolved when requested, rather than mapped ahead
+// of time, under a URL prefix ending with "/".
+type dynamicRoot struct {
+	prefix string
+	dir    string
+}
+
+// Serves each directory under the URL prefix it is given by, as
+// `Handler.MapDirAt()` would, but resolves request paths against the directories
+// as requests arrive instead of mapping their files now. Files added later are
+// served without a restart. Where prefixes overlap the longest takes priority,
+// falling back to shorter ones for files it lacks.
+func (h *Handler) SetDynamicRoots(roots map[string]string) {
+	h.dynamicRoots = []dynamicRoot{}
+
+	for prefix, dir := range roots {
+		prefix = "/" + strings.Trim(prefix, "/")
+
+		if prefix != "/" {
+			prefix += "/"
+		}
+
+		h.dynamicRoots = append(h.dynamicRoots, dynamicRoot{prefix, dir})
+		h.ValidPaths = append(h.ValidPaths, prefix)
+		logger.GlobalLog.LogInfo("Resolving URIs under '" + prefix + "' against '" + dir + "' when requested")
+	}
+
+	sort.Slice(h.dynamicRoots, func(i, j int) bool {
+		return len(h.dynamicRoots[i].prefix) > len(h.dynamicRoots[j].prefix)
+	})
+}
+
+// Gives the file that the given URL path is mapped or resolved to, see
+// `Handler.SetDynamicRoots()`.
+func (h *Handler) fileFor(uriPath string) (string, bool) {
+	if file, ok := h.PathMap[uriPath]; ok {
+		return file, true
+	}
+
+	return h.resolve(uriPath)
+}
+
+// Resolves the given URL path against the dynamic roots. The path is cleaned
+// first so that it cannot refer outside of a root. Directories resolve to their
+// "index.html", whether or not it exists, with or without a trailing slash, as
+// they are mapped by `Handler.MapDirAt()`.
+func (h *Handler) resolve(uriPath string) (string, bool) {
+	if len(h.dynamicRoots) == 0 || strings.ContainsRune(uriPath, 0) {
+		return "", false
+	}
+
+	cleaned := path.Clean("/" + uriPath)
+
+	for _, root := range h.dynamicRoots {
+		rel, ok := strings.CutPrefix(strings.TrimSuffix(cleaned, "/")+"/", root.prefix)
+
+		if !ok {
+			continue
+		}
+
+		file := filepath.Join(root.dir, filepath.FromSlash(rel))
+		dir := filepath.Clean(root.dir)
+
+		if file != dir && !strings.HasPrefix(file, dir+string(filepath.Separator)) {
+			continue
+		}
+
+		stat, err := os.Stat(file)
+
+		if err != nil {
+			continue
+		}
+
+		if stat.IsDir() {
+			return filepath.Join(file, "index.html"), true
+		}
+
+		// Only directories are served with a trailing slash.
+		if strings.HasSuffix(uriPath, "/") {
+			continue
+		}
+
+		return file, true
+	}
+
+	return "", false
+}
@@ -0,0 +1,121 @@
+// Copyright (c) 2024 Evan Overman (https://an-prata.it).
+// Licensed under the MIT License.
+// See LICENSE file in repository root for complete license text.
+
+package server
+
+import (
+	"io/fs"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// A content encoding that files may be precompressed with.
+type contentEncoding struct {
+	// Token used in the Accept-Encoding and Content-Encoding headers.
+	Token string
+
+	// Extension of precompressed sibling files, e.g. "style.css.zst".
+	Extension string
+}
+
+// Encodings of precompressed siblings, in order of preference.
+var precompressedEncodings = []contentEncoding{
+	{"zstd", ".zst"},
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+// Returns true if the given Accept-Encoding header value accepts the given
+// encoding token, either by name or by wildcard, with a non-zero quality.
+func acceptsEncoding(header, token string) bool {
+	accepted := false
+
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(fields[0]))
+
+		if name != token && name != "*" {
+			continue
+		}
+
+		quality := 1.0
+
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					quality = q
+				}
+			}
+		}
+
+		// An explicit entry for the encoding overrides a wildcard.
+		if name == token {
+			return quality > 0
+		}
+
+		accepted = quality > 0
+	}
+
+	return accepted
+}
+
+// Finds a precompressed sibling of the given file that the request accepts.
+// Returns the sibling's path and encoding, and false if there is none.
+func precompressedFor(fsys fs.FS, req *http.Request, file string) (string, contentEncoding, bool) {
+	header := req.Header.Get("Accept-Encoding")
+
+	for _, encoding := range precompressedEncodings {
+		if !acceptsEncoding(header, encoding.Token) {
+			continue
+		}
+
+		if stat, err := fs.Stat(fsys, file+encoding.Extension); err == nil && !stat.IsDir() {
+			return file + encoding.Extension, encoding, true
+		}
+	}
+
+	return "", contentEncoding{}, false
+}
+
+// Sets the headers needed for a response encoded with the given encoding to be
+// treated as the given original file.
+func setEncodedHeaders(w http.ResponseWriter, file string, encoding contentEncoding) {
+	// The type must be given explicitly or it would be sniffed from the encoded
+	// content.
+	contentType := mime.TypeByExtension(filepath.Ext(file))
+
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Encoding", encoding.Token)
+}
+
+// Sets the Content-Length of full responses of encoded files, which
+// `http.ServeContent()` leaves out when a Content-Encoding is set in case the
+// content is being encoded as it is written. Precompressed files are already
+// encoded, so their length is known.
+type encodedLengthWriter struct {
+	http.ResponseWriter
+	length int64
+}
+
+func (w *encodedLengthWriter) WriteHeader(status int) {
+	if status == http.StatusOK && w.Header().Get("Content-Length") == "" {
+		w.Header().Set("Content-Length", strconv.FormatInt(w.length, 10))
+	}
+
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Allows `http.ResponseController` to reach the underlying writer.
+func (w *encodedLengthWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
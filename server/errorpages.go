@@ -0,0 +1,73 @@
+// Copyright (c) 2024 Evan Overman (https://an-prata.it).
+// Licensed under the MIT License.
+// See LICENSE file in repository root for complete license text.
+
+package server
+
+import (
+	"io/fs"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+
+	"github.com/an-prata/webby/logger"
+)
+
+// Serves the pages of the site at the given URI paths in place of the default
+// responses for each status code, e.g. {"404": "/errors/404.html"}. Pages must
+// be mapped, or their directories set as dynamic roots, before calling this
+// function.
+func (h *Handler) AddErrorPages(pages map[string]string) {
+	for code, page := range pages {
+		status, err := strconv.Atoi(code)
+
+		if err != nil || status < 400 || status > 599 {
+			logger.GlobalLog.LogWarn("Ignoring error page for '" + code + "', expected a 4xx or 5xx status code")
+			continue
+		}
+
+		if _, ok := h.fileFor(page); !ok {
+			logger.GlobalLog.LogWarn("Ignoring error page '" + page + "' for " + code + ", no file is mapped to it")
+			continue
+		}
+
+		logger.GlobalLog.LogInfo("Mapped status " + code + " to error page '" + page + "'")
+		h.errorPages[status] = page
+	}
+}
+
+// Responds with the given error status, using its error page if one is set.
+func (h *Handler) serveError(w http.ResponseWriter, req *http.Request, status int) {
+	if page, ok := h.errorPages[status]; ok {
+		file, _ := h.fileFor(page)
+		content, err := fs.ReadFile(h.fsys, file)
+
+		if err == nil {
+			contentType := mime.TypeByExtension(filepath.Ext(file))
+
+			if contentType == "" {
+				contentType = http.DetectContentType(content)
+			}
+
+			w.Header().Set("Content-Type", contentType)
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+			w.WriteHeader(status)
+
+			if req.Method != http.MethodHead {
+				w.Write(content)
+			}
+
+			return
+		}
+
+		logger.GlobalLog.LogErr("Could not read error page '" + file + "': " + err.Error())
+	}
+
+	if status == http.StatusNotFound {
+		http.NotFound(w, req)
+		return
+	}
+
+	http.Error(w, http.StatusText(status), status)
+}
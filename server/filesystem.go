@@ -0,0 +1,63 @@
+// Copyright (c) 2024 Evan Overman (https://an-prata.it).
+// Licensed under the MIT License.
+// See LICENSE file in repository root for complete license text.
+
+package server
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+)
+
+// An `fs.FS` that opens operating system paths as given, without the path
+// validation of `os.DirFS()`, so that file paths mapped by `Handler.MapDir()`
+// may be used unchanged.
+type osFS struct{}
+
+func (osFS) Open(name string) (fs.File, error) {
+	return os.Open(name)
+}
+
+func (osFS) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (osFS) ReadFile(name string) ([]byte, error) {
+	return os.ReadFile(name)
+}
+
+// Serves the named file from the given filesystem. Files that cannot seek are
+// read into memory to support range requests.
+func serveFromFS(w http.ResponseWriter, req *http.Request, fsys fs.FS, name string) {
+	file, err := fsys.Open(name)
+
+	if err != nil {
+		http.NotFound(w, req)
+		return
+	}
+
+	defer file.Close()
+	stat, err := file.Stat()
+
+	if err != nil || stat.IsDir() {
+		http.NotFound(w, req)
+		return
+	}
+
+	if seeker, ok := file.(io.ReadSeeker); ok {
+		http.ServeContent(w, req, name, stat.ModTime(), seeker)
+		return
+	}
+
+	content, err := io.ReadAll(file)
+
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	http.ServeContent(w, req, name, stat.ModTime(), bytes.NewReader(content))
+}
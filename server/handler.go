@@ -5,12 +5,21 @@
 package server
 
 import (
+	"crypto/sha256"
 	"errors"
+	"fmt"
+	"io"
 	"io/fs"
+	"mime"
+	"net"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
+	"runtime/debug"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/an-prata/webby/logger"
 )
@@ -29,27 +38,275 @@ type Handler struct {
 	// Whether or not the handler should automatically redirect HTTP requests to an
 	// equivilant HTTPS URL.
 	redirectHttp bool
+
+	// Port HTTP requests are redirected to, empty for the default HTTPS port.
+	redirectPort string
+
+	// Requires an OpenID Connect login for protected paths, may be nil.
+	oidc *oidcAuth
+
+	// Path prefixes only served to clients with a verified certificate.
+	clientAuthPrefixes []string
+
+	// Path prefixes only served to requests signed with `signedKey`.
+	signedPrefixes []string
+	signedKey      string
+
+	// Rules for serving files as attachments, see `Handler.AddAttachmentRules()`.
+	attachments map[string]string
+
+	// Whether or not to serve precompressed siblings of files.
+	precompressed bool
+
+	// Minifies text assets as they are served, may be nil.
+	minifier *minifier
+
+	// Renders files of one extension as templates, nil if disabled, see
+	// `Handler.SetTemplates()`.
+	templates *templater
+
+	// Whether or not to serve modern format siblings of images.
+	modernImages bool
+
+	// Assets to preload by URL path, and whether to send them as early hints.
+	preloads   map[string][]string
+	earlyHints bool
+
+	// Filesystem that mapped files are served from, the operating system's unless
+	// another has been mapped.
+	fsys fs.FS
+
+	// Path to the mapped archive, if any, and a closer for it, which may be nil.
+	archive       string
+	archiveCloser io.Closer
+
+	// Tracks per client IP quotas, may be nil.
+	quota *quotaTracker
+
+	// Bans client IPs that scan for dead paths or missing files, may be nil.
+	bans *banTracker
+
+	// Counts requests and connections for `Stats()`.
+	stats *statsTracker
+
+	// Prefixes proxied to upstream servers, longest first.
+	proxies []proxyRule
+
+	// Compresses responses on the fly, may be nil.
+	compressor *compressor
+
+	// Records every request served, may be nil.
+	accessLog *accessLog
+
+	// Whether or not directories without an "index.html" are listed.
+	autoIndex bool
+
+	// URI paths of pages served in place of default error responses, by status.
+	errorPages map[int]string
+
+	// Whether or not the root "index.html" is served for unmapped GET requests.
+	spaFallback bool
+
+	// Whether or not files are served with an ETag header.
+	etags bool
+
+	// Rules for the Cache-Control header of served files, see
+	// `Handler.AddCacheControl()`.
+	cacheControl map[string]string
+
+	// Headers set on responses by URL prefix, see `Handler.AddHeaders()`.
+	headers map[string]map[string]string
+
+	// Host and trailing slash policy that requests are redirected to, see
+	// `Handler.SetCanonicalHost()` and `Handler.SetCanonicalTrailingSlash()`.
+	canonicalHost string
+	trailingSlash string
+
+	// Time after which a request is logged as a warning, zero for never.
+	slowRequest time.Duration
+
+	// Directories resolved against when requested, longest prefix first, see
+	// `Handler.SetDynamicRoots()`.
+	dynamicRoots []dynamicRoot
+
+	// Whether or not hidden files, those starting with ".", are mapped and served.
+	serveHiddenFiles bool
+
+	// HTTP methods allowed by URL prefix, see `Handler.AddAllowedMethods()`.
+	allowedMethods map[string][]string
+
+	// Handlers, files, dead paths, and middleware given by `Handler.Map()`,
+	// `Handler.MapFile()`, `Handler.AddDeadResponses()`, and `Handler.Use()`,
+	// kept so that they outlast a restart, see `Handler.renewed()`, and the
+	// handler's own serving wrapped in the middleware, nil without any.
+	mapped      map[string]http.Handler
+	mappedFiles map[string]string
+	deadPaths   []string
+	middleware  []func(http.Handler) http.Handler
+	wrapped     http.Handler
+
+	// Dead paths given as patterns rather than exact paths, and how dead
+	// responses are given, see `Handler.SetDeadResponseMode()`.
+	deadPatterns []deadPattern
+	deadMode     string
+
+	// Rules blocking requests by their headers, in order, see
+	// `Handler.AddBlockRules()`.
+	blockRules []blockMatcher
+
+	// Paces tarpit responses, see `Handler.SetTarpit()`.
+	tarpits *tarpitter
+
+	// Largest request body accepted in bytes, zero for no limit, see
+	// `Handler.SetMaxRequestBodyBytes()`.
+	maxBodyBytes int64
+
+	// Bandwidth limits on responses in total and by path prefix, see
+	// `Handler.SetThrottle()`.
+	throttleTotal *rateLimiter
+	throttlePaths []throttlePath
+
+	// Whether in maintenance mode and who is served anyway, see
+	// `Handler.SetMaintenance()`.
+	maintenance *maintenanceRules
+
+	// Serves a directory over WebDAV under a prefix, nil if disabled, see
+	// `Handler.SetWebDav()`.
+	webDav *webDav
+
+	// Accepts file uploads at a path, nil if disabled, see `Handler.SetUpload()`.
+	upload *uploadHandler
+
+	// Languages requests are redirected to the directories of, nil if disabled,
+	// see `Handler.SetLanguages()`.
+	languages *languageRules
+
+	// Resizes images requested with a width, nil if disabled, see
+	// `Handler.SetImageResizing()`.
+	resizer *imageResizer
+
+	// URL path prefixes under which range requests are ignored, see
+	// `Handler.SetNoRangePaths()`.
+	noRangePrefixes []string
+
+	// Filesystem the site is served from in place of `Site`, nil to use `Site`,
+	// see `Handler.SetSiteFS()`.
+	siteFS fs.FS
+
+	// Updates the site when notified of a push, nil if disabled, see
+	// `Handler.SetDeployHook()`.
+	deployHook *deployHook
+
+	// Receives when the site has changed and the server should restart to map it
+	// again, kept so that it outlasts a restart.
+	rescans chan struct{}
 }
 
 // A custom handler that may respond with special or dynamic data rather than a
 // static file.
 type CustomHandler struct {
 	Handler func(http.ResponseWriter, *http.Request)
+
+	// Describes the handler when listing routes, see `Handler.Routes()`, along
+	// with what it serves, e.g. a file path. Both may be empty.
+	Kind   string
+	Target string
 }
 
 // Creates a new Handler, redirecting to HTTPS automatically if directed.
 func NewHandler(redirectHttp bool) *Handler {
 	return &Handler{
-		[]string{},
-		map[string]string{},
-		map[string]http.Handler{},
-		redirectHttp,
+		ValidPaths:         []string{},
+		PathMap:            map[string]string{},
+		handlerMap:         map[string]http.Handler{},
+		redirectHttp:       redirectHttp,
+		clientAuthPrefixes: []string{},
+		signedPrefixes:     []string{},
+		attachments:        map[string]string{},
+		preloads:           map[string][]string{},
+		fsys:               osFS{},
+		stats:              newStatsTracker(),
+		proxies:            []proxyRule{},
+		errorPages:         map[int]string{},
+		cacheControl:       map[string]string{},
+		headers:            map[string]map[string]string{},
+		dynamicRoots:       []dynamicRoot{},
+		allowedMethods:     map[string][]string{},
+		mapped:             map[string]http.Handler{},
+		mappedFiles:        map[string]string{},
+		deadPaths:          []string{},
+		middleware:         []func(http.Handler) http.Handler{},
+		deadPatterns:       []deadPattern{},
+		deadMode:           DeadRedirect,
+		blockRules:         []blockMatcher{},
+		tarpits:            &tarpitter{2 * time.Second, 60 * time.Second, false, 0},
+		throttlePaths:      []throttlePath{},
+		maintenance:        &maintenanceRules{},
+		noRangePrefixes:    []string{},
+		rescans:            make(chan struct{}, 1),
+	}
+}
+
+// Logs the outcome of the given request, as a warning if it was slow to handle.
+func (h *Handler) logRequest(req *http.Request, status int, written int64, duration time.Duration) {
+	// Nothing written means the handler gave an implicit 200 OK.
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	level := logger.Info
+	msg := "Served " + req.Method + " (" + req.Proto + ") from " + req.RemoteAddr + " for " + req.URL.Path
+
+	if h.slowRequest > 0 && duration >= h.slowRequest {
+		level = logger.Warn
+		msg = "Slow request: " + msg
 	}
+
+	logger.GlobalLog.LogFields(level, msg, map[string]interface{}{
+		"status":   status,
+		"bytes":    written,
+		"duration": duration.Round(time.Microsecond).String(),
+	})
+}
+
+// Handles a panic recovered while serving the given request, logging it with a
+// stack trace and counting it in the handler's stats. Responds with 500
+// Internal Server Error if nothing was yet written, otherwise the response is
+// cut short by giving `http.ErrAbortHandler` to abort with, as is any handler
+// that was deliberately aborted, see `dropConnection()`.
+func (h *Handler) recoverPanic(w *statusWriter, req *http.Request, recovered interface{}) interface{} {
+	if recovered == http.ErrAbortHandler {
+		return recovered
+	}
+
+	h.stats.recordPanic()
+	logger.GlobalLog.LogErr(fmt.Sprintf("Recovered from panic serving %s from %s: %v", req.URL.Path, req.RemoteAddr, recovered))
+	logger.GlobalLog.LogErr(string(debug.Stack()))
+
+	if w.status != 0 {
+		w.status = http.StatusInternalServerError
+		return http.ErrAbortHandler
+	}
+
+	h.serveError(w, req, http.StatusInternalServerError)
+	return nil
 }
 
 // Maps the given request URI to a file path. Returns an error if a stat of the
-// given file path fails.
+// given file path fails. The file is mapped again when the server restarts,
+// taking priority over those of the site.
 func (h *Handler) MapFile(uriPath, filePath string) error {
+	if err := h.mapFile(uriPath, filePath); err != nil {
+		return err
+	}
+
+	h.mappedFiles[uriPath] = filePath
+	return nil
+}
+
+// Maps the given request URI to a file path, as `Handler.MapFile()` does,
+// without keeping it for a restart.
+func (h *Handler) mapFile(uriPath, filePath string) error {
 	if _, err := os.Stat(filePath); err != nil {
 		logger.GlobalLog.LogErr("Could not map '" + uriPath + "' to '" + filePath + "' due to failed stat")
 		return errors.New("Could not stat '" + filePath + "'")
@@ -66,9 +323,75 @@ func (h *Handler) MapFile(uriPath, filePath string) error {
 	return nil
 }
 
+// Maps the given URL path to a handler, such as a dynamic endpoint of a program
+// embedding webby. Like other custom handlers it takes priority over a file
+// mapped to the same path, as well as over handlers the server's options map to
+// it, e.g. dead responses. Handlers are kept when the server restarts.
+func (h *Handler) Map(uriPath string, handler http.Handler) {
+	logger.GlobalLog.LogInfo("Mapped URI '" + uriPath + "' to a custom handler")
+	h.mapped[uriPath] = handler
+	h.handlerMap[uriPath] = handler
+}
+
+// Wraps the handler's serving of every request in the given middleware, the
+// first given being outermost. Middleware is kept when the server restarts.
+func (h *Handler) Use(middleware func(http.Handler) http.Handler) {
+	h.middleware = append(h.middleware, middleware)
+	h.wrapped = http.HandlerFunc(h.serveHTTP)
+
+	for i := len(h.middleware) - 1; i >= 0; i-- {
+		h.wrapped = h.middleware[i](h.wrapped)
+	}
+}
+
+// Gives a new handler for a server to restart with, keeping the handlers, files,
+// dead paths, and middleware given to this one, see `Handler.applyKept()`, along
+// with its request stats, quota usage, and bans. Directories and other options
+// are left to be mapped again.
+func (h *Handler) renewed() *Handler {
+	renewed := NewHandler(h.redirectHttp)
+	renewed.mapped = h.mapped
+	renewed.mappedFiles = h.mappedFiles
+	renewed.deadPaths = h.deadPaths
+	renewed.stats = h.stats
+	renewed.quota = h.quota
+	renewed.bans = h.bans
+	renewed.siteFS = h.siteFS
+	renewed.rescans = h.rescans
+
+	for _, middleware := range h.middleware {
+		renewed.Use(middleware)
+	}
+
+	return renewed
+}
+
+// Maps the handlers, files, and dead paths kept by the handler again, over those
+// mapped from a server's options since they were given.
+func (h *Handler) applyKept() {
+	h.addDeadResponses(h.deadPaths)
+
+	for uriPath, filePath := range h.mappedFiles {
+		h.mapFile(uriPath, filePath)
+	}
+
+	for uriPath, handler := range h.mapped {
+		h.handlerMap[uriPath] = handler
+	}
+}
+
 // Map a directory and all subdirectories to paths on the server. All directory
 // roots, when requested, will serve an "index.html" file from that directory.
 func (h *Handler) MapDir(dirPath string) error {
+	return h.MapDirAt("/", dirPath)
+}
+
+// Maps a directory and all subdirectories to paths under the given URL prefix,
+// as `Handler.MapDir()` does at the root. The directory path should end with a
+// "/". Paths already mapped are replaced by those of the directory.
+func (h *Handler) MapDirAt(prefix, dirPath string) error {
+	prefix = strings.TrimSuffix(prefix, "/") + "/"
+
 	err := filepath.WalkDir(dirPath, func(path string, d fs.DirEntry, err error) error {
 		if _, err := os.Stat(path); err != nil {
 			logger.GlobalLog.LogErr("Could not stat '" + path + "'")
@@ -77,15 +400,33 @@ func (h *Handler) MapDir(dirPath string) error {
 
 		path = strings.ReplaceAll(path, dirPath, "")
 
+		if !h.serveHiddenFiles && isHidden("/"+path) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
 		if d.IsDir() {
-			h.PathMap["/"+path] = dirPath + path + "index.html"
-			logger.GlobalLog.LogInfo("Mapped URI '/" + path + "index.html' to file '" + dirPath + path + "'")
+			index := dirPath + path + "index.html"
+
+			// Subdirectories are mapped both with and without a trailing slash.
+			if path != "" {
+				index = dirPath + path + "/index.html"
+				h.PathMap[prefix+path+"/"] = index
+			} else if prefix != "/" {
+				h.PathMap[strings.TrimSuffix(prefix, "/")] = index
+			}
+
+			h.PathMap[prefix+path] = index
+			logger.GlobalLog.LogInfo("Mapped URI '" + prefix + path + "' to file '" + index + "'")
 		} else {
-			h.PathMap["/"+path] = dirPath + path
-			logger.GlobalLog.LogInfo("Mapped URI '/" + path + "' to file '" + dirPath + path + "'")
+			h.PathMap[prefix+path] = dirPath + path
+			logger.GlobalLog.LogInfo("Mapped URI '" + prefix + path + "' to file '" + dirPath + path + "'")
 		}
 
-		h.ValidPaths = append(h.ValidPaths, "/"+path)
+		h.ValidPaths = append(h.ValidPaths, prefix+path)
 		return nil
 	})
 
@@ -96,38 +437,630 @@ func (h *Handler) MapDir(dirPath string) error {
 	return nil
 }
 
-// For each path given a response that redirects the client to the same path but
-// on itself (e.g. "http://localhost/some/dead/path") will be given. This
-// creates a custom handler, adding another custom handler will override this
-// dead response. If a file is mapped to the same path as this dead response
-// then, like other custom handlers, the dead response takes priority.
+// Maps all files in the given filesystem to paths on the server, as
+// `Handler.MapDir()` does for directories, serving them from the filesystem.
+// Any `fs.FS` may be used, such as an `embed.FS`, which may need `fs.Sub()` to
+// remove the directory it was embedded from. Files from the operating system
+// cannot be mapped alongside another filesystem.
+func (h *Handler) MapFS(fsys fs.FS) error {
+	h.fsys = fsys
+
+	err := fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			logger.GlobalLog.LogErr("Could not read '" + path + "' from filesystem")
+			return nil
+		}
+
+		uriPath := "/"
+		filePath := path
+
+		if path != "." {
+			uriPath += path
+		} else {
+			filePath = ""
+		}
+
+		if !h.serveHiddenFiles && isHidden(uriPath) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+
+			return nil
+		}
+
+		if d.IsDir() {
+			if path != "." {
+				uriPath += "/"
+				filePath += "/"
+			}
+
+			h.PathMap[uriPath] = filePath + "index.html"
+			logger.GlobalLog.LogInfo("Mapped URI '" + uriPath + "' to file '" + filePath + "index.html' in filesystem")
+		} else {
+			h.PathMap[uriPath] = filePath
+			logger.GlobalLog.LogInfo("Mapped URI '" + uriPath + "' to file '" + filePath + "' in filesystem")
+		}
+
+		h.ValidPaths = append(h.ValidPaths, uriPath)
+		return nil
+	})
+
+	if err != nil {
+		return errors.New("Could not walk filesystem: " + err.Error())
+	}
+
+	return nil
+}
+
+// Serves the site from the given filesystem rather than from `Site` when given
+// to `NewServerWithHandler()`, e.g. an `embed.FS` of a program embedding webby,
+// which may need `fs.Sub()` to remove the directory it was embedded from. The
+// filesystem is kept when the server restarts. Mounts and `DynamicPaths` are
+// not supported alongside it, as with archives.
+func (h *Handler) SetSiteFS(fsys fs.FS) {
+	h.siteFS = fsys
+}
+
+// Maps all files in the archive at the given path to paths on the server, as
+// `Handler.MapFS()` does, serving them from the archive without extraction.
+func (h *Handler) MapArchive(archivePath string) error {
+	fsys, closer, err := openArchive(archivePath)
+
+	if err != nil {
+		return err
+	}
+
+	h.archive = archivePath
+	h.archiveCloser = closer
+
+	if err = h.MapFS(fsys); err != nil {
+		return errors.New("Could not walk archive '" + archivePath + "'")
+	}
+
+	return nil
+}
+
+// Maps all objects listed in the given bucket to paths on the server, as
+// `Handler.MapDir()` does for directories, with objects named "index.html"
+// also served for their directory. Files from the operating system cannot be
+// mapped alongside a bucket.
+func (h *Handler) MapBucket(fsys *s3FS) {
+	h.fsys = fsys
+
+	for _, name := range fsys.names() {
+		if !h.serveHiddenFiles && isHidden("/"+name) {
+			continue
+		}
+
+		h.PathMap["/"+name] = name
+		h.ValidPaths = append(h.ValidPaths, "/"+name)
+		logger.GlobalLog.LogInfo("Mapped URI '/" + name + "' to object '" + name + "' in bucket")
+
+		if path.Base(name) == "index.html" {
+			dir := "/" + strings.TrimSuffix(name, "index.html")
+			h.PathMap[dir] = name
+			h.ValidPaths = append(h.ValidPaths, dir)
+			logger.GlobalLog.LogInfo("Mapped URI '" + dir + "' to object '" + name + "' in bucket")
+		}
+	}
+}
+
+// Gives the operating system paths of all files served by this handler, for
+// watching for changes. For a mapped archive this is just the archive itself,
+// and for other mapped filesystems there are none.
+func (h *Handler) SourceFiles() []string {
+	if h.archive != "" {
+		return []string{h.archive}
+	}
+
+	if _, ok := h.fsys.(osFS); !ok {
+		return []string{}
+	}
+
+	files := []string{}
+
+	for _, file := range h.PathMap {
+		files = append(files, file)
+	}
+
+	return files
+}
+
+// Releases any archive mapped by the handler and closes its access log. The
+// handler should not be used to serve requests afterwards.
+func (h *Handler) Close() error {
+	var err error
+
+	if h.accessLog != nil {
+		err = h.accessLog.close()
+		h.accessLog = nil
+	}
+
+	if h.archiveCloser != nil {
+		err = h.archiveCloser.Close()
+		h.archiveCloser = nil
+	}
+
+	return err
+}
+
+// Opens an access log at the given path, recording every request served in the
+// given format, which may be "common", "combined", or "json". An empty path
+// disables the access log.
+func (h *Handler) SetAccessLog(path, format string) error {
+	if path == "" {
+		return nil
+	}
+
+	accessLog, err := newAccessLog(path, format)
+
+	if err != nil {
+		return err
+	}
+
+	logger.GlobalLog.LogInfo("Recording access log to '" + path + "' in " + format + " format")
+	h.accessLog = accessLog
+	return nil
+}
+
+// Closes the access log and opens it again at the same path, so that an access
+// log moved away by log rotation is replaced by a new file. Does nothing if
+// there is no access log.
+func (h *Handler) ReopenAccessLog() error {
+	if h.accessLog == nil {
+		return nil
+	}
+
+	return h.accessLog.reopen()
+}
+
+// For each path given a dead response will be given, by default one that
+// redirects the client to the same path but on itself (e.g.
+// "http://localhost/some/dead/path"), see `Handler.SetDeadResponseMode()`. Paths
+// may also be regular expressions, starting with "~", or wildcards with "*"
+// matching any run of characters, e.g. "/wp-admin/*" or "*.php". This creates a
+// custom handler for exact paths, adding another custom handler will override
+// this dead response. If a file is mapped to the same path as this dead response
+// then, like other custom handlers, the dead response takes priority. Dead
+// responses are kept when the server restarts.
 func (h *Handler) AddDeadResponses(paths []string) {
+	h.deadPaths = append(h.deadPaths, paths...)
+	h.addDeadResponses(paths)
+}
+
+// Adds dead responses, as `Handler.AddDeadResponses()` does, without keeping
+// them for a restart.
+func (h *Handler) addDeadResponses(paths []string) {
 	for _, path := range paths {
+		if isDeadPattern(path) {
+			re, err := compileDeadPattern(path)
+
+			if err != nil {
+				logger.GlobalLog.LogWarn(err.Error())
+				continue
+			}
+
+			logger.GlobalLog.LogInfo("Mapped URIs matching '" + path + "' to a dead response.")
+			h.deadPatterns = append(h.deadPatterns, deadPattern{path, re})
+			continue
+		}
+
 		if len(path) > 0 && path[0] != '/' {
 			path = "/" + path
 		}
 
 		logger.GlobalLog.LogInfo("Mapped URI '" + path + "' to a dead response.")
 		h.handlerMap[path] = CustomHandler{
-			Handler: func(w http.ResponseWriter, req *http.Request) {
-				logger.GlobalLog.LogInfo("Dead responding to request from '" + req.RemoteAddr + "'")
-				http.Redirect(w, req, "http://localhost/"+path, http.StatusMovedPermanently)
-			},
+			Handler: h.deadRespond,
+			Kind:    RouteDead,
 		}
 	}
 }
 
+// Requires that requests for paths under any of the given prefixes be made over
+// TLS with a client certificate verified against the server's client CA.
+// Requests without one are responded to with 403 Forbidden.
+func (h *Handler) RequireClientCerts(prefixes []string) {
+	for _, prefix := range prefixes {
+		logger.GlobalLog.LogInfo("Requiring client certificates for prefix '" + prefix + "'")
+	}
+
+	h.clientAuthPrefixes = append(h.clientAuthPrefixes, prefixes...)
+}
+
+// Requires that requests for paths under any of the given prefixes be signed
+// with the given key, see `SignPath()`. Unsigned, incorrectly signed, or expired
+// requests are responded to with 403 Forbidden.
+func (h *Handler) RequireSignatures(prefixes []string, key string) {
+	for _, prefix := range prefixes {
+		logger.GlobalLog.LogInfo("Requiring signed URLs for prefix '" + prefix + "'")
+	}
+
+	h.signedPrefixes = append(h.signedPrefixes, prefixes...)
+	h.signedKey = key
+}
+
+// Adds rules for serving files as downloads using a "Content-Disposition:
+// attachment" header. Rules starting with '.' match file extensions, rules ending
+// in '/' match path prefixes, and any other rule matches an exact path. Each
+// rule maps to a file name to give downloads, or an empty string to use the
+// served file's name. Exact paths take priority over prefixes, which take
+// priority over extensions.
+func (h *Handler) AddAttachmentRules(rules map[string]string) {
+	for rule, filename := range rules {
+		logger.GlobalLog.LogInfo("Serving '" + rule + "' as attachment")
+		h.attachments[rule] = filename
+	}
+}
+
+// Finds the attachment rule for the given URL path, returning the file name to
+// use and true if one matches.
+func (h *Handler) attachmentFor(uriPath string) (string, bool) {
+	return matchRule(h.attachments, uriPath, path.Ext(uriPath))
+}
+
+// Sets whether or not files are served with an ETag header, so that clients
+// may revalidate them with If-None-Match rather than downloading them again.
+func (h *Handler) SetEtags(etags bool) {
+	h.etags = etags
+}
+
+// Adds rules for the Cache-Control header of served files, matched the same
+// way as attachment rules, see `Handler.AddAttachmentRules()`, except that
+// extensions are matched against the served file rather than the URL path.
+func (h *Handler) AddCacheControl(rules map[string]string) {
+	for rule, value := range rules {
+		logger.GlobalLog.LogInfo("Serving '" + rule + "' with 'Cache-Control: " + value + "'")
+		h.cacheControl[rule] = value
+	}
+}
+
+// Sets the Cache-Control header for the given URL path, served from the given
+// file, if any rule matches it.
+func (h *Handler) setCacheControl(w http.ResponseWriter, uriPath, file string) {
+	if value, ok := matchRule(h.cacheControl, uriPath, path.Ext(file)); ok {
+		w.Header().Set("Cache-Control", value)
+	}
+}
+
+// Sets whether or not precompressed siblings of files (e.g. "style.css.zst")
+// should be served in place of the file to clients that accept their encoding.
+func (h *Handler) SetPrecompressed(precompressed bool) {
+	h.precompressed = precompressed
+}
+
+// Sets the encodings to compress responses with on the fly, in order of
+// preference, along with the minimum size and allowed MIME types of compressed
+// responses. Only "gzip" is supported, an empty list disables compression.
+func (h *Handler) SetCompression(encodings []string, minSize int64, types []string) {
+	h.compressor = newCompressor(encodings, minSize, types)
+
+	if h.compressor != nil {
+		logger.GlobalLog.LogInfo("Compressing responses with " + strings.Join(h.compressor.encodings, ", "))
+	}
+}
+
+// Sets whether or not requests for directories without an "index.html" are
+// responded to with a generated listing of the directory's contents.
+func (h *Handler) SetAutoIndex(autoIndex bool) {
+	h.autoIndex = autoIndex
+}
+
+// Sets whether or not hidden files, those starting with "." such as ".git" or
+// ".env", are mapped and served. Must be set before mapping files to take
+// effect for them.
+func (h *Handler) SetServeHiddenFiles(serveHiddenFiles bool) {
+	h.serveHiddenFiles = serveHiddenFiles
+}
+
+// Sets the port that HTTP requests are redirected to when redirecting to HTTPS,
+// empty for the default HTTPS port.
+func (h *Handler) SetRedirectPort(port string) {
+	if port == "443" || port == "https" {
+		port = ""
+	}
+
+	h.redirectPort = port
+}
+
+// Sets whether or not the site's root "index.html" should be served, with 200
+// OK, for GET requests to paths with nothing mapped to them, so that single
+// page apps with client side routing may be deep linked to.
+func (h *Handler) SetSpaFallback(spaFallback bool) {
+	h.spaFallback = spaFallback
+}
+
+// Sets the time after which handling a request is slow enough that it is logged
+// as a warning rather than info, zero to never warn of slow requests.
+func (h *Handler) SetSlowRequestThreshold(threshold time.Duration) {
+	h.slowRequest = threshold
+}
+
+// Sets whether or not AVIF or WebP siblings of images (e.g. "photo.avif" for
+// "photo.jpg") should be served in place of the image to clients that accept
+// them.
+func (h *Handler) SetModernImages(modernImages bool) {
+	h.modernImages = modernImages
+}
+
+// Adds assets to preload for pages, mapping URL paths to the assets to preload
+// when they are requested. Each asset is sent in a Link preload header, and if
+// directed, in a 103 Early Hints response before the page itself.
+func (h *Handler) AddPreloads(preloads map[string][]string, earlyHints bool) {
+	for page, assets := range preloads {
+		logger.GlobalLog.LogInfo("Preloading " + strings.Join(assets, ", ") + " for '" + page + "'")
+		h.preloads[page] = append(h.preloads[page], assets...)
+	}
+
+	h.earlyHints = earlyHints
+}
+
+// Sets hourly and daily quotas on the requests made by and bytes served to each
+// client IP, zero for no limit. Clients over any quota are responded to with 429
+// Too Many Requests until it resets.
+func (h *Handler) SetQuotas(requestsHourly, requestsDaily, bytesHourly, bytesDaily int64) {
+	limits := quotaLimits{requestsHourly, requestsDaily, bytesHourly, bytesDaily}
+
+	if !limits.enabled() {
+		h.quota = nil
+		return
+	}
+
+	logger.GlobalLog.LogInfo("Enforcing per client IP quotas")
+
+	// Usage is kept when quotas are set again, e.g. when restarting.
+	if h.quota != nil {
+		h.quota.setLimits(limits)
+		return
+	}
+
+	h.quota = newQuotaTracker(limits)
+}
+
+// Gives a table of the quota usage of each client IP seen today, or an empty
+// string if no quotas are set.
+func (h *Handler) QuotaReport() string {
+	if h.quota == nil {
+		return ""
+	}
+
+	return h.quota.report()
+}
+
+// Temporarily bans client IPs after the given number of requests for dead paths
+// or missing files, with strikes decaying over the given window. Zero disables
+// banning.
+func (h *Handler) SetBans(threshold int64, window, duration time.Duration) {
+	if threshold <= 0 {
+		h.bans = nil
+		return
+	}
+
+	logger.GlobalLog.LogInfo("Banning client IPs after " + strconv.FormatInt(threshold, 10) + " dead or missing paths")
+
+	// Strikes and bans are kept when bans are set again, e.g. when restarting.
+	if h.bans != nil {
+		h.bans.setLimits(threshold, window, duration)
+		return
+	}
+
+	h.bans = newBanTracker(threshold, window, duration)
+}
+
+// Adds a ban strike against the client making the given request, if banning
+// is enabled.
+func (h *Handler) strike(req *http.Request) {
+	if h.bans != nil {
+		h.bans.strike(clientIp(req), req.URL.Path)
+	}
+}
+
+// Sets the types of text assets to minify when served, which may be "html",
+// "css", or "js". An empty list disables minification.
+func (h *Handler) SetMinifiedTypes(types []string) {
+	if len(types) == 0 {
+		h.minifier = nil
+		return
+	}
+
+	logger.GlobalLog.LogInfo("Minifying types: " + strings.Join(types, ", "))
+	h.minifier = newMinifier(types)
+}
+
+// Gives a snapshot of the requests served by the handler.
+func (h *Handler) Stats() Stats {
+	return h.stats.snapshot()
+}
+
+// Gives the requests made for, bytes served for, and statuses given for each
+// path served by the handler, most requested first. Only a limited number of
+// distinct paths are counted, those requested first.
+func (h *Handler) PathHits() []PathHits {
+	return h.stats.pathHits()
+}
+
 func (h *Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	logger.GlobalLog.LogInfo("Got request (" + req.Proto + ") from " + req.RemoteAddr + " for " + req.URL.Path)
+	if h.wrapped != nil {
+		h.wrapped.ServeHTTP(w, req)
+		return
+	}
+
+	h.serveHTTP(w, req)
+}
+
+// Serves the request without any middleware given by `Handler.Use()`.
+func (h *Handler) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	start := time.Now()
+	status := &statusWriter{h.throttle(w, req), 0, 0}
+	w = status
+
+	defer func() {
+		recovered := recover()
+
+		if recovered != nil {
+			recovered = h.recoverPanic(status, req, recovered)
+		}
+
+		h.logRequest(req, status.status, status.written, time.Since(start))
+		h.stats.record(req, status.status, status.written)
+
+		if h.accessLog != nil {
+			h.accessLog.record(req, status.status, status.written, time.Since(start))
+		}
+
+		// Aborting closes the connection, which only the HTTP server can do.
+		if recovered == http.ErrAbortHandler {
+			panic(recovered)
+		}
+	}()
+
+	if h.bans != nil && h.bans.isBanned(clientIp(req)) {
+		if h.tarpits.abusive {
+			logger.GlobalLog.LogInfo("Tarpitting request from banned client " + req.RemoteAddr)
+			h.tarpit(w, req)
+			return
+		}
+
+		logger.GlobalLog.LogInfo("Refused request from banned client " + req.RemoteAddr)
+		h.serveError(w, req, http.StatusForbidden)
+		return
+	}
+
+	if h.block(w, req) {
+		return
+	}
+
+	if h.limitBody(w, req) {
+		return
+	}
+
+	if h.underMaintenance(w, req) {
+		return
+	}
+
+	if h.quota != nil {
+		ip := clientIp(req)
+
+		if ok, retry := h.quota.allow(ip); !ok {
+			if h.tarpits.abusive {
+				logger.GlobalLog.LogWarn("Client " + ip + " is over quota, tarpitting request for " + req.URL.Path)
+				h.tarpit(w, req)
+				return
+			}
+
+			logger.GlobalLog.LogWarn("Client " + ip + " is over quota, refusing request for " + req.URL.Path)
+			w.Header().Set("Retry-After", strconv.FormatInt(int64(retry.Seconds())+1, 10))
+			h.serveError(w, req, http.StatusTooManyRequests)
+			return
+		}
+
+		defer func() { h.quota.addBytes(ip, status.written) }()
+	}
+
+	// Paths are checked before any prefix is matched against them, so that a path
+	// like "/public/../private/" cannot pass as being under "/public/".
+	if !isCanonicalPath(req.URL.Path) {
+		logger.GlobalLog.LogWarn("Refused request for non-canonical path '" + req.URL.Path + "' from " + req.RemoteAddr)
+		h.strike(req)
+		h.serveError(w, req, http.StatusBadRequest)
+		return
+	}
+
+	if !h.serveHiddenFiles && isHidden(req.URL.Path) {
+		logger.GlobalLog.LogWarn("Refused request for hidden file '" + req.URL.Path + "' from " + req.RemoteAddr)
+		h.strike(req)
+		h.serveError(w, req, http.StatusNotFound)
+		return
+	}
+
+	if h.compressor != nil {
+		compress := h.compressor.wrap(w, req)
+		defer compress.Close()
+		w = compress
+	}
+
+	if h.redirectHttp && req.TLS == nil {
+		host := req.Host
+
+		if hostname, _, err := net.SplitHostPort(host); err == nil {
+			host = hostname
+		}
+
+		if h.redirectPort != "" {
+			host = net.JoinHostPort(host, h.redirectPort)
+		}
 
-	if h.redirectHttp && req.ProtoMajor < 2 {
-		http.Redirect(w, req, "https://"+req.Host+req.URL.Path, http.StatusMovedPermanently)
+		http.Redirect(w, req, "https://"+host+req.URL.Path, http.StatusMovedPermanently)
 		logger.GlobalLog.LogInfo("Redirected HTTP request for '" + req.URL.Path + "' to HTTPS")
 		return
 	}
 
-	if strings.Contains(req.URL.Path, "..") {
-		logger.GlobalLog.LogWarn("Request was made to a path containing '..' by " + req.RemoteAddr)
+	if url, ok := h.canonicalUrl(req); ok {
+		http.Redirect(w, req, url, http.StatusMovedPermanently)
+		logger.GlobalLog.LogInfo("Redirected request for '" + req.Host + req.URL.Path + "' to canonical URL '" + url + "'")
+		return
+	}
+
+	if url, ok := h.languageUrl(w, req); ok {
+		http.Redirect(w, req, url, http.StatusFound)
+		logger.GlobalLog.LogInfo("Redirected request for '" + req.URL.Path + "' to translation '" + url + "'")
+		return
+	}
+
+	if h.oidc != nil && !h.oidc.authorize(w, req) {
+		return
+	}
+
+	for _, prefix := range h.clientAuthPrefixes {
+		if strings.HasPrefix(req.URL.Path, prefix) && (req.TLS == nil || len(req.TLS.VerifiedChains) == 0) {
+			logger.GlobalLog.LogWarn("Denied request without a verified client certificate from " + req.RemoteAddr + " for " + req.URL.Path)
+			h.serveError(w, req, http.StatusForbidden)
+			return
+		}
+	}
+
+	for _, prefix := range h.signedPrefixes {
+		if strings.HasPrefix(req.URL.Path, prefix) && !verifySignedRequest(h.signedKey, req) {
+			logger.GlobalLog.LogWarn("Denied request without a valid signature from " + req.RemoteAddr + " for " + req.URL.Path)
+			h.serveError(w, req, http.StatusForbidden)
+			return
+		}
+	}
+
+	h.setHeaders(w, req.URL.Path)
+	w = h.withoutRanges(w, req)
+
+	if h.webDav != nil && h.webDav.serves(req.URL.Path) {
+		h.webDav.ServeHTTP(w, req)
+		return
+	}
+
+	if h.upload != nil && req.URL.Path == h.upload.path {
+		h.upload.ServeHTTP(w, req)
+		return
+	}
+
+	if h.deployHook != nil && req.URL.Path == h.deployHook.path {
+		h.deployHook.ServeHTTP(w, req)
+		return
+	}
+
+	// Without a rule for the path, proxies and custom handlers accept any method
+	// but TRACE, and files only those of `staticMethods`.
+	methods := h.methodsFor(req.URL.Path, nil)
+
+	if methods == nil && req.Method == http.MethodTrace {
+		methods = staticMethods
+	}
+
+	if methods != nil && h.refuseMethod(w, req, methods) {
+		return
+	}
+
+	if rule, ok := h.proxyFor(req.URL.Path); ok {
+		logger.GlobalLog.LogInfo("Proxying request for '" + req.URL.Path + "' to '" + rule.upstream + "'")
+		rule.proxy.ServeHTTP(w, req)
+		return
 	}
 
 	handler, ok := h.handlerMap[req.URL.Path]
@@ -137,19 +1070,158 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	file, ok := h.PathMap[req.URL.Path]
+	if h.isDeadPath(req.URL.Path) {
+		h.deadRespond(w, req)
+		return
+	}
+
+	file, ok := h.fileFor(req.URL.Path)
 
 	if ok {
-		if _, err := os.Stat(file); err != nil {
+		if methods == nil && h.refuseMethod(w, req, staticMethods) {
+			return
+		}
+
+		if _, err := fs.Stat(h.fsys, file); err != nil {
+			if h.autoIndex && path.Base(file) == "index.html" && serveDirListing(w, req, h.fsys, path.Dir(file)) {
+				return
+			}
+
 			logger.GlobalLog.LogErr("A request was made for '" + file + "' but stat failed")
+			h.serveError(w, req, http.StatusNotFound)
+			return
 		}
 
-		http.ServeFile(w, req, file)
+		if filename, ok := h.attachmentFor(req.URL.Path); ok {
+			if filename == "" {
+				filename = filepath.Base(file)
+			}
+
+			w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+		}
+
+		h.setCacheControl(w, req.URL.Path, file)
+
+		if assets, ok := h.preloads[req.URL.Path]; ok {
+			writePreloads(w, req, assets, h.earlyHints)
+		}
+
+		h.serveFile(w, req, file)
+		return
+	}
+
+	if index, ok := h.fileFor("/"); ok && h.spaFallback && (req.Method == http.MethodGet || req.Method == http.MethodHead) {
+		h.setCacheControl(w, req.URL.Path, index)
+		h.serveFile(w, req, index)
 		return
 	}
 
 	// No file nor special handler for requested path.
-	http.NotFound(w, req)
+	h.strike(req)
+	h.serveError(w, req, http.StatusNotFound)
+}
+
+// Serves the given file, preferring a modern image format, a precompressed
+// sibling, and then minified content when each is enabled.
+func (h *Handler) serveFile(w http.ResponseWriter, req *http.Request, file string) {
+	if h.resizer != nil && h.resizer.serve(w, req, h.fsys, file) {
+		return
+	}
+
+	if h.modernImages && isReplaceableImage(file) {
+		addVary(w.Header(), "Accept")
+
+		if image, ok := modernImageFor(h.fsys, req, file); ok {
+			file = image
+		}
+	}
+
+	if h.templates != nil && h.templates.serve(w, req, h.fsys, file) {
+		return
+	}
+
+	if h.precompressed {
+		addVary(w.Header(), "Accept-Encoding")
+
+		if sibling, encoding, ok := precompressedFor(h.fsys, req, file); ok {
+			setEncodedHeaders(w, file, encoding)
+
+			if stat, err := fs.Stat(h.fsys, sibling); err == nil {
+				w = &encodedLengthWriter{w, stat.Size()}
+			}
+
+			h.serveRaw(w, req, sibling)
+			return
+		}
+	}
+
+	if h.minifier != nil && h.minifier.serve(w, req, h.fsys, file, h.etags) {
+		return
+	}
+
+	h.serveRaw(w, req, file)
+}
+
+// Serves the given file unchanged from the handler's filesystem, with an ETag
+// from its metadata if enabled. Conditional requests are handled by
+// `http.ServeContent()`.
+func (h *Handler) serveRaw(w http.ResponseWriter, req *http.Request, file string) {
+	if h.etags {
+		if stat, err := fs.Stat(h.fsys, file); err == nil && !stat.IsDir() {
+			w.Header().Set("ETag", metadataEtag(stat))
+		}
+	}
+
+	if _, ok := h.fsys.(osFS); ok {
+		http.ServeFile(w, req, file)
+		return
+	}
+
+	serveFromFS(w, req, h.fsys, file)
+}
+
+// Gives the SHA-256 digest and size of the file served unchanged for the given
+// URL path, so that what is served can be checked against the site. Gives false
+// for paths not served from a file, or whose file is rendered as a template or
+// minified.
+func (h *Handler) FileDigest(uriPath string) ([]byte, int64, bool) {
+	if _, ok := h.handlerMap[uriPath]; ok || h.isDeadPath(uriPath) {
+		return nil, 0, false
+	}
+
+	file, ok := h.fileFor(uriPath)
+
+	if !ok {
+		return nil, 0, false
+	}
+
+	ext := strings.ToLower(filepath.Ext(file))
+
+	if h.templates != nil && ext == h.templates.extension {
+		return nil, 0, false
+	}
+
+	if h.minifier != nil {
+		if _, ok := h.minifier.types[ext]; ok {
+			return nil, 0, false
+		}
+	}
+
+	f, err := h.fsys.Open(file)
+
+	if err != nil {
+		return nil, 0, false
+	}
+
+	defer f.Close()
+	hash := sha256.New()
+	size, err := io.Copy(hash, f)
+
+	if err != nil {
+		return nil, 0, false
+	}
+
+	return hash.Sum(nil), size, true
 }
 
 func (h CustomHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
@@ -0,0 +1,49 @@
+// Copyright (c) 2024 Evan Overman (https://an-prata.it).
+// Licensed under the MIT License.
+// See LICENSE file in repository root for complete license text.
+
+package server
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/an-prata/webby/logger"
+)
+
+// Adds headers to set on responses by the URL prefix they are set for, e.g.
+// {"/api/": {"Access-Control-Allow-Origin": "*"}}. Headers of every matching
+// prefix are set, with longer prefixes overriding shorter ones where they give
+// the same header.
+func (h *Handler) AddHeaders(headers map[string]map[string]string) {
+	for prefix, set := range headers {
+		if h.headers[prefix] == nil {
+			h.headers[prefix] = map[string]string{}
+		}
+
+		for key, value := range set {
+			logger.GlobalLog.LogInfo("Setting header '" + key + ": " + value + "' for prefix '" + prefix + "'")
+			h.headers[prefix][key] = value
+		}
+	}
+}
+
+// Sets the configured headers of every prefix matching the given URL path.
+func (h *Handler) setHeaders(w http.ResponseWriter, uriPath string) {
+	prefixes := []string{}
+
+	for prefix := range h.headers {
+		if strings.HasPrefix(uriPath, prefix) {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+
+	sort.Slice(prefixes, func(i, j int) bool { return len(prefixes[i]) < len(prefixes[j]) })
+
+	for _, prefix := range prefixes {
+		for key, value := range h.headers[prefix] {
+			w.Header().Set(key, value)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright (c) 2024 Evan Overman (https://an-prata.it).
+// Licensed under the MIT License.
+// See LICENSE file in repository root for complete license text.
+
+package server
+
+import (
+	"io/fs"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// A modern image format that may be served in place of an image requested in an
+// older format.
+type imageFormat struct {
+	MediaType string
+	Extension string
+}
+
+// Image formats to prefer, in order of preference.
+var modernImageFormats = []imageFormat{
+	{"image/avif", ".avif"},
+	{"image/webp", ".webp"},
+}
+
+// Extensions of images that may be replaced by a modern format.
+var replaceableImageExtensions = []string{".jpg", ".jpeg", ".png", ".gif"}
+
+// Returns true if the given Accept header value explicitly lists the given media
+// type with a non-zero quality. Wildcards are not considered since clients send
+// them regardless of which image formats they support.
+func acceptsMediaType(header, mediaType string) bool {
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+
+		if strings.ToLower(strings.TrimSpace(fields[0])) != mediaType {
+			continue
+		}
+
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(param[2:], 64); err == nil && q <= 0 {
+					return false
+				}
+			}
+		}
+
+		return true
+	}
+
+	return false
+}
+
+// Finds a sibling of the given image in a modern format that the request
+// accepts, e.g. "photo.avif" for "photo.jpg". Returns the sibling's path and
+// false if there is none or the file is not a replaceable image.
+func modernImageFor(fsys fs.FS, req *http.Request, file string) (string, bool) {
+	if !isReplaceableImage(file) {
+		return "", false
+	}
+
+	header := req.Header.Get("Accept")
+	base := strings.TrimSuffix(file, filepath.Ext(file))
+
+	for _, format := range modernImageFormats {
+		if !acceptsMediaType(header, format.MediaType) {
+			continue
+		}
+
+		if stat, err := fs.Stat(fsys, base+format.Extension); err == nil && !stat.IsDir() {
+			return base + format.Extension, true
+		}
+	}
+
+	return "", false
+}
+
+// Returns true if the given file is an image which may be replaced by a modern
+// format.
+func isReplaceableImage(file string) bool {
+	ext := strings.ToLower(filepath.Ext(file))
+
+	for _, e := range replaceableImageExtensions {
+		if e == ext {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,183 @@
+// Copyright (c) 2024 Evan Overman (https://an-prata.it).
+// Licensed under the MIT License.
+// See LICENSE file in repository root for complete license text.
+
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strconv"
+)
+
+// Parses the given config JSON into the given value, allowing "//" and "/* */"
+// comments as well as trailing commas in objects and arrays. Errors give the
+// line they occur on.
+func unmarshalConfig(data []byte, v interface{}) error {
+	stripped := stripJsonComments(data)
+	err := json.Unmarshal(stripped, v)
+
+	if syntaxErr, ok := err.(*json.SyntaxError); ok {
+		line := bytes.Count(stripped[:syntaxErr.Offset], []byte("\n")) + 1
+		return errors.New("line " + strconv.Itoa(line) + ": " + err.Error())
+	}
+
+	return err
+}
+
+// A member of a JSON object or element of an array by its offsets in the JSON
+// it was read from. Elements of arrays have no key.
+type jsonChild struct {
+	key        string
+	keyStart   int
+	valueStart int
+	valueEnd   int
+}
+
+// Gives the members of the JSON object or elements of the JSON array beginning
+// at the given offset of the given JSON, which must not have comments, along
+// with the offset of the object or array's closing brace or bracket.
+func jsonChildren(data []byte, start int) ([]jsonChild, int, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data[start:]))
+	token, err := decoder.Token()
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	object := token == json.Delim('{')
+
+	if !object && token != json.Delim('[') {
+		return nil, 0, errors.New("expected an object or array")
+	}
+
+	children := []jsonChild{}
+
+	for decoder.More() {
+		child := jsonChild{keyStart: start + int(decoder.InputOffset())}
+
+		if object {
+			token, err = decoder.Token()
+
+			if err != nil {
+				return nil, 0, err
+			}
+
+			child.key, _ = token.(string)
+			child.keyStart += bytes.IndexByte(data[child.keyStart:], '"')
+		}
+
+		var value json.RawMessage
+
+		if err = decoder.Decode(&value); err != nil {
+			return nil, 0, err
+		}
+
+		child.valueEnd = start + int(decoder.InputOffset())
+		child.valueStart = child.valueEnd - len(value)
+		children = append(children, child)
+	}
+
+	if _, err = decoder.Token(); err != nil {
+		return nil, 0, err
+	}
+
+	return children, start + int(decoder.InputOffset()) - 1, nil
+}
+
+// Sets the named member of the JSON object beginning at the given offset of the
+// given config JSON to the given value, changing nothing else of the config so
+// that its formatting and any comments are kept. A new member is added after
+// the object's last member with the same indentation.
+func setJsonMember(data []byte, start int, key string, value []byte) ([]byte, error) {
+	members, end, err := jsonChildren(stripJsonComments(data), start)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, member := range members {
+		if member.key == key {
+			return splice(data, member.valueStart, member.valueEnd, value), nil
+		}
+	}
+
+	encodedKey, _ := json.Marshal(key)
+	member := append(append(encodedKey, ": "...), value...)
+
+	if len(members) == 0 {
+		return splice(data, end, end, member), nil
+	}
+
+	last := members[len(members)-1]
+	lineStart := bytes.LastIndexByte(data[:last.keyStart], '\n') + 1
+	indent := data[lineStart:last.keyStart]
+
+	if lineStart == 0 || len(bytes.TrimLeft(indent, " \t")) > 0 {
+		return splice(data, last.valueEnd, last.valueEnd, append([]byte(", "), member...)), nil
+	}
+
+	return splice(data, last.valueEnd, last.valueEnd, append(append([]byte(",\n"), indent...), member...)), nil
+}
+
+// Gives the given data with the bytes between the given offsets replaced.
+func splice(data []byte, start, end int, replacement []byte) []byte {
+	out := make([]byte, 0, len(data)-(end-start)+len(replacement))
+	out = append(out, data[:start]...)
+	out = append(out, replacement...)
+	return append(out, data[end:]...)
+}
+
+// Replaces comments and trailing commas in the given JSON with spaces, leaving
+// newlines in place so that offsets into it still refer to the same lines.
+func stripJsonComments(data []byte) []byte {
+	out := make([]byte, len(data))
+	copy(out, data)
+
+	// Index of the last comma outside of a string, while only whitespace and
+	// comments have followed it, or -1.
+	comma := -1
+
+	for i := 0; i < len(out); i++ {
+		switch {
+		case out[i] == '"':
+			comma = -1
+
+			for i++; i < len(out) && out[i] != '"'; i++ {
+				if out[i] == '\\' {
+					i++
+				}
+			}
+		case out[i] == '/' && i+1 < len(out) && out[i+1] == '/':
+			for ; i < len(out) && out[i] != '\n'; i++ {
+				out[i] = ' '
+			}
+		case out[i] == '/' && i+1 < len(out) && out[i+1] == '*':
+			out[i], out[i+1] = ' ', ' '
+
+			for i += 2; i < len(out) && !(out[i] == '*' && i+1 < len(out) && out[i+1] == '/'); i++ {
+				if out[i] != '\n' {
+					out[i] = ' '
+				}
+			}
+
+			if i < len(out) {
+				out[i], out[i+1] = ' ', ' '
+				i++
+			}
+		case out[i] == ',':
+			comma = i
+		case out[i] == '}' || out[i] == ']':
+			if comma >= 0 {
+				out[comma] = ' '
+			}
+
+			comma = -1
+		case out[i] != ' ' && out[i] != '\t' && out[i] != '\r' && out[i] != '\n':
+			comma = -1
+		}
+	}
+
+	return out
+}
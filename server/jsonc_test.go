@@ -0,0 +1,122 @@
+// Copyright (c) 2024 Evan Overman (https://an-prata.it).
+// Licensed under the MIT License.
+// See LICENSE file in repository root for complete license text.
+
+package server
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSetOptionInFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   string
+		instance string
+		key      string
+		value    string
+		expected string
+	}{
+		{
+			"replace",
+			"{\n  \"Site\": \"/srv\",\n  \"WriteTimeout\": 10,\n  \"Etags\": true\n}\n",
+			"", "WriteTimeout", "30",
+			"{\n  \"Site\": \"/srv\",\n  \"WriteTimeout\": 30,\n  \"Etags\": true\n}\n",
+		},
+		{
+			"add with indentation",
+			"{\n\t\"Site\": \"/srv\",\n\t\"Etags\": true\n}\n",
+			"", "DeadPaths", `[ "/a",  "/b" ]`,
+			"{\n\t\"Site\": \"/srv\",\n\t\"Etags\": true,\n\t\"DeadPaths\": [\"/a\",\"/b\"]\n}\n",
+		},
+		{
+			"add on one line",
+			`{"Site": "/srv"}`,
+			"", "Etags", "false",
+			`{"Site": "/srv", "Etags": false}`,
+		},
+		{
+			"add to empty",
+			"{}",
+			"", "Etags", "false",
+			`{"Etags": false}`,
+		},
+		{
+			"comments and trailing commas",
+			"{\n    // Where the site is.\n    \"Site\": \"/srv\", /* old */\n    \"WriteTimeout\": 10, // seconds\n}\n",
+			"", "WriteTimeout", "30",
+			"{\n    // Where the site is.\n    \"Site\": \"/srv\", /* old */\n    \"WriteTimeout\": 30, // seconds\n}\n",
+		},
+		{
+			"add after trailing comma",
+			"{\n    \"Site\": \"/srv\",\n}\n",
+			"", "Etags", "false",
+			"{\n    \"Site\": \"/srv\",\n    \"Etags\": false,\n}\n",
+		},
+		{
+			"named instance",
+			"{\n    \"WriteTimeout\": 10,\n    \"Instances\": [\n        {\"Name\": \"a\", \"WriteTimeout\": 10},\n        {\"Name\": \"b\", \"WriteTimeout\": 10}\n    ]\n}\n",
+			"b", "WriteTimeout", "30",
+			"{\n    \"WriteTimeout\": 10,\n    \"Instances\": [\n        {\"Name\": \"a\", \"WriteTimeout\": 10},\n        {\"Name\": \"b\", \"WriteTimeout\": 30}\n    ]\n}\n",
+		},
+		{
+			"unnamed instance",
+			`{"Instances": [{"Name": "a"}, {"Site": "/srv"}]}`,
+			"instance-1", "Etags", "false",
+			`{"Instances": [{"Name": "a"}, {"Site": "/srv", "Etags": false}]}`,
+		},
+		{
+			"instance without instances",
+			`{"Name": "a", "Etags": true}`,
+			"a", "Etags", "false",
+			`{"Name": "a", "Etags": false}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.json")
+
+			if err := os.WriteFile(path, []byte(test.config), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := SetOptionInFile(path, test.instance, test.key, json.RawMessage(test.value)); err != nil {
+				t.Fatal(err)
+			}
+
+			written, _ := os.ReadFile(path)
+
+			if string(written) != test.expected {
+				t.Errorf("expected:\n%s\ngot:\n%s", test.expected, written)
+			}
+
+			var opts ServerOptions
+
+			if err := unmarshalConfig(written, &opts); err != nil {
+				t.Errorf("expected a config that parses, got %v", err)
+			}
+		})
+	}
+}
+
+func TestSetOptionInFileErrors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	config := `{"Instances": [{"Name": "a"}]}`
+	os.WriteFile(path, []byte(config), 0o644)
+
+	if err := SetOptionInFile(path, "b", "Etags", json.RawMessage("false")); err == nil {
+		t.Errorf("expected an unknown instance to be refused")
+	}
+
+	if err := SetOptionInFile(path, "", "Etags", json.RawMessage("fals")); err == nil {
+		t.Errorf("expected an invalid value to be refused")
+	}
+
+	if written, _ := os.ReadFile(path); string(written) != config {
+		t.Errorf("expected the config to be left as it was, got %s", written)
+	}
+}
@@ -0,0 +1,178 @@
+// Copyright (c) 2024 Evan Overman (https://an-prata.it).
+// Licensed under the MIT License.
+// See LICENSE file in repository root for complete license text.
+
+package server
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/an-prata/webby/logger"
+)
+
+// Languages a site is translated into, each with its own directory tree such
+// as "/en/" and "/de/", see `Handler.SetLanguages()`.
+type languageRules struct {
+	// Lower case language codes, e.g. "en" or "pt-br".
+	codes []string
+
+	// Language chosen when a client accepts none of the others.
+	fallback string
+
+	// Name of the cookie remembering a client's chosen language.
+	cookie string
+}
+
+// Query parameter that chooses a language, remembering it with the language
+// cookie, e.g. "/about/?lang=de".
+const languageQuery = "lang"
+
+// Redirects GET and HEAD requests for paths outside of any language's directory
+// to the same path within the directory of the client's language, e.g. "/about/"
+// to "/de/about/", when that path is mapped. The language is chosen by the
+// "lang" query parameter, then by the given cookie, then by the request's
+// Accept-Language header, and otherwise is the fallback. A "lang" query
+// parameter also sets the cookie so that the choice is remembered. An empty
+// fallback defaults to the first language and an empty cookie name to "lang".
+// No languages disables redirection.
+func (h *Handler) SetLanguages(codes []string, fallback, cookie string) {
+	h.languages = nil
+
+	if len(codes) == 0 {
+		return
+	}
+
+	lowered := []string{}
+
+	for _, code := range codes {
+		lowered = append(lowered, strings.ToLower(strings.Trim(code, "/")))
+	}
+
+	if fallback == "" {
+		fallback = lowered[0]
+	}
+
+	if cookie == "" {
+		cookie = languageQuery
+	}
+
+	logger.GlobalLog.LogInfo("Negotiating languages " + strings.Join(lowered, ", ") + ", defaulting to '" + fallback + "'")
+	h.languages = &languageRules{lowered, strings.ToLower(fallback), cookie}
+}
+
+// Gives the URL to redirect the given request to for the client's language, and
+// true if it should be redirected. A language chosen by query parameter is set
+// in a cookie on the given response.
+func (h *Handler) languageUrl(w http.ResponseWriter, req *http.Request) (string, bool) {
+	rules := h.languages
+
+	if rules == nil || (req.Method != http.MethodGet && req.Method != http.MethodHead) {
+		return "", false
+	}
+
+	segment, _, _ := strings.Cut(strings.TrimPrefix(req.URL.Path, "/"), "/")
+
+	if rules.known(strings.ToLower(segment)) {
+		return "", false
+	}
+
+	query := req.URL.Query()
+	chosen := strings.ToLower(query.Get(languageQuery))
+
+	if rules.known(chosen) {
+		http.SetCookie(w, &http.Cookie{
+			Name:     rules.cookie,
+			Value:    chosen,
+			Path:     "/",
+			MaxAge:   365 * 24 * 60 * 60,
+			SameSite: http.SameSiteLaxMode,
+		})
+	} else if cookie, err := req.Cookie(rules.cookie); err == nil && rules.known(strings.ToLower(cookie.Value)) {
+		chosen = strings.ToLower(cookie.Value)
+	} else {
+		chosen = rules.negotiate(req.Header.Get("Accept-Language"))
+	}
+
+	for _, language := range []string{chosen, rules.fallback} {
+		target := "/" + language + req.URL.Path
+
+		if !h.isMapped(target) {
+			continue
+		}
+
+		url := "/" + language + req.URL.EscapedPath()
+		query.Del(languageQuery)
+
+		if encoded := query.Encode(); encoded != "" {
+			url += "?" + encoded
+		}
+
+		// Redirects differ by client, so caches must not give one client another's.
+		w.Header().Add("Vary", "Accept-Language, Cookie")
+		return url, true
+	}
+
+	return "", false
+}
+
+// Returns true if the given lower case code is one of the site's languages.
+func (rules *languageRules) known(code string) bool {
+	for _, known := range rules.codes {
+		if code == known {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Gives the site's language most preferred by the given Accept-Language header,
+// or the fallback if none are accepted. A language range matches a code that
+// is the same, that it is a subtag of, or that is a subtag of it, so that
+// "de-AT" accepts "de" and "pt" accepts "pt-br".
+func (rules *languageRules) negotiate(header string) string {
+	type accepted struct {
+		tag     string
+		quality float64
+	}
+
+	ranges := []accepted{}
+
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		quality := 1.0
+
+		if value, found := strings.CutPrefix(strings.TrimSpace(params), "q="); found {
+			if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+				quality = parsed
+			}
+		}
+
+		if tag != "" && quality > 0 {
+			ranges = append(ranges, accepted{strings.ToLower(strings.TrimSpace(tag)), quality})
+		}
+	}
+
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return ranges[i].quality > ranges[j].quality
+	})
+
+	for _, accepted := range ranges {
+		if accepted.tag == "*" {
+			return rules.fallback
+		}
+
+		primary, _, _ := strings.Cut(accepted.tag, "-")
+
+		for _, code := range rules.codes {
+			if code == accepted.tag || code == primary || strings.HasPrefix(code, accepted.tag+"-") {
+				return code
+			}
+		}
+	}
+
+	return rules.fallback
+}
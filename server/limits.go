@@ -0,0 +1,158 @@
+// Copyright (c) 2024 Evan Overman (https://an-prata.it).
+// Licensed under the MIT License.
+// See LICENSE file in repository root for complete license text.
+
+package server
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/an-prata/webby/logger"
+)
+
+// Sets the largest request body accepted in bytes, zero for no limit. Requests
+// declaring a larger body are refused with 413 Content Too Large, and reading
+// past the limit from any other request's body fails.
+func (h *Handler) SetMaxRequestBodyBytes(max int64) {
+	h.maxBodyBytes = max
+}
+
+// Limits the body of the given request, returning true if it was refused for
+// declaring a body too large.
+func (h *Handler) limitBody(w http.ResponseWriter, req *http.Request) bool {
+	if h.maxBodyBytes <= 0 {
+		return false
+	}
+
+	if req.ContentLength > h.maxBodyBytes {
+		logger.GlobalLog.LogInfo("Refused request from " + req.RemoteAddr + " with body of " + strconv.FormatInt(req.ContentLength, 10) + " bytes")
+		w.Header().Set("Connection", "close")
+		h.serveError(w, req, http.StatusRequestEntityTooLarge)
+		return true
+	}
+
+	req.Body = http.MaxBytesReader(w, req.Body, h.maxBodyBytes)
+	return false
+}
+
+// Limits the connections open at once, in total and from each client. Accepted
+// connections over either limit are closed immediately, rather than left to
+// wait for room.
+type limitListener struct {
+	net.Listener
+
+	// Connections allowed in total and from each client, zero for no limit.
+	maxTotal int64
+	maxPerIp int64
+
+	mutex sync.Mutex
+	total int64
+	perIp map[string]int64
+}
+
+// A connection counted by a `limitListener`. Its client is counted on first
+// read rather than when accepted, since reading a PROXY protocol header for its
+// address may otherwise hold up accepting others.
+type limitConn struct {
+	net.Conn
+	listener *limitListener
+
+	counted sync.Once
+	ip      string
+	err     error
+	closed  sync.Once
+}
+
+// Wraps the given listener to limit its connections, see `limitListener`,
+// giving the listener unchanged when both limits are zero.
+func newLimitListener(listener net.Listener, maxTotal, maxPerIp int64) net.Listener {
+	if maxTotal <= 0 && maxPerIp <= 0 {
+		return listener
+	}
+
+	return &limitListener{listener, maxTotal, maxPerIp, sync.Mutex{}, 0, map[string]int64{}}
+}
+
+func (l *limitListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+
+		if err != nil {
+			return nil, err
+		}
+
+		l.mutex.Lock()
+
+		if l.maxTotal > 0 && l.total >= l.maxTotal {
+			l.mutex.Unlock()
+			logger.GlobalLog.LogWarn("Closing connection from " + conn.RemoteAddr().String() + ", " + strconv.FormatInt(l.maxTotal, 10) + " connections are already open")
+			conn.Close()
+			continue
+		}
+
+		l.total++
+		l.mutex.Unlock()
+		return &limitConn{Conn: conn, listener: l}, nil
+	}
+}
+
+// Counts the connection against its client, closing it if the client already
+// has too many open.
+func (c *limitConn) count() error {
+	c.counted.Do(func() {
+		if c.listener.maxPerIp <= 0 {
+			return
+		}
+
+		ip := c.RemoteAddr().String()
+
+		if host, _, err := net.SplitHostPort(ip); err == nil {
+			ip = host
+		}
+
+		c.listener.mutex.Lock()
+
+		if c.listener.perIp[ip] >= c.listener.maxPerIp {
+			c.listener.mutex.Unlock()
+			logger.GlobalLog.LogWarn("Closing connection from " + ip + ", which already has " + strconv.FormatInt(c.listener.maxPerIp, 10) + " connections open")
+			c.err = errors.New("too many connections from " + ip)
+			c.Close()
+			return
+		}
+
+		c.listener.perIp[ip]++
+		c.ip = ip
+		c.listener.mutex.Unlock()
+	})
+
+	return c.err
+}
+
+func (c *limitConn) Read(b []byte) (int, error) {
+	if err := c.count(); err != nil {
+		return 0, err
+	}
+
+	return c.Conn.Read(b)
+}
+
+func (c *limitConn) Close() error {
+	c.closed.Do(func() {
+		c.listener.mutex.Lock()
+		c.listener.total--
+
+		if c.ip != "" {
+			if c.listener.perIp[c.ip]--; c.listener.perIp[c.ip] <= 0 {
+				delete(c.listener.perIp, c.ip)
+			}
+		}
+
+		c.listener.mutex.Unlock()
+	})
+
+	return c.Conn.Close()
+}
@@ -0,0 +1,111 @@
+// Copyright (c) 2024 Evan Overman (https://an-prata.it).
+// Licensed under the MIT License.
+// See LICENSE file in repository root for complete license text.
+
+package server
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/an-prata/webby/logger"
+)
+
+// Whether a handler is in maintenance mode, and who it still serves while it is,
+// see `Handler.SetMaintenance()` and `Handler.SetMaintenanceRules()`.
+type maintenanceRules struct {
+	// Non-zero while in maintenance mode, changed while serving.
+	enabled int32
+
+	retryAfter int64
+	paths      []string
+	networks   []*net.IPNet
+}
+
+// Turns maintenance mode on or off, taking effect for the next request. While
+// on, requests not allowed by `Handler.SetMaintenanceRules()` are answered with
+// 503 Service Unavailable, using the error page for 503 if there is one.
+func (h *Handler) SetMaintenance(enabled bool) {
+	if enabled {
+		atomic.StoreInt32(&h.maintenance.enabled, 1)
+	} else {
+		atomic.StoreInt32(&h.maintenance.enabled, 0)
+	}
+}
+
+// Returns true while in maintenance mode, see `Handler.SetMaintenance()`.
+func (h *Handler) Maintenance() bool {
+	return atomic.LoadInt32(&h.maintenance.enabled) != 0
+}
+
+// Sets the seconds clients are told to retry after during maintenance, zero to
+// not tell them, and the URL path prefixes and client IPs or CIDR ranges still
+// served as usual. Invalid IPs and ranges are skipped with a warning.
+func (h *Handler) SetMaintenanceRules(retryAfter int64, paths []string, ips []string) {
+	h.maintenance.retryAfter = retryAfter
+	h.maintenance.paths = paths
+	h.maintenance.networks = []*net.IPNet{}
+
+	for _, ip := range ips {
+		network, err := parseNetwork(ip)
+
+		if err != nil {
+			logger.GlobalLog.LogWarn("Ignoring maintenance IP '" + ip + "': " + err.Error())
+			continue
+		}
+
+		h.maintenance.networks = append(h.maintenance.networks, network)
+	}
+}
+
+// Parses an IP or CIDR range, giving a single IP as a range of one.
+func parseNetwork(text string) (*net.IPNet, error) {
+	if !strings.Contains(text, "/") {
+		if ip := net.ParseIP(text); ip != nil {
+			bits := 8 * len(ip.To16())
+
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 32
+			}
+
+			return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+		}
+	}
+
+	_, network, err := net.ParseCIDR(text)
+	return network, err
+}
+
+// Answers the given request with 503 Service Unavailable if in maintenance mode
+// and the request is not allowed through, returning true if it did.
+func (h *Handler) underMaintenance(w http.ResponseWriter, req *http.Request) bool {
+	if !h.Maintenance() {
+		return false
+	}
+
+	for _, prefix := range h.maintenance.paths {
+		if strings.HasPrefix(req.URL.Path, prefix) {
+			return false
+		}
+	}
+
+	if ip := net.ParseIP(clientIp(req)); ip != nil {
+		for _, network := range h.maintenance.networks {
+			if network.Contains(ip) {
+				return false
+			}
+		}
+	}
+
+	if h.maintenance.retryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.FormatInt(h.maintenance.retryAfter, 10))
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	h.serveError(w, req, http.StatusServiceUnavailable)
+	return true
+}
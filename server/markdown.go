@@ -0,0 +1,335 @@
+// Copyright (c) 2024 Evan Overman (https://an-prata.it).
+// Licensed under the MIT License.
+// See LICENSE file in repository root for complete license text.
+
+package server
+
+import (
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Renders Markdown to HTML for `BuildSite()`. Only the common subset is
+// supported, being headings, paragraphs, emphasis, code spans and blocks,
+// links, images, lists, block quotes, and rules, along with blocks of raw HTML.
+// Written here rather than taken as a dependency, as with the rest of webby.
+func renderMarkdown(src string) string {
+	lines := strings.Split(strings.ReplaceAll(src, "\r\n", "\n"), "\n")
+	var out strings.Builder
+	renderBlocks(&out, lines)
+	return out.String()
+}
+
+var (
+	markdownHeading   = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*\s*$`)
+	markdownRule      = regexp.MustCompile(`^\s{0,3}(?:(?:-\s*){3,}|(?:\*\s*){3,}|(?:_\s*){3,})$`)
+	markdownBullet    = regexp.MustCompile(`^(\s{0,3})([-*+])\s+`)
+	markdownOrdered   = regexp.MustCompile(`^(\s{0,3})(\d{1,9})[.)]\s+`)
+	markdownFence     = regexp.MustCompile("^\\s{0,3}(```+|~~~+)\\s*([^`\\s]*)")
+	markdownHtmlBlock = regexp.MustCompile(`^\s{0,3}</?[a-zA-Z][a-zA-Z0-9-]*(\s|/?>|$)`)
+)
+
+// Renders the given lines as a sequence of blocks.
+func renderBlocks(out *strings.Builder, lines []string) {
+	for i := 0; i < len(lines); {
+		line := lines[i]
+
+		switch {
+		case strings.TrimSpace(line) == "":
+			i++
+
+		case markdownFence.MatchString(line):
+			match := markdownFence.FindStringSubmatch(line)
+			fence := match[1]
+			code := []string{}
+			i++
+
+			for i < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[i]), fence) {
+				code = append(code, lines[i])
+				i++
+			}
+
+			// Skips the closing fence.
+			i++
+
+			if match[2] != "" {
+				out.WriteString(`<pre><code class="language-` + html.EscapeString(match[2]) + `">`)
+			} else {
+				out.WriteString("<pre><code>")
+			}
+
+			out.WriteString(html.EscapeString(strings.Join(code, "\n")))
+			out.WriteString("\n</code></pre>\n")
+
+		case strings.HasPrefix(line, "    ") || strings.HasPrefix(line, "\t"):
+			code := []string{}
+
+			for i < len(lines) && (strings.HasPrefix(lines[i], "    ") || strings.HasPrefix(lines[i], "\t") || strings.TrimSpace(lines[i]) == "") {
+				code = append(code, strings.TrimPrefix(strings.TrimPrefix(lines[i], "\t"), "    "))
+				i++
+			}
+
+			for len(code) > 0 && strings.TrimSpace(code[len(code)-1]) == "" {
+				code = code[:len(code)-1]
+			}
+
+			out.WriteString("<pre><code>" + html.EscapeString(strings.Join(code, "\n")) + "\n</code></pre>\n")
+
+		case markdownHeading.MatchString(line):
+			match := markdownHeading.FindStringSubmatch(line)
+			level := strconv.Itoa(len(match[1]))
+			out.WriteString("<h" + level + ` id="` + slugify(match[2]) + `">` + renderInline(match[2]) + "</h" + level + ">\n")
+			i++
+
+		case markdownRule.MatchString(line):
+			out.WriteString("<hr>\n")
+			i++
+
+		case strings.HasPrefix(strings.TrimSpace(line), ">"):
+			quoted := []string{}
+
+			for i < len(lines) && strings.TrimSpace(lines[i]) != "" {
+				trimmed := strings.TrimSpace(lines[i])
+				trimmed = strings.TrimPrefix(trimmed, ">")
+				quoted = append(quoted, strings.TrimPrefix(trimmed, " "))
+				i++
+			}
+
+			out.WriteString("<blockquote>\n")
+			renderBlocks(out, quoted)
+			out.WriteString("</blockquote>\n")
+
+		case markdownBullet.MatchString(line) || markdownOrdered.MatchString(line):
+			i = renderList(out, lines, i)
+
+		case markdownHtmlBlock.MatchString(line):
+			for i < len(lines) && strings.TrimSpace(lines[i]) != "" {
+				out.WriteString(lines[i] + "\n")
+				i++
+			}
+
+		default:
+			paragraph := []string{}
+
+			for i < len(lines) && strings.TrimSpace(lines[i]) != "" && !startsBlock(lines[i]) {
+				paragraph = append(paragraph, lines[i])
+				i++
+			}
+
+			out.WriteString("<p>" + renderInline(strings.Join(paragraph, "\n")) + "</p>\n")
+		}
+	}
+}
+
+// Returns true if the given line starts a block other than a paragraph, and so
+// ends any paragraph before it.
+func startsBlock(line string) bool {
+	return markdownHeading.MatchString(line) ||
+		markdownRule.MatchString(line) ||
+		markdownFence.MatchString(line) ||
+		markdownBullet.MatchString(line) ||
+		markdownOrdered.MatchString(line) ||
+		strings.HasPrefix(strings.TrimSpace(line), ">")
+}
+
+// Renders the list starting at the given line, giving the index of the line
+// after it. Lines indented past an item's marker belong to the item, so that
+// items may hold paragraphs and nested lists.
+func renderList(out *strings.Builder, lines []string, i int) int {
+	ordered := markdownOrdered.MatchString(lines[i])
+	marker := markdownBullet
+
+	if ordered {
+		marker = markdownOrdered
+		start := markdownOrdered.FindStringSubmatch(lines[i])[2]
+
+		if start != "1" {
+			out.WriteString(`<ol start="` + start + `">` + "\n")
+		} else {
+			out.WriteString("<ol>\n")
+		}
+	} else {
+		out.WriteString("<ul>\n")
+	}
+
+	items := [][]string{}
+	loose := false
+
+	for i < len(lines) {
+		match := marker.FindString(lines[i])
+
+		if match == "" {
+			break
+		}
+
+		indent := len(match)
+		item := []string{lines[i][indent:]}
+		i++
+
+		for i < len(lines) {
+			line := lines[i]
+
+			if strings.TrimSpace(line) == "" {
+				// A blank line followed by more of the item, or by another item, makes
+				// the list loose, with its items' text in paragraphs.
+				if i+1 < len(lines) && (leadingSpace(lines[i+1]) >= indent || marker.MatchString(lines[i+1])) {
+					loose = true
+					item = append(item, "")
+					i++
+					continue
+				}
+
+				break
+			}
+
+			if leadingSpace(line) >= indent {
+				item = append(item, line[indent:])
+			} else if marker.MatchString(line) || startsBlock(line) {
+				break
+			} else {
+				// A lazy continuation of the item's paragraph.
+				item = append(item, strings.TrimSpace(line))
+			}
+
+			i++
+		}
+
+		items = append(items, item)
+	}
+
+	for _, item := range items {
+		for len(item) > 0 && strings.TrimSpace(item[len(item)-1]) == "" {
+			item = item[:len(item)-1]
+		}
+
+		var content strings.Builder
+		renderBlocks(&content, item)
+		rendered := strings.TrimSuffix(content.String(), "\n")
+
+		// Tight lists give the text of their items without paragraphs.
+		if !loose && strings.HasPrefix(rendered, "<p>") {
+			end := strings.Index(rendered, "</p>")
+			rendered = rendered[3:end] + rendered[end+4:]
+		}
+
+		out.WriteString("<li>" + rendered + "</li>\n")
+	}
+
+	if ordered {
+		out.WriteString("</ol>\n")
+	} else {
+		out.WriteString("</ul>\n")
+	}
+
+	return i
+}
+
+// Gives the number of spaces a line is indented by, counting tabs as four.
+func leadingSpace(line string) int {
+	n := 0
+
+	for _, c := range line {
+		switch c {
+		case ' ':
+			n++
+		case '\t':
+			n += 4
+		default:
+			return n
+		}
+	}
+
+	return n
+}
+
+var (
+	markdownCode     = regexp.MustCompile("(`+)(.+?)`+")
+	markdownImage    = regexp.MustCompile(`!\[([^\]]*)\]\(\s*([^)\s]+)(?:\s+&#34;(.*?)&#34;)?\s*\)`)
+	markdownLink     = regexp.MustCompile(`\[([^\]]+)\]\(\s*([^)\s]+)(?:\s+&#34;(.*?)&#34;)?\s*\)`)
+	markdownAutolink = regexp.MustCompile(`&lt;((?:https?|mailto):[^\s&]+)&gt;`)
+	markdownStrong   = regexp.MustCompile(`(\*\*|__)(\S(?:.*?\S)?)(\*\*|__)`)
+	markdownEmphasis = regexp.MustCompile(`(^|[^\w*])[*_](\S(?:.*?\S)?)[*_]($|[^\w*])`)
+	markdownEscape   = regexp.MustCompile("\\\\([\\\\`*_{}\\[\\]()#+\\-.!<>])")
+	markdownBreak    = regexp.MustCompile(`( {2,}|\\)\n`)
+)
+
+// Renders the inline elements of the given text, escaping everything else.
+// Code spans and escaped characters are set aside first so that nothing within
+// them is rendered.
+func renderInline(text string) string {
+	held := []string{}
+
+	hold := func(rendered string) string {
+		held = append(held, rendered)
+		return "\x00" + strconv.Itoa(len(held)-1) + "\x00"
+	}
+
+	text = markdownCode.ReplaceAllStringFunc(text, func(match string) string {
+		parts := markdownCode.FindStringSubmatch(match)
+		return hold("<code>" + html.EscapeString(strings.TrimSpace(parts[2])) + "</code>")
+	})
+
+	text = markdownEscape.ReplaceAllStringFunc(text, func(match string) string {
+		return hold(html.EscapeString(match[1:]))
+	})
+
+	text = html.EscapeString(text)
+
+	text = markdownImage.ReplaceAllStringFunc(text, func(match string) string {
+		parts := markdownImage.FindStringSubmatch(match)
+		return hold(`<img src="` + parts[2] + `" alt="` + parts[1] + `"` + titleAttribute(parts[3]) + ">")
+	})
+
+	text = markdownLink.ReplaceAllStringFunc(text, func(match string) string {
+		parts := markdownLink.FindStringSubmatch(match)
+		return `<a href="` + hold(parts[2]) + `"` + titleAttribute(parts[3]) + ">" + parts[1] + "</a>"
+	})
+
+	text = markdownAutolink.ReplaceAllStringFunc(text, func(match string) string {
+		url := markdownAutolink.FindStringSubmatch(match)[1]
+		return hold(`<a href="` + url + `">` + url + "</a>")
+	})
+
+	text = markdownStrong.ReplaceAllString(text, "<strong>$2</strong>")
+	text = markdownEmphasis.ReplaceAllString(text, "$1<em>$2</em>$3")
+	text = markdownBreak.ReplaceAllString(text, "<br>\n")
+
+	for i := len(held) - 1; i >= 0; i-- {
+		text = strings.ReplaceAll(text, "\x00"+strconv.Itoa(i)+"\x00", held[i])
+	}
+
+	return text
+}
+
+// Gives a title attribute for a link or image, if it has a title.
+func titleAttribute(title string) string {
+	if title == "" {
+		return ""
+	}
+
+	return ` title="` + title + `"`
+}
+
+// Gives an ID for a heading from its text, e.g. "getting-started" for "Getting
+// Started!".
+func slugify(text string) string {
+	var slug strings.Builder
+	dash := false
+
+	for _, c := range strings.ToLower(text) {
+		if (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') {
+			if dash && slug.Len() > 0 {
+				slug.WriteByte('-')
+			}
+
+			slug.WriteRune(c)
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+
+	return slug.String()
+}
@@ -0,0 +1,80 @@
+// Copyright (c) 2024 Evan Overman (https://an-prata.it).
+// Licensed under the MIT License.
+// See LICENSE file in repository root for complete license text.
+
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/an-prata/webby/logger"
+)
+
+// Methods files are served for when no rule of `Handler.AddAllowedMethods()`
+// matches their path.
+var staticMethods = []string{http.MethodGet, http.MethodHead}
+
+// Adds the HTTP methods allowed by the URL prefix they are allowed for, e.g.
+// {"/api/": ["GET", "POST"]}. The longest matching prefix is used. Paths
+// matching no prefix allow only GET and HEAD for files, and any method for
+// proxies and other handlers. OPTIONS is always allowed, and TRACE never is.
+func (h *Handler) AddAllowedMethods(methods map[string][]string) {
+	for prefix, list := range methods {
+		allowed := []string{}
+
+		for _, method := range list {
+			method = strings.ToUpper(method)
+
+			if method == http.MethodTrace {
+				logger.GlobalLog.LogWarn("Ignoring TRACE as an allowed method for prefix '" + prefix + "', it is never allowed")
+				continue
+			}
+
+			allowed = append(allowed, method)
+		}
+
+		logger.GlobalLog.LogInfo("Allowing methods " + strings.Join(allowed, ", ") + " for prefix '" + prefix + "'")
+		h.allowedMethods[prefix] = allowed
+	}
+}
+
+// Gives the methods allowed for the given URL path by the longest matching
+// prefix, or the given defaults if none match.
+func (h *Handler) methodsFor(uriPath string, defaults []string) []string {
+	longest := ""
+	methods := defaults
+
+	for prefix, allowed := range h.allowedMethods {
+		if strings.HasPrefix(uriPath, prefix) && len(prefix) >= len(longest) {
+			longest = prefix
+			methods = allowed
+		}
+	}
+
+	return methods
+}
+
+// Responds to a request whose method is not one of the given methods, giving
+// false if it is one of them and the request should be served. OPTIONS requests
+// are answered with the allowed methods, others are refused with 405 Method Not
+// Allowed. HEAD is allowed wherever GET is.
+func (h *Handler) refuseMethod(w http.ResponseWriter, req *http.Request, methods []string) bool {
+	for _, method := range methods {
+		if req.Method == method || (req.Method == http.MethodHead && method == http.MethodGet) {
+			return false
+		}
+	}
+
+	allow := strings.Join(append(append([]string{}, methods...), http.MethodOptions), ", ")
+	w.Header().Set("Allow", allow)
+
+	if req.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return true
+	}
+
+	logger.GlobalLog.LogWarn("Refused " + req.Method + " request for '" + req.URL.Path + "' from " + req.RemoteAddr + ", allowed methods are " + allow)
+	h.serveError(w, req, http.StatusMethodNotAllowed)
+	return true
+}
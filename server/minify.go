@@ -0,0 +1,361 @@
+// Copyright (c) 2024 Evan Overman (https://an-prata.it).
+// Licensed under the MIT License.
+// See LICENSE file in repository root for complete license text.
+
+package server
+
+import (
+	"bytes"
+	"io/fs"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/an-prata/webby/logger"
+)
+
+// Minifies text assets when they are served, caching the result until the file
+// changes. The minifiers are conservative, only removing comments and
+// collapsing whitespace, so that they are safe for sites without a build step.
+type minifier struct {
+	// Minifying functions by file extension.
+	types map[string]func([]byte) []byte
+
+	mutex sync.Mutex
+	cache map[string]minifiedFile
+}
+
+// A cached minified file along with the stat it was minified from.
+type minifiedFile struct {
+	modTime time.Time
+	size    int64
+	content []byte
+	etag    string
+}
+
+// Creates a minifier for the given types, which may be "html", "css", or "js".
+// Unknown types are logged and ignored.
+func newMinifier(types []string) *minifier {
+	m := &minifier{map[string]func([]byte) []byte{}, sync.Mutex{}, map[string]minifiedFile{}}
+
+	for _, t := range types {
+		switch strings.ToLower(t) {
+		case "html":
+			m.types[".html"] = minifyHtml
+			m.types[".htm"] = minifyHtml
+		case "css":
+			m.types[".css"] = minifyCss
+		case "js":
+			m.types[".js"] = minifyJs
+			m.types[".mjs"] = minifyJs
+		default:
+			logger.GlobalLog.LogWarn("Unknown minification type '" + t + "'")
+		}
+	}
+
+	return m
+}
+
+// Serves the minified content of the given file if its type is minified,
+// returning false without writing a response otherwise. If directed, an ETag
+// from a hash of the minified content is also given.
+func (m *minifier) serve(w http.ResponseWriter, req *http.Request, fsys fs.FS, file string, etag bool) bool {
+	minify, ok := m.types[strings.ToLower(filepath.Ext(file))]
+
+	if !ok {
+		return false
+	}
+
+	stat, err := fs.Stat(fsys, file)
+
+	if err != nil {
+		return false
+	}
+
+	m.mutex.Lock()
+	cached, ok := m.cache[file]
+	m.mutex.Unlock()
+
+	if !ok || cached.modTime != stat.ModTime() || cached.size != stat.Size() {
+		content, err := fs.ReadFile(fsys, file)
+
+		if err != nil {
+			logger.GlobalLog.LogErr("Could not read '" + file + "' for minification")
+			return false
+		}
+
+		minified := minify(content)
+		cached = minifiedFile{stat.ModTime(), stat.Size(), minified, contentEtag(minified)}
+
+		m.mutex.Lock()
+		m.cache[file] = cached
+		m.mutex.Unlock()
+	}
+
+	if etag {
+		w.Header().Set("ETag", cached.etag)
+	}
+
+	http.ServeContent(w, req, file, cached.modTime, bytes.NewReader(cached.content))
+	return true
+}
+
+// Removes comments from HTML and collapses whitespace, leaving the contents of
+// "pre", "textarea", "script", and "style" elements untouched.
+func minifyHtml(src []byte) []byte {
+	out := make([]byte, 0, len(src))
+	lower := bytes.ToLower(src)
+
+	for i := 0; i < len(src); {
+		if bytes.HasPrefix(src[i:], []byte("<!--")) && !bytes.HasPrefix(src[i:], []byte("<!--[if")) {
+			end := bytes.Index(src[i+4:], []byte("-->"))
+
+			if end < 0 {
+				break
+			}
+
+			i += 4 + end + 3
+			continue
+		}
+
+		if src[i] == '<' {
+			skipped := false
+
+			for _, tag := range []string{"pre", "textarea", "script", "style"} {
+				if !isOpeningTag(lower[i:], tag) {
+					continue
+				}
+
+				end := bytes.Index(lower[i:], []byte("</"+tag))
+
+				if end < 0 {
+					end = len(src) - i
+				}
+
+				out = append(out, src[i:i+end]...)
+				i += end
+				skipped = true
+				break
+			}
+
+			if skipped {
+				continue
+			}
+		}
+
+		if isSpace(src[i]) {
+			for i < len(src) && isSpace(src[i]) {
+				i++
+			}
+
+			if len(out) == 0 || out[len(out)-1] != ' ' {
+				out = append(out, ' ')
+			}
+
+			continue
+		}
+
+		out = append(out, src[i])
+		i++
+	}
+
+	return bytes.TrimSpace(out)
+}
+
+// Returns true if the given lowercase source starts with an opening tag of the
+// given name.
+func isOpeningTag(src []byte, tag string) bool {
+	if !bytes.HasPrefix(src, []byte("<"+tag)) || len(src) <= len(tag)+1 {
+		return false
+	}
+
+	next := src[len(tag)+1]
+	return next == '>' || next == '/' || isSpace(next)
+}
+
+// Removes comments from CSS and collapses whitespace, removing it entirely
+// around braces, semicolons, and commas. Strings are left untouched.
+func minifyCss(src []byte) []byte {
+	out := make([]byte, 0, len(src))
+
+	for i := 0; i < len(src); {
+		c := src[i]
+
+		if c == '/' && i+1 < len(src) && src[i+1] == '*' {
+			end := bytes.Index(src[i+2:], []byte("*/"))
+
+			if end < 0 {
+				break
+			}
+
+			i += 2 + end + 2
+			continue
+		}
+
+		if c == '"' || c == '\'' {
+			end := skipString(src, i)
+			out = append(out, src[i:end]...)
+			i = end
+			continue
+		}
+
+		if isSpace(c) {
+			for i < len(src) && isSpace(src[i]) {
+				i++
+			}
+
+			if len(out) > 0 && i < len(src) && !strings.ContainsRune("{};,", rune(out[len(out)-1])) && !strings.ContainsRune("{};,", rune(src[i])) {
+				out = append(out, ' ')
+			}
+
+			continue
+		}
+
+		out = append(out, c)
+		i++
+	}
+
+	return out
+}
+
+// Removes comments from JavaScript, strips indentation and empty lines, and
+// collapses other whitespace. Line breaks are kept so that automatic semicolon
+// insertion is unaffected. Strings, template literals, and regular expression
+// literals are left untouched.
+func minifyJs(src []byte) []byte {
+	out := make([]byte, 0, len(src))
+
+	// Characters after which a '/' begins a regular expression rather than
+	// division.
+	const regexPreceders = "(,=:[!&|?{};+-*%<>~^"
+
+	lastSignificant := func() byte {
+		for j := len(out) - 1; j >= 0; j-- {
+			if !isSpace(out[j]) {
+				return out[j]
+			}
+		}
+
+		return 0
+	}
+
+	for i := 0; i < len(src); {
+		c := src[i]
+
+		switch {
+		case c == '/' && i+1 < len(src) && src[i+1] == '/':
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+
+		case c == '/' && i+1 < len(src) && src[i+1] == '*':
+			end := bytes.Index(src[i+2:], []byte("*/"))
+
+			if end < 0 {
+				i = len(src)
+				break
+			}
+
+			// A block comment spanning lines still seperates statements.
+			if bytes.IndexByte(src[i:i+2+end], '\n') >= 0 {
+				out = append(out, '\n')
+			} else {
+				out = append(out, ' ')
+			}
+
+			i += 2 + end + 2
+
+		case c == '"' || c == '\'' || c == '`':
+			end := skipString(src, i)
+			out = append(out, src[i:end]...)
+			i = end
+
+		case c == '/' && (lastSignificant() == 0 || strings.IndexByte(regexPreceders, lastSignificant()) >= 0):
+			end := skipRegex(src, i)
+			out = append(out, src[i:end]...)
+			i = end
+
+		case isSpace(c):
+			newline := false
+
+			for i < len(src) && isSpace(src[i]) {
+				newline = newline || src[i] == '\n'
+				i++
+			}
+
+			// Drop trailing spaces before emitting whitespace.
+			for len(out) > 0 && out[len(out)-1] == ' ' {
+				out = out[:len(out)-1]
+			}
+
+			if len(out) == 0 || out[len(out)-1] == '\n' {
+				continue
+			}
+
+			if newline {
+				out = append(out, '\n')
+			} else {
+				out = append(out, ' ')
+			}
+
+		default:
+			out = append(out, c)
+			i++
+		}
+	}
+
+	return bytes.TrimSpace(out)
+}
+
+// Gives the index just past the end of the string starting at the given index,
+// whose character is the string's quote.
+func skipString(src []byte, start int) int {
+	quote := src[start]
+
+	for i := start + 1; i < len(src); i++ {
+		if src[i] == '\\' {
+			i++
+		} else if src[i] == quote {
+			return i + 1
+		}
+	}
+
+	return len(src)
+}
+
+// Gives the index just past the end of the regular expression literal starting
+// at the given index, including any flags.
+func skipRegex(src []byte, start int) int {
+	inClass := false
+
+	for i := start + 1; i < len(src); i++ {
+		switch src[i] {
+		case '\\':
+			i++
+		case '[':
+			inClass = true
+		case ']':
+			inClass = false
+		case '\n':
+			// Not a regular expression after all, leave the line as is.
+			return i
+		case '/':
+			if inClass {
+				continue
+			}
+
+			for i++; i < len(src) && (src[i] >= 'a' && src[i] <= 'z'); i++ {
+			}
+
+			return i
+		}
+	}
+
+	return len(src)
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
@@ -0,0 +1,51 @@
+// Copyright (c) 2024 Evan Overman (https://an-prata.it).
+// Licensed under the MIT License.
+// See LICENSE file in repository root for complete license text.
+
+package server
+
+import (
+	"errors"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/an-prata/webby/logger"
+)
+
+// Maps each directory to paths under the URL prefix it is mounted at, e.g.
+// {"/docs": "/srv/docs-build"}, over paths already mapped. Where mounts overlap
+// the longest prefix takes priority. Returns an error if a directory could not
+// be statted.
+func (h *Handler) AddMounts(mounts map[string]string) error {
+	prefixes := []string{}
+
+	for prefix := range mounts {
+		prefixes = append(prefixes, prefix)
+	}
+
+	// Longer prefixes are mapped last so that they replace the paths of shorter
+	// ones they lie within.
+	sort.Slice(prefixes, func(i, j int) bool {
+		return len(prefixes[i]) < len(prefixes[j])
+	})
+
+	for _, prefix := range prefixes {
+		dirPath := mounts[prefix]
+
+		if stat, err := os.Stat(dirPath); err != nil || !stat.IsDir() {
+			logger.GlobalLog.LogErr("Could not mount '" + dirPath + "' at '" + prefix + "' due to failed stat")
+			return errors.New("Could not stat mounted directory '" + dirPath + "'")
+		}
+
+		if !strings.HasSuffix(dirPath, "/") {
+			dirPath += "/"
+		}
+
+		if err := h.MapDirAt("/"+strings.TrimPrefix(prefix, "/"), dirPath); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,591 @@
+// Copyright (c) 2024 Evan Overman (https://an-prata.it).
+// Licensed under the MIT License.
+// See LICENSE file in repository root for complete license text.
+
+package server
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/an-prata/webby/logger"
+)
+
+const (
+	// Cookie holding a signed login session.
+	oidcSessionCookie = "webby_session"
+
+	// Cookie holding the signed state, nonce, and original path of a login in
+	// progress.
+	oidcStateCookie = "webby_oidc_state"
+
+	// How long a login may take before its state cookie expires.
+	oidcStateLength = 10 * time.Minute
+)
+
+// Protects configured path prefixes by requiring an OpenID Connect login,
+// acting as a relying party using the authorization code flow.
+type oidcAuth struct {
+	issuer        string
+	clientId      string
+	clientSecret  string
+	redirectUrl   string
+	callbackPath  string
+	prefixes      []string
+	claim         string
+	allowedValues []string
+	allowedEmails []string
+	sessionLength time.Duration
+
+	// Key for signing session and state cookies, generated randomly so sessions
+	// do not survive the server being reinstantiated.
+	sessionKey []byte
+
+	client *http.Client
+
+	// Provider metadata and signing keys, fetched when first needed.
+	mutex    sync.Mutex
+	provider *oidcProvider
+	keys     map[string]*rsa.PublicKey
+}
+
+// The parts of an OpenID Connect discovery document that webby uses.
+type oidcProvider struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JwksUri               string `json:"jwks_uri"`
+}
+
+// Creates a new OpenID Connect authenticator from the given options. Returns
+// an error if the redirect URL cannot be parsed.
+func newOidcAuth(opts ServerOptions) (*oidcAuth, error) {
+	redirect, err := url.Parse(opts.OidcRedirectUrl)
+
+	if err != nil || redirect.Path == "" {
+		return nil, errors.New("Could not parse OpenID Connect redirect URL '" + opts.OidcRedirectUrl + "'")
+	}
+
+	sessionKey := make([]byte, 32)
+
+	if _, err := rand.Read(sessionKey); err != nil {
+		return nil, errors.New("Could not generate OpenID Connect session key: " + err.Error())
+	}
+
+	return &oidcAuth{
+		issuer:        strings.TrimSuffix(opts.OidcIssuer, "/"),
+		clientId:      opts.OidcClientId,
+		clientSecret:  opts.OidcClientSecret,
+		redirectUrl:   opts.OidcRedirectUrl,
+		callbackPath:  redirect.Path,
+		prefixes:      opts.OidcPrefixes,
+		claim:         opts.OidcClaim,
+		allowedValues: opts.OidcAllowedValues,
+		allowedEmails: opts.OidcAllowedEmails,
+		sessionLength: time.Duration(opts.OidcSessionLength) * time.Second,
+		sessionKey:    sessionKey,
+		client:        &http.Client{Timeout: 10 * time.Second},
+		keys:          map[string]*rsa.PublicKey{},
+	}, nil
+}
+
+// Checks that the given request may proceed, returning true if so. When false
+// is returned a response has already been written, either redirecting to the
+// provider for login or completing a login.
+func (a *oidcAuth) authorize(w http.ResponseWriter, req *http.Request) bool {
+	if req.URL.Path == a.callbackPath {
+		a.handleCallback(w, req)
+		return false
+	}
+
+	if !a.protects(req.URL.Path) {
+		return true
+	}
+
+	if cookie, err := req.Cookie(oidcSessionCookie); err == nil {
+		if _, err := a.verifyCookie(cookie.Value); err == nil {
+			return true
+		}
+	}
+
+	a.redirectToProvider(w, req)
+	return false
+}
+
+// Returns true if the given path falls under a protected prefix.
+func (a *oidcAuth) protects(path string) bool {
+	for _, prefix := range a.prefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Starts a login by redirecting the client to the provider's authorization
+// endpoint.
+func (a *oidcAuth) redirectToProvider(w http.ResponseWriter, req *http.Request) {
+	provider, err := a.getProvider()
+
+	if err != nil {
+		logger.GlobalLog.LogErr(err.Error())
+		http.Error(w, "Login is currently unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	state := randomToken()
+	nonce := randomToken()
+	expiry := time.Now().Add(oidcStateLength)
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    a.signCookie(expiry, state, nonce, req.URL.RequestURI()),
+		Path:     "/",
+		Expires:  expiry,
+		HttpOnly: true,
+		Secure:   req.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", a.clientId)
+	query.Set("redirect_uri", a.redirectUrl)
+	query.Set("scope", "openid profile email")
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+
+	logger.GlobalLog.LogInfo("Redirecting request from '" + req.RemoteAddr + "' for '" + req.URL.Path + "' to OpenID Connect login")
+	http.Redirect(w, req, provider.AuthorizationEndpoint+"?"+query.Encode(), http.StatusFound)
+}
+
+// Completes a login once the provider redirects back, exchanging the given code
+// for an ID token and starting a session if its claims allow access.
+func (a *oidcAuth) handleCallback(w http.ResponseWriter, req *http.Request) {
+	cookie, err := req.Cookie(oidcStateCookie)
+
+	if err != nil {
+		http.Error(w, "No login in progress", http.StatusBadRequest)
+		return
+	}
+
+	fields, err := a.verifyCookie(cookie.Value)
+
+	if err != nil || len(fields) != 3 || fields[0] != req.URL.Query().Get("state") {
+		http.Error(w, "Invalid login state", http.StatusBadRequest)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Path: "/", MaxAge: -1})
+
+	if providerErr := req.URL.Query().Get("error"); providerErr != "" {
+		logger.GlobalLog.LogWarn("OpenID Connect provider refused login from '" + req.RemoteAddr + "': " + providerErr)
+		http.Error(w, "Login failed", http.StatusForbidden)
+		return
+	}
+
+	claims, err := a.exchangeCode(req.URL.Query().Get("code"), fields[1])
+
+	if err != nil {
+		logger.GlobalLog.LogErr("OpenID Connect login from '" + req.RemoteAddr + "' failed: " + err.Error())
+		http.Error(w, "Login failed", http.StatusForbidden)
+		return
+	}
+
+	subject, _ := claims["sub"].(string)
+
+	if !a.claimsAllowed(claims) {
+		logger.GlobalLog.LogWarn("OpenID Connect user '" + subject + "' is not allowed access")
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	expiry := time.Now().Add(a.sessionLength)
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcSessionCookie,
+		Value:    a.signCookie(expiry, subject),
+		Path:     "/",
+		Expires:  expiry,
+		HttpOnly: true,
+		Secure:   req.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	// Only redirect to local paths so the state cannot be used as an open
+	// redirect.
+	target := fields[2]
+
+	if !strings.HasPrefix(target, "/") || strings.HasPrefix(target, "//") {
+		target = "/"
+	}
+
+	logger.GlobalLog.LogInfo("OpenID Connect user '" + subject + "' logged in from '" + req.RemoteAddr + "'")
+	http.Redirect(w, req, target, http.StatusFound)
+}
+
+// Returns true if the configured claim holds one of the allowed values, or if
+// no claim or values are configured, and the email claim is allowed, see
+// `oidcAuth.emailAllowed()`.
+func (a *oidcAuth) claimsAllowed(claims map[string]interface{}) bool {
+	if !a.emailAllowed(claims) {
+		return false
+	}
+
+	if a.claim == "" || len(a.allowedValues) == 0 {
+		return true
+	}
+
+	values := []string{}
+
+	switch claim := claims[a.claim].(type) {
+	case string:
+		values = append(values, claim)
+	case []interface{}:
+		for _, v := range claim {
+			if str, ok := v.(string); ok {
+				values = append(values, str)
+			}
+		}
+	}
+
+	for _, value := range values {
+		for _, allowed := range a.allowedValues {
+			if value == allowed {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Returns true if the email claim is one of the allowed addresses or under one
+// of the allowed domains, given as "@example.com", or if none are configured.
+// Addresses the provider marks as unverified are never allowed.
+func (a *oidcAuth) emailAllowed(claims map[string]interface{}) bool {
+	if len(a.allowedEmails) == 0 {
+		return true
+	}
+
+	email, _ := claims["email"].(string)
+
+	if verified, ok := claims["email_verified"].(bool); email == "" || (ok && !verified) {
+		return false
+	}
+
+	email = strings.ToLower(email)
+
+	for _, allowed := range a.allowedEmails {
+		allowed = strings.ToLower(allowed)
+
+		if email == allowed || (strings.HasPrefix(allowed, "@") && strings.HasSuffix(email, allowed)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Exchanges an authorization code for an ID token at the provider's token
+// endpoint and returns the token's verified claims.
+func (a *oidcAuth) exchangeCode(code, nonce string) (map[string]interface{}, error) {
+	provider, err := a.getProvider()
+
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := a.client.PostForm(provider.TokenEndpoint, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {a.redirectUrl},
+		"client_id":     {a.clientId},
+		"client_secret": {a.clientSecret},
+	})
+
+	if err != nil {
+		return nil, errors.New("Could not reach token endpoint: " + err.Error())
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, errors.New("Token endpoint responded with " + response.Status)
+	}
+
+	var token struct {
+		IdToken string `json:"id_token"`
+	}
+
+	if err := json.NewDecoder(response.Body).Decode(&token); err != nil || token.IdToken == "" {
+		return nil, errors.New("Token endpoint gave no ID token")
+	}
+
+	return a.verifyIdToken(token.IdToken, nonce)
+}
+
+// Verifies the signature, issuer, audience, expiry, and nonce of an RS256
+// signed ID token, returning its claims.
+func (a *oidcAuth) verifyIdToken(token, nonce string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+
+	if len(parts) != 3 {
+		return nil, errors.New("Malformed ID token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+
+	if err := decodeJwtPart(parts[0], &header); err != nil {
+		return nil, err
+	}
+
+	if header.Alg != "RS256" {
+		return nil, errors.New("Unsupported ID token algorithm '" + header.Alg + "'")
+	}
+
+	key, err := a.getKey(header.Kid)
+
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+
+	if err != nil {
+		return nil, errors.New("Malformed ID token signature")
+	}
+
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+
+	if rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) != nil {
+		return nil, errors.New("Invalid ID token signature")
+	}
+
+	var claims map[string]interface{}
+
+	if err := decodeJwtPart(parts[1], &claims); err != nil {
+		return nil, err
+	}
+
+	provider, _ := a.getProvider()
+
+	if issuer, _ := claims["iss"].(string); issuer != provider.Issuer {
+		return nil, errors.New("ID token issued by unexpected issuer '" + issuer + "'")
+	}
+
+	audienceOk := false
+
+	switch audience := claims["aud"].(type) {
+	case string:
+		audienceOk = audience == a.clientId
+	case []interface{}:
+		for _, aud := range audience {
+			audienceOk = audienceOk || aud == a.clientId
+		}
+	}
+
+	if !audienceOk {
+		return nil, errors.New("ID token was not issued for this client")
+	}
+
+	if expiry, ok := claims["exp"].(float64); !ok || time.Now().Unix() >= int64(expiry) {
+		return nil, errors.New("ID token has expired")
+	}
+
+	if claimNonce, _ := claims["nonce"].(string); claimNonce != nonce {
+		return nil, errors.New("ID token nonce does not match")
+	}
+
+	return claims, nil
+}
+
+// Gets the provider's discovery document, fetching it if it has not been yet.
+func (a *oidcAuth) getProvider() (*oidcProvider, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if a.provider != nil {
+		return a.provider, nil
+	}
+
+	var provider oidcProvider
+
+	if err := a.getJson(a.issuer+"/.well-known/openid-configuration", &provider); err != nil {
+		return nil, errors.New("Could not get OpenID Connect provider configuration: " + err.Error())
+	}
+
+	a.provider = &provider
+	return a.provider, nil
+}
+
+// Gets the provider's signing key with the given ID, refetching the provider's
+// keys if it is not known, since keys may be rotated.
+func (a *oidcAuth) getKey(kid string) (*rsa.PublicKey, error) {
+	provider, err := a.getProvider()
+
+	if err != nil {
+		return nil, err
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if key, ok := a.keys[kid]; ok {
+		return key, nil
+	}
+
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+
+	if err := a.getJson(provider.JwksUri, &jwks); err != nil {
+		return nil, errors.New("Could not get OpenID Connect provider keys: " + err.Error())
+	}
+
+	for _, jwk := range jwks.Keys {
+		if jwk.Kty != "RSA" {
+			continue
+		}
+
+		n, errN := base64.RawURLEncoding.DecodeString(jwk.N)
+		e, errE := base64.RawURLEncoding.DecodeString(jwk.E)
+
+		if errN != nil || errE != nil {
+			logger.GlobalLog.LogWarn("Skipping malformed OpenID Connect provider key '" + jwk.Kid + "'")
+			continue
+		}
+
+		a.keys[jwk.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	if key, ok := a.keys[kid]; ok {
+		return key, nil
+	}
+
+	return nil, errors.New("No OpenID Connect provider key with ID '" + kid + "'")
+}
+
+// Makes a GET request to the given URL and decodes the JSON response into v.
+func (a *oidcAuth) getJson(uri string, v interface{}) error {
+	response, err := a.client.Get(uri)
+
+	if err != nil {
+		return err
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return errors.New("'" + uri + "' responded with " + response.Status)
+	}
+
+	return json.NewDecoder(response.Body).Decode(v)
+}
+
+// Joins the given fields with an expiry time and signs them for use as a
+// cookie value.
+func (a *oidcAuth) signCookie(expiry time.Time, fields ...string) string {
+	encoded := []string{strconv.FormatInt(expiry.Unix(), 10)}
+
+	for _, field := range fields {
+		encoded = append(encoded, base64.RawURLEncoding.EncodeToString([]byte(field)))
+	}
+
+	payload := strings.Join(encoded, ".")
+	mac := hmac.New(sha256.New, a.sessionKey)
+	mac.Write([]byte(payload))
+	return payload + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Verifies a cookie value given by `oidcAuth.signCookie()` and returns its
+// fields. Returns an error if the signature is invalid or the cookie expired.
+func (a *oidcAuth) verifyCookie(value string) ([]string, error) {
+	i := strings.LastIndex(value, ".")
+
+	if i < 0 {
+		return nil, errors.New("Malformed cookie")
+	}
+
+	payload := value[:i]
+	signature, err := base64.RawURLEncoding.DecodeString(value[i+1:])
+
+	if err != nil {
+		return nil, errors.New("Malformed cookie signature")
+	}
+
+	mac := hmac.New(sha256.New, a.sessionKey)
+	mac.Write([]byte(payload))
+
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return nil, errors.New("Invalid cookie signature")
+	}
+
+	encoded := strings.Split(payload, ".")
+	expiry, err := strconv.ParseInt(encoded[0], 10, 64)
+
+	if err != nil || time.Now().Unix() >= expiry {
+		return nil, errors.New("Cookie has expired")
+	}
+
+	fields := []string{}
+
+	for _, field := range encoded[1:] {
+		decoded, err := base64.RawURLEncoding.DecodeString(field)
+
+		if err != nil {
+			return nil, errors.New("Malformed cookie")
+		}
+
+		fields = append(fields, string(decoded))
+	}
+
+	return fields, nil
+}
+
+// Decodes a base64 encoded JSON section of a JWT into v.
+func decodeJwtPart(part string, v interface{}) error {
+	decoded, err := base64.RawURLEncoding.DecodeString(part)
+
+	if err != nil {
+		return errors.New("Malformed ID token")
+	}
+
+	if err := json.Unmarshal(decoded, v); err != nil {
+		return errors.New("Malformed ID token")
+	}
+
+	return nil
+}
+
+// Generates a random, URL safe token.
+func randomToken() string {
+	buf := make([]byte, 24)
+	rand.Read(buf)
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
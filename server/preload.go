@@ -0,0 +1,183 @@
+// Copyright (c) 2024 Evan Overman (https://an-prata.it).
+// Licensed under the MIT License.
+// See LICENSE file in repository root for complete license text.
+
+package server
+
+import (
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/an-prata/webby/logger"
+)
+
+// Gives a Link header value preloading the given asset. Values already in Link
+// header form (e.g. "</app.js>; rel=preload; as=script") are given unchanged,
+// otherwise the asset's type is guessed from its extension.
+func preloadLink(asset string) string {
+	if strings.HasPrefix(asset, "<") {
+		return asset
+	}
+
+	link := "<" + asset + ">; rel=preload"
+
+	file, _, _ := strings.Cut(asset, "?")
+
+	switch strings.ToLower(path.Ext(file)) {
+	case ".css":
+		link += "; as=style"
+	case ".js", ".mjs":
+		link += "; as=script"
+	case ".woff", ".woff2", ".ttf", ".otf":
+		// Fonts are always fetched in CORS mode.
+		link += "; as=font; crossorigin"
+	case ".jpg", ".jpeg", ".png", ".gif", ".webp", ".avif", ".svg", ".ico":
+		link += "; as=image"
+	case ".json":
+		link += "; as=fetch; crossorigin"
+	}
+
+	return link
+}
+
+// Adds Link preload headers for the given assets to the response, and sends
+// them in a 103 Early Hints response first if directed to and the client is
+// using HTTP/2 or newer. Early hints are not sent over HTTP/1.x since some
+// older clients mishandle informational responses.
+func writePreloads(w http.ResponseWriter, req *http.Request, assets []string, earlyHints bool) {
+	for _, asset := range assets {
+		w.Header().Add("Link", preloadLink(asset))
+	}
+
+	if earlyHints && req.ProtoMajor >= 2 {
+		w.WriteHeader(http.StatusEarlyHints)
+	}
+}
+
+// Most of an HTML document read when looking for assets in its head.
+const preloadScanBytes = 64 << 10
+
+var (
+	preloadHeadEnd   = regexp.MustCompile(`(?i)</head\s*>|<body[\s>]`)
+	preloadTag       = regexp.MustCompile(`(?i)<(link|script)\b[^>]*>`)
+	preloadAttribute = regexp.MustCompile(`([a-zA-Z-]+)\s*=\s*("[^"]*"|'[^']*'|[^\s"'>]+)`)
+)
+
+// Preloads the stylesheets and scripts linked from the head of each mapped HTML
+// page, found by scanning the pages now, along with any assets they already
+// preload. Only assets of the site itself are preloaded, cross origin assets
+// would need more than a Link header gives. Assets given by
+// `Handler.AddPreloads()` are kept.
+func (h *Handler) DerivePreloads() {
+	found := map[string][]string{}
+
+	for uriPath, file := range h.PathMap {
+		if ext := strings.ToLower(path.Ext(file)); ext != ".html" && ext != ".htm" {
+			continue
+		}
+
+		assets, ok := found[file]
+
+		if !ok {
+			assets = headAssets(h.fsys, file, uriPath)
+			found[file] = assets
+		}
+
+		for _, asset := range assets {
+			if !containsString(h.preloads[uriPath], asset) {
+				h.preloads[uriPath] = append(h.preloads[uriPath], asset)
+			}
+		}
+	}
+
+	for file, assets := range found {
+		if len(assets) > 0 {
+			logger.GlobalLog.LogInfo("Preloading " + strings.Join(assets, ", ") + " for '" + file + "'")
+		}
+	}
+}
+
+// Gives the URL paths of the stylesheets, scripts, and preloaded assets in the
+// head of the given HTML file, resolving relative paths against the page's URL
+// path.
+func headAssets(fsys fs.FS, file, uriPath string) []string {
+	f, err := fsys.Open(file)
+
+	if err != nil {
+		return nil
+	}
+
+	defer f.Close()
+	head, err := io.ReadAll(io.LimitReader(f, preloadScanBytes))
+
+	if err != nil {
+		return nil
+	}
+
+	if end := preloadHeadEnd.FindIndex(head); end != nil {
+		head = head[:end[0]]
+	}
+
+	dir := uriPath
+
+	if !strings.HasSuffix(dir, "/") {
+		dir = path.Dir(dir) + "/"
+	}
+
+	assets := []string{}
+
+	for _, tag := range preloadTag.FindAllSubmatch(head, -1) {
+		attributes := map[string]string{}
+
+		for _, attribute := range preloadAttribute.FindAllSubmatch(tag[0], -1) {
+			attributes[strings.ToLower(string(attribute[1]))] = strings.Trim(string(attribute[2]), `"'`)
+		}
+
+		var asset string
+		module := false
+
+		if strings.EqualFold(string(tag[1]), "script") {
+			asset = attributes["src"]
+			module = strings.EqualFold(attributes["type"], "module")
+		} else if rel := strings.Fields(strings.ToLower(attributes["rel"])); containsString(rel, "stylesheet") || containsString(rel, "preload") {
+			asset = attributes["href"]
+		}
+
+		// Absolute URLs, protocol relative URLs, and data URIs are left alone.
+		if asset == "" || strings.Contains(asset, ":") || strings.HasPrefix(asset, "//") {
+			continue
+		}
+
+		asset, _, _ = strings.Cut(asset, "#")
+
+		if !strings.HasPrefix(asset, "/") {
+			asset = path.Join(dir, asset)
+		}
+
+		// Modules are fetched in CORS mode, which a plain preload would not match.
+		if module {
+			asset = "<" + asset + ">; rel=modulepreload"
+		}
+
+		if !containsString(assets, asset) {
+			assets = append(assets, asset)
+		}
+	}
+
+	return assets
+}
+
+// Returns true if the given list contains the given string.
+func containsString(list []string, s string) bool {
+	for _, element := range list {
+		if element == s {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,86 @@
+// Copyright (c) 2024 Evan Overman (https://an-prata.it).
+// Licensed under the MIT License.
+// See LICENSE file in repository root for complete license text.
+
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/an-prata/webby/logger"
+)
+
+// Proxies requests under a URL prefix to an upstream server.
+type proxyRule struct {
+	prefix   string
+	upstream string
+	proxy    *httputil.ReverseProxy
+}
+
+// Returns true if the given path is the rule's prefix or is beneath it.
+func (p proxyRule) matches(path string) bool {
+	return path == p.prefix || strings.HasPrefix(path, strings.TrimSuffix(p.prefix, "/")+"/")
+}
+
+// Proxies requests for paths under each prefix to the upstream URL it maps to,
+// the request path being appended to the upstream URL unchanged. Where prefixes
+// overlap the longest is used.
+func (h *Handler) AddProxies(proxies map[string]string) error {
+	for prefix, upstream := range proxies {
+		target, err := url.Parse(upstream)
+
+		if err != nil || target.Scheme == "" || target.Host == "" {
+			return errors.New("Could not parse upstream URL '" + upstream + "' for prefix '" + prefix + "'")
+		}
+
+		if len(prefix) == 0 || prefix[0] != '/' {
+			prefix = "/" + prefix
+		}
+
+		proxy := httputil.NewSingleHostReverseProxy(target)
+		director := proxy.Director
+
+		proxy.Director = func(req *http.Request) {
+			director(req)
+			req.Header.Set("X-Forwarded-Host", req.Host)
+
+			if req.TLS != nil {
+				req.Header.Set("X-Forwarded-Proto", "https")
+			} else {
+				req.Header.Set("X-Forwarded-Proto", "http")
+			}
+		}
+
+		proxy.ErrorHandler = func(w http.ResponseWriter, req *http.Request, err error) {
+			logger.GlobalLog.LogErr("Could not proxy request for '" + req.URL.Path + "' to '" + upstream + "': " + err.Error())
+			h.serveError(w, req, http.StatusBadGateway)
+		}
+
+		proxy.ErrorLog = logger.GlobalLog.StdLogger(logger.Warn)
+
+		logger.GlobalLog.LogInfo("Proxying URI prefix '" + prefix + "' to '" + upstream + "'")
+		h.proxies = append(h.proxies, proxyRule{prefix, upstream, proxy})
+	}
+
+	sort.SliceStable(h.proxies, func(i, j int) bool {
+		return len(h.proxies[i].prefix) > len(h.proxies[j].prefix)
+	})
+
+	return nil
+}
+
+// Gives the proxy rule for the given path, if any.
+func (h *Handler) proxyFor(path string) (proxyRule, bool) {
+	for _, rule := range h.proxies {
+		if rule.matches(path) {
+			return rule, true
+		}
+	}
+
+	return proxyRule{}, false
+}
@@ -0,0 +1,188 @@
+// Copyright (c) 2024 Evan Overman (https://an-prata.it).
+// Licensed under the MIT License.
+// See LICENSE file in repository root for complete license text.
+
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/an-prata/webby/logger"
+)
+
+// Time allowed for a client to send its PROXY protocol header.
+const proxyHeaderTimeout = 5 * time.Second
+
+// Signature beginning every PROXY protocol version 2 header.
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// Accepts connections that begin with a PROXY protocol header, as sent by
+// HAProxy or stunnel in TCP mode, giving them the client address from the
+// header. Connections without a valid header are closed.
+type proxyProtocolListener struct {
+	net.Listener
+}
+
+// A connection whose PROXY protocol header is read on first use, so that a
+// slow client cannot hold up accepting others.
+type proxyProtocolConn struct {
+	net.Conn
+	reader *bufio.Reader
+
+	once       sync.Once
+	remoteAddr net.Addr
+	err        error
+}
+
+func (l proxyProtocolListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &proxyProtocolConn{Conn: conn, reader: bufio.NewReader(conn)}, nil
+}
+
+// Reads the PROXY protocol header once, closing the connection if it is
+// invalid.
+func (c *proxyProtocolConn) readHeader() {
+	c.once.Do(func() {
+		c.Conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+		c.remoteAddr, c.err = readProxyHeader(c.reader)
+		c.Conn.SetReadDeadline(time.Time{})
+
+		if c.err != nil {
+			logger.GlobalLog.LogWarn("Closing connection from " + c.Conn.RemoteAddr().String() + " with invalid PROXY protocol header: " + c.err.Error())
+			c.Conn.Close()
+		}
+
+		// A header without addresses, e.g. a health check by the proxy itself,
+		// leaves the connection's own address.
+		if c.remoteAddr == nil {
+			c.remoteAddr = c.Conn.RemoteAddr()
+		}
+	})
+}
+
+func (c *proxyProtocolConn) Read(b []byte) (int, error) {
+	c.readHeader()
+
+	if c.err != nil {
+		return 0, c.err
+	}
+
+	return c.reader.Read(b)
+}
+
+func (c *proxyProtocolConn) RemoteAddr() net.Addr {
+	c.readHeader()
+	return c.remoteAddr
+}
+
+// Reads a version 1 or 2 PROXY protocol header, giving the client address it
+// carries, or nil if it carries none.
+func readProxyHeader(reader *bufio.Reader) (net.Addr, error) {
+	peek, err := reader.Peek(len(proxyV2Signature))
+
+	if err == nil && bytes.Equal(peek, proxyV2Signature) {
+		return readProxyHeaderV2(reader)
+	}
+
+	if len(peek) >= 6 && string(peek[:6]) == "PROXY " {
+		return readProxyHeaderV1(reader)
+	}
+
+	return nil, errors.New("missing PROXY protocol header")
+}
+
+// Reads a human readable version 1 header, e.g.
+// "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n".
+func readProxyHeaderV1(reader *bufio.Reader) (net.Addr, error) {
+	line := []byte{}
+
+	// Version 1 headers are at most 107 bytes long.
+	for len(line) < 107 && !bytes.HasSuffix(line, []byte("\r\n")) {
+		b, err := reader.ReadByte()
+
+		if err != nil {
+			return nil, err
+		}
+
+		line = append(line, b)
+	}
+
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errors.New("version 1 header is too long")
+	}
+
+	fields := strings.Fields(string(line))
+
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, errors.New("malformed version 1 header")
+	}
+
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+
+	if ip == nil || err != nil {
+		return nil, errors.New("malformed source address in version 1 header")
+	}
+
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// Reads a binary version 2 header.
+func readProxyHeaderV2(reader *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, 16)
+
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, err
+	}
+
+	if header[12]>>4 != 2 {
+		return nil, errors.New("unsupported version 2 header version")
+	}
+
+	body := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+
+	if _, err := io.ReadFull(reader, body); err != nil {
+		return nil, err
+	}
+
+	// Local connections are made by the proxy itself, e.g. for health checks.
+	if header[12]&0x0F == 0 {
+		return nil, nil
+	}
+
+	switch header[13] {
+	case 0x11:
+		if len(body) < 12 {
+			return nil, errors.New("truncated IPv4 addresses in version 2 header")
+		}
+
+		return &net.TCPAddr{IP: net.IP(body[0:4]), Port: int(binary.BigEndian.Uint16(body[8:10]))}, nil
+	case 0x21:
+		if len(body) < 36 {
+			return nil, errors.New("truncated IPv6 addresses in version 2 header")
+		}
+
+		return &net.TCPAddr{IP: net.IP(body[0:16]), Port: int(binary.BigEndian.Uint16(body[32:34]))}, nil
+	}
+
+	// Other address families, such as Unix sockets, carry no client IP.
+	return nil, nil
+}
@@ -0,0 +1,187 @@
+// Copyright (c) 2024 Evan Overman (https://an-prata.it).
+// Licensed under the MIT License.
+// See LICENSE file in repository root for complete license text.
+
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limits on requests and bytes served to a single client IP, zero for no limit.
+type quotaLimits struct {
+	RequestsHourly int64
+	RequestsDaily  int64
+	BytesHourly    int64
+	BytesDaily     int64
+}
+
+// Usage of a single client IP within the current hour and day.
+type quotaUsage struct {
+	hour         time.Time
+	day          time.Time
+	requestsHour int64
+	requestsDay  int64
+	bytesHour    int64
+	bytesDay     int64
+}
+
+// Tracks per client IP usage against hourly and daily quotas. Windows are fixed
+// to the start of each hour and day.
+type quotaTracker struct {
+	limits quotaLimits
+
+	mutex     sync.Mutex
+	usage     map[string]*quotaUsage
+	lastSweep time.Time
+}
+
+func newQuotaTracker(limits quotaLimits) *quotaTracker {
+	return &quotaTracker{limits, sync.Mutex{}, map[string]*quotaUsage{}, time.Now().Truncate(time.Hour)}
+}
+
+// Sets the limits usage is tracked against, keeping the usage tracked so far.
+func (q *quotaTracker) setLimits(limits quotaLimits) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.limits = limits
+}
+
+// Returns true if any quota is set.
+func (l quotaLimits) enabled() bool {
+	return l.RequestsHourly > 0 || l.RequestsDaily > 0 || l.BytesHourly > 0 || l.BytesDaily > 0
+}
+
+// Gets the usage of the given IP, resetting windows that have passed. Must be
+// called with the mutex held.
+func (q *quotaTracker) current(ip string, now time.Time) *quotaUsage {
+	hour := now.Truncate(time.Hour)
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	// Forget clients whose usage is from a previous day once an hour.
+	if hour.After(q.lastSweep) {
+		for k, u := range q.usage {
+			if u.day.Before(day) {
+				delete(q.usage, k)
+			}
+		}
+
+		q.lastSweep = hour
+	}
+
+	usage, ok := q.usage[ip]
+
+	if !ok {
+		usage = &quotaUsage{hour: hour, day: day}
+		q.usage[ip] = usage
+	}
+
+	if usage.hour.Before(hour) {
+		usage.hour = hour
+		usage.requestsHour = 0
+		usage.bytesHour = 0
+	}
+
+	if usage.day.Before(day) {
+		usage.day = day
+		usage.requestsDay = 0
+		usage.bytesDay = 0
+	}
+
+	return usage
+}
+
+// Counts a request from the given IP. Returns false and the time until the
+// exceeded quota resets if the IP has exceeded any quota, in which case the
+// request is not counted.
+func (q *quotaTracker) allow(ip string) (bool, time.Duration) {
+	now := time.Now()
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	usage := q.current(ip, now)
+	l := q.limits
+
+	if (l.RequestsDaily > 0 && usage.requestsDay >= l.RequestsDaily) || (l.BytesDaily > 0 && usage.bytesDay >= l.BytesDaily) {
+		return false, usage.day.AddDate(0, 0, 1).Sub(now)
+	}
+
+	if (l.RequestsHourly > 0 && usage.requestsHour >= l.RequestsHourly) || (l.BytesHourly > 0 && usage.bytesHour >= l.BytesHourly) {
+		return false, usage.hour.Add(time.Hour).Sub(now)
+	}
+
+	usage.requestsHour++
+	usage.requestsDay++
+	return true, 0
+}
+
+// Adds bytes served to the given IP.
+func (q *quotaTracker) addBytes(ip string, n int64) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	usage := q.current(ip, time.Now())
+	usage.bytesHour += n
+	usage.bytesDay += n
+}
+
+// Gives a table of the usage of every client IP seen today, heaviest first.
+func (q *quotaTracker) report() string {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	now := time.Now()
+	ips := []string{}
+
+	for ip := range q.usage {
+		q.current(ip, now)
+		ips = append(ips, ip)
+	}
+
+	sort.Slice(ips, func(i, j int) bool {
+		return q.usage[ips[i]].bytesDay > q.usage[ips[j]].bytesDay
+	})
+
+	var builder strings.Builder
+	builder.WriteString(fmt.Sprintf("%-40s %21s %27s\n", "IP", "Requests (hour/day)", "Bytes (hour/day)"))
+
+	for _, ip := range ips {
+		u := q.usage[ip]
+		builder.WriteString(fmt.Sprintf(
+			"%-40s %21s %27s\n",
+			ip,
+			quotaColumn(u.requestsHour, q.limits.RequestsHourly)+"/"+quotaColumn(u.requestsDay, q.limits.RequestsDaily),
+			quotaColumn(u.bytesHour, q.limits.BytesHourly)+"/"+quotaColumn(u.bytesDay, q.limits.BytesDaily),
+		))
+	}
+
+	return builder.String()
+}
+
+// Formats usage along with its limit, if any.
+func quotaColumn(used, limit int64) string {
+	if limit <= 0 {
+		return strconv.FormatInt(used, 10)
+	}
+
+	return strconv.FormatInt(used, 10) + "(" + strconv.FormatInt(limit, 10) + ")"
+}
+
+// Gets the IP of the client making the given request.
+func clientIp(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+
+	if err != nil {
+		return req.RemoteAddr
+	}
+
+	return host
+}
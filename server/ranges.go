@@ -0,0 +1,65 @@
+// Copyright (c) 2024 Evan Overman (https://an-prata.it).
+// Licensed under the MIT License.
+// See LICENSE file in repository root for complete license text.
+
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/an-prata/webby/logger"
+)
+
+// Sets URL path prefixes under which byte range requests are ignored, always
+// sending whole files and advertising "Accept-Ranges: none", e.g. to keep
+// download managers from opening many connections for one file.
+func (h *Handler) SetNoRangePaths(prefixes []string) {
+	if len(prefixes) > 0 {
+		logger.GlobalLog.LogInfo("Ignoring range requests under " + strings.Join(prefixes, ", "))
+	}
+
+	h.noRangePrefixes = prefixes
+}
+
+// Removes the range headers of a request under a prefix without ranges, giving
+// a writer that advertises as much. Other requests are given the writer as is.
+func (h *Handler) withoutRanges(w http.ResponseWriter, req *http.Request) http.ResponseWriter {
+	if !hasAnyPrefix(req.URL.Path, h.noRangePrefixes) {
+		return w
+	}
+
+	req.Header.Del("Range")
+	req.Header.Del("If-Range")
+	return &noRangeWriter{w, false}
+}
+
+// Replaces the "Accept-Ranges: bytes" set by `http.ServeContent()` before the
+// response header is written.
+type noRangeWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (w *noRangeWriter) WriteHeader(status int) {
+	// Informational responses, such as early hints, precede the real status.
+	if status >= 200 {
+		w.Header().Set("Accept-Ranges", "none")
+		w.wroteHeader = true
+	}
+
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *noRangeWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	return w.ResponseWriter.Write(b)
+}
+
+// Allows `http.ResponseController` to reach the underlying writer.
+func (w *noRangeWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,308 @@
+// Copyright (c) 2024 Evan Overman (https://an-prata.it).
+// Licensed under the MIT License.
+// See LICENSE file in repository root for complete license text.
+
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/an-prata/webby/logger"
+)
+
+// Content of the file served by range tests, long enough to be compressed.
+var rangeContent = strings.Repeat("0123456789", 100)
+
+func init() {
+	logger.GlobalLog.SetPrinting(logger.None)
+	logger.GlobalLog.SetRecording(logger.None)
+}
+
+// Creates a handler serving "/file.txt" with `rangeContent`, along with a
+// gzipped sibling of it, configured by the given function.
+func newRangeHandler(t *testing.T, configure func(h *Handler)) *Handler {
+	t.Helper()
+	dir := t.TempDir()
+
+	if err := os.WriteFile(filepath.Join(dir, "file.txt"), []byte(rangeContent), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var gzipped bytes.Buffer
+	encoder := gzip.NewWriter(&gzipped)
+	encoder.Write([]byte(rangeContent))
+	encoder.Close()
+
+	if err := os.WriteFile(filepath.Join(dir, "file.txt.gz"), gzipped.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	h := NewHandler(false)
+
+	if configure != nil {
+		configure(h)
+	}
+
+	if err := h.MapDir(dir + "/"); err != nil {
+		t.Fatal(err)
+	}
+
+	return h
+}
+
+// Requests "/file.txt" from the given handler with the given headers.
+func getRange(h *Handler, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/file.txt", nil)
+
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestRanges(t *testing.T) {
+	h := newRangeHandler(t, func(h *Handler) { h.SetEtags(true) })
+	size := strconv.Itoa(len(rangeContent))
+
+	full := getRange(h, nil)
+	etag := full.Header().Get("ETag")
+	modified := full.Header().Get("Last-Modified")
+
+	if etag == "" || modified == "" {
+		t.Fatalf("expected ETag and Last-Modified, got %q and %q", etag, modified)
+	}
+
+	tests := []struct {
+		name         string
+		headers      map[string]string
+		status       int
+		body         string
+		contentRange string
+	}{
+		{"none", nil, http.StatusOK, rangeContent, ""},
+		{"single", map[string]string{"Range": "bytes=0-4"}, http.StatusPartialContent, "01234", "bytes 0-4/" + size},
+		{"open ended", map[string]string{"Range": "bytes=995-"}, http.StatusPartialContent, "56789", "bytes 995-999/" + size},
+		{"suffix", map[string]string{"Range": "bytes=-3"}, http.StatusPartialContent, "789", "bytes 997-999/" + size},
+		{"past end", map[string]string{"Range": "bytes=998-2000"}, http.StatusPartialContent, "89", "bytes 998-999/" + size},
+		{"unsatisfiable", map[string]string{"Range": "bytes=1000-1005"}, http.StatusRequestedRangeNotSatisfiable, "", "bytes */" + size},
+		{"if-range etag", map[string]string{"Range": "bytes=0-1", "If-Range": etag}, http.StatusPartialContent, "01", "bytes 0-1/" + size},
+		{"if-range stale etag", map[string]string{"Range": "bytes=0-1", "If-Range": `"stale"`}, http.StatusOK, rangeContent, ""},
+		{"if-range date", map[string]string{"Range": "bytes=2-3", "If-Range": modified}, http.StatusPartialContent, "23", "bytes 2-3/" + size},
+		{"if-range old date", map[string]string{"Range": "bytes=2-3", "If-Range": time.Unix(0, 0).UTC().Format(http.TimeFormat)}, http.StatusOK, rangeContent, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := getRange(h, test.headers)
+
+			if w.Code != test.status {
+				t.Fatalf("expected status %d, got %d", test.status, w.Code)
+			}
+
+			if got := w.Header().Get("Content-Range"); got != test.contentRange {
+				t.Errorf("expected Content-Range %q, got %q", test.contentRange, got)
+			}
+
+			if test.status == http.StatusRequestedRangeNotSatisfiable {
+				return
+			}
+
+			if got := w.Body.String(); got != test.body {
+				t.Errorf("expected body %q, got %q", test.body, got)
+			}
+
+			if got := w.Header().Get("Content-Length"); got != strconv.Itoa(len(test.body)) {
+				t.Errorf("expected Content-Length %d, got %q", len(test.body), got)
+			}
+
+			if got := w.Header().Get("Accept-Ranges"); got != "bytes" {
+				t.Errorf("expected Accept-Ranges 'bytes', got %q", got)
+			}
+		})
+	}
+}
+
+func TestMultipleRanges(t *testing.T) {
+	h := newRangeHandler(t, nil)
+	w := getRange(h, map[string]string{"Range": "bytes=0-1,10-12"})
+
+	if w.Code != http.StatusPartialContent {
+		t.Fatalf("expected status %d, got %d", http.StatusPartialContent, w.Code)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(w.Header().Get("Content-Type"))
+
+	if err != nil || mediaType != "multipart/byteranges" {
+		t.Fatalf("expected multipart/byteranges, got %q", w.Header().Get("Content-Type"))
+	}
+
+	reader := multipart.NewReader(w.Body, params["boundary"])
+	expected := []struct{ body, contentRange string }{
+		{"01", "bytes 0-1/1000"},
+		{"012", "bytes 10-12/1000"},
+	}
+
+	for _, part := range expected {
+		p, err := reader.NextPart()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		body, _ := io.ReadAll(p)
+
+		if string(body) != part.body || p.Header.Get("Content-Range") != part.contentRange {
+			t.Errorf("expected %q of %q, got %q of %q", part.body, part.contentRange, body, p.Header.Get("Content-Range"))
+		}
+	}
+
+	if _, err := reader.NextPart(); err != io.EOF {
+		t.Errorf("expected two parts, got more")
+	}
+}
+
+func TestNoRangePaths(t *testing.T) {
+	h := newRangeHandler(t, func(h *Handler) { h.SetNoRangePaths([]string{"/file"}) })
+	w := getRange(h, map[string]string{"Range": "bytes=0-4"})
+
+	if w.Code != http.StatusOK || w.Body.String() != rangeContent {
+		t.Fatalf("expected the whole file with status 200, got %d with %d bytes", w.Code, w.Body.Len())
+	}
+
+	if got := w.Header().Get("Accept-Ranges"); got != "none" {
+		t.Errorf("expected Accept-Ranges 'none', got %q", got)
+	}
+
+	if got := w.Header().Get("Content-Range"); got != "" {
+		t.Errorf("expected no Content-Range, got %q", got)
+	}
+}
+
+func TestCompressedRanges(t *testing.T) {
+	h := newRangeHandler(t, func(h *Handler) {
+		h.SetCompression([]string{"gzip"}, 0, []string{"text/plain"})
+	})
+
+	t.Run("whole", func(t *testing.T) {
+		w := getRange(h, map[string]string{"Accept-Encoding": "gzip"})
+
+		if w.Code != http.StatusOK || w.Header().Get("Content-Encoding") != "gzip" {
+			t.Fatalf("expected a gzipped 200, got %d encoded as %q", w.Code, w.Header().Get("Content-Encoding"))
+		}
+
+		// Neither the length nor byte offsets of the identity content apply.
+		if got := w.Header().Get("Content-Length"); got != "" {
+			t.Errorf("expected no Content-Length, got %q", got)
+		}
+
+		if got := w.Header().Get("Accept-Ranges"); got != "" {
+			t.Errorf("expected no Accept-Ranges, got %q", got)
+		}
+
+		if got := w.Header().Get("Vary"); got != "Accept-Encoding" {
+			t.Errorf("expected Vary 'Accept-Encoding', got %q", got)
+		}
+
+		decoder, err := gzip.NewReader(w.Body)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if body, _ := io.ReadAll(decoder); string(body) != rangeContent {
+			t.Errorf("expected the file once decompressed")
+		}
+	})
+
+	t.Run("range", func(t *testing.T) {
+		w := getRange(h, map[string]string{"Accept-Encoding": "gzip", "Range": "bytes=0-4"})
+
+		if w.Code != http.StatusPartialContent || w.Body.String() != "01234" {
+			t.Fatalf("expected '01234' with status 206, got %q with %d", w.Body.String(), w.Code)
+		}
+
+		if got := w.Header().Get("Content-Encoding"); got != "" {
+			t.Errorf("expected a part of the identity encoding, got %q", got)
+		}
+
+		if got := w.Header().Get("Content-Length"); got != "5" {
+			t.Errorf("expected Content-Length 5, got %q", got)
+		}
+
+		if got := w.Header().Get("Vary"); got != "Accept-Encoding" {
+			t.Errorf("expected Vary 'Accept-Encoding', got %q", got)
+		}
+	})
+
+	t.Run("unsatisfiable", func(t *testing.T) {
+		w := getRange(h, map[string]string{"Accept-Encoding": "gzip", "Range": "bytes=5000-"})
+
+		if w.Code != http.StatusRequestedRangeNotSatisfiable {
+			t.Fatalf("expected status %d, got %d", http.StatusRequestedRangeNotSatisfiable, w.Code)
+		}
+
+		if got := w.Header().Get("Content-Encoding"); got != "" {
+			t.Errorf("expected no Content-Encoding, got %q", got)
+		}
+	})
+}
+
+func TestPrecompressedRanges(t *testing.T) {
+	h := newRangeHandler(t, func(h *Handler) { h.SetPrecompressed(true) })
+	full := getRange(h, map[string]string{"Accept-Encoding": "gzip"})
+	size := full.Body.Len()
+
+	t.Run("whole", func(t *testing.T) {
+		if full.Code != http.StatusOK || full.Header().Get("Content-Encoding") != "gzip" {
+			t.Fatalf("expected a gzipped 200, got %d encoded as %q", full.Code, full.Header().Get("Content-Encoding"))
+		}
+
+		if got := full.Header().Get("Content-Length"); got != strconv.Itoa(size) {
+			t.Errorf("expected Content-Length %d of the precompressed file, got %q", size, got)
+		}
+
+		if got := full.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/plain") {
+			t.Errorf("expected the type of the original file, got %q", got)
+		}
+	})
+
+	t.Run("range", func(t *testing.T) {
+		w := getRange(h, map[string]string{"Accept-Encoding": "gzip", "Range": "bytes=0-9"})
+
+		if w.Code != http.StatusPartialContent || w.Header().Get("Content-Encoding") != "gzip" {
+			t.Fatalf("expected a gzipped 206, got %d encoded as %q", w.Code, w.Header().Get("Content-Encoding"))
+		}
+
+		// Ranges of an encoded response are of the encoded content.
+		if got := w.Header().Get("Content-Range"); got != "bytes 0-9/"+strconv.Itoa(size) {
+			t.Errorf("expected Content-Range of the precompressed file, got %q", got)
+		}
+
+		if !bytes.Equal(w.Body.Bytes(), full.Body.Bytes()[:10]) {
+			t.Errorf("expected the first 10 bytes of the precompressed file")
+		}
+	})
+
+	t.Run("identity", func(t *testing.T) {
+		w := getRange(h, map[string]string{"Range": "bytes=0-4"})
+
+		if w.Code != http.StatusPartialContent || w.Body.String() != "01234" || w.Header().Get("Content-Encoding") != "" {
+			t.Fatalf("expected '01234' unencoded with status 206, got %q with %d", w.Body.String(), w.Code)
+		}
+	})
+}
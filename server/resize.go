@@ -0,0 +1,421 @@
+// Copyright (c) 2024 Evan Overman (https://an-prata.it).
+// Licensed under the MIT License.
+// See LICENSE file in repository root for complete license text.
+
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/an-prata/webby/logger"
+)
+
+// Resizes JPEG and PNG images when requested with a width, e.g.
+// "/photos/cat.jpg?w=800", caching each resized image on disk.
+type imageResizer struct {
+	// URL path prefixes of images that may be resized.
+	prefixes []string
+
+	// Widths images are resized to, smallest first. Requested widths are rounded
+	// up to one of these so that clients cannot fill the cache.
+	widths []int
+
+	// Directory resized images are cached in.
+	cacheDir string
+
+	// Resizing is done one image at a time, both to bound the memory and CPU used
+	// and so that an image requested by many clients at once is resized once.
+	mutex sync.Mutex
+}
+
+// Query parameter giving the width to resize an image to.
+const resizeQuery = "w"
+
+// Quality of resized JPEG images, from 1 to 100.
+const resizeJpegQuality = 82
+
+// Resizes JPEG and PNG images under the given URL path prefixes when they are
+// requested with a "w" query parameter, caching them in the given directory.
+// Widths are rounded up to the nearest of those given, and images are never
+// enlarged. No prefixes disables resizing.
+func (h *Handler) SetImageResizing(prefixes []string, widths []int64, cacheDir string) error {
+	h.resizer = nil
+
+	if len(prefixes) == 0 {
+		return nil
+	}
+
+	if len(widths) == 0 {
+		return errors.New("No widths given to resize images to")
+	}
+
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return errors.New("Could not create image cache '" + cacheDir + "': " + err.Error())
+	}
+
+	sorted := []int{}
+
+	for _, width := range widths {
+		if width > 0 {
+			sorted = append(sorted, int(width))
+		}
+	}
+
+	sort.Ints(sorted)
+	logger.GlobalLog.LogInfo("Resizing images under " + strings.Join(prefixes, ", ") + " into '" + cacheDir + "'")
+	h.resizer = &imageResizer{prefixes, sorted, cacheDir, sync.Mutex{}}
+	return nil
+}
+
+// Removes every resized image from the given cache directory, e.g. to free
+// space taken by images no longer on the site. Images are resized again when
+// next requested.
+func PurgeImageCache(cacheDir string) error {
+	entries, err := os.ReadDir(cacheDir)
+
+	if err != nil {
+		return errors.New("Could not read image cache '" + cacheDir + "': " + err.Error())
+	}
+
+	for _, entry := range entries {
+		if err = os.Remove(filepath.Join(cacheDir, entry.Name())); err != nil {
+			return errors.New("Could not purge image cache '" + cacheDir + "': " + err.Error())
+		}
+	}
+
+	logger.GlobalLog.LogInfo("Purged " + strconv.Itoa(len(entries)) + " images from '" + cacheDir + "'")
+	return nil
+}
+
+// Serves the given image resized to the requested width, returning false
+// without writing a response if the request is not for a resized image or it
+// could not be resized, in which case the original should be served.
+func (r *imageResizer) serve(w http.ResponseWriter, req *http.Request, fsys fs.FS, file string) bool {
+	query := req.URL.Query().Get(resizeQuery)
+	ext := strings.ToLower(filepath.Ext(file))
+
+	if query == "" || !hasAnyPrefix(req.URL.Path, r.prefixes) || (ext != ".jpg" && ext != ".jpeg" && ext != ".png") {
+		return false
+	}
+
+	requested, err := strconv.Atoi(query)
+
+	if err != nil || requested <= 0 {
+		http.Error(w, "Expected a positive integer width", http.StatusBadRequest)
+		return true
+	}
+
+	width := r.widths[len(r.widths)-1]
+
+	for _, allowed := range r.widths {
+		if allowed >= requested {
+			width = allowed
+			break
+		}
+	}
+
+	cached, err := r.resized(fsys, file, width)
+
+	if err != nil {
+		logger.GlobalLog.LogErr(err.Error())
+		return false
+	}
+
+	if cached == "" {
+		return false
+	}
+
+	http.ServeFile(w, req, cached)
+	return true
+}
+
+// Gives the path of the given image resized to the given width in the cache,
+// resizing it if it has not been already. Gives an empty path if the image is
+// no wider than the width, and so is not resized.
+func (r *imageResizer) resized(fsys fs.FS, file string, width int) (string, error) {
+	stat, err := fs.Stat(fsys, file)
+
+	if err != nil {
+		return "", err
+	}
+
+	// Cached images are named for what they were made from, so that a changed
+	// image is resized again.
+	key := sha256.Sum256([]byte(file + "\x00" + stat.ModTime().String() + "\x00" + strconv.FormatInt(stat.Size(), 10) + "\x00" + strconv.Itoa(width)))
+	cached := filepath.Join(r.cacheDir, hex.EncodeToString(key[:])+strings.ToLower(filepath.Ext(file)))
+	unresized := cached + ".original"
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, err := os.Stat(cached); err == nil {
+		return cached, nil
+	}
+
+	if _, err := os.Stat(unresized); err == nil {
+		return "", nil
+	}
+
+	content, err := fs.ReadFile(fsys, file)
+
+	if err != nil {
+		return "", errors.New("Could not read image '" + file + "': " + err.Error())
+	}
+
+	img, format, err := image.Decode(bytes.NewReader(content))
+
+	if err != nil {
+		return "", errors.New("Could not decode image '" + file + "': " + err.Error())
+	}
+
+	if format == "jpeg" {
+		img = orient(img, jpegOrientation(content))
+	}
+
+	if img.Bounds().Dx() <= width {
+		// Marks the image as too small to resize so it is not decoded again.
+		return "", os.WriteFile(unresized, nil, 0644)
+	}
+
+	height := (img.Bounds().Dy()*width + img.Bounds().Dx()/2) / img.Bounds().Dx()
+
+	if height < 1 {
+		height = 1
+	}
+
+	var buf bytes.Buffer
+	resized := resizeImage(img, width, height)
+
+	if format == "jpeg" {
+		err = jpeg.Encode(&buf, resized, &jpeg.Options{Quality: resizeJpegQuality})
+	} else {
+		err = png.Encode(&buf, resized)
+	}
+
+	if err != nil {
+		return "", errors.New("Could not encode resized image '" + file + "': " + err.Error())
+	}
+
+	temp, err := os.CreateTemp(r.cacheDir, ".resize-*")
+
+	if err != nil {
+		return "", errors.New("Could not cache resized image '" + file + "': " + err.Error())
+	}
+
+	_, err = temp.Write(buf.Bytes())
+
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Chmod(temp.Name(), 0644)
+	}
+
+	if err == nil {
+		err = os.Rename(temp.Name(), cached)
+	}
+
+	if err != nil {
+		os.Remove(temp.Name())
+		return "", errors.New("Could not cache resized image '" + file + "': " + err.Error())
+	}
+
+	logger.GlobalLog.LogInfo("Resized image '" + file + "' to " + strconv.Itoa(width) + "px wide")
+	return cached, nil
+}
+
+// Scales the given image to the given size by averaging the pixels that each
+// pixel of the result covers, which suits shrinking images.
+func resizeImage(src image.Image, width, height int) *image.RGBA {
+	bounds := src.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), src, bounds.Min, draw.Src)
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	srcWidth, srcHeight := bounds.Dx(), bounds.Dy()
+
+	for y := 0; y < height; y++ {
+		y0 := y * srcHeight / height
+		y1 := (y + 1) * srcHeight / height
+
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+
+		for x := 0; x < width; x++ {
+			x0 := x * srcWidth / width
+			x1 := (x + 1) * srcWidth / width
+
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+
+			var r, g, b, a, n uint64
+
+			for sy := y0; sy < y1; sy++ {
+				row := rgba.Pix[sy*rgba.Stride:]
+
+				for sx := x0; sx < x1; sx++ {
+					pixel := row[sx*4 : sx*4+4]
+					r += uint64(pixel[0])
+					g += uint64(pixel[1])
+					b += uint64(pixel[2])
+					a += uint64(pixel[3])
+					n++
+				}
+			}
+
+			i := y*dst.Stride + x*4
+			dst.Pix[i] = uint8(r / n)
+			dst.Pix[i+1] = uint8(g / n)
+			dst.Pix[i+2] = uint8(b / n)
+			dst.Pix[i+3] = uint8(a / n)
+		}
+	}
+
+	return dst
+}
+
+// Gives the EXIF orientation of the given JPEG, from 1 to 8, or 1 if it has
+// none. Browsers rotate images by their orientation, which is lost on resizing,
+// so resized images are rotated to match instead.
+func jpegOrientation(content []byte) int {
+	reader := bytes.NewReader(content)
+	marker := make([]byte, 4)
+
+	if _, err := io.ReadFull(reader, marker[:2]); err != nil || marker[0] != 0xFF || marker[1] != 0xD8 {
+		return 1
+	}
+
+	for {
+		if _, err := io.ReadFull(reader, marker); err != nil || marker[0] != 0xFF {
+			return 1
+		}
+
+		length := int(binary.BigEndian.Uint16(marker[2:])) - 2
+
+		// Start of scan, after which there is only image data.
+		if marker[1] == 0xDA || length < 0 {
+			return 1
+		}
+
+		segment := make([]byte, length)
+
+		if _, err := io.ReadFull(reader, segment); err != nil {
+			return 1
+		}
+
+		if marker[1] == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return exifOrientation(segment[6:])
+		}
+	}
+}
+
+// Gives the orientation tag of the given TIFF structured EXIF data, or 1 if it
+// has none.
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+
+	var order binary.ByteOrder
+
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	offset := int(order.Uint32(tiff[4:]))
+
+	if offset+2 > len(tiff) {
+		return 1
+	}
+
+	entries := int(order.Uint16(tiff[offset:]))
+
+	for i := 0; i < entries; i++ {
+		entry := offset + 2 + i*12
+
+		if entry+12 > len(tiff) {
+			return 1
+		}
+
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			if orientation := int(order.Uint16(tiff[entry+8:])); orientation >= 1 && orientation <= 8 {
+				return orientation
+			}
+
+			return 1
+		}
+	}
+
+	return 1
+}
+
+// Rotates and flips the given image as directed by an EXIF orientation.
+func orient(src image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return src
+	}
+
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	// Orientations 5 through 8 swap width and height.
+	dstWidth, dstHeight := width, height
+
+	if orientation >= 5 {
+		dstWidth, dstHeight = height, width
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dstWidth, dstHeight))
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			var dx, dy int
+
+			switch orientation {
+			case 2:
+				dx, dy = width-1-x, y
+			case 3:
+				dx, dy = width-1-x, height-1-y
+			case 4:
+				dx, dy = x, height-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = height-1-y, x
+			case 7:
+				dx, dy = height-1-y, width-1-x
+			case 8:
+				dx, dy = y, width-1-x
+			}
+
+			dst.Set(dx, dy, src.At(bounds.Min.X+x, bounds.Min.Y+y))
+		}
+	}
+
+	return dst
+}
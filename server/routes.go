@@ -0,0 +1,71 @@
+// Copyright (c) 2024 Evan Overman (https://an-prata.it).
+// Licensed under the MIT License.
+// See LICENSE file in repository root for complete license text.
+
+package server
+
+import (
+	"sort"
+)
+
+// Kinds of routes given by `Handler.Routes()`.
+const (
+	RouteFile      = "file"
+	RouteDynamic   = "dynamic"
+	RouteDead      = "dead"
+	RouteProxy     = "proxy"
+	RouteWellKnown = "well-known"
+	RouteGenerated = "generated"
+	RouteCustom    = "custom"
+)
+
+// A URL path a handler responds to, how it responds, and what it serves, e.g.
+// a file path or an upstream URL.
+type Route struct {
+	Path   string
+	Kind   string
+	Target string
+}
+
+// Gives every route of the handler sorted by path. Where a custom handler and a
+// file are mapped to the same path only the custom handler is given, as it takes
+// priority. Proxies and directories resolved when requested are given by their
+// prefix, and dead paths matched by pattern by their pattern.
+func (h *Handler) Routes() []Route {
+	routes := []Route{}
+
+	for uriPath, handler := range h.handlerMap {
+		route := Route{uriPath, RouteCustom, ""}
+
+		if custom, ok := handler.(CustomHandler); ok && custom.Kind != "" {
+			route.Kind = custom.Kind
+			route.Target = custom.Target
+		}
+
+		routes = append(routes, route)
+	}
+
+	for uriPath, file := range h.PathMap {
+		if _, ok := h.handlerMap[uriPath]; !ok {
+			routes = append(routes, Route{uriPath, RouteFile, file})
+		}
+	}
+
+	for _, pattern := range h.deadPatterns {
+		routes = append(routes, Route{pattern.pattern, RouteDead, ""})
+	}
+
+	for _, root := range h.dynamicRoots {
+		routes = append(routes, Route{root.prefix, RouteDynamic, root.dir})
+	}
+
+	for _, rule := range h.proxies {
+		routes = append(routes, Route{rule.prefix, RouteProxy, rule.upstream})
+	}
+
+	sort.Slice(routes, func(i, j int) bool {
+		return routes[i].Path < routes[j].Path
+	})
+
+	return routes
+}
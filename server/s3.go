@@ -0,0 +1,353 @@
+// Copyright (c) 2024 Evan Overman (https://an-prata.it).
+// Licensed under the MIT License.
+// See LICENSE file in repository root for complete license text.
+
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/an-prata/webby/logger"
+)
+
+// An `fs.FS` of the objects in an S3 compatible bucket. Objects are listed once
+// when the filesystem is created, like directories are scanned once, and are
+// downloaded into a local cache when first opened. Cached objects are reused
+// for as long as their ETag matches the listing.
+type s3FS struct {
+	endpoint  string
+	bucket    string
+	region    string
+	prefix    string
+	accessKey string
+	secretKey string
+	cacheDir  string
+
+	client *http.Client
+
+	// Listed objects by name, relative to the prefix.
+	objects map[string]s3Object
+
+	// Prevents the same object being downloaded concurrently.
+	mutex sync.Mutex
+}
+
+// An object as given by a bucket listing.
+type s3Object struct {
+	Key          string    `xml:"Key"`
+	LastModified time.Time `xml:"LastModified"`
+	ETag         string    `xml:"ETag"`
+	Size         int64     `xml:"Size"`
+}
+
+// Information about a listed object, satisfying `fs.FileInfo`.
+type s3FileInfo struct {
+	name   string
+	object s3Object
+}
+
+func (i s3FileInfo) Name() string       { return path.Base(i.name) }
+func (i s3FileInfo) Size() int64        { return i.object.Size }
+func (i s3FileInfo) Mode() fs.FileMode  { return 0444 }
+func (i s3FileInfo) ModTime() time.Time { return i.object.LastModified }
+func (i s3FileInfo) IsDir() bool        { return false }
+func (i s3FileInfo) Sys() interface{}   { return nil }
+
+// Creates a filesystem of the objects in the bucket configured by the given
+// options, listing all objects under the configured prefix.
+func newS3FS(opts ServerOptions) (*s3FS, error) {
+	fsys := &s3FS{
+		endpoint:  strings.TrimSuffix(opts.S3Endpoint, "/"),
+		bucket:    opts.S3Bucket,
+		region:    opts.S3Region,
+		prefix:    opts.S3Prefix,
+		accessKey: opts.S3AccessKey,
+		secretKey: opts.S3SecretKey,
+		cacheDir:  opts.S3CacheDir,
+		client:    &http.Client{Timeout: 60 * time.Second},
+		objects:   map[string]s3Object{},
+	}
+
+	if err := os.MkdirAll(fsys.cacheDir, 0755); err != nil {
+		return nil, errors.New("Could not create S3 cache directory '" + fsys.cacheDir + "': " + err.Error())
+	}
+
+	if err := fsys.list(); err != nil {
+		return nil, err
+	}
+
+	return fsys, nil
+}
+
+// Lists every object under the prefix, following continuation tokens.
+func (fsys *s3FS) list() error {
+	token := ""
+
+	for {
+		query := url.Values{}
+		query.Set("list-type", "2")
+		query.Set("prefix", fsys.prefix)
+
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		response, err := fsys.request("/"+fsys.bucket, query)
+
+		if err != nil {
+			return errors.New("Could not list S3 bucket '" + fsys.bucket + "': " + err.Error())
+		}
+
+		var result struct {
+			Contents              []s3Object `xml:"Contents"`
+			IsTruncated           bool       `xml:"IsTruncated"`
+			NextContinuationToken string     `xml:"NextContinuationToken"`
+		}
+
+		err = xml.NewDecoder(response.Body).Decode(&result)
+		response.Body.Close()
+
+		if err != nil {
+			return errors.New("Could not parse listing of S3 bucket '" + fsys.bucket + "': " + err.Error())
+		}
+
+		for _, object := range result.Contents {
+			name := strings.TrimPrefix(strings.TrimPrefix(object.Key, fsys.prefix), "/")
+
+			if name == "" || strings.HasSuffix(name, "/") || !fs.ValidPath(name) {
+				continue
+			}
+
+			fsys.objects[name] = object
+		}
+
+		if !result.IsTruncated {
+			return nil
+		}
+
+		token = result.NextContinuationToken
+	}
+}
+
+// Gives the names of all listed objects, sorted.
+func (fsys *s3FS) names() []string {
+	names := make([]string, 0, len(fsys.objects))
+
+	for name := range fsys.objects {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+func (fsys *s3FS) Stat(name string) (fs.FileInfo, error) {
+	object, ok := fsys.objects[name]
+
+	if !ok {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+
+	return s3FileInfo{name, object}, nil
+}
+
+// Opens the named object from the local cache, downloading it first if it is
+// not cached or has changed.
+func (fsys *s3FS) Open(name string) (fs.File, error) {
+	object, ok := fsys.objects[name]
+
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+
+	etag := hex.EncodeToString([]byte(strings.Trim(object.ETag, "\"")))
+	key := sha256.Sum256([]byte(object.Key))
+	cachePath := filepath.Join(fsys.cacheDir, hex.EncodeToString(key[:])+"-"+etag)
+
+	if file, err := os.Open(cachePath); err == nil {
+		return file, nil
+	}
+
+	fsys.mutex.Lock()
+	defer fsys.mutex.Unlock()
+
+	// The object may have been downloaded while waiting for the lock.
+	if file, err := os.Open(cachePath); err == nil {
+		return file, nil
+	}
+
+	if err := fsys.download(object, cachePath); err != nil {
+		logger.GlobalLog.LogErr(err.Error())
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	return os.Open(cachePath)
+}
+
+// Downloads an object to the given path in the cache.
+func (fsys *s3FS) download(object s3Object, cachePath string) error {
+	response, err := fsys.request("/"+fsys.bucket+"/"+object.Key, url.Values{})
+
+	if err != nil {
+		return errors.New("Could not download S3 object '" + object.Key + "': " + err.Error())
+	}
+
+	defer response.Body.Close()
+	temp, err := os.CreateTemp(fsys.cacheDir, ".download-")
+
+	if err != nil {
+		return errors.New("Could not create S3 cache file: " + err.Error())
+	}
+
+	_, err = io.Copy(temp, response.Body)
+	temp.Close()
+
+	if err != nil {
+		os.Remove(temp.Name())
+		return errors.New("Could not download S3 object '" + object.Key + "': " + err.Error())
+	}
+
+	os.Chtimes(temp.Name(), object.LastModified, object.LastModified)
+	logger.GlobalLog.LogInfo("Cached S3 object '" + object.Key + "'")
+	return os.Rename(temp.Name(), cachePath)
+}
+
+// Makes a GET request to the endpoint, signed if credentials are configured.
+// Responses other than 200 OK are given as errors.
+func (fsys *s3FS) request(uriPath string, query url.Values) (*http.Response, error) {
+	reqUrl := fsys.endpoint + s3EscapePath(uriPath)
+
+	if len(query) > 0 {
+		reqUrl += "?" + s3CanonicalQuery(query)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, reqUrl, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if fsys.accessKey != "" {
+		fsys.sign(req, s3EscapePath(uriPath), s3CanonicalQuery(query))
+	}
+
+	response, err := fsys.client.Do(req)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode != http.StatusOK {
+		response.Body.Close()
+		return nil, errors.New("Bucket responded with " + response.Status)
+	}
+
+	return response, nil
+}
+
+// Signs a GET request using AWS Signature Version 4.
+func (fsys *s3FS) sign(req *http.Request, canonicalPath, canonicalQuery string) {
+	now := time.Now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := hex.EncodeToString(sha256Sum(nil))
+
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		canonicalPath,
+		canonicalQuery,
+		"host:" + req.URL.Host,
+		"x-amz-content-sha256:" + payloadHash,
+		"x-amz-date:" + amzDate,
+		"",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + fsys.region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(sha256Sum([]byte(canonicalRequest)))
+
+	key := hmacSum([]byte("AWS4"+fsys.secretKey), date)
+	key = hmacSum(key, fsys.region)
+	key = hmacSum(key, "s3")
+	key = hmacSum(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSum(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+fsys.accessKey+"/"+scope+", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+// Escapes each segment of a path as required for signing.
+func s3EscapePath(uriPath string) string {
+	segments := strings.Split(uriPath, "/")
+
+	for i, segment := range segments {
+		segments[i] = s3Escape(segment)
+	}
+
+	return strings.Join(segments, "/")
+}
+
+// Encodes a query with sorted keys, escaped as required for signing.
+func s3CanonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+
+	for key := range query {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+	pairs := []string{}
+
+	for _, key := range keys {
+		for _, value := range query[key] {
+			pairs = append(pairs, s3Escape(key)+"="+s3Escape(value))
+		}
+	}
+
+	return strings.Join(pairs, "&")
+}
+
+// Percent encodes all but unreserved characters.
+func s3Escape(str string) string {
+	var builder strings.Builder
+
+	for _, c := range []byte(str) {
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '-' || c == '_' || c == '.' || c == '~' {
+			builder.WriteByte(c)
+		} else {
+			builder.WriteString("%" + strings.ToUpper(hex.EncodeToString([]byte{c})))
+		}
+	}
+
+	return builder.String()
+}
+
+func sha256Sum(data []byte) []byte {
+	sum := sha256.Sum256(data)
+	return sum[:]
+}
+
+func hmacSum(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
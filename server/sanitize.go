@@ -0,0 +1,41 @@
+// Copyright (c) 2024 Evan Overman (https://an-prata.it).
+// Licensed under the MIT License.
+// See LICENSE file in repository root for complete license text.
+
+package server
+
+import (
+	"path"
+	"strings"
+)
+
+// Gives whether the given URL path, already percent decoded, is in canonical
+// form: rooted, without "." or ".." segments, empty segments, or NUL bytes. A
+// trailing slash is allowed. Such a path cannot refer outside of the directory
+// it is resolved against, nor slip past a prefix it does not appear to match.
+func isCanonicalPath(uriPath string) bool {
+	if !strings.HasPrefix(uriPath, "/") || strings.ContainsRune(uriPath, 0) {
+		return false
+	}
+
+	cleaned := path.Clean(uriPath)
+
+	if cleaned != "/" && strings.HasSuffix(uriPath, "/") {
+		cleaned += "/"
+	}
+
+	return cleaned == uriPath
+}
+
+// Gives whether any segment of the given URL path names a hidden file, one
+// starting with ".", such as ".git" or ".env". The "/.well-known/" directory is
+// not hidden.
+func isHidden(uriPath string) bool {
+	for i, segment := range strings.Split(uriPath, "/") {
+		if strings.HasPrefix(segment, ".") && !(i == 1 && segment == ".well-known") {
+			return true
+		}
+	}
+
+	return false
+}
@@ -5,10 +5,14 @@
 package server
 
 import (
+	"context"
+	"crypto/tls"
 	"errors"
+	"net"
 	"net/http"
 	"os"
-	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/an-prata/webby/logger"
@@ -29,104 +33,707 @@ const (
 	Restart
 )
 
+// Minimum and maximum amount of time to wait before attempting to restart an
+// HTTP server that stopped unexpectedly. The wait doubles on every consecutive
+// failure.
+const (
+	minRestartBackoff = 1 * time.Second
+	maxRestartBackoff = 1 * time.Minute
+)
+
 type Server struct {
+	// The handler serving requests, replaced on each restart of a server started
+	// by `Server.StartThreaded()`, in which case it should be read through
+	// `Server.Handler()` instead.
 	ReqHandler *Handler
 	srv        *http.Server
 	opts       ServerOptions
+
+	// Certificates served over HTTPS, nil if TLS is not supported.
+	certs *certStore
+
+	// Guards the handler, HTTP server, options, and certificates, which are
+	// replaced by the thread of a server started by `Server.StartThreaded()`
+	// while being read or set from others.
+	mu sync.RWMutex
+
+	// Set to a non-zero value while a server started by `Server.StartThreaded()`
+	// is not serving due to an error. Should only be accessed atomically.
+	degraded int32
+
+	// Closed once a server started by `Server.StartThreaded()` has shut off and
+	// drained its connections.
+	stopped chan struct{}
+
+	// Closed once a server started by `Server.StartThreaded()` has first bound
+	// its listeners.
+	ready     chan struct{}
+	readyOnce sync.Once
+
+	// Receives each error that stops a server started by `Server.StartThreaded()`,
+	// see `Server.Errors()`.
+	errs chan error
 }
 
 // Creates a new server given the specified options. Will return an error if any
 // of the given paths could not be statted or if the program lacks read
 // permissions. This function will map directories from the options given.
 func NewServer(opts ServerOptions) (*Server, error) {
+	return NewServerWithHandler(opts, NewHandler(opts.RedirectHttp))
+}
+
+// Creates a new server as `NewServer()` does, but serving with the given
+// handler, e.g. one given custom handlers with `Handler.Map()` or middleware with
+// `Handler.Use()` by a program embedding webby. The handler is configured from
+// the options as well, and should not be given to more than one server.
+func NewServerWithHandler(opts ServerOptions, handler *Handler) (*Server, error) {
 	var err error
 	opts.checkForDefaults()
 
-	if _, err = os.Stat(opts.Site); err != nil {
+	if _, err = os.Stat(opts.Site); err != nil && opts.S3Bucket == "" && handler.siteFS == nil {
 		return nil, errors.New("Could not stat '" + opts.Site + "'")
 	}
 
+	var certs *certStore
+
 	if opts.SupportsTLS() {
-		if _, err = os.Stat(opts.Cert); err != nil {
-			return nil, errors.New("Could not stat '" + opts.Cert + "'")
+		if certs, err = newCertStore(opts.CertificatePairs()); err != nil {
+			return nil, err
+		}
+	}
+
+	handler.redirectHttp = opts.RedirectHttp
+	handler.SetServeHiddenFiles(opts.ServeHiddenFiles)
+
+	if opts.S3Bucket != "" {
+		bucket, err := newS3FS(opts)
+
+		if err != nil {
+			return nil, err
+		}
+
+		handler.MapBucket(bucket)
+	} else if handler.siteFS != nil {
+		if err = handler.MapFS(handler.siteFS); err != nil {
+			return nil, err
+		}
+	} else if IsArchive(opts.Site) {
+		if err = handler.MapArchive(opts.Site); err != nil {
+			return nil, err
+		}
+	} else if opts.DynamicPaths {
+		roots := map[string]string{"/": resolveRelease(opts.Site)}
+
+		for prefix, dir := range opts.Mounts {
+			roots[prefix] = dir
 		}
 
-		if _, err = os.Stat(opts.Key); err != nil {
-			return nil, errors.New("Could not stat '" + opts.Key + "'")
+		handler.SetDynamicRoots(roots)
+	} else {
+		handler.MapDir(resolveRelease(opts.Site))
+
+		if err = handler.AddMounts(opts.Mounts); err != nil {
+			return nil, err
 		}
 	}
 
-	var port string
+	// Sites not served from a directory of the operating system.
+	notDir := opts.S3Bucket != "" || IsArchive(opts.Site) || handler.siteFS != nil
+
+	if len(opts.Mounts) > 0 && notDir {
+		logger.GlobalLog.LogWarn("Ignoring 'Mounts', which are not supported for sites served from an archive, bucket, or embedded filesystem")
+	}
+
+	if opts.DynamicPaths && notDir {
+		logger.GlobalLog.LogWarn("Ignoring 'DynamicPaths', which is not supported for sites served from an archive, bucket, or embedded filesystem")
+	}
 
-	if opts.Port > 0 {
-		port = ":" + strconv.FormatInt(int64(opts.Port), 10)
+	if opts.WebDavPrefix != "" {
+		root := opts.WebDavRoot
+
+		if root == "" {
+			root = opts.Site
+		}
+
+		if opts.WebDavRoot == "" && notDir {
+			logger.GlobalLog.LogWarn("Ignoring 'WebDavPrefix', which needs a 'WebDavRoot' for sites served from an archive, bucket, or embedded filesystem")
+		} else {
+			handler.SetWebDav(opts.WebDavPrefix, root, opts.WebDavUsers)
+		}
+	}
+
+	// Uploads to a path protected otherwise, e.g. by OpenID Connect, need not be
+	// authenticated again.
+	handler.SetUpload(opts.UploadPath, opts.UploadDir, opts.UploadMaxBytes, opts.UploadExtensions, opts.UploadUsers, opts.UploadAnonymous || opts.Protects(opts.UploadPath))
+
+	if opts.DeployHookPath != "" && notDir {
+		logger.GlobalLog.LogWarn("Ignoring 'DeployHookPath', which cannot update a site served from an archive, bucket, or embedded filesystem")
 	} else {
-		port = ""
+		handler.SetDeployHook(opts.DeployHookPath, opts.DeployHookSecret, opts.DeployHookCommand, opts.Site)
+	}
+
+	if opts.UploadPath != "" && len(opts.UploadUsers) == 0 && opts.UploadAnonymous && !opts.Protects(opts.UploadPath) {
+		logger.GlobalLog.LogWarn("Anyone may upload to '" + opts.UploadPath + "', as 'UploadAnonymous' is set")
+	}
+
+	handler.SetDeadResponseMode(opts.DeadResponseMode)
+	handler.AddBlockRules(opts.BlockRules)
+	handler.SetMaxRequestBodyBytes(opts.MaxRequestBodyBytes)
+	handler.SetThrottle(opts.ThrottleBytesPerSecond, opts.ThrottlePaths)
+	handler.SetMaintenanceRules(opts.MaintenanceRetryAfter, opts.MaintenanceAllowPaths, opts.MaintenanceAllowIPs)
+	handler.SetMaintenance(opts.Maintenance)
+	handler.SetTarpit(time.Duration(opts.TarpitInterval)*time.Millisecond, time.Duration(opts.TarpitDuration)*time.Second, opts.TarpitAbusive)
+	handler.addDeadResponses(opts.DeadPaths)
+	handler.AddErrorPages(opts.ErrorPages)
+	handler.RequireSignatures(opts.SignedPrefixes, opts.SignedUrlKey)
+
+	// Requiring a client certificate globally must also refuse plain HTTP.
+	if opts.ClientCA != "" && len(opts.ClientAuthPaths) == 0 {
+		handler.RequireClientCerts([]string{"/"})
+	} else if opts.ClientCA != "" {
+		handler.RequireClientCerts(opts.ClientAuthPaths)
+	}
+	handler.AddAttachmentRules(opts.Attachments)
+	handler.SetNoRangePaths(opts.NoRangePaths)
+	handler.SetPrecompressed(opts.Precompressed)
+	handler.SetMinifiedTypes(opts.Minify)
+	handler.SetTemplates(opts.TemplateExtension, opts.TemplateSiteData)
+	handler.SetCompression(opts.Compression, opts.CompressionMinSize, opts.CompressionTypes)
+	handler.SetModernImages(opts.ModernImages)
+
+	if err = handler.SetImageResizing(opts.ImageResizePrefixes, opts.ImageResizeWidths, opts.ImageCacheDir); err != nil {
+		return nil, err
 	}
 
-	handler := NewHandler(opts.RedirectHttp)
-	handler.MapDir(opts.Site)
-	handler.AddDeadResponses(opts.DeadPaths)
+	handler.SetAutoIndex(opts.AutoIndex)
+	handler.SetSpaFallback(opts.SpaFallback)
+	handler.SetEtags(opts.Etags)
+	handler.SetSlowRequestThreshold(time.Duration(opts.SlowRequestThreshold) * time.Millisecond)
+	handler.AddCacheControl(opts.CacheControl)
+	handler.AddHeaders(opts.Headers)
+	handler.AddAllowedMethods(opts.AllowedMethods)
+	handler.SetCanonicalHost(opts.CanonicalHost)
+	handler.SetCanonicalTrailingSlash(opts.CanonicalTrailingSlash)
+	handler.SetLanguages(opts.Languages, opts.DefaultLanguage, opts.LanguageCookie)
+
+	if addr, ok := opts.HttpsAddr(); ok {
+		_, port, _ := net.SplitHostPort(addr)
+		handler.SetRedirectPort(port)
+	}
+	handler.AddPreloads(opts.Preloads, opts.EarlyHints)
+
+	if opts.AutoPreloads {
+		handler.DerivePreloads()
+	}
+
+	handler.SetQuotas(opts.QuotaRequestsHourly, opts.QuotaRequestsDaily, opts.QuotaBytesHourly, opts.QuotaBytesDaily)
+	handler.SetBans(opts.BanThreshold, time.Duration(opts.BanWindow)*time.Second, time.Duration(opts.BanDuration)*time.Second)
+
+	if err = handler.SetAccessLog(opts.AccessLog, opts.AccessLogFormat); err != nil {
+		return nil, err
+	}
+
+	if err = handler.AddProxies(opts.Proxies); err != nil {
+		return nil, err
+	}
+
+	if err = handler.AddWellKnown(opts.WellKnown); err != nil {
+		return nil, err
+	}
+
+	if len(opts.SecurityContacts) > 0 {
+		var expires time.Time
+
+		if opts.SecurityExpires != "" {
+			if expires, err = time.Parse(time.RFC3339, opts.SecurityExpires); err != nil {
+				return nil, errors.New("Could not parse 'SecurityExpires' as an RFC 3339 date: " + err.Error())
+			}
+		}
+
+		handler.AddSecurityTxt(opts.SecurityContacts, expires, opts.SecurityPolicy)
+	}
+
+	if opts.Sitemap {
+		exclude := append(opts.protectedPrefixes(), opts.SitemapExclude...)
+
+		for _, page := range opts.ErrorPages {
+			exclude = append(exclude, page)
+		}
+
+		handler.AddSitemap(opts.CanonicalHost, exclude)
+	}
+
+	if opts.RobotsTxt {
+		handler.AddRobotsTxt(opts.CanonicalHost, opts.RobotsDisallow)
+	}
+
+	if opts.SupportsOidc() {
+		if handler.oidc, err = newOidcAuth(opts); err != nil {
+			return nil, err
+		}
+	}
+
+	handler.applyKept()
 
 	httpSrv := http.Server{
-		Addr:              port,
 		Handler:           handler,
-		ReadHeaderTimeout: time.Duration(opts.ReadTimeout) * time.Second,
+		ConnState:         handler.stats.connState,
+		ReadTimeout:       time.Duration(opts.ReadTimeout) * time.Second,
+		ReadHeaderTimeout: time.Duration(opts.ReadHeaderTimeout) * time.Second,
 		WriteTimeout:      time.Duration(opts.WriteTimeout) * time.Second,
+		IdleTimeout:       time.Duration(opts.IdleTimeout) * time.Second,
+		MaxHeaderBytes:    int(opts.MaxHeaderBytes),
+		ErrorLog:          logger.GlobalLog.StdLogger(logger.Warn),
+	}
+
+	if certs != nil {
+		if httpSrv.TLSConfig, err = newTLSConfig(opts, certs); err != nil {
+			return nil, err
+		}
 	}
 
-	return &Server{handler, &httpSrv, opts}, nil
+	// Protocols are given explicitly since HTTP/2 is otherwise not negotiated when
+	// the server's TLS config is served alongside plain HTTP. A non-nil, empty map
+	// keeps the HTTPS listener from negotiating HTTP/2.
+	if httpSrv.TLSConfig != nil {
+		httpSrv.TLSConfig.NextProtos = []string{"http/1.1"}
+
+		if opts.EnableHttp2 {
+			httpSrv.TLSConfig.NextProtos = []string{"h2", "http/1.1"}
+		}
+	}
+
+	if !opts.EnableHttp2 {
+		httpSrv.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+	}
+
+	return &Server{
+		ReqHandler: handler,
+		srv:        &httpSrv,
+		opts:       opts,
+		certs:      certs,
+		stopped:    make(chan struct{}),
+		ready:      make(chan struct{}),
+		errs:       make(chan error, 8),
+	}, nil
 }
 
-// Starts the server, if TLS is supports then it is started in another thread
-// and regular HTTP is started in the current thread. This function will only
-// ever return on an error. If the server is started in this fashion then it may
-// be stopped using the `Server.Stop()` method, in which case it will return an
-// error indicating this.
+// Starts the server, if TLS is supported then it is served alongside regular
+// HTTP. This function will only ever return on an error, including errors
+// binding to either port or a failure in either of the HTTP or HTTPS servers.
+// If the server is started in this fashion then it may be stopped using the
+// `Server.Stop()` method, in which case it will return an error indicating
+// this.
 func (s *Server) Start() error {
-	if s.opts.SupportsTLS() {
-		go s.srv.ListenAndServeTLS(s.opts.Cert, s.opts.Key)
+	httpListener, tlsListener, err := s.listen()
+
+	if err != nil {
+		return err
 	}
 
-	return s.srv.ListenAndServe()
+	return s.serve(httpListener, tlsListener)
 }
 
 // Starts the server in a seperate thread and returns a channel for giving said
 // thread commands. This method, unlike the more standard `Server.Start()`
 // method, cannot be stopped using the `Server.Stop()` method and must instead
 // be instructed to stop using the provided channel. This method also does not
-// report errors except in logs.
+// report errors except in logs and through `Server.Errors()`.
+//
+// Should the server stop unexpectedly (e.g. its port was taken or TLS failed)
+// it will be marked as degraded and restarted with an exponential backoff
+// until it binds successfully again.
 func (s *Server) StartThreaded() chan ServerThreadCommand {
 	commandChan := make(chan ServerThreadCommand, 1)
+	Supervise("HTTP server", func() error {
+		err := s.run(commandChan)
 
-	go func() {
-		for {
-			go s.Start()
-			command := <-commandChan
-			s.Stop()
+		// Only returns without an error once, on `Shutoff`.
+		if err == nil {
+			close(s.stopped)
+		}
+
+		return err
+	})
+
+	return commandChan
+}
+
+// Blocks until a server started with `Server.StartThreaded()` has been given
+// `Shutoff` and finished draining its connections.
+func (s *Server) Wait() {
+	<-s.stopped
+}
+
+// Gives a channel that is closed once a server started by
+// `Server.StartThreaded()` is first accepting connections.
+func (s *Server) Ready() <-chan struct{} {
+	return s.ready
+}
+
+// Gives a channel receiving each error that stops a server started by
+// `Server.StartThreaded()`, such as failing to bind its port or load its
+// certificates, before the server is retried. Errors are dropped while the
+// channel is full.
+func (s *Server) Errors() <-chan error {
+	return s.errs
+}
+
+// Runs the server until given `Shutoff` through the given channel, restarting
+// it on command or after unexpected failures. Returns an error only if the
+// server could not be reinstantiated.
+func (s *Server) run(commandChan chan ServerThreadCommand) error {
+	backoff := minRestartBackoff
+
+	for {
+		errChan := make(chan error, 1)
+		httpListener, tlsListener, err := s.listen()
+
+		if err != nil {
+			errChan <- err
+		} else {
+			atomic.StoreInt32(&s.degraded, 0)
+			s.readyOnce.Do(func() { close(s.ready) })
+			go func(srv *Server) { errChan <- srv.serve(httpListener, tlsListener) }(s)
+		}
+
+		select {
+		case command := <-commandChan:
+			// Once the old server stops accepting connections a restarted server may
+			// bind while the old one finishes its requests.
+			drained := s.drain()
 
 			if command == Shutoff {
 				logger.GlobalLog.LogInfo("HTTP server shutting off...")
-				return
-			} else if command == Restart {
-				logger.GlobalLog.LogInfo("HTTP server restarting...")
-				srv, err := NewServer(s.opts)
+				<-drained
+				return nil
+			}
 
-				if err != nil {
-					logger.GlobalLog.LogErr("Could not reinstantiate HTTP server")
-					return
+			logger.GlobalLog.LogInfo("HTTP server restarting...")
+			backoff = minRestartBackoff
+
+		case <-s.Handler().rescans:
+			s.drain()
+			logger.GlobalLog.LogInfo("HTTP server restarting to map its changed site...")
+			backoff = minRestartBackoff
+
+		case err := <-errChan:
+			s.Stop()
+			atomic.StoreInt32(&s.degraded, 1)
+
+			select {
+			case s.errs <- err:
+			default:
+			}
+
+			logger.GlobalLog.LogErr("HTTP server stopped unexpectedly: " + err.Error())
+			logger.GlobalLog.LogErr("Retrying HTTP server in " + backoff.String() + "...")
+
+			select {
+			case command := <-commandChan:
+				if command == Shutoff {
+					logger.GlobalLog.LogInfo("HTTP server shutting off...")
+					return nil
 				}
 
-				*s = *srv
+				logger.GlobalLog.LogInfo("HTTP server restarting...")
+				backoff = minRestartBackoff
+
+			case <-time.After(backoff):
+				backoff *= 2
+
+				if backoff > maxRestartBackoff {
+					backoff = maxRestartBackoff
+				}
 			}
 		}
-	}()
 
-	return commandChan
+		srv, err := NewServerWithHandler(s.Options(), s.Handler().renewed())
+
+		if err != nil {
+			atomic.StoreInt32(&s.degraded, 1)
+			logger.GlobalLog.LogErr("Could not reinstantiate HTTP server")
+			return err
+		}
+
+		s.mu.Lock()
+
+		// Maintenance may have been toggled while the server was reinstantiated.
+		srv.ReqHandler.SetMaintenance(s.opts.Maintenance)
+		s.ReqHandler = srv.ReqHandler
+		s.srv = srv.srv
+		s.certs = srv.certs
+		s.mu.Unlock()
+	}
 }
 
 // Stops a server started by the `Server.Start()` method. This method will not
 // stop servers started using the `Server.StartThreaded()` method.
 func (s *Server) Stop() error {
-	return s.srv.Close()
+	s.mu.RLock()
+	handler, srv := s.ReqHandler, s.srv
+	s.mu.RUnlock()
+
+	handler.Close()
+	return srv.Close()
+}
+
+// Gracefully stops a server started by the `Server.Start()` method, waiting up
+// to the configured `ShutdownTimeout` for in flight requests to finish before
+// closing their connections. This method will not stop servers started using
+// the `Server.StartThreaded()` method.
+func (s *Server) Shutdown() {
+	<-s.drain()
+}
+
+// Stops the server from accepting connections and then drains existing ones in
+// the background, closing any left after the configured `ShutdownTimeout`.
+// Returns once the server's listeners are closed, giving a channel that is
+// closed once draining completes.
+func (s *Server) drain() chan struct{} {
+	s.mu.RLock()
+	srv, handler := s.srv, s.ReqHandler
+	timeout := time.Duration(s.opts.ShutdownTimeout) * time.Second
+	s.mu.RUnlock()
+
+	listenersClosed := make(chan struct{})
+	drained := make(chan struct{})
+
+	// Functions registered for shutdown are only called once listeners have been
+	// closed.
+	srv.RegisterOnShutdown(func() { close(listenersClosed) })
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		if err := srv.Shutdown(ctx); err != nil {
+			logger.GlobalLog.LogWarn("Connections did not drain within " + timeout.String() + ", closing them")
+			srv.Close()
+		}
+
+		handler.Close()
+		close(drained)
+	}()
+
+	<-listenersClosed
+	return drained
+}
+
+// Gives the operating system paths that the server's content is read from, for
+// watching for changes. This is the site directory or archive along with any
+// mounted directories and well known files, or nothing for a site served from a
+// bucket.
+func (s *Server) SourcePaths() []string {
+	opts := s.Options()
+
+	if opts.S3Bucket != "" {
+		return []string{}
+	}
+
+	paths := []string{opts.Site}
+
+	// Builds are written to the site on reload, so it is their source that is
+	// watched, watching the site would reload on every build.
+	if opts.BuildOnReload && opts.BuildSource != "" {
+		paths = []string{opts.BuildSource}
+	}
+
+	if !IsArchive(opts.Site) {
+		for _, dir := range opts.Mounts {
+			paths = append(paths, dir)
+		}
+	}
+
+	for _, file := range opts.WellKnown {
+		paths = append(paths, file)
+	}
+
+	return paths
+}
+
+// Gives the operating system paths of the certificates and keys served over
+// HTTPS, for watching for renewals.
+func (s *Server) CertificatePaths() []string {
+	paths := []string{}
+	opts := s.Options()
+
+	for _, pair := range opts.CertificatePairs() {
+		paths = append(paths, pair.Cert, pair.Key)
+	}
+
+	return paths
+}
+
+// Reloads the certificates served over HTTPS from disk without interrupting the
+// server, so that renewed certificates are served to new connections. If any
+// certificate fails to load then the previous certificates are kept.
+func (s *Server) ReloadCertificates() error {
+	s.mu.RLock()
+	certs := s.certs
+	s.mu.RUnlock()
+
+	if certs == nil {
+		return errors.New("Server does not serve HTTPS")
+	}
+
+	return certs.load()
+}
+
+// Gives when each certificate served over HTTPS expires by the path of its
+// file, none if the server does not serve HTTPS.
+func (s *Server) CertificateExpiries() map[string]time.Time {
+	s.mu.RLock()
+	certs := s.certs
+	s.mu.RUnlock()
+
+	if certs == nil {
+		return map[string]time.Time{}
+	}
+
+	return certs.expiries()
+}
+
+// Turns maintenance mode on or off without restarting, see
+// `Handler.SetMaintenance()`. The change is kept in the server's options so that
+// it outlasts a restart.
+func (s *Server) SetMaintenance(enabled bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.opts.Maintenance = enabled
+	s.ReqHandler.SetMaintenance(enabled)
+}
+
+// Gives the options the server is running with.
+func (s *Server) Options() ServerOptions {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.opts
+}
+
+// Replaces the options of a server started by `Server.StartThreaded()`, which
+// only take effect once it is given `Restart` through its command channel.
+func (s *Server) SetOptions(opts ServerOptions) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.opts = opts
+}
+
+// Gives the handler currently serving requests, which changes each time a
+// server started by `Server.StartThreaded()` restarts.
+func (s *Server) Handler() *Handler {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.ReqHandler
+}
+
+// Returns true if a server started using `Server.StartThreaded()` is currently
+// not serving requests due to an error.
+func (s *Server) Degraded() bool {
+	return atomic.LoadInt32(&s.degraded) != 0
+}
+
+// Binds to the HTTP and HTTPS addresses given by the server's options without
+// serving on either. Either returned listener may be nil if its protocol is not
+// served, but not both.
+func (s *Server) listen() (httpListener, tlsListener net.Listener, err error) {
+	opts := s.Options()
+
+	if addr, ok := opts.HttpsAddr(); ok {
+		tlsListener, err = net.Listen("tcp", addr)
+
+		if err != nil {
+			return nil, nil, errors.New("Could not bind HTTPS server to '" + addr + "': " + err.Error())
+		}
+	}
+
+	if addr, ok := opts.HttpAddr(); ok {
+		httpListener, err = net.Listen("tcp", addr)
+
+		if err != nil {
+			if tlsListener != nil {
+				tlsListener.Close()
+			}
+
+			return nil, nil, errors.New("Could not bind HTTP server to '" + addr + "': " + err.Error())
+		}
+	}
+
+	if httpListener == nil && tlsListener == nil {
+		return nil, nil, errors.New("Both the HTTP and HTTPS listeners are disabled")
+	}
+
+	if httpListener != nil {
+		httpListener = newThrottleListener(httpListener, opts.ThrottleConnectionBytesPerSecond)
+	}
+
+	if tlsListener != nil {
+		tlsListener = newThrottleListener(tlsListener, opts.ThrottleConnectionBytesPerSecond)
+	}
+
+	if opts.AcceptProxyProtocol {
+		if httpListener != nil {
+			httpListener = proxyProtocolListener{httpListener}
+		}
+
+		if tlsListener != nil {
+			tlsListener = proxyProtocolListener{tlsListener}
+		}
+	}
+
+	if httpListener != nil {
+		httpListener = newLimitListener(httpListener, opts.MaxConcurrentConnections, opts.MaxConnectionsPerIP)
+	}
+
+	if tlsListener != nil {
+		tlsListener = newLimitListener(tlsListener, opts.MaxConcurrentConnections, opts.MaxConnectionsPerIP)
+	}
+
+	return httpListener, tlsListener, nil
+}
+
+// Serves on listeners given by `Server.listen()`, returning the first error
+// given by either of them. Each error is logged with the listener it came from.
+func (s *Server) serve(httpListener, tlsListener net.Listener) error {
+	errChan := make(chan error, 2)
+
+	s.mu.RLock()
+	srv, http2 := s.srv, s.opts.EnableHttp2
+	s.mu.RUnlock()
+
+	if tlsListener != nil {
+		protocols := "HTTP/1.1"
+
+		if http2 {
+			protocols = "HTTP/2, " + protocols
+		}
+
+		logger.GlobalLog.LogInfo("Serving HTTPS (" + protocols + ") on " + tlsListener.Addr().String())
+
+		go func() {
+			err := srv.ServeTLS(tlsListener, "", "")
+
+			if err != http.ErrServerClosed {
+				logger.GlobalLog.LogErr("HTTPS listener on " + tlsListener.Addr().String() + " stopped: " + err.Error())
+			}
+
+			errChan <- errors.New("HTTPS: " + err.Error())
+		}()
+	}
+
+	if httpListener != nil {
+		logger.GlobalLog.LogInfo("Serving HTTP (HTTP/1.1) on " + httpListener.Addr().String())
+
+		go func() {
+			err := srv.Serve(httpListener)
+
+			if err != http.ErrServerClosed {
+				logger.GlobalLog.LogErr("HTTP listener on " + httpListener.Addr().String() + " stopped: " + err.Error())
+			}
+
+			errChan <- errors.New("HTTP: " + err.Error())
+		}()
+	}
+
+	return <-errChan
 }
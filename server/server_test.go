@@ -0,0 +1,89 @@
+// Copyright (c) 2024 Evan Overman (https://an-prata.it).
+// Licensed under the MIT License.
+// See LICENSE file in repository root for complete license text.
+
+package server
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Gives options serving a site of a single page over HTTP on a free local port.
+func newTestOptions(t *testing.T) ServerOptions {
+	t.Helper()
+	dir := t.TempDir()
+
+	if err := os.WriteFile(filepath.Join(dir, "index.html"), []byte("<p>webby</p>"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	return ServerOptions{
+		Site:            dir + "/",
+		HttpPort:        int32(port),
+		BindAddress:     "127.0.0.1",
+		ShutdownTimeout: 1,
+	}
+}
+
+// Toggles maintenance mode and replaces options from several goroutines while
+// the server restarts, which should be run with the race detector.
+func TestConcurrentMaintenance(t *testing.T) {
+	srv, err := NewServer(newTestOptions(t))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	commands := srv.StartThreaded()
+
+	select {
+	case <-srv.Ready():
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not start")
+	}
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			for j := 0; j < 50; j++ {
+				srv.SetMaintenance((i+j)%2 == 0)
+				srv.SetOptions(srv.Options())
+				srv.Handler().Stats()
+				srv.SourcePaths()
+			}
+		}(i)
+	}
+
+	for i := 0; i < 3; i++ {
+		commands <- Restart
+	}
+
+	wg.Wait()
+	srv.SetMaintenance(true)
+	commands <- Restart
+	commands <- Shutoff
+	srv.Wait()
+
+	if !srv.Options().Maintenance || !srv.Handler().Maintenance() {
+		t.Errorf("expected maintenance mode to outlast restarts")
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright (c) 2024 Evan Overman (https://an-prata.it).
+// Licensed under the MIT License.
+// See LICENSE file in repository root for complete license text.
+
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Signs the given URL path with the given key so that it may be requested from
+// under a signed prefix until the given expiry. Returns the path with its
+// "expires" and "sig" query parameters.
+func SignPath(key, path string, expires time.Time) string {
+	expiry := strconv.FormatInt(expires.Unix(), 10)
+	query := url.Values{}
+	query.Set("expires", expiry)
+	query.Set("sig", signature(key, path, expiry))
+	return path + "?" + query.Encode()
+}
+
+// Returns true if the given request carries a valid signature for its path which
+// has not yet expired. Always returns false for an empty key.
+func verifySignedRequest(key string, req *http.Request) bool {
+	if key == "" {
+		return false
+	}
+
+	expiry := req.URL.Query().Get("expires")
+	expires, err := strconv.ParseInt(expiry, 10, 64)
+
+	if err != nil || time.Now().Unix() >= expires {
+		return false
+	}
+
+	given, err := base64.RawURLEncoding.DecodeString(req.URL.Query().Get("sig"))
+
+	if err != nil {
+		return false
+	}
+
+	expected, _ := base64.RawURLEncoding.DecodeString(signature(key, req.URL.Path, expiry))
+	return hmac.Equal(given, expected)
+}
+
+// Gives the base64 encoded HMAC of a path and expiry.
+func signature(key, path, expiry string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(path + "\n" + expiry))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,161 @@
+// Copyright (c) 2024 Evan Overman (https://an-prata.it).
+// Licensed under the MIT License.
+// See LICENSE file in repository root for complete license text.
+
+package server
+
+import (
+	"encoding/xml"
+	"io/fs"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/an-prata/webby/logger"
+)
+
+// Generates "/sitemap.xml" listing every HTML page the handler has mapped,
+// other than those under any of the given prefixes. URLs are given for the
+// given host, e.g. "example.com", or for the host of each request if empty.
+// Pages found when requested, as with `DynamicPaths`, are not listed. Like
+// other custom handlers this takes priority over a "sitemap.xml" of the site.
+func (h *Handler) AddSitemap(host string, exclude []string) {
+	pages := []string{}
+	listed := map[string]bool{}
+
+	for _, uriPath := range h.ValidPaths {
+		file, ok := h.PathMap[uriPath]
+
+		if !ok || listed[uriPath] || hasAnyPrefix(uriPath, exclude) {
+			continue
+		}
+
+		if ext := strings.ToLower(path.Ext(file)); ext != ".html" && ext != ".htm" {
+			continue
+		}
+
+		// Directories are mapped to their "index.html" whether or not they have
+		// one, and are listed with a trailing slash.
+		if stat, err := fs.Stat(h.fsys, file); err != nil || stat.IsDir() {
+			continue
+		}
+
+		// An "index.html" is listed only by its directory's URL.
+		if path.Base(uriPath) == "index.html" {
+			if _, ok := h.PathMap[strings.TrimSuffix(uriPath, "index.html")]; ok {
+				continue
+			}
+		}
+
+		if path.Base(file) == "index.html" && !strings.HasSuffix(uriPath, "/") {
+			if _, ok := h.PathMap[uriPath+"/"]; ok {
+				uriPath += "/"
+			}
+		}
+
+		if !listed[uriPath] {
+			listed[uriPath] = true
+			pages = append(pages, uriPath)
+		}
+	}
+
+	sort.Strings(pages)
+
+	if _, ok := h.PathMap["/sitemap.xml"]; ok {
+		logger.GlobalLog.LogWarn("Serving a generated '/sitemap.xml' in place of the site's own")
+	}
+
+	modTime := time.Now()
+
+	logger.GlobalLog.LogInfo("Generated '/sitemap.xml' listing " + strings.Join(pages, ", "))
+	h.handlerMap["/sitemap.xml"] = CustomHandler{
+		Handler: func(w http.ResponseWriter, req *http.Request) {
+			base := requestBaseUrl(req, host)
+			var builder strings.Builder
+
+			builder.WriteString(xml.Header)
+			builder.WriteString(`<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">` + "\n")
+
+			for _, page := range pages {
+				builder.WriteString("  <url><loc>")
+				xml.EscapeText(&builder, []byte(base+page))
+				builder.WriteString("</loc></url>\n")
+			}
+
+			builder.WriteString("</urlset>\n")
+			w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+			http.ServeContent(w, req, "sitemap.xml", modTime, strings.NewReader(builder.String()))
+		},
+		Kind: RouteGenerated,
+	}
+}
+
+// Generates "/robots.txt" allowing every user agent everywhere but the given
+// path prefixes, and pointing to "/sitemap.xml" if one was generated by
+// `Handler.AddSitemap()`, which must then be called first. The host is used for
+// the sitemap's URL as it is by `Handler.AddSitemap()`.
+func (h *Handler) AddRobotsTxt(host string, disallow []string) {
+	var rules strings.Builder
+	rules.WriteString("User-agent: *\n")
+
+	if len(disallow) == 0 {
+		rules.WriteString("Disallow:\n")
+	}
+
+	for _, prefix := range disallow {
+		rules.WriteString("Disallow: " + prefix + "\n")
+	}
+
+	_, sitemap := h.handlerMap["/sitemap.xml"]
+
+	if _, ok := h.PathMap["/robots.txt"]; ok {
+		logger.GlobalLog.LogWarn("Serving a generated '/robots.txt' in place of the site's own")
+	}
+
+	modTime := time.Now()
+
+	logger.GlobalLog.LogInfo("Generated '/robots.txt'")
+	h.handlerMap["/robots.txt"] = CustomHandler{
+		Handler: func(w http.ResponseWriter, req *http.Request) {
+			content := rules.String()
+
+			if sitemap {
+				content += "\nSitemap: " + requestBaseUrl(req, host) + "/sitemap.xml\n"
+			}
+
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			http.ServeContent(w, req, "robots.txt", modTime, strings.NewReader(content))
+		},
+		Kind: RouteGenerated,
+	}
+}
+
+// Gives the scheme and host that absolute URLs of the site start with, e.g.
+// "https://example.com", using the given host if not empty and otherwise that
+// of the request.
+func requestBaseUrl(req *http.Request, host string) string {
+	scheme := "http"
+
+	if req.TLS != nil {
+		scheme = "https"
+	}
+
+	if host == "" {
+		host = req.Host
+	}
+
+	return scheme + "://" + host
+}
+
+// Returns true if the given URL path starts with any of the given prefixes.
+func hasAnyPrefix(uriPath string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(uriPath, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,237 @@
+// Copyright (c) 2024 Evan Overman (https://an-prata.it).
+// Licensed under the MIT License.
+// See LICENSE file in repository root for complete license text.
+
+package server
+
+import (
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The number of most requested paths given by `Handler.Stats()`.
+const statsTopPaths = 10
+
+// The number of most recent error responses given by `Handler.Stats()`.
+const statsRecentErrors = 10
+
+// The number of distinct paths counted, beyond which requests for new paths are
+// only counted in the total so that scanners cannot grow the count forever.
+const statsMaxPaths = 4096
+
+// A snapshot of the requests served by a handler since it was created.
+type Stats struct {
+	// Total requests served.
+	Requests int64
+
+	// Total responses with a 4xx or 5xx status.
+	Errors int64
+
+	// Total responses with a 5xx status.
+	ServerErrors int64
+
+	// Total requests whose handling panicked, each also counted as a server
+	// error.
+	Panics int64
+
+	// Connections currently open to the server.
+	ActiveConnections int64
+
+	// The most requested paths, most requested first.
+	TopPaths []PathCount
+
+	// The most recent responses with a 4xx or 5xx status, most recent first.
+	RecentErrors []ErrorResponse
+}
+
+// The number of requests made for a path.
+type PathCount struct {
+	Path  string
+	Count int64
+}
+
+// The requests made for a path, the bytes served for them, and the number of
+// responses given with each status.
+type PathHits struct {
+	Path     string
+	Requests int64
+	Bytes    int64
+	Statuses map[int]int64
+}
+
+// A response with an error status.
+type ErrorResponse struct {
+	Time   time.Time
+	Client string
+	Path   string
+	Status int
+}
+
+// Counts requests and connections as they are served.
+type statsTracker struct {
+	requests         int64
+	errorCount       int64
+	serverErrorCount int64
+	panicCount       int64
+	connections      int64
+
+	mutex  sync.Mutex
+	paths  map[string]*PathHits
+	errors []ErrorResponse
+}
+
+func newStatsTracker() *statsTracker {
+	return &statsTracker{0, 0, 0, 0, 0, sync.Mutex{}, map[string]*PathHits{}, []ErrorResponse{}}
+}
+
+// Records a served request, the status it was responded to with, and the bytes
+// of its response body.
+func (s *statsTracker) record(req *http.Request, status int, written int64) {
+	atomic.AddInt64(&s.requests, 1)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	hits, ok := s.paths[req.URL.Path]
+
+	if !ok && len(s.paths) < statsMaxPaths {
+		hits = &PathHits{req.URL.Path, 0, 0, map[int]int64{}}
+		s.paths[req.URL.Path] = hits
+	}
+
+	if hits != nil {
+		hits.Requests++
+		hits.Bytes += written
+		hits.Statuses[status]++
+	}
+
+	if status >= 500 {
+		atomic.AddInt64(&s.serverErrorCount, 1)
+	}
+
+	if status >= 400 {
+		atomic.AddInt64(&s.errorCount, 1)
+		s.errors = append(s.errors, ErrorResponse{time.Now(), req.RemoteAddr, req.URL.Path, status})
+
+		if len(s.errors) > statsRecentErrors {
+			s.errors = s.errors[len(s.errors)-statsRecentErrors:]
+		}
+	}
+}
+
+// Counts a request whose handling panicked.
+func (s *statsTracker) recordPanic() {
+	atomic.AddInt64(&s.panicCount, 1)
+}
+
+// Counts open connections, for use as `http.Server.ConnState`.
+func (s *statsTracker) connState(_ net.Conn, state http.ConnState) {
+	switch state {
+	case http.StateNew:
+		atomic.AddInt64(&s.connections, 1)
+	case http.StateClosed, http.StateHijacked:
+		atomic.AddInt64(&s.connections, -1)
+	}
+}
+
+func (s *statsTracker) snapshot() Stats {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	paths := make([]PathCount, 0, len(s.paths))
+
+	for path, hits := range s.paths {
+		paths = append(paths, PathCount{path, hits.Requests})
+	}
+
+	sort.Slice(paths, func(i, j int) bool {
+		if paths[i].Count == paths[j].Count {
+			return paths[i].Path < paths[j].Path
+		}
+
+		return paths[i].Count > paths[j].Count
+	})
+
+	if len(paths) > statsTopPaths {
+		paths = paths[:statsTopPaths]
+	}
+
+	errors := make([]ErrorResponse, len(s.errors))
+
+	for i, e := range s.errors {
+		errors[len(errors)-1-i] = e
+	}
+
+	return Stats{
+		atomic.LoadInt64(&s.requests),
+		atomic.LoadInt64(&s.errorCount),
+		atomic.LoadInt64(&s.serverErrorCount),
+		atomic.LoadInt64(&s.panicCount),
+		atomic.LoadInt64(&s.connections),
+		paths,
+		errors,
+	}
+}
+
+// Gives the hits of every path counted, most requested first.
+func (s *statsTracker) pathHits() []PathHits {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	hits := make([]PathHits, 0, len(s.paths))
+
+	for _, h := range s.paths {
+		statuses := map[int]int64{}
+
+		for status, count := range h.Statuses {
+			statuses[status] = count
+		}
+
+		hits = append(hits, PathHits{h.Path, h.Requests, h.Bytes, statuses})
+	}
+
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Requests == hits[j].Requests {
+			return hits[i].Path < hits[j].Path
+		}
+
+		return hits[i].Requests > hits[j].Requests
+	})
+
+	return hits
+}
+
+// Records the status and body size of a response.
+type statusWriter struct {
+	http.ResponseWriter
+	status  int
+	written int64
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	// Informational responses, such as early hints, precede the real status.
+	if w.status == 0 && status >= 200 {
+		w.status = status
+	}
+
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+
+	n, err := w.ResponseWriter.Write(b)
+	w.written += int64(n)
+	return n, err
+}
+
+// Allows `http.ResponseController` to reach the underlying writer.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
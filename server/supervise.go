@@ -0,0 +1,63 @@
+// Copyright (c) 2024 Evan Overman (https://an-prata.it).
+// Licensed under the MIT License.
+// See LICENSE file in repository root for complete license text.
+
+package server
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"github.com/an-prata/webby/logger"
+)
+
+// Runs the given function in a seperate thread as a supervised subsystem. Should
+// the function panic or return an error it is logged, along with a stack trace
+// for panics, and the function is called again after an exponential backoff.
+// The function will not be called again once it returns nil.
+func Supervise(name string, fn func() error) {
+	go func() {
+		backoff := minRestartBackoff
+
+		for {
+			started := time.Now()
+			err := callRecovered(name, fn)
+
+			if err == nil {
+				return
+			}
+
+			logger.GlobalLog.LogErr("Subsystem '" + name + "' failed: " + err.Error())
+
+			// Subsystems that ran for a while before failing are not considered to be
+			// failing repeatedly.
+			if time.Since(started) > maxRestartBackoff {
+				backoff = minRestartBackoff
+			}
+
+			logger.GlobalLog.LogErr("Restarting subsystem '" + name + "' in " + backoff.String() + "...")
+			time.Sleep(backoff)
+			backoff *= 2
+
+			if backoff > maxRestartBackoff {
+				backoff = maxRestartBackoff
+			}
+		}
+	}()
+}
+
+// Calls the given function, recovering from and logging any panics. A recovered
+// panic is returned as an error.
+func callRecovered(name string, fn func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.GlobalLog.LogErr(fmt.Sprintf("Recovered from panic in subsystem '%s': %v", name, r))
+			logger.GlobalLog.LogErr(string(debug.Stack()))
+			err = errors.New("panic: " + fmt.Sprint(r))
+		}
+	}()
+
+	return fn()
+}
@@ -0,0 +1,90 @@
+// Copyright (c) 2024 Evan Overman (https://an-prata.it).
+// Licensed under the MIT License.
+// See LICENSE file in repository root for complete license text.
+
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/an-prata/webby/logger"
+)
+
+// Most requests tarpitted at once, further ones have their connection dropped
+// so that scanners cannot exhaust the server's connections.
+const maxTarpits = 512
+
+// Paces tarpit responses, see `Handler.SetTarpit()`.
+type tarpitter struct {
+	interval time.Duration
+	duration time.Duration
+
+	// Whether banned clients and those over quota are tarpitted rather than
+	// refused.
+	abusive bool
+
+	// Number of requests currently tarpitted. Should only be accessed atomically.
+	active int32
+}
+
+// Sets the time between each byte of a tarpit response and how long it lasts in
+// total, and whether banned clients and those over quota are tarpitted rather
+// than refused. Tarpits are given as dead responses with `DeadTarpit` and by
+// block rules with `BlockTarpit`.
+func (h *Handler) SetTarpit(interval, duration time.Duration, abusive bool) {
+	if interval <= 0 {
+		interval = time.Second
+	}
+
+	h.tarpits.interval = interval
+	h.tarpits.duration = duration
+	h.tarpits.abusive = abusive
+
+	if abusive {
+		logger.GlobalLog.LogInfo("Tarpitting banned clients and those over quota for " + duration.String() + ", writing a byte every " + interval.String())
+	}
+}
+
+// Responds slowly, writing a byte of the body every interval until the tarpit's
+// duration has passed or the client gives up, keeping a scanner waiting. Only
+// the request's own goroutine is used, waiting between writes. Past
+// `maxTarpits` at once the connection is dropped instead.
+func (h *Handler) tarpit(w http.ResponseWriter, req *http.Request) {
+	if atomic.AddInt32(&h.tarpits.active, 1) > maxTarpits {
+		atomic.AddInt32(&h.tarpits.active, -1)
+		logger.GlobalLog.LogWarn("Dropping request from " + req.RemoteAddr + " rather than tarpitting it, " + strconv.Itoa(maxTarpits) + " requests are already tarpitted")
+		dropConnection()
+	}
+
+	defer atomic.AddInt32(&h.tarpits.active, -1)
+
+	controller := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/html")
+	w.WriteHeader(http.StatusOK)
+
+	ticker := time.NewTicker(h.tarpits.interval)
+	defer ticker.Stop()
+	timeout := time.After(h.tarpits.duration)
+
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case <-timeout:
+			return
+		case <-ticker.C:
+			controller.SetWriteDeadline(time.Now().Add(h.tarpits.interval * 2))
+
+			if _, err := w.Write([]byte(" ")); err != nil {
+				return
+			}
+
+			if err := controller.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
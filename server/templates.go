@@ -0,0 +1,146 @@
+// Copyright (c) 2024 Evan Overman (https://an-prata.it).
+// Licensed under the MIT License.
+// See LICENSE file in repository root for complete license text.
+
+package server
+
+import (
+	"bytes"
+	"errors"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/an-prata/webby/logger"
+)
+
+// Renders files of one extension through `html/template` when they are served,
+// caching each parsed template until the file changes.
+type templater struct {
+	extension string
+	site      map[string]string
+
+	mutex sync.Mutex
+	cache map[string]parsedTemplate
+}
+
+// A cached template along with the stat it was parsed from.
+type parsedTemplate struct {
+	modTime  time.Time
+	size     int64
+	template *template.Template
+}
+
+// The data templates are executed with, e.g. `{{ .Query.Get "name" }}` or
+// `{{ .Site.title }}`.
+type TemplateData struct {
+	Method string
+	Host   string
+	Path   string
+	Query  url.Values
+
+	// Headers of the request, e.g. `{{ .Headers.Get "User-Agent" }}`.
+	Headers http.Header
+
+	// IP address of the client.
+	ClientIp string
+
+	// The time the request is being served.
+	Now time.Time
+
+	// Values given by `TemplateSiteData` in the config.
+	Site map[string]string
+}
+
+// Renders files with the given extension, e.g. ".gohtml", through
+// `html/template` when they are served, giving them `TemplateData` with the
+// given site values. An empty extension disables templates.
+func (h *Handler) SetTemplates(extension string, site map[string]string) {
+	if extension == "" {
+		h.templates = nil
+		return
+	}
+
+	extension = "." + strings.TrimPrefix(strings.ToLower(extension), ".")
+	logger.GlobalLog.LogInfo("Rendering files ending in '" + extension + "' as templates")
+	h.templates = &templater{extension, site, sync.Mutex{}, map[string]parsedTemplate{}}
+}
+
+// Serves the given file rendered as a template if it has the template
+// extension, returning false without writing a response otherwise. Rendered
+// pages depend on the request and so are not cached by clients.
+func (t *templater) serve(w http.ResponseWriter, req *http.Request, fsys fs.FS, file string) bool {
+	if strings.ToLower(filepath.Ext(file)) != t.extension {
+		return false
+	}
+
+	tmpl, err := t.parse(fsys, file)
+
+	if err != nil {
+		logger.GlobalLog.LogErr(err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return true
+	}
+
+	data := TemplateData{req.Method, req.Host, req.URL.Path, req.URL.Query(), req.Header, clientIp(req), time.Now(), t.site}
+	var buf bytes.Buffer
+
+	// Rendering to a buffer first keeps a failed template from sending half a
+	// page with a success status.
+	if err := tmpl.Execute(&buf, data); err != nil {
+		logger.GlobalLog.LogErr("Could not render template '" + file + "': " + err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return true
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.Header().Set("Cache-Control", "private, no-cache")
+
+	if req.Method != http.MethodHead {
+		w.Write(buf.Bytes())
+	}
+
+	return true
+}
+
+// Gives the parsed template of the given file, parsing it again if it changed.
+func (t *templater) parse(fsys fs.FS, file string) (*template.Template, error) {
+	stat, err := fs.Stat(fsys, file)
+
+	if err != nil {
+		return nil, errors.New("Could not stat template '" + file + "': " + err.Error())
+	}
+
+	t.mutex.Lock()
+	cached, ok := t.cache[file]
+	t.mutex.Unlock()
+
+	if ok && cached.modTime == stat.ModTime() && cached.size == stat.Size() {
+		return cached.template, nil
+	}
+
+	content, err := fs.ReadFile(fsys, file)
+
+	if err != nil {
+		return nil, errors.New("Could not read template '" + file + "': " + err.Error())
+	}
+
+	tmpl, err := template.New(filepath.Base(file)).Parse(string(content))
+
+	if err != nil {
+		return nil, errors.New("Could not parse template '" + file + "': " + err.Error())
+	}
+
+	t.mutex.Lock()
+	t.cache[file] = parsedTemplate{stat.ModTime(), stat.Size(), tmpl}
+	t.mutex.Unlock()
+
+	return tmpl, nil
+}
@@ -0,0 +1,197 @@
+// Copyright (c) 2024 Evan Overman (https://an-prata.it).
+// Licensed under the MIT License.
+// See LICENSE file in repository root for complete license text.
+
+package server
+
+import (
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Largest write made at once by a throttled writer, so that throttled bytes are
+// spread over each second rather than sent in bursts.
+const maxThrottleChunk = 32 * 1024
+
+// Paces bytes written through it to a rate in bytes per second, shared by all
+// writers using it.
+type rateLimiter struct {
+	rate int64
+
+	mutex sync.Mutex
+	next  time.Time
+}
+
+// Bandwidth limits on responses by path prefix, see `Handler.SetThrottle()`.
+type throttlePath struct {
+	prefix  string
+	limiter *rateLimiter
+}
+
+// Writes through each of the given rate limiters, see `rateLimiter`.
+type throttledWriter struct {
+	http.ResponseWriter
+	limiters []*rateLimiter
+}
+
+// Accepts connections whose writes are each limited to a rate in bytes per
+// second.
+type throttleListener struct {
+	net.Listener
+	rate int64
+}
+
+type throttledConn struct {
+	net.Conn
+	limiter *rateLimiter
+}
+
+func newRateLimiter(rate int64) *rateLimiter {
+	return &rateLimiter{rate: rate}
+}
+
+// Waits until the given number of bytes may be written.
+func (r *rateLimiter) wait(n int) {
+	r.mutex.Lock()
+	now := time.Now()
+
+	if r.next.Before(now) {
+		r.next = now
+	}
+
+	delay := r.next.Sub(now)
+	r.next = r.next.Add(time.Duration(n) * time.Second / time.Duration(r.rate))
+	r.mutex.Unlock()
+
+	time.Sleep(delay)
+}
+
+// Gives the size of the chunks bytes should be written in at the given rate,
+// about a tenth of a second's worth.
+func throttleChunk(rate int64) int {
+	if chunk := rate / 10; chunk < maxThrottleChunk {
+		if chunk < 1 {
+			return 1
+		}
+
+		return int(chunk)
+	}
+
+	return maxThrottleChunk
+}
+
+// Writes the given bytes in chunks, waiting on each limiter before each chunk.
+func throttledWrite(b []byte, limiters []*rateLimiter, write func([]byte) (int, error)) (int, error) {
+	chunk := maxThrottleChunk
+
+	for _, limiter := range limiters {
+		if size := throttleChunk(limiter.rate); size < chunk {
+			chunk = size
+		}
+	}
+
+	written := 0
+
+	for written < len(b) {
+		end := written + chunk
+
+		if end > len(b) {
+			end = len(b)
+		}
+
+		for _, limiter := range limiters {
+			limiter.wait(end - written)
+		}
+
+		n, err := write(b[written:end])
+		written += n
+
+		if err != nil {
+			return written, err
+		}
+	}
+
+	return written, nil
+}
+
+// Limits the bandwidth used by responses, in bytes per second, with zero for no
+// limit. The total limit is shared by all responses, and each path prefix's
+// limit by all responses under it, using the longest prefix matched.
+func (h *Handler) SetThrottle(total int64, paths map[string]int64) {
+	h.throttleTotal = nil
+	h.throttlePaths = []throttlePath{}
+
+	if total > 0 {
+		h.throttleTotal = newRateLimiter(total)
+	}
+
+	for prefix, rate := range paths {
+		if rate > 0 {
+			h.throttlePaths = append(h.throttlePaths, throttlePath{prefix, newRateLimiter(rate)})
+		}
+	}
+
+	sort.Slice(h.throttlePaths, func(i, j int) bool {
+		return len(h.throttlePaths[i].prefix) > len(h.throttlePaths[j].prefix)
+	})
+}
+
+// Gives a writer limited to the bandwidth set for the given request, or the
+// given writer if there is no limit.
+func (h *Handler) throttle(w http.ResponseWriter, req *http.Request) http.ResponseWriter {
+	limiters := []*rateLimiter{}
+
+	if h.throttleTotal != nil {
+		limiters = append(limiters, h.throttleTotal)
+	}
+
+	for _, path := range h.throttlePaths {
+		if strings.HasPrefix(req.URL.Path, path.prefix) {
+			limiters = append(limiters, path.limiter)
+			break
+		}
+	}
+
+	if len(limiters) == 0 {
+		return w
+	}
+
+	return &throttledWriter{w, limiters}
+}
+
+func (w *throttledWriter) Write(b []byte) (int, error) {
+	return throttledWrite(b, w.limiters, w.ResponseWriter.Write)
+}
+
+// Allows `http.ResponseController` to reach the underlying writer.
+func (w *throttledWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Wraps the given listener to limit each connection's writes to the given
+// rate, giving the listener unchanged when the rate is zero.
+func newThrottleListener(listener net.Listener, rate int64) net.Listener {
+	if rate <= 0 {
+		return listener
+	}
+
+	return throttleListener{listener, rate}
+}
+
+func (l throttleListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &throttledConn{conn, newRateLimiter(l.rate)}, nil
+}
+
+func (c *throttledConn) Write(b []byte) (int, error) {
+	return throttledWrite(b, []*rateLimiter{c.limiter}, c.Conn.Write)
+}
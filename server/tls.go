@@ -0,0 +1,204 @@
+// Copyright (c) 2024 Evan Overman (https://an-prata.it).
+// Licensed under the MIT License.
+// See LICENSE file in repository root for complete license text.
+
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/an-prata/webby/logger"
+)
+
+// A certificate file along with the file of its private key.
+type CertificatePair struct {
+	Cert string
+	Key  string
+}
+
+// Holds the certificates served over TLS, choosing between them by the server
+// name the client asks for.
+type certStore struct {
+	pairs []CertificatePair
+
+	mutex sync.RWMutex
+	certs []*tls.Certificate
+}
+
+// Creates a store of the given certificate pairs, loading each of them. The
+// first pair is served to clients whose server name matches no certificate.
+func newCertStore(pairs []CertificatePair) (*certStore, error) {
+	store := &certStore{pairs, sync.RWMutex{}, nil}
+
+	if err := store.load(); err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+// Loads every certificate pair of the store from disk, replacing those served
+// only if all of them load successfully.
+func (c *certStore) load() error {
+	certs := make([]*tls.Certificate, 0, len(c.pairs))
+
+	for _, pair := range c.pairs {
+		cert, err := tls.LoadX509KeyPair(pair.Cert, pair.Key)
+
+		if err != nil {
+			return errors.New("Could not load certificate '" + pair.Cert + "' with key '" + pair.Key + "': " + err.Error())
+		}
+
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return errors.New("Could not parse certificate '" + pair.Cert + "': " + err.Error())
+		}
+
+		logger.GlobalLog.LogInfo("Loaded certificate '" + pair.Cert + "' for " + certNames(cert.Leaf))
+		certs = append(certs, &cert)
+	}
+
+	c.mutex.Lock()
+	c.certs = certs
+	c.mutex.Unlock()
+	return nil
+}
+
+// Gives the first certificate supporting the client's server name, or the
+// default certificate if none do. Used as `tls.Config.GetCertificate`.
+func (c *certStore) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	if len(c.certs) == 0 {
+		return nil, errors.New("No certificates loaded")
+	}
+
+	for _, cert := range c.certs {
+		if hello.ServerName != "" && cert.Leaf.VerifyHostname(hello.ServerName) == nil && hello.SupportsCertificate(cert) == nil {
+			return cert, nil
+		}
+	}
+
+	return c.certs[0], nil
+}
+
+// Gives when each certificate served expires by the path of its file.
+func (c *certStore) expiries() map[string]time.Time {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	expiries := map[string]time.Time{}
+
+	for i, cert := range c.certs {
+		expiries[c.pairs[i].Cert] = cert.Leaf.NotAfter
+	}
+
+	return expiries
+}
+
+// Versions of TLS by the names they may be configured with.
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// Elliptic curves by the names they may be configured with.
+var tlsCurves = map[string]tls.CurveID{
+	"X25519": tls.X25519,
+	"P256":   tls.CurveP256,
+	"P384":   tls.CurveP384,
+	"P521":   tls.CurveP521,
+}
+
+// Builds the TLS configuration of a server serving the given certificates with
+// the TLS policy given by the options. Returns an error if the policy names an
+// unknown version, cipher suite, or curve.
+func newTLSConfig(opts ServerOptions, certs *certStore) (*tls.Config, error) {
+	config := &tls.Config{GetCertificate: certs.getCertificate}
+
+	if opts.MinTLSVersion != "" {
+		version, ok := tlsVersions[opts.MinTLSVersion]
+
+		if !ok {
+			return nil, errors.New("Unknown TLS version '" + opts.MinTLSVersion + "', expected one of 1.0, 1.1, 1.2, or 1.3")
+		}
+
+		config.MinVersion = version
+		logger.GlobalLog.LogInfo("Requiring at least TLS " + opts.MinTLSVersion)
+	}
+
+	if len(opts.CipherSuites) > 0 {
+		suites := map[string]uint16{}
+
+		for _, suite := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+			suites[suite.Name] = suite.ID
+		}
+
+		for _, name := range opts.CipherSuites {
+			id, ok := suites[name]
+
+			if !ok {
+				return nil, errors.New("Unknown TLS cipher suite '" + name + "'")
+			}
+
+			config.CipherSuites = append(config.CipherSuites, id)
+		}
+
+		// TLS 1.3 suites are not configurable and always enabled.
+		logger.GlobalLog.LogInfo("Limiting TLS 1.2 and earlier to cipher suites: " + strings.Join(opts.CipherSuites, ", "))
+	}
+
+	if opts.ClientCA != "" {
+		pem, err := os.ReadFile(opts.ClientCA)
+
+		if err != nil {
+			return nil, errors.New("Could not read client CA '" + opts.ClientCA + "': " + err.Error())
+		}
+
+		config.ClientCAs = x509.NewCertPool()
+
+		if !config.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.New("Could not find any certificates in client CA '" + opts.ClientCA + "'")
+		}
+
+		// With only some paths protected, other clients need not give a certificate,
+		// see `Handler.RequireClientCerts()`.
+		if len(opts.ClientAuthPaths) == 0 {
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+			logger.GlobalLog.LogInfo("Requiring client certificates signed by '" + opts.ClientCA + "'")
+		} else {
+			config.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+	}
+
+	for _, name := range opts.CurvePreferences {
+		curve, ok := tlsCurves[name]
+
+		if !ok {
+			return nil, errors.New("Unknown elliptic curve '" + name + "', expected one of X25519, P256, P384, or P521")
+		}
+
+		config.CurvePreferences = append(config.CurvePreferences, curve)
+	}
+
+	return config, nil
+}
+
+// Gives the names a certificate is valid for, for logging.
+func certNames(cert *x509.Certificate) string {
+	names := cert.DNSNames
+
+	if len(names) == 0 {
+		names = []string{cert.Subject.CommonName}
+	}
+
+	return "'" + strings.Join(names, "', '") + "'"
+}
@@ -0,0 +1,241 @@
+// Copyright (c) 2024 Evan Overman (https://an-prata.it).
+// Licensed under the MIT License.
+// See LICENSE file in repository root for complete license text.
+
+package server
+
+import (
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/an-prata/webby/logger"
+)
+
+// Accepts multipart file uploads into a directory, see `Handler.SetUpload()`.
+type uploadHandler struct {
+	path string
+	dir  string
+
+	// Largest request accepted in bytes, zero for no limit.
+	maxBytes int64
+
+	// Lower case file extensions accepted, including their dot, any if empty.
+	extensions []string
+
+	// Users allowed to upload, see `basicAuthorize()`. Anyone may upload if
+	// empty, which must be asked for when setting the handler.
+	users map[string]string
+}
+
+// Accepts POST requests to the given URL path, e.g. "/upload", with files in a
+// "multipart/form-data" body, saving each into the given directory. Requests
+// larger than the given number of bytes are refused, as are files whose
+// extension is not one of those given, unless zero or none are given. Users are
+// given by name with the hex encoded SHA-256 hash of their password. Without
+// any, uploads are refused unless anonymous uploads are allowed, e.g. for a
+// path protected otherwise. An empty path leaves uploads disabled.
+func (h *Handler) SetUpload(path, dir string, maxBytes int64, extensions []string, users map[string]string, anonymous bool) {
+	h.upload = nil
+
+	if path == "" {
+		return
+	}
+
+	if len(users) == 0 && !anonymous {
+		logger.GlobalLog.LogWarn("Ignoring 'UploadPath', which needs 'UploadUsers' to authenticate uploads, or 'UploadAnonymous' to let anyone upload")
+		return
+	}
+
+	lowered := []string{}
+
+	for _, extension := range extensions {
+		lowered = append(lowered, "."+strings.TrimPrefix(strings.ToLower(extension), "."))
+	}
+
+	logger.GlobalLog.LogInfo("Accepting uploads to '" + path + "' into '" + dir + "'")
+	h.upload = &uploadHandler{path, dir, maxBytes, lowered, users}
+}
+
+func (u *uploadHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if len(u.users) > 0 && !basicAuthorize(w, req, u.users, "upload") {
+		return
+	}
+
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	if u.maxBytes > 0 {
+		if req.ContentLength > u.maxBytes {
+			http.Error(w, "Upload is larger than "+strconv.FormatInt(u.maxBytes, 10)+" bytes", http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		req.Body = http.MaxBytesReader(w, req.Body, u.maxBytes)
+	}
+
+	reader, err := req.MultipartReader()
+
+	if err != nil {
+		http.Error(w, "Expected a multipart/form-data body", http.StatusBadRequest)
+		return
+	}
+
+	saved := []string{}
+
+	// A request is saved in whole or not at all.
+	fail := func(status int, message string) {
+		for _, name := range saved {
+			os.Remove(filepath.Join(u.dir, name))
+		}
+
+		http.Error(w, message, status)
+	}
+
+	for {
+		part, err := reader.NextPart()
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			if status := uploadErrorStatus(err); status != http.StatusBadRequest {
+				fail(status, http.StatusText(status))
+			} else {
+				fail(status, "Malformed multipart body")
+			}
+
+			return
+		}
+
+		if part.FileName() == "" {
+			part.Close()
+			continue
+		}
+
+		name, err := u.save(part)
+		part.Close()
+
+		if err != nil {
+			status := uploadErrorStatus(err)
+
+			if status == http.StatusInternalServerError {
+				logger.GlobalLog.LogErr("Could not save upload '" + part.FileName() + "': " + err.Error())
+			}
+
+			fail(status, err.Error())
+			return
+		}
+
+		saved = append(saved, name)
+	}
+
+	if len(saved) == 0 {
+		http.Error(w, "No files were uploaded", http.StatusBadRequest)
+		return
+	}
+
+	logger.GlobalLog.LogInfo("Saved upload of " + strings.Join(saved, ", ") + " from " + req.RemoteAddr)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusCreated)
+	w.Write([]byte(strings.Join(saved, "\n") + "\n"))
+}
+
+// Errors of uploaded files that are refused rather than failing to save.
+var (
+	errUploadName      = errors.New("File name is not allowed")
+	errUploadExtension = errors.New("File type is not allowed")
+)
+
+// Gives the status to respond with for an error saving an upload.
+func uploadErrorStatus(err error) int {
+	var tooLarge *http.MaxBytesError
+
+	switch {
+	case errors.As(err, &tooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, errUploadName):
+		return http.StatusBadRequest
+	case errors.Is(err, errUploadExtension):
+		return http.StatusUnsupportedMediaType
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return http.StatusBadRequest
+	}
+
+	return http.StatusInternalServerError
+}
+
+// Saves an uploaded file under its own name, or with a number added to it if
+// that is taken, giving the name it was saved as.
+func (u *uploadHandler) save(part *multipart.Part) (string, error) {
+	name := filepath.Base(filepath.Clean("/" + strings.ReplaceAll(part.FileName(), "\\", "/")))
+
+	if name == "/" || strings.HasPrefix(name, ".") {
+		return "", errUploadName
+	}
+
+	extension := strings.ToLower(filepath.Ext(name))
+	allowed := len(u.extensions) == 0
+
+	for _, allowedExtension := range u.extensions {
+		if extension == allowedExtension {
+			allowed = true
+		}
+	}
+
+	if !allowed {
+		return "", errUploadExtension
+	}
+
+	temp, err := os.CreateTemp(u.dir, ".webby-upload-*")
+
+	if err != nil {
+		return "", err
+	}
+
+	_, err = io.Copy(temp, part)
+
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Chmod(temp.Name(), 0644)
+	}
+
+	if err != nil {
+		os.Remove(temp.Name())
+		return "", err
+	}
+
+	base := strings.TrimSuffix(name, filepath.Ext(name))
+
+	// Links are made rather than renaming so that an existing file is never
+	// replaced, even by another upload racing this one.
+	for i := 1; ; i++ {
+		err = os.Link(temp.Name(), filepath.Join(u.dir, name))
+
+		if !errors.Is(err, os.ErrExist) {
+			break
+		}
+
+		name = base + "-" + strconv.Itoa(i) + filepath.Ext(name)
+	}
+
+	os.Remove(temp.Name())
+
+	if err != nil {
+		return "", err
+	}
+
+	return name, nil
+}
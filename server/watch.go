@@ -0,0 +1,29 @@
+// Copyright (c) 2024 Evan Overman (https://an-prata.it).
+// Licensed under the MIT License.
+// See LICENSE file in repository root for complete license text.
+
+package server
+
+import "sync"
+
+// Watches the given files and directories for changes, directories being
+// watched recursively so that files added to them, including within new
+// subdirectories, and removed from them are also detected. All paths are
+// watched by a single watcher, using inotify where available.
+//
+// The callback is given the kind of each change and may return true to stop
+// watching. Errors are also reported through the callback. Watching may also
+// be stopped by calling the returned function, after which the callback will
+// not be called again.
+func WatchPaths(callback func(FileChangeSignal) bool, paths ...string) func() {
+	stop := make(chan struct{})
+	var once sync.Once
+
+	Supervise("file watcher", func() error {
+		return watchPaths(callback, paths, stop)
+	})
+
+	return func() {
+		once.Do(func() { close(stop) })
+	}
+}
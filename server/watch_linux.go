@@ -0,0 +1,201 @@
+// Copyright (c) 2024 Evan Overman (https://an-prata.it).
+// Licensed under the MIT License.
+// See LICENSE file in repository root for complete license text.
+
+package server
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"github.com/an-prata/webby/logger"
+)
+
+// Events watched for on every directory.
+const inotifyMask = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MODIFY | syscall.IN_CLOSE_WRITE |
+	syscall.IN_ATTRIB | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_DELETE_SELF
+
+// Watches with a single inotify instance. Files are watched through their
+// parent directories so that they are still watched after being replaced, as
+// many editors do when saving.
+func watchPaths(callback func(FileChangeSignal) bool, paths []string, stop chan struct{}) error {
+	// Directories are watched recursively, other files through their parents.
+	dirs := []string{}
+	files := []string{}
+
+	for _, path := range paths {
+		path = filepath.Clean(path)
+		stat, err := os.Stat(path)
+
+		if err != nil {
+			if callback(InitialReadError) {
+				return nil
+			}
+
+			continue
+		}
+
+		if stat.IsDir() {
+			dirs = append(dirs, path)
+		} else {
+			files = append(files, path)
+		}
+	}
+
+	fd, err := syscall.InotifyInit1(syscall.IN_NONBLOCK | syscall.IN_CLOEXEC)
+
+	if err != nil {
+		return errors.New("Could not create inotify instance: " + err.Error())
+	}
+
+	// A non-blocking descriptor is read through the runtime's poller, so closing
+	// the file interrupts a pending read.
+	file := os.NewFile(uintptr(fd), "inotify")
+	defer file.Close()
+
+	go func() {
+		<-stop
+		file.Close()
+	}()
+
+	watched := map[int32]string{}
+
+	addWatch := func(dir string) {
+		wd, err := syscall.InotifyAddWatch(fd, dir, inotifyMask)
+
+		if err != nil {
+			logger.GlobalLog.LogErr("Could not watch '" + dir + "': " + err.Error())
+			return
+		}
+
+		watched[int32(wd)] = dir
+	}
+
+	addTree := func(root string) {
+		filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err == nil && d.IsDir() {
+				addWatch(path)
+			}
+
+			return nil
+		})
+	}
+
+	for _, dir := range dirs {
+		addTree(dir)
+	}
+
+	for _, file := range files {
+		addWatch(filepath.Dir(file))
+	}
+
+	buf := make([]byte, 64*1024)
+
+	for {
+		n, err := file.Read(buf)
+
+		select {
+		case <-stop:
+			return nil
+		default:
+		}
+
+		if err != nil {
+			if callback(ReadError) {
+				return nil
+			}
+
+			return errors.New("Could not read inotify events: " + err.Error())
+		}
+
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameBytes := buf[offset+syscall.SizeofInotifyEvent : offset+syscall.SizeofInotifyEvent+int(event.Len)]
+			offset += syscall.SizeofInotifyEvent + int(event.Len)
+
+			if event.Mask&syscall.IN_Q_OVERFLOW != 0 {
+				if callback(TimeModifiedChange) {
+					return nil
+				}
+
+				continue
+			}
+
+			dir, ok := watched[event.Wd]
+
+			if !ok {
+				continue
+			}
+
+			if event.Mask&syscall.IN_IGNORED != 0 {
+				delete(watched, event.Wd)
+				continue
+			}
+
+			path := dir
+
+			if name := trimNul(nameBytes); name != "" {
+				path = filepath.Join(dir, name)
+			}
+
+			if !isWatchedPath(path, dirs, files) {
+				continue
+			}
+
+			var signal FileChangeSignal
+
+			switch {
+			case event.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0:
+				signal = CreateChange
+
+				// New subdirectories must be watched too, along with anything created in
+				// them before the watch was added.
+				if event.Mask&syscall.IN_ISDIR != 0 {
+					addTree(path)
+				}
+			case event.Mask&(syscall.IN_DELETE|syscall.IN_MOVED_FROM|syscall.IN_DELETE_SELF) != 0:
+				signal = RemoveChange
+			default:
+				signal = TimeModifiedChange
+			}
+
+			if callback(signal) {
+				return nil
+			}
+		}
+	}
+}
+
+// Returns true if the given path is one of the given files or is within one of
+// the given directories.
+func isWatchedPath(path string, dirs, files []string) bool {
+	for _, file := range files {
+		if path == file {
+			return true
+		}
+	}
+
+	for _, dir := range dirs {
+		if path == dir || strings.HasPrefix(path, dir+string(filepath.Separator)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Gives the string in the given NUL padded bytes.
+func trimNul(b []byte) string {
+	for i, c := range b {
+		if c == 0 {
+			return string(b[:i])
+		}
+	}
+
+	return string(b)
+}
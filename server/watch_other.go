@@ -0,0 +1,84 @@
+// Copyright (c) 2024 Evan Overman (https://an-prata.it).
+// Licensed under the MIT License.
+// See LICENSE file in repository root for complete license text.
+
+//go:build !linux
+
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Watches by walking every path once a second and comparing what was found,
+// since inotify is unavailable.
+func watchPaths(callback func(FileChangeSignal) bool, paths []string, stop chan struct{}) error {
+	previous, err := statPaths(paths)
+
+	if err != nil && callback(InitialReadError) {
+		return nil
+	}
+
+	for {
+		select {
+		case <-stop:
+			return nil
+		case <-time.After(time.Second):
+		}
+
+		current, err := statPaths(paths)
+		signals := []FileChangeSignal{}
+
+		if err != nil {
+			signals = append(signals, ReadError)
+		}
+
+		for path, stat := range current {
+			old, ok := previous[path]
+
+			if !ok {
+				signals = append(signals, CreateChange)
+			} else if stat.ModTime() != old.ModTime() {
+				signals = append(signals, TimeModifiedChange)
+			} else if stat.Size() != old.Size() {
+				signals = append(signals, SizeChange)
+			}
+		}
+
+		for path := range previous {
+			if _, ok := current[path]; !ok {
+				signals = append(signals, RemoveChange)
+			}
+		}
+
+		for _, signal := range signals {
+			if callback(signal) {
+				return nil
+			}
+		}
+
+		previous = current
+	}
+}
+
+// Stats every file within the given paths, giving the last error encountered.
+func statPaths(paths []string) (map[string]os.FileInfo, error) {
+	stats := map[string]os.FileInfo{}
+	var lastErr error
+
+	for _, root := range paths {
+		filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				lastErr = err
+				return nil
+			}
+
+			stats[path] = info
+			return nil
+		})
+	}
+
+	return stats, lastErr
+}
@@ -0,0 +1,542 @@
+// Copyright (c) 2024 Evan Overman (https://an-prata.it).
+// Licensed under the MIT License.
+// See LICENSE file in repository root for complete license text.
+
+package server
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/an-prata/webby/logger"
+)
+
+// Methods answered by the WebDAV handler, which is class 1 and so does not
+// support locking.
+const webDavMethods = "OPTIONS, GET, HEAD, PUT, DELETE, MKCOL, PROPFIND, MOVE, COPY"
+
+// Serves a directory over WebDAV under a URL prefix, so that it may be updated
+// remotely, see `Handler.SetWebDav()`. Implemented here rather than with
+// golang.org/x/net/webdav to keep webby without dependencies.
+type webDav struct {
+	prefix string
+	root   string
+
+	// Hex encoded SHA-256 hashes of each user's password by user name.
+	users map[string]string
+
+	// Whether hidden files and directories are listed, see
+	// `Handler.SetServeHiddenFiles()`.
+	hidden bool
+}
+
+// A PROPFIND response, see RFC 4918 section 14.16.
+type davMultistatus struct {
+	XMLName   xml.Name      `xml:"D:multistatus"`
+	Namespace string        `xml:"xmlns:D,attr"`
+	Responses []davResponse `xml:"D:response"`
+}
+
+type davResponse struct {
+	Href     string      `xml:"D:href"`
+	Propstat davPropstat `xml:"D:propstat"`
+}
+
+type davPropstat struct {
+	Prop   davProp `xml:"D:prop"`
+	Status string  `xml:"D:status"`
+}
+
+type davProp struct {
+	DisplayName   string          `xml:"D:displayname"`
+	ResourceType  davResourceType `xml:"D:resourcetype"`
+	ContentLength *int64          `xml:"D:getcontentlength,omitempty"`
+	ContentType   string          `xml:"D:getcontenttype,omitempty"`
+	LastModified  string          `xml:"D:getlastmodified"`
+	ETag          string          `xml:"D:getetag,omitempty"`
+}
+
+type davResourceType struct {
+	Collection *struct{} `xml:"D:collection,omitempty"`
+}
+
+// Serves the given directory over WebDAV under the given URL prefix, e.g.
+// "/dav/", to users authenticating with HTTP basic auth. Users are given by name
+// with the hex encoded SHA-256 hash of their password. An empty prefix or no
+// users leaves WebDAV disabled.
+func (h *Handler) SetWebDav(prefix, root string, users map[string]string) {
+	h.webDav = nil
+
+	if prefix == "" {
+		return
+	}
+
+	if len(users) == 0 {
+		logger.GlobalLog.LogWarn("Not serving WebDAV under '" + prefix + "' without any users")
+		return
+	}
+
+	logger.GlobalLog.LogInfo("Serving '" + root + "' over WebDAV under '" + prefix + "'")
+	h.webDav = &webDav{strings.TrimSuffix(prefix, "/") + "/", root, users, h.serveHiddenFiles}
+}
+
+// Returns true if the given URL path is under the WebDAV prefix.
+func (d *webDav) serves(urlPath string) bool {
+	return urlPath == strings.TrimSuffix(d.prefix, "/") || strings.HasPrefix(urlPath, d.prefix)
+}
+
+// Gives the file path for the given URL path under the WebDAV prefix.
+func (d *webDav) filePath(urlPath string) string {
+	rel := strings.TrimPrefix(urlPath, strings.TrimSuffix(d.prefix, "/"))
+	return filepath.Join(d.root, filepath.FromSlash(path.Clean("/"+rel)))
+}
+
+// Gives the URL path for the given file path under the WebDAV root, escaped for
+// use as an href.
+func (d *webDav) href(filePath string, dir bool) string {
+	rel, _ := filepath.Rel(d.root, filePath)
+	href := path.Join(d.prefix, filepath.ToSlash(rel))
+
+	if dir {
+		href = strings.TrimSuffix(href, "/") + "/"
+	}
+
+	return (&url.URL{Path: href}).EscapedPath()
+}
+
+// Gives the given file with the symbolic links of the directory it is in
+// resolved, or false if they lead outside of the WebDAV root, so that a link
+// under the root cannot have files written or removed elsewhere. The file
+// itself is not resolved, as it is a link and not what it points to that is
+// replaced or removed.
+func (d *webDav) resolve(file string) (string, bool) {
+	root, err := filepath.EvalSymlinks(d.root)
+
+	if err != nil {
+		return "", false
+	}
+
+	dir, err := filepath.EvalSymlinks(filepath.Dir(file))
+
+	// A missing directory is refused by the caller as a missing parent.
+	if errors.Is(err, fs.ErrNotExist) {
+		return file, true
+	}
+
+	if err != nil || (dir != root && !strings.HasPrefix(dir, root+string(filepath.Separator))) {
+		return "", false
+	}
+
+	return filepath.Join(dir, filepath.Base(file)), true
+}
+
+func (d *webDav) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if !basicAuthorize(w, req, d.users, "WebDAV") {
+		return
+	}
+
+	w.Header().Set("DAV", "1")
+	file := d.filePath(req.URL.Path)
+
+	switch req.Method {
+	case http.MethodOptions:
+		w.Header().Set("Allow", webDavMethods)
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodGet, http.MethodHead:
+		d.get(w, req, file)
+	case http.MethodPut:
+		d.put(w, req, file)
+	case http.MethodDelete:
+		d.delete(w, req, file)
+	case "MKCOL":
+		d.mkcol(w, req, file)
+	case "PROPFIND":
+		d.propfind(w, req, file)
+	case "MOVE", "COPY":
+		d.moveOrCopy(w, req, file)
+	default:
+		w.Header().Set("Allow", webDavMethods)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+func (d *webDav) get(w http.ResponseWriter, req *http.Request, file string) {
+	f, err := os.Open(file)
+
+	if err != nil {
+		http.NotFound(w, req)
+		return
+	}
+
+	defer f.Close()
+	info, err := f.Stat()
+
+	if err != nil || info.IsDir() {
+		// Collections are listed with PROPFIND rather than GET.
+		w.Header().Set("Allow", "OPTIONS, PUT, DELETE, MKCOL, PROPFIND, MOVE, COPY")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	http.ServeContent(w, req, info.Name(), info.ModTime(), f)
+}
+
+// Writes the request body to the given file through a temporary file, so that
+// the site never serves a partial upload.
+func (d *webDav) put(w http.ResponseWriter, req *http.Request, file string) {
+	file, ok := d.resolve(file)
+
+	if !ok {
+		http.Error(w, "Outside of the WebDAV root", http.StatusForbidden)
+		return
+	}
+
+	info, err := os.Stat(file)
+	existed := err == nil
+
+	if existed && info.IsDir() {
+		http.Error(w, "Cannot replace a collection", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if parent, err := os.Stat(filepath.Dir(file)); err != nil || !parent.IsDir() {
+		http.Error(w, "Parent collection does not exist", http.StatusConflict)
+		return
+	}
+
+	temp, err := os.CreateTemp(filepath.Dir(file), ".webby-upload-*")
+
+	if err != nil {
+		logger.GlobalLog.LogErr("Could not create upload for '" + file + "': " + err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	_, err = io.Copy(temp, req.Body)
+
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Chmod(temp.Name(), 0644)
+	}
+
+	if err == nil {
+		err = os.Rename(temp.Name(), file)
+	}
+
+	if err != nil {
+		os.Remove(temp.Name())
+		logger.GlobalLog.LogErr("Could not write upload to '" + file + "': " + err.Error())
+
+		var tooLarge *http.MaxBytesError
+
+		if errors.As(err, &tooLarge) {
+			http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	logger.GlobalLog.LogInfo("Wrote '" + file + "' over WebDAV from " + req.RemoteAddr)
+
+	if existed {
+		w.WriteHeader(http.StatusNoContent)
+	} else {
+		w.WriteHeader(http.StatusCreated)
+	}
+}
+
+func (d *webDav) delete(w http.ResponseWriter, req *http.Request, file string) {
+	if file == filepath.Clean(d.root) {
+		http.Error(w, "Cannot delete the root collection", http.StatusForbidden)
+		return
+	}
+
+	file, ok := d.resolve(file)
+
+	if !ok {
+		http.Error(w, "Outside of the WebDAV root", http.StatusForbidden)
+		return
+	}
+
+	if _, err := os.Lstat(file); err != nil {
+		http.NotFound(w, req)
+		return
+	}
+
+	if err := os.RemoveAll(file); err != nil {
+		logger.GlobalLog.LogErr("Could not delete '" + file + "': " + err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	logger.GlobalLog.LogInfo("Deleted '" + file + "' over WebDAV from " + req.RemoteAddr)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (d *webDav) mkcol(w http.ResponseWriter, req *http.Request, file string) {
+	if req.ContentLength > 0 {
+		http.Error(w, "MKCOL does not accept a body", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	file, ok := d.resolve(file)
+
+	if !ok {
+		http.Error(w, "Outside of the WebDAV root", http.StatusForbidden)
+		return
+	}
+
+	if _, err := os.Lstat(file); err == nil {
+		http.Error(w, "Already exists", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := os.Mkdir(file, 0755); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			http.Error(w, "Parent collection does not exist", http.StatusConflict)
+			return
+		}
+
+		logger.GlobalLog.LogErr("Could not create '" + file + "': " + err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	logger.GlobalLog.LogInfo("Created '" + file + "' over WebDAV from " + req.RemoteAddr)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// Describes the given file and, with a depth of one, its entries. Every
+// property webby knows is given regardless of those requested, as allowed for
+// "allprop" requests and harmless for others. Requests without a depth are
+// given a depth of one, as a depth of infinity is not supported.
+func (d *webDav) propfind(w http.ResponseWriter, req *http.Request, file string) {
+	depth := req.Header.Get("Depth")
+
+	switch depth {
+	case "infinity":
+		http.Error(w, "Depth infinity is not supported", http.StatusForbidden)
+		return
+	case "", "0", "1":
+	default:
+		http.Error(w, "Depth must be '0', '1', or 'infinity'", http.StatusBadRequest)
+		return
+	}
+
+	info, err := os.Stat(file)
+
+	if err != nil {
+		http.NotFound(w, req)
+		return
+	}
+
+	status := davMultistatus{Namespace: "DAV:", Responses: []davResponse{d.describe(file, info)}}
+
+	if info.IsDir() && depth != "0" {
+		entries, err := os.ReadDir(file)
+
+		if err != nil {
+			logger.GlobalLog.LogErr("Could not list '" + file + "': " + err.Error())
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		for _, entry := range entries {
+			if strings.HasPrefix(entry.Name(), ".") && !d.hidden {
+				continue
+			}
+
+			if entryInfo, err := entry.Info(); err == nil {
+				status.Responses = append(status.Responses, d.describe(filepath.Join(file, entry.Name()), entryInfo))
+			}
+		}
+	}
+
+	body, err := xml.Marshal(status)
+
+	if err != nil {
+		logger.GlobalLog.LogErr("Could not encode WebDAV properties: " + err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusMultiStatus)
+	w.Write([]byte(xml.Header))
+	w.Write(body)
+}
+
+// Gives the properties of the given file.
+func (d *webDav) describe(file string, info fs.FileInfo) davResponse {
+	prop := davProp{
+		DisplayName:  info.Name(),
+		LastModified: info.ModTime().UTC().Format(http.TimeFormat),
+	}
+
+	if info.IsDir() {
+		prop.ResourceType.Collection = &struct{}{}
+	} else {
+		size := info.Size()
+		prop.ContentLength = &size
+		prop.ContentType = mime.TypeByExtension(filepath.Ext(file))
+		prop.ETag = `"` + strconv.FormatInt(info.ModTime().UnixNano(), 36) + "-" + strconv.FormatInt(size, 36) + `"`
+	}
+
+	return davResponse{d.href(file, info.IsDir()), davPropstat{prop, "HTTP/1.1 200 OK"}}
+}
+
+// Moves or copies the given file to the request's destination, which must be
+// under the WebDAV prefix of the same host and not hidden unless hidden files
+// are served. Collections are moved or copied
+// with everything in them, or copied alone given a depth of zero. An existing
+// destination is replaced unless the request's "Overwrite" header is "F".
+func (d *webDav) moveOrCopy(w http.ResponseWriter, req *http.Request, file string) {
+	depth := req.Header.Get("Depth")
+
+	if depth != "" && depth != "infinity" && (req.Method != "COPY" || depth != "0") {
+		http.Error(w, "Depth must be 'infinity', or '0' to copy a collection alone", http.StatusBadRequest)
+		return
+	}
+
+	overwrite := req.Header.Get("Overwrite")
+
+	if overwrite != "" && overwrite != "T" && overwrite != "F" {
+		http.Error(w, "Overwrite must be 'T' or 'F'", http.StatusBadRequest)
+		return
+	}
+
+	destination, err := url.Parse(req.Header.Get("Destination"))
+
+	if err != nil || destination.Path == "" || (destination.Host != "" && destination.Host != req.Host) || !d.serves(destination.Path) {
+		http.Error(w, "Destination must be under '"+d.prefix+"'", http.StatusBadGateway)
+		return
+	}
+
+	target := d.filePath(destination.Path)
+
+	if rel, _ := filepath.Rel(d.root, target); !d.hidden && isHidden("/"+filepath.ToSlash(rel)) {
+		http.Error(w, "Destination is hidden", http.StatusForbidden)
+		return
+	}
+
+	file, ok := d.resolve(file)
+	target, targetOk := d.resolve(target)
+
+	if !ok || !targetOk {
+		http.Error(w, "Outside of the WebDAV root", http.StatusForbidden)
+		return
+	}
+
+	info, err := os.Lstat(file)
+
+	if err != nil {
+		http.NotFound(w, req)
+		return
+	}
+
+	if target == file || strings.HasPrefix(target, file+string(filepath.Separator)) {
+		http.Error(w, "Destination is within the source", http.StatusForbidden)
+		return
+	}
+
+	// Replacing the destination would delete the source along with it.
+	if strings.HasPrefix(file, target+string(filepath.Separator)) {
+		http.Error(w, "Source is within the destination", http.StatusForbidden)
+		return
+	}
+
+	if parent, err := os.Stat(filepath.Dir(target)); err != nil || !parent.IsDir() {
+		http.Error(w, "Parent collection does not exist", http.StatusConflict)
+		return
+	}
+
+	_, err = os.Lstat(target)
+	existed := err == nil
+
+	if existed {
+		if overwrite == "F" {
+			http.Error(w, "Destination exists", http.StatusPreconditionFailed)
+			return
+		}
+
+		if err := os.RemoveAll(target); err != nil {
+			logger.GlobalLog.LogErr("Could not replace '" + target + "': " + err.Error())
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	switch {
+	case req.Method == "MOVE":
+		err = os.Rename(file, target)
+	case depth == "0" && info.IsDir():
+		err = os.Mkdir(target, 0755)
+	default:
+		err = copyPath(file, target)
+	}
+
+	if err != nil {
+		logger.GlobalLog.LogErr("Could not " + strings.ToLower(req.Method) + " '" + file + "' to '" + target + "': " + err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	logger.GlobalLog.LogInfo("Completed " + req.Method + " of '" + file + "' to '" + target + "' over WebDAV from " + req.RemoteAddr)
+
+	if existed {
+		w.WriteHeader(http.StatusNoContent)
+	} else {
+		w.WriteHeader(http.StatusCreated)
+	}
+}
+
+// Copies a file, or a directory and everything in it.
+func copyPath(source, target string) error {
+	return filepath.WalkDir(source, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, _ := filepath.Rel(source, file)
+		dest := filepath.Join(target, rel)
+
+		if entry.IsDir() {
+			return os.Mkdir(dest, 0755)
+		}
+
+		in, err := os.Open(file)
+
+		if err != nil {
+			return err
+		}
+
+		defer in.Close()
+		out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+
+		if err != nil {
+			return err
+		}
+
+		if _, err := io.Copy(out, in); err != nil {
+			out.Close()
+			return err
+		}
+
+		return out.Close()
+	})
+}
@@ -0,0 +1,408 @@
+// Copyright (c) 2024 Evan Overman (https://an-prata.it).
+// Licensed under the MIT License.
+// See LICENSE file in repository root for complete license text.
+
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// Creates a handler serving a directory over WebDAV under "/dav/" to the user
+// "ann", holding "a.txt", "dir/b.txt", and "dir/sub/c.txt". Gives the handler
+// and the directory.
+func newWebDavHandler(t *testing.T) (*Handler, string) {
+	t.Helper()
+	dir := t.TempDir()
+
+	for name, content := range map[string]string{"a.txt": "a", "dir/b.txt": "b", "dir/sub/c.txt": "c"} {
+		file := filepath.Join(dir, filepath.FromSlash(name))
+
+		if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	hash := sha256.Sum256([]byte("password"))
+	h := NewHandler(false)
+	h.SetWebDav("/dav/", dir, map[string]string{"ann": hex.EncodeToString(hash[:])})
+	return h, dir
+}
+
+// Makes a WebDAV request as "ann" from the local machine with the given
+// headers.
+func davRequest(h *Handler, method, urlPath string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, urlPath, nil)
+	req.RemoteAddr = "127.0.0.1:1234"
+	req.SetBasicAuth("ann", "password")
+
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+// Gives the files under the given directory as slash separated paths, with
+// directories ending in a slash.
+func listTree(t *testing.T, dir string) string {
+	t.Helper()
+	files := []string{}
+
+	filepath.WalkDir(dir, func(file string, entry os.DirEntry, err error) error {
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rel, _ := filepath.Rel(dir, file)
+
+		if rel == "." {
+			return nil
+		}
+
+		if entry.IsDir() {
+			rel += "/"
+		}
+
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	})
+
+	sort.Strings(files)
+	return strings.Join(files, " ")
+}
+
+func TestWebDavAuthorization(t *testing.T) {
+	h, _ := newWebDavHandler(t)
+
+	req := httptest.NewRequest("PROPFIND", "/dav/", nil)
+	req.RemoteAddr = "127.0.0.1:1234"
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected status %d without credentials, got %d", http.StatusUnauthorized, w.Code)
+	}
+
+	req = httptest.NewRequest("PROPFIND", "/dav/", nil)
+	req.SetBasicAuth("ann", "password")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expected status %d over plain HTTP from afar, got %d", http.StatusForbidden, w.Code)
+	}
+}
+
+func TestWebDavPropfindDepth(t *testing.T) {
+	h, _ := newWebDavHandler(t)
+
+	tests := []struct {
+		name   string
+		path   string
+		depth  string
+		status int
+		hrefs  []string
+	}{
+		{"zero", "/dav/dir/", "0", http.StatusMultiStatus, []string{"/dav/dir/"}},
+		{"one", "/dav/dir/", "1", http.StatusMultiStatus, []string{"/dav/dir/", "/dav/dir/b.txt", "/dav/dir/sub/"}},
+		{"none", "/dav/dir/", "", http.StatusMultiStatus, []string{"/dav/dir/", "/dav/dir/b.txt", "/dav/dir/sub/"}},
+		{"file", "/dav/a.txt", "1", http.StatusMultiStatus, []string{"/dav/a.txt"}},
+		{"infinity", "/dav/dir/", "infinity", http.StatusForbidden, nil},
+		{"invalid", "/dav/dir/", "2", http.StatusBadRequest, nil},
+		{"missing", "/dav/none/", "0", http.StatusNotFound, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			headers := map[string]string{}
+
+			if test.depth != "" {
+				headers["Depth"] = test.depth
+			}
+
+			w := davRequest(h, "PROPFIND", test.path, headers)
+
+			if w.Code != test.status {
+				t.Fatalf("expected status %d, got %d", test.status, w.Code)
+			}
+
+			if test.status != http.StatusMultiStatus {
+				return
+			}
+
+			var status struct {
+				Responses []struct {
+					Href string `xml:"href"`
+				} `xml:"response"`
+			}
+
+			if err := xml.Unmarshal(w.Body.Bytes(), &status); err != nil {
+				t.Fatal(err)
+			}
+
+			hrefs := []string{}
+
+			for _, response := range status.Responses {
+				hrefs = append(hrefs, response.Href)
+			}
+
+			sort.Strings(hrefs)
+
+			if strings.Join(hrefs, " ") != strings.Join(test.hrefs, " ") {
+				t.Errorf("expected %v, got %v", test.hrefs, hrefs)
+			}
+		})
+	}
+}
+
+func TestWebDavMoveAndCopy(t *testing.T) {
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		headers map[string]string
+		status  int
+		tree    string
+	}{
+		{
+			"move", "MOVE", "/dav/a.txt",
+			map[string]string{"Destination": "/dav/moved.txt"},
+			http.StatusCreated, "dir/ dir/b.txt dir/sub/ dir/sub/c.txt moved.txt",
+		},
+		{
+			"copy collection", "COPY", "/dav/dir/",
+			map[string]string{"Destination": "/dav/copy/"},
+			http.StatusCreated, "a.txt copy/ copy/b.txt copy/sub/ copy/sub/c.txt dir/ dir/b.txt dir/sub/ dir/sub/c.txt",
+		},
+		{
+			"copy collection alone", "COPY", "/dav/dir/",
+			map[string]string{"Destination": "/dav/copy/", "Depth": "0"},
+			http.StatusCreated, "a.txt copy/ dir/ dir/b.txt dir/sub/ dir/sub/c.txt",
+		},
+		{
+			"move depth zero", "MOVE", "/dav/dir/",
+			map[string]string{"Destination": "/dav/moved/", "Depth": "0"},
+			http.StatusBadRequest, "a.txt dir/ dir/b.txt dir/sub/ dir/sub/c.txt",
+		},
+		{
+			"absolute destination", "COPY", "/dav/a.txt",
+			map[string]string{"Destination": "http://example.com/dav/b.txt"},
+			http.StatusCreated, "a.txt b.txt dir/ dir/b.txt dir/sub/ dir/sub/c.txt",
+		},
+		{
+			"other host", "COPY", "/dav/a.txt",
+			map[string]string{"Destination": "http://elsewhere.com/dav/b.txt"},
+			http.StatusBadGateway, "a.txt dir/ dir/b.txt dir/sub/ dir/sub/c.txt",
+		},
+		{
+			"outside prefix", "MOVE", "/dav/a.txt",
+			map[string]string{"Destination": "/a.txt"},
+			http.StatusBadGateway, "a.txt dir/ dir/b.txt dir/sub/ dir/sub/c.txt",
+		},
+		{
+			"escaping root", "MOVE", "/dav/a.txt",
+			map[string]string{"Destination": "/dav/../../escaped.txt"},
+			http.StatusCreated, "dir/ dir/b.txt dir/sub/ dir/sub/c.txt escaped.txt",
+		},
+		{
+			"no destination", "MOVE", "/dav/a.txt",
+			nil,
+			http.StatusBadGateway, "a.txt dir/ dir/b.txt dir/sub/ dir/sub/c.txt",
+		},
+		{
+			"into itself", "MOVE", "/dav/dir/",
+			map[string]string{"Destination": "/dav/dir/sub/dir/"},
+			http.StatusForbidden, "a.txt dir/ dir/b.txt dir/sub/ dir/sub/c.txt",
+		},
+		{
+			"over its parent", "MOVE", "/dav/dir/sub/",
+			map[string]string{"Destination": "/dav/dir/"},
+			http.StatusForbidden, "a.txt dir/ dir/b.txt dir/sub/ dir/sub/c.txt",
+		},
+		{
+			"over the root", "COPY", "/dav/a.txt",
+			map[string]string{"Destination": "/dav/"},
+			http.StatusForbidden, "a.txt dir/ dir/b.txt dir/sub/ dir/sub/c.txt",
+		},
+		{
+			"overwrite", "MOVE", "/dav/a.txt",
+			map[string]string{"Destination": "/dav/dir/b.txt", "Overwrite": "T"},
+			http.StatusNoContent, "dir/ dir/b.txt dir/sub/ dir/sub/c.txt",
+		},
+		{
+			"overwrite by default", "COPY", "/dav/a.txt",
+			map[string]string{"Destination": "/dav/dir/sub/"},
+			http.StatusNoContent, "a.txt dir/ dir/b.txt dir/sub",
+		},
+		{
+			"no overwrite", "MOVE", "/dav/a.txt",
+			map[string]string{"Destination": "/dav/dir/b.txt", "Overwrite": "F"},
+			http.StatusPreconditionFailed, "a.txt dir/ dir/b.txt dir/sub/ dir/sub/c.txt",
+		},
+		{
+			"invalid overwrite", "MOVE", "/dav/a.txt",
+			map[string]string{"Destination": "/dav/dir/b.txt", "Overwrite": "yes"},
+			http.StatusBadRequest, "a.txt dir/ dir/b.txt dir/sub/ dir/sub/c.txt",
+		},
+		{
+			"missing parent", "COPY", "/dav/a.txt",
+			map[string]string{"Destination": "/dav/none/a.txt"},
+			http.StatusConflict, "a.txt dir/ dir/b.txt dir/sub/ dir/sub/c.txt",
+		},
+		{
+			"hidden destination", "COPY", "/dav/a.txt",
+			map[string]string{"Destination": "/dav/dir/.env"},
+			http.StatusForbidden, "a.txt dir/ dir/b.txt dir/sub/ dir/sub/c.txt",
+		},
+		{
+			"into hidden collection", "MOVE", "/dav/dir/",
+			map[string]string{"Destination": "/dav/.git/"},
+			http.StatusForbidden, "a.txt dir/ dir/b.txt dir/sub/ dir/sub/c.txt",
+		},
+		{
+			"missing source", "MOVE", "/dav/none.txt",
+			map[string]string{"Destination": "/dav/b.txt"},
+			http.StatusNotFound, "a.txt dir/ dir/b.txt dir/sub/ dir/sub/c.txt",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h, dir := newWebDavHandler(t)
+			w := davRequest(h, test.method, test.path, test.headers)
+
+			if w.Code != test.status {
+				t.Fatalf("expected status %d, got %d: %s", test.status, w.Code, w.Body.String())
+			}
+
+			if tree := listTree(t, dir); tree != test.tree {
+				t.Errorf("expected files %q, got %q", test.tree, tree)
+			}
+		})
+	}
+
+	t.Run("overwritten content", func(t *testing.T) {
+		h, dir := newWebDavHandler(t)
+		davRequest(h, "COPY", "/dav/a.txt", map[string]string{"Destination": "/dav/dir/b.txt"})
+
+		if content, _ := os.ReadFile(filepath.Join(dir, "dir", "b.txt")); string(content) != "a" {
+			t.Errorf("expected the destination to be replaced, got %q", content)
+		}
+	})
+}
+
+func TestWebDavPutAndDelete(t *testing.T) {
+	h, dir := newWebDavHandler(t)
+
+	put := func(urlPath, body string) int {
+		req := httptest.NewRequest(http.MethodPut, urlPath, strings.NewReader(body))
+		req.RemoteAddr = "127.0.0.1:1234"
+		req.SetBasicAuth("ann", "password")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if status := put("/dav/new.txt", "new"); status != http.StatusCreated {
+		t.Errorf("expected status %d for a new file, got %d", http.StatusCreated, status)
+	}
+
+	if status := put("/dav/new.txt", "newer"); status != http.StatusNoContent {
+		t.Errorf("expected status %d for a replaced file, got %d", http.StatusNoContent, status)
+	}
+
+	if content, _ := os.ReadFile(filepath.Join(dir, "new.txt")); string(content) != "newer" {
+		t.Errorf("expected the replaced content, got %q", content)
+	}
+
+	if status := put("/dav/none/new.txt", "new"); status != http.StatusConflict {
+		t.Errorf("expected status %d without a parent, got %d", http.StatusConflict, status)
+	}
+
+	if status := put("/dav/dir", "new"); status != http.StatusMethodNotAllowed {
+		t.Errorf("expected status %d over a collection, got %d", http.StatusMethodNotAllowed, status)
+	}
+
+	if w := davRequest(h, "MKCOL", "/dav/made/", nil); w.Code != http.StatusCreated {
+		t.Errorf("expected status %d for MKCOL, got %d", http.StatusCreated, w.Code)
+	}
+
+	if w := davRequest(h, "MKCOL", "/dav/made/", nil); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected status %d for MKCOL of an existing collection, got %d", http.StatusMethodNotAllowed, w.Code)
+	}
+
+	if w := davRequest(h, http.MethodDelete, "/dav/", nil); w.Code != http.StatusForbidden {
+		t.Errorf("expected status %d deleting the root, got %d", http.StatusForbidden, w.Code)
+	}
+
+	if w := davRequest(h, http.MethodDelete, "/dav/dir/", nil); w.Code != http.StatusNoContent {
+		t.Errorf("expected status %d deleting a collection, got %d", http.StatusNoContent, w.Code)
+	}
+
+	if tree := listTree(t, dir); tree != "a.txt made/ new.txt" {
+		t.Errorf("expected files %q, got %q", "a.txt made/ new.txt", tree)
+	}
+}
+
+// Writes and removes files through a symbolic link under the WebDAV root to a
+// directory outside of it, which should all be refused.
+func TestWebDavSymlinks(t *testing.T) {
+	h, dir := newWebDavHandler(t)
+	outside := t.TempDir()
+	os.WriteFile(filepath.Join(outside, "kept.txt"), []byte("kept"), 0o644)
+
+	if err := os.Symlink(outside, filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	requests := []struct {
+		method  string
+		path    string
+		headers map[string]string
+	}{
+		{http.MethodPut, "/dav/link/new.txt", nil},
+		{"MKCOL", "/dav/link/made/", nil},
+		{http.MethodDelete, "/dav/link/kept.txt", nil},
+		{"COPY", "/dav/a.txt", map[string]string{"Destination": "/dav/link/a.txt"}},
+		{"MOVE", "/dav/link/kept.txt", map[string]string{"Destination": "/dav/kept.txt"}},
+	}
+
+	for _, request := range requests {
+		if w := davRequest(h, request.method, request.path, request.headers); w.Code != http.StatusForbidden {
+			t.Errorf("expected status %d for %s of '%s', got %d", http.StatusForbidden, request.method, request.path, w.Code)
+		}
+	}
+
+	if tree := listTree(t, outside); tree != "kept.txt" {
+		t.Errorf("expected files outside of the root to be left alone, got %q", tree)
+	}
+
+	// Links within the root are followed.
+	if err := os.Symlink(filepath.Join(dir, "dir"), filepath.Join(dir, "inner")); err != nil {
+		t.Fatal(err)
+	}
+
+	if w := davRequest(h, "COPY", "/dav/a.txt", map[string]string{"Destination": "/dav/inner/a.txt"}); w.Code != http.StatusCreated {
+		t.Errorf("expected status %d copying through a link within the root, got %d", http.StatusCreated, w.Code)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "dir", "a.txt")); err != nil {
+		t.Errorf("expected the copy to be made in the linked directory: %v", err)
+	}
+}
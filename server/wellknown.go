@@ -0,0 +1,76 @@
+// Copyright (c) 2024 Evan Overman (https://an-prata.it).
+// Licensed under the MIT License.
+// See LICENSE file in repository root for complete license text.
+
+package server
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/an-prata/webby/logger"
+)
+
+// Prefix of the URIs reserved for well known endpoints, see RFC 8615.
+const wellKnownPrefix = "/.well-known/"
+
+// Maps each endpoint name to a file on disk, served under "/.well-known/". Like
+// other custom handlers these take priority over files of the site.
+func (h *Handler) AddWellKnown(endpoints map[string]string) error {
+	for name, filePath := range endpoints {
+		uriPath := wellKnownPrefix + strings.TrimPrefix(name, "/")
+
+		if _, err := os.Stat(filePath); err != nil {
+			logger.GlobalLog.LogErr("Could not map '" + uriPath + "' to '" + filePath + "' due to failed stat")
+			return errors.New("Could not stat '" + filePath + "'")
+		}
+
+		filePath := filePath
+		logger.GlobalLog.LogInfo("Mapped URI '" + uriPath + "' to well known file '" + filePath + "'")
+		h.handlerMap[uriPath] = CustomHandler{
+			Handler: func(w http.ResponseWriter, req *http.Request) {
+				http.ServeFile(w, req, filePath)
+			},
+			Kind:   RouteWellKnown,
+			Target: filePath,
+		}
+	}
+
+	return nil
+}
+
+// Generates "/.well-known/security.txt" as described by RFC 9116, listing the
+// given contacts and an optional policy URL. A zero expiry defaults to one year
+// from now.
+func (h *Handler) AddSecurityTxt(contacts []string, expires time.Time, policy string) {
+	if expires.IsZero() {
+		expires = time.Now().AddDate(1, 0, 0)
+	}
+
+	var builder strings.Builder
+
+	for _, contact := range contacts {
+		builder.WriteString("Contact: " + contact + "\n")
+	}
+
+	builder.WriteString("Expires: " + expires.UTC().Format(time.RFC3339) + "\n")
+
+	if policy != "" {
+		builder.WriteString("Policy: " + policy + "\n")
+	}
+
+	content := builder.String()
+	modTime := time.Now()
+
+	logger.GlobalLog.LogInfo("Generated '" + wellKnownPrefix + "security.txt'")
+	h.handlerMap[wellKnownPrefix+"security.txt"] = CustomHandler{
+		Handler: func(w http.ResponseWriter, req *http.Request) {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			http.ServeContent(w, req, "security.txt", modTime, strings.NewReader(content))
+		},
+		Kind: RouteGenerated,
+	}
+}